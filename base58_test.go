@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBase58LeadingZeros(t *testing.T) {
+	for _, input := range [][]byte{{0, 0, 0, 1, 2}, {0, 1}, {1, 0}} {
+		assert.Equal(t, input, Base58Decode(Base58Encode(input)))
+	}
+	assert.Equal(t, "111", string(Base58Encode([]byte{0, 0, 0})))
+}
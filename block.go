@@ -3,11 +3,8 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
 	"fmt"
-	"io"
 	"log"
-	"time"
 )
 
 // Block represents a block in the blockchain
@@ -19,6 +16,8 @@ type Block struct {
 	Nonce         int
 	Height        int
 	Data          []byte
+	// UTXOCommitment 区块接入后 UTXO 集的承诺（见 utxo_accumulator.go），写在区块头中
+	UTXOCommitment []byte
 }
 
 // NewBlock 这段代码是用于创建新区块的函数 `NewBlock`。以下是这个函数的关键部分：
@@ -36,9 +35,14 @@ type Block struct {
 //5. 返回创建的新区块，其中包含了正确的哈希和随机数，表示该区块已经符合了工作量证明的规则。
 //这个函数的目的是创建一个新的区块，并计算出符合工作量证明的哈希和随机数，以便该区块可以被添加到区块链中。
 func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, Consensustype int, Data []byte) *Block {
+	return newBlock(transactions, prevBlockHash, height, Consensustype, Data, nil)
+}
+
+// newBlock 同 NewBlock，区块头带上 UTXO 承诺 commitment
+func newBlock(transactions []*Transaction, prevBlockHash []byte, height int, Consensustype int, Data []byte, commitment []byte) *Block {
 	fmt.Println("NewBlock")
 	fmt.Println("data", Data)
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height, Data}
+	block := &Block{now().Unix(), transactions, prevBlockHash, []byte{}, 0, height, Data, commitment}
 	fmt.Println("block", block.Data)
 	if Consensustype == 0 {
 		pow := NewProofOfWork(block)
@@ -47,86 +51,124 @@ func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, Con
 		block.Hash = hash[:]
 		block.Nonce = nonce
 	} else if Consensustype == 1 {
-		//hotstuff
-		//TODO
-
-		var hash [32]byte
-		//nonce值为一个随机的正整数
-		nonce := 0
-		data := bytes.Join(
-			[][]byte{
-				block.PrevBlockHash,
-				block.HashTransactions(),
-				IntToHex(block.Timestamp),
-				IntToHex(int64(targetBits)),
-				IntToHex(int64(nonce)),
-			},
-			[]byte{},
-		)
-		hash = sha256.Sum256(data)
-		block.Hash = hash[:]
-		block.Nonce = nonce
+		//hotstuff 区块不做工作量证明，nonce 固定为 0，区块哈希直接取区块头的哈希
+		block.Nonce = 0
+		block.Hash = block.ComputeHash()
 	}
 
 	return block
 }
 
-// NewGenesisBlock creates and returns genesis Block
-func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, 0, []byte("NewGenesisBlock"))
-}
-
 // HashTransactions returns a hash of the transactions in the block
 func (b *Block) HashTransactions() []byte {
+	return b.MerkleTree().RootNode.Data
+}
+
+// MerkleTree 区块交易的默克尔树，叶子是各交易的规范编码
+func (b *Block) MerkleTree() *MerkleTree {
 	var transactions [][]byte
 
 	for _, tx := range b.Transactions {
 		transactions = append(transactions, tx.Serialize())
 	}
-	mTree := NewMerkleTree(transactions)
 
-	return mTree.RootNode.Data
+	return NewMerkleTree(transactions)
 }
 
-// Serialize 这个函数是 `Block` 结构体的方法，用于将区块对象序列化为字节数组。
-//以下是这个方法的功能和步骤解释：
-//1. 创建一个空的字节缓冲区 `result` 用于存储序列化后的数据。
-//2. 使用 `gob.NewEncoder` 创建一个编码器 `encoder`，用于将数据编码为字节序列。
-//3. 将区块对象 `b` 通过编码器进行编码，将编码后的结果存储在字节缓冲区 `result` 中。
-//4. 如果在编码过程中出现错误，即无法将区块对象编码为字节序列，将触发 Panic。
-//5. 返回字节缓冲区 `result` 的内容作为序列化后的区块数据。
-//总的来说，这个方法的目的是将区块对象序列化为字节数组，以便将区块数据存储在文件中、通过网络传输或进行其他需要持久化和传输的操作。
-func (b *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
+// TransactionProof 区块中交易 txID 的默克尔包含证明
+func (b *Block) TransactionProof(txID []byte) (*MerkleProof, error) {
+	for i, tx := range b.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			return b.MerkleTree().Proof(i)
+		}
+	}
 
-	err := encoder.Encode(b)
-	if err != nil {
-		log.Panic(err)
+	return nil, fmt.Errorf("transaction %x is not in block %x", txID, b.Hash)
+}
+
+// BlockHeader 区块头，字段与 HeaderBytes 的编码一一对应，轻节点只凭它和默克尔证明就能验证交易
+type BlockHeader struct {
+	PrevBlockHash  []byte
+	MerkleRoot     []byte
+	Timestamp      int64
+	TargetBits     int
+	Nonce          int
+	Height         int
+	DataHash       []byte
+	UTXOCommitment []byte
+}
+
+// Header 返回区块头
+func (b *Block) Header() *BlockHeader {
+	dataHash := sha256.Sum256(b.Data)
+
+	return &BlockHeader{
+		PrevBlockHash:  b.PrevBlockHash,
+		MerkleRoot:     b.HashTransactions(),
+		Timestamp:      b.Timestamp,
+		TargetBits:     chainParams.TargetBits,
+		Nonce:          b.Nonce,
+		Height:         b.Height,
+		DataHash:       dataHash[:],
+		UTXOCommitment: b.UTXOCommitment,
 	}
+}
 
-	return result.Bytes()
+// Bytes 区块头的规范编码，格式见 encoding.go
+func (h *BlockHeader) Bytes() []byte {
+	var buff bytes.Buffer
+
+	writeUint32(&buff, encodingVersion)
+	writeVarBytes(&buff, h.PrevBlockHash)
+	buff.Write(h.MerkleRoot)
+	writeInt64(&buff, h.Timestamp)
+	writeUint32(&buff, uint32(h.TargetBits))
+	writeInt64(&buff, int64(h.Nonce))
+	writeInt64(&buff, int64(h.Height))
+	buff.Write(h.DataHash)
+	writeCommitment(&buff, h.UTXOCommitment)
+
+	return buff.Bytes()
 }
 
-// DeserializeBlock 这个函数是用于反序列化字节数组为区块对象的操作。
-//以下是这个函数的功能和步骤解释：
-//1. 创建一个新的空区块对象 `block`，用于存储反序列化后的数据。
-//2. 使用 `gob.NewDecoder` 创建一个解码器 `decoder`，用于将字节数组解码为区块对象。
-//3. 将字节数组 `d` 通过解码器进行解码，将解码后的数据存储在区块对象 `block` 中。
-//4. 如果在解码过程中出现错误，即无法将字节数组解码为区块对象，将触发 Panic。
-//5. 返回区块对象 `block` 的指针，指向反序列化后的区块数据。
-//总的来说，这个函数的目的是将字节数组反序列化为区块对象，以便从文件中读取区块数据、接收网络传输的区块数据或进行其他需要解析和操作区块数据的操作。
-func DeserializeBlock(d []byte) *Block {
-	var block Block
+// Hash 区块头的哈希，即区块哈希
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Bytes())
 
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&block)
-	if err == io.EOF {
+	return hash[:]
+}
+
+// HeaderBytes 返回以 nonce 为随机数的规范区块头编码，区块哈希和工作量证明都基于它计算，格式见 encoding.go
+func (b *Block) HeaderBytes(nonce int) []byte {
+	header := b.Header()
+	header.Nonce = nonce
+
+	return header.Bytes()
+}
+
+// ComputeHash 按当前 Nonce 重新计算区块哈希
+func (b *Block) ComputeHash() []byte {
+	hash := sha256.Sum256(b.HeaderBytes(b.Nonce))
+
+	return hash[:]
+}
+
+// Serialize 按规范二进制编码序列化区块，格式见 encoding.go
+func (b *Block) Serialize() []byte {
+	return encodeBlock(b)
+}
+
+// DeserializeBlock 将规范编码的字节数组反序列化为区块，数据为空时返回 nil，格式错误时触发 panic。
+func DeserializeBlock(d []byte) *Block {
+	if len(d) == 0 {
 		fmt.Println("DeserializeBlock reached EOF")
 		return nil
-	} else if err != nil {
+	}
+
+	block, err := decodeBlock(d)
+	if err != nil {
 		log.Panic("DeserializeBlock:", err)
 	}
 
-	return &block
+	return block
 }
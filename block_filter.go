@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 紧凑区块过滤器
+//
+// 每个区块有一个 GCS 过滤器（见 gcs.go），元素为区块中所有输出的 PubKeyHash 和非奖励交易输入花费的
+// 输出点（Txid || uint32 小端序 Vout），SipHash 的 key 取区块哈希的前 16 字节。
+// 过滤器头 = SHA256(SHA256(过滤器) || 上一个区块的过滤器头)，创世区块的上一个过滤器头为 32 个零字节，
+// 轻节点据此确认收到的过滤器前后相连、没有被替换。
+// 全节点在第一次被请求时为已有的区块建立过滤器，之后接入区块时一起写入，每次请求前补上缺少的过滤器
+
+const (
+	cfilterBucket  = "cfilters"  // 区块哈希 -> 过滤器
+	cfheaderBucket = "cfheaders" // 区块哈希 -> 过滤器头
+)
+
+// maxFiltersPerMessage 一条 cfilter 消息最多携带的过滤器数，收到满额时轻节点继续请求
+const maxFiltersPerMessage = 1000
+
+type getcfilters struct {
+	AddrFrom string
+	Height   int
+}
+
+// cfilter 高度 Height+1 开始的连续区块的过滤器
+type cfilter struct {
+	AddrFrom    string
+	Height      int
+	PrevHeader  []byte // 高度 Height 的过滤器头
+	BlockHashes [][]byte
+	Filters     [][]byte
+}
+
+// outpointBytes 过滤器中的输出点元素
+func outpointBytes(txID []byte, vout int) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(vout))
+
+	return append(append([]byte(nil), txID...), b[:]...)
+}
+
+// blockFilterElements 区块过滤器的元素，去掉重复和空的公钥哈希
+func blockFilterElements(block *Block) [][]byte {
+	seen := make(map[string]bool)
+	var items [][]byte
+	add := func(item []byte) {
+		if len(item) > 0 && !seen[string(item)] {
+			seen[string(item)] = true
+			items = append(items, item)
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		for _, out := range tx.Vout {
+			add(out.PubKeyHash)
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Vin {
+			add(outpointBytes(in.Txid, in.Vout))
+		}
+	}
+
+	return items
+}
+
+// filterKey 区块过滤器的 SipHash key
+func filterKey(blockHash []byte) []byte {
+	key := make([]byte, 16)
+	copy(key, blockHash)
+
+	return key
+}
+
+// NewBlockFilter 构建区块的过滤器
+func NewBlockFilter(block *Block) []byte {
+	return BuildGCS(filterKey(block.Hash), blockFilterElements(block))
+}
+
+// MatchBlockFilter 区块过滤器是否可能包含 items 中的公钥哈希或输出点
+func MatchBlockFilter(blockHash, filter []byte, items [][]byte) (bool, error) {
+	return MatchGCS(filterKey(blockHash), filter, items)
+}
+
+// FilterHeader 由过滤器和上一个过滤器头计算过滤器头
+func FilterHeader(filter, prevHeader []byte) []byte {
+	filterHash := sha256.Sum256(filter)
+	hash := sha256.Sum256(append(filterHash[:], prevHeader...))
+
+	return hash[:]
+}
+
+// IndexFilters 为还没有过滤器的区块建立过滤器：从链尾往前找到最后一个已建立的区块，再按高度升序补齐
+func (bc *Blockchain) IndexFilters() error {
+	var missing []*Block
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+		if block == nil {
+			break
+		}
+		header, err := bc.FilterHeader(block.Hash)
+		if err != nil {
+			return err
+		}
+		if header != nil {
+			break
+		}
+		missing = append(missing, block)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return bc.db.Update(func(tx StoreTx) error {
+		for i := len(missing) - 1; i >= 0; i-- {
+			block := missing[i]
+			if len(block.PrevBlockHash) > 0 && indexesOf(tx).Get(cfheaderBucket, block.PrevBlockHash) == nil {
+				return fmt.Errorf("no filter header for parent of block %x", block.Hash)
+			}
+			if err := putBlockFilter(tx, block); err != nil {
+				return err
+			}
+		}
+		logDebugf("built compact filters for %d blocks", len(missing))
+
+		return nil
+	})
+}
+
+// putBlockFilter 写入区块的过滤器和过滤器头，上一个区块还没有过滤器头时跳过，留给 IndexFilters
+func putBlockFilter(tx StoreTx, block *Block) error {
+	indexes := indexesOf(tx)
+	prevHeader := make([]byte, sha256.Size)
+	if len(block.PrevBlockHash) > 0 {
+		prevHeader = indexes.Get(cfheaderBucket, block.PrevBlockHash)
+		if prevHeader == nil {
+			return nil
+		}
+	}
+	filter := NewBlockFilter(block)
+	if err := indexes.Put(cfilterBucket, block.Hash, filter); err != nil {
+		return err
+	}
+
+	return indexes.Put(cfheaderBucket, block.Hash, FilterHeader(filter, prevHeader))
+}
+
+// FilterHeader 区块的过滤器头，还没有建立时返回 nil
+func (bc *Blockchain) FilterHeader(hash []byte) ([]byte, error) {
+	return bc.filterData(cfheaderBucket, hash)
+}
+
+// BlockFilter 区块的过滤器，还没有建立时返回 nil
+func (bc *Blockchain) BlockFilter(hash []byte) ([]byte, error) {
+	return bc.filterData(cfilterBucket, hash)
+}
+
+func (bc *Blockchain) filterData(bucket string, hash []byte) ([]byte, error) {
+	var data []byte
+	err := bc.db.View(func(tx StoreTx) error {
+		data = indexesOf(tx).Get(bucket, hash)
+		return nil
+	})
+
+	return data, err
+}
+
+// FiltersAfter 按高度升序返回高度大于 height 的区块的过滤器，最多 max 个，以及高度 height 的过滤器头
+func (bc *Blockchain) FiltersAfter(height, max int) (*cfilter, error) {
+	if err := bc.IndexFilters(); err != nil {
+		return nil, err
+	}
+
+	headers, _, err := bc.HeadersAfter(height, max)
+	if err != nil {
+		return nil, err
+	}
+	reply := &cfilter{Height: height, PrevHeader: make([]byte, sha256.Size)}
+	if len(headers) == 0 {
+		return reply, nil
+	}
+	if prev := headers[0].PrevBlockHash; len(prev) > 0 {
+		if reply.PrevHeader, err = bc.FilterHeader(prev); err != nil {
+			return nil, err
+		}
+	}
+	for _, header := range headers {
+		hash := header.Hash()
+		filter, err := bc.BlockFilter(hash)
+		if err != nil {
+			return nil, err
+		}
+		if filter == nil {
+			return nil, fmt.Errorf("no filter for block %x", hash)
+		}
+		reply.BlockHashes = append(reply.BlockHashes, hash)
+		reply.Filters = append(reply.Filters, filter)
+	}
+
+	return reply, nil
+}
+
+// verifyFilterChain 由 prevHeader 依次计算 filters 的过滤器头
+func verifyFilterChain(prevHeader []byte, filters [][]byte) ([][]byte, error) {
+	if len(prevHeader) != sha256.Size {
+		return nil, errors.New("invalid previous filter header")
+	}
+
+	var headers [][]byte
+	for _, filter := range filters {
+		prevHeader = FilterHeader(filter, prevHeader)
+		headers = append(headers, prevHeader)
+	}
+
+	return headers, nil
+}
+
+func sendGetCFilters(address string, height int) {
+	payload := gobEncode(getcfilters{nodeAddress, height})
+	request := append(commandToBytes("getcfilters"), payload...)
+
+	sendData(address, request)
+}
+
+// handleGetCFilters 全节点回复区块过滤器
+func handleGetCFilters(chains *ChainService, request []byte) {
+	var payload getcfilters
+	decodePayload(request, &payload)
+
+	bc := chains.Blockchain(NodeIPAddress)
+	if bc == nil {
+		return
+	}
+	defer chains.Release(bc)
+
+	reply, err := bc.FiltersAfter(payload.Height, maxFiltersPerMessage)
+	if err != nil {
+		logWarnf("filters for %s: %v", payload.AddrFrom, err)
+		return
+	}
+	reply.AddrFrom = nodeAddress
+	logDebugf("send %d filters after height %d to %s", len(reply.Filters), payload.Height, payload.AddrFrom)
+
+	sendData(payload.AddrFrom, append(commandToBytes("cfilter"), gobEncode(*reply)...))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSipHash(t *testing.T) {
+	// SipHash-2-4 论文附录的测试向量，key 为 00 01 ... 0f，消息为 00 01 ... (n-1)
+	var key [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+	message := make([]byte, 15)
+	for i := range message {
+		message[i] = byte(i)
+	}
+
+	assert.Equal(t, uint64(0x726fdb47dd0e0e31), sipHash(k0, k1, nil))
+	assert.Equal(t, uint64(0xa129ca6149be45e5), sipHash(k0, k1, message))
+}
+
+func TestGCS(t *testing.T) {
+	key := make([]byte, 16)
+	var items, others [][]byte
+	for i := 0; i < 500; i++ {
+		items = append(items, []byte(fmt.Sprintf("item %d", i)))
+		others = append(others, []byte(fmt.Sprintf("other %d", i)))
+	}
+	filter := BuildGCS(key, items)
+	assert.Less(t, len(filter), len(items)*3, "about P+2 bits per element")
+
+	for _, item := range items {
+		matched, err := MatchGCS(key, filter, [][]byte{item})
+		assert.Nil(t, err)
+		assert.True(t, matched)
+	}
+	falsePositives := 0
+	for _, item := range others {
+		matched, err := MatchGCS(key, filter, [][]byte{item})
+		assert.Nil(t, err)
+		if matched {
+			falsePositives++
+		}
+	}
+	assert.LessOrEqual(t, falsePositives, 1)
+
+	matched, err := MatchGCS(key, filter, append(others[:10:10], items[42]))
+	assert.Nil(t, err)
+	assert.True(t, matched, "any of the items")
+
+	otherKey := sha256.Sum256([]byte("key"))
+	matched, err = MatchGCS(otherKey[:16], filter, items[:1])
+	assert.Nil(t, err)
+	assert.False(t, matched, "filters are keyed")
+
+	empty := BuildGCS(key, nil)
+	matched, err = MatchGCS(key, empty, items)
+	assert.Nil(t, err)
+	assert.False(t, matched)
+
+	_, err = MatchGCS(key, filter[:len(filter)/2], others)
+	assert.NotNil(t, err, "truncated filter")
+}
+
+func TestBlockFilters(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+	lc := testLightClient(t)
+
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	chainParams.GenesisHash = hex.EncodeToString(genesis.Hash)
+	bc := testChain(t, genesis)
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+	other := string(NewWallet().GetAddress())
+	_, err = bc.Generate(chainParams.CoinbaseMaturity, other)
+	assert.Nil(t, err)
+
+	// 花费创世区块的奖励
+	wallets := &Wallets{Wallets: map[string]*Wallet{address: wallet}}
+	tx := NewUTXOTransaction(wallets, address, other, 5, &UTXOSet, DefaultSendOptions())
+	spend := bc.MineBlock([]*Transaction{bc.NewRewardTX(other, []*Transaction{tx}), tx})
+	UTXOSet.Update(spend)
+
+	msg, err := bc.FiltersAfter(-1, maxFiltersPerMessage)
+	assert.Nil(t, err)
+	assert.Len(t, msg.Filters, bc.GetBestHeight()+1)
+	assert.Equal(t, genesis.Hash, msg.BlockHashes[0])
+	prev := make([]byte, sha256.Size)
+	for i, hash := range msg.BlockHashes {
+		header, err := bc.FilterHeader(hash)
+		assert.Nil(t, err)
+		assert.Equal(t, FilterHeader(msg.Filters[i], prev), header)
+		prev = header
+	}
+
+	matched, err := MatchBlockFilter(genesis.Hash, msg.Filters[0], [][]byte{pubKeyHash})
+	assert.Nil(t, err)
+	assert.True(t, matched, "genesis pays the wallet")
+	spent := outpointBytes(genesis.Transactions[0].ID, 0)
+	matched, err = MatchBlockFilter(spend.Hash, msg.Filters[len(msg.Filters)-1], [][]byte{spent})
+	assert.Nil(t, err)
+	assert.True(t, matched, "the last block spends the genesis output")
+
+	// 新区块在下次请求时补上，之前的过滤器头不变
+	_, err = bc.Generate(2, other)
+	assert.Nil(t, err)
+	next, err := bc.FiltersAfter(spend.Height, maxFiltersPerMessage)
+	assert.Nil(t, err)
+	assert.Len(t, next.Filters, 2)
+	assert.Equal(t, prev, next.PrevHeader)
+
+	// 轻节点验证过滤器头链
+	headers, qcs, err := bc.HeadersAfter(-1, maxHeadersPerMessage)
+	assert.Nil(t, err)
+	assert.Nil(t, lc.AddHeaders(headers, qcs))
+	assert.NotNil(t, lc.AddFilters(next), "does not extend the saved filter headers")
+
+	tampered := *msg
+	tampered.Filters = append([][]byte(nil), msg.Filters...)
+	tampered.Filters[1] = BuildGCS(filterKey(msg.BlockHashes[1]), nil)
+	assert.Nil(t, lc.AddFilters(&tampered), "a consistent chain on its own")
+	assert.NotNil(t, lc.AddFilters(msg), "conflicts with the saved filter headers")
+	assert.NotNil(t, lc.AddFilters(next), "the previous filter header differs")
+
+	lc = testLightClient(t)
+	assert.Nil(t, lc.AddHeaders(headers, qcs))
+	assert.Nil(t, lc.AddFilters(msg))
+	assert.Nil(t, lc.AddFilters(next))
+	assert.Nil(t, lc.AddFilters(msg), "overlapping filters")
+	assert.Equal(t, bc.GetBestHeight(), lc.FilterHeight())
+
+	// 轻节点只用过滤器找到涉及钱包的区块
+	pubKeyHashes := [][]byte{pubKeyHash}
+	first, err := lc.MatchFilters(msg, pubKeyHashes, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, first)
+	items, err := bc.FindProofs(pubKeyHashes, -1)
+	assert.Nil(t, err)
+	added, err := lc.AddProofs(items[len(items)-1:])
+	assert.Nil(t, err)
+	assert.Equal(t, 1, added, "only the genesis reward")
+	after, err := lc.MatchFilters(msg, pubKeyHashes, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, spend.Height, after, "the spent outpoint")
+	none, err := lc.MatchFilters(next, pubKeyHashes, spend.Height)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, none)
+}
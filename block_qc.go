@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const qcBucket = "qcs"
+
+// BlockQC hotstuff 区块的法定人数证书。对命令达成共识、区块上链后，领导者把区块头发给投过票的节点，
+// 每张票都是验证者用网络参数中配置的公钥（ChainParams.ValidatorKeys）对区块头哈希的签名，
+// 轻节点只凭区块头和证书就能确认区块得到了验证者的认可
+type BlockQC struct {
+	Votes []QCVote
+}
+
+// QCVote 证书中的一张投票
+type QCVote struct {
+	NodeID string
+	PubKey []byte
+	R      []byte
+	S      []byte
+}
+
+// newBlockQC 由验证者对区块头的签名生成证书，按 NodeID 排序
+func newBlockQC(votes map[string]QCVote) *BlockQC {
+	qc := &BlockQC{}
+	for _, vote := range votes {
+		qc.Votes = append(qc.Votes, vote)
+	}
+	sort.Slice(qc.Votes, func(i, j int) bool { return qc.Votes[i].NodeID < qc.Votes[j].NodeID })
+
+	return qc
+}
+
+// Serialize 证书的规范编码，格式见 encoding.go
+func (qc *BlockQC) Serialize() []byte {
+	return encodeQC(qc)
+}
+
+// DeserializeBlockQC 解码 Serialize 的结果
+func DeserializeBlockQC(data []byte) (*BlockQC, error) {
+	return decodeQC(data)
+}
+
+// qcQuorum 分片 shard 的证书至少需要的有效票数：该分片验证者公钥数的三分之二以上
+func qcQuorum(shard int) int {
+	validators := 0
+	for nodeID := range chainParams.ValidatorKeys {
+		if chainParams.validatorShard(nodeID) == shard {
+			validators++
+		}
+	}
+
+	return validators*2/3 + 1
+}
+
+// checkQuorum 检查签名的验证者 signers 中是否有同一分片的验证者达到该分片的 qcQuorum
+func checkQuorum(signers map[string]bool) error {
+	counts := make(map[int]int)
+	for nodeID := range signers {
+		counts[chainParams.validatorShard(nodeID)]++
+	}
+	best, required := 0, qcQuorum(0)
+	for shard, count := range counts {
+		if count >= qcQuorum(shard) {
+			return nil
+		}
+		if count > best {
+			best, required = count, qcQuorum(shard)
+		}
+	}
+
+	return fmt.Errorf("quorum certificate has %d valid votes of one shard, %d required", best, required)
+}
+
+// verify 检查投票是节点配置的验证者公钥对区块头哈希 hash 的签名
+func (vote QCVote) verify(hash []byte) bool {
+	key := chainParams.validatorKey(vote.NodeID)
+	if key == nil || !bytes.Equal(vote.PubKey, key) {
+		return false
+	}
+	pubKey := unmarshalPubKey(key)
+
+	return ecdsa.Verify(&pubKey, hash, new(big.Int).SetBytes(vote.R), new(big.Int).SetBytes(vote.S))
+}
+
+// Verify 检查证书中来自同一分片不同验证者的有效签名是否达到 qcQuorum，没有配置验证者公钥时任何证书都无效
+func (qc *BlockQC) Verify(header *BlockHeader) error {
+	if qc == nil {
+		return errors.New("block has no quorum certificate")
+	}
+	if len(chainParams.ValidatorKeys) == 0 {
+		return errors.New("no validator keys are configured")
+	}
+
+	hash := header.Hash()
+	signers := make(map[string]bool)
+	for _, vote := range qc.Votes {
+		if !signers[vote.NodeID] && vote.verify(hash) {
+			signers[vote.NodeID] = true
+		}
+	}
+
+	return checkQuorum(signers)
+}
+
+// PutBlockQC 保存区块的法定人数证书
+func (bc *Blockchain) PutBlockQC(hash []byte, qc *BlockQC) error {
+	return bc.db.Update(func(tx StoreTx) error {
+		return indexesOf(tx).Put(qcBucket, hash, qc.Serialize())
+	})
+}
+
+// BlockQC 区块的法定人数证书，没有时返回 nil
+func (bc *Blockchain) BlockQC(hash []byte) (*BlockQC, error) {
+	var data []byte
+	err := bc.db.View(func(tx StoreTx) error {
+		data = indexesOf(tx).Get(qcBucket, hash)
+		return nil
+	})
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	return DeserializeBlockQC(data)
+}
+
+// certify 共识达成、区块上链后为区块收集验证者对区块头的签名：本节点先签，再把区块头发给投过票的节点，
+// 签名达到 qcQuorum 时保存证书，供轻节点验证区块头。chainID 是 bc 的节点 ID，收到签名时据此打开区块链
+func (vc *VoteCollector) certify(bc *Blockchain, chainID string, block *Block) {
+	var voters []string
+	for nodeID := range vc.votes {
+		voters = append(voters, nodeID)
+	}
+	certifyBlock(bc, chainID, block, voters)
+}
+
+// certifyBlock 同 certify，把区块头发给 voters 和区块所在分片的节点，只有对区块命令投过票、
+// 区块已经接入本地主链的节点会签名。本节点只为自己的区块链上的区块签名
+func certifyBlock(bc *Blockchain, chainID string, block *Block, voters []string) {
+	header := block.Header()
+	headerSignatures.start(chainID, header)
+	if chainID == NodeIPAddress {
+		if vote, err := signMainChainHeader(bc, header); err != nil {
+			logDebugf("sign header of block %x: %v", block.Hash, err)
+		} else if qc := headerSignatures.add(block.Hash, vote); qc != nil {
+			saveBlockQC(bc, block.Hash, qc)
+		}
+	}
+
+	// 跨分片命令达成共识时，本分片的节点可能在领导者提交区块之后才投票，所以也发给它们
+	for _, nodes := range knownShardingNodes {
+		for _, nodeID := range nodes {
+			if nodeID == chainID {
+				voters = append(voters, nodes...)
+				break
+			}
+		}
+	}
+	sent := make(map[string]bool)
+	for _, nodeID := range voters {
+		if nodeID != NodeIPAddress && !sent[nodeID] {
+			sent[nodeID] = true
+			sendSignHeader(strings.Replace(nodeID, " ", ":", -1), header)
+		}
+	}
+}
+
+// saveBlockQC 保存收齐的证书，区块是跨分片转账的扣款区块时接着把入账证明发给目标分片
+func saveBlockQC(bc *Blockchain, hash []byte, qc *BlockQC) {
+	if err := bc.PutBlockQC(hash, qc); err != nil {
+		logWarnf("save quorum certificate of block %x: %v", hash, err)
+	}
+	releaseCredit(hash, qc)
+}
+
+// headerSigPool 领导者正在收集签名的区块头，按区块哈希索引
+type headerSigPool struct {
+	mu      sync.Mutex
+	pending map[string]*pendingQC
+	order   []string
+}
+
+// pendingQC 一个区块头已经收到的签名
+type pendingQC struct {
+	chainID string
+	header  *BlockHeader
+	votes   map[string]QCVote
+	done    bool
+}
+
+// maxPendingQCs 最多同时收集签名的区块数，超出时丢弃最早的
+const maxPendingQCs = 100
+
+var headerSignatures = &headerSigPool{pending: make(map[string]*pendingQC)}
+
+func (p *headerSigPool) start(chainID string, header *BlockHeader) *pendingQC {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := string(header.Hash())
+	if pending, ok := p.pending[key]; ok {
+		return pending
+	}
+	if len(p.order) >= maxPendingQCs {
+		delete(p.pending, p.order[0])
+		p.order = p.order[1:]
+	}
+	pending := &pendingQC{chainID: chainID, header: header, votes: make(map[string]QCVote)}
+	p.pending[key] = pending
+	p.order = append(p.order, key)
+
+	return pending
+}
+
+// add 加入一张有效的签名，签名第一次达到 qcQuorum 时返回证书，其余情况返回 nil
+func (p *headerSigPool) add(hash []byte, vote QCVote) *BlockQC {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := p.pending[string(hash)]
+	if pending == nil || pending.done || !vote.verify(hash) {
+		return nil
+	}
+	pending.votes[vote.NodeID] = vote
+	signers := make(map[string]bool)
+	for nodeID := range pending.votes {
+		signers[nodeID] = true
+	}
+	if checkQuorum(signers) != nil {
+		return nil
+	}
+	pending.done = true
+
+	return newBlockQC(pending.votes)
+}
+
+// chainOf 收集签名的区块所在区块链的节点 ID
+func (p *headerSigPool) chainOf(hash []byte) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pending := p.pending[string(hash)]; pending != nil {
+		return pending.chainID
+	}
+
+	return ""
+}
+
+// maxVotedCommands 最多记住的投票、签名和等待区块的签名请求数，超出时忘掉最早的
+const maxVotedCommands = 1000
+
+// votedCommands 每个节点投过、还没有用来签名的同意票数，键为 voteKey。每张票只能为一个区块头签名
+var votedCommands = struct {
+	sync.Mutex
+	votes map[string]int
+	order []string
+}{votes: make(map[string]int)}
+
+// voteKey 节点 nodeID 对 SHA256 为 dataHash 的命令的投票记录的键
+func voteKey(nodeID string, dataHash []byte) string {
+	return nodeID + " " + string(dataHash)
+}
+
+// rememberVote 记录本节点对命令 command 投了一张同意票
+func rememberVote(command string) {
+	hash := sha256.Sum256([]byte(command))
+	key := voteKey(NodeIPAddress, hash[:])
+
+	votedCommands.Lock()
+	defer votedCommands.Unlock()
+	if _, ok := votedCommands.votes[key]; !ok {
+		if len(votedCommands.order) >= maxVotedCommands {
+			delete(votedCommands.votes, votedCommands.order[0])
+			votedCommands.order = votedCommands.order[1:]
+		}
+		votedCommands.order = append(votedCommands.order, key)
+	}
+	votedCommands.votes[key]++
+}
+
+// takeVote 用掉一张本节点对区块头命令的同意票，没有票时返回 false。
+// 跨分片命令在两个分片各有一个区块，两个分片的验证者各自用自己的票签本分片的区块头
+func takeVote(header *BlockHeader) bool {
+	key := voteKey(NodeIPAddress, header.DataHash)
+
+	votedCommands.Lock()
+	defer votedCommands.Unlock()
+	if votedCommands.votes[key] == 0 {
+		return false
+	}
+	votedCommands.votes[key]--
+
+	return true
+}
+
+// signedHeaders 每个节点在每个高度签过的区块头哈希，键为 "节点 ID 高度"
+var signedHeaders = struct {
+	sync.Mutex
+	hashes map[string][]byte
+	order  []string
+}{hashes: make(map[string][]byte)}
+
+// allowSigning 检查本节点能否为区块头签名并记下这次签名：同一高度只签一个区块头，签过的区块头可以再签；
+// 签新的区块头要用掉一张对它的命令的同意票
+func allowSigning(header *BlockHeader) error {
+	hash := header.Hash()
+	key := fmt.Sprintf("%s %d", NodeIPAddress, header.Height)
+
+	signedHeaders.Lock()
+	defer signedHeaders.Unlock()
+	if signed, ok := signedHeaders.hashes[key]; ok {
+		if bytes.Equal(signed, hash) {
+			return nil
+		}
+		return fmt.Errorf("already signed header %x at height %d", signed, header.Height)
+	}
+	if !takeVote(header) {
+		return errors.New("this node has no unused vote for the command of the header")
+	}
+	if len(signedHeaders.order) >= maxVotedCommands {
+		delete(signedHeaders.hashes, signedHeaders.order[0])
+		signedHeaders.order = signedHeaders.order[1:]
+	}
+	signedHeaders.hashes[key] = hash
+	signedHeaders.order = append(signedHeaders.order, key)
+
+	return nil
+}
+
+var errNotOnMainChain = errors.New("header is not on the main chain")
+
+// onMainChain 区块头是否就是本地主链上同一高度的区块的区块头
+func (bc *Blockchain) onMainChain(header *BlockHeader) bool {
+	found := false
+	bc.db.View(func(tx StoreTx) error {
+		current := headerIn(tx, blocksOf(tx).Tip())
+		for current != nil && current.Height > header.Height {
+			current = headerIn(tx, current.PrevBlockHash)
+		}
+		found = current != nil && current.Height == header.Height && bytes.Equal(current.Bytes(), header.Bytes())
+		return nil
+	})
+
+	return found
+}
+
+// signMainChainHeader 区块头在本地主链上并且 allowSigning 允许时签名
+func signMainChainHeader(bc *Blockchain, header *BlockHeader) (QCVote, error) {
+	if !bc.onMainChain(header) {
+		return QCVote{}, errNotOnMainChain
+	}
+	if err := allowSigning(header); err != nil {
+		return QCVote{}, err
+	}
+
+	return signHeader(header)
+}
+
+// signHeader 用本节点钱包中与 validatorKeys 配置一致的密钥对区块头哈希签名
+func signHeader(header *BlockHeader) (QCVote, error) {
+	key := chainParams.validatorKey(NodeIPAddress)
+	if key == nil {
+		return QCVote{}, fmt.Errorf("%s is not a validator", NodeIPAddress)
+	}
+	wallets, err := NewWallets(NodeIPAddress)
+	if err != nil {
+		return QCVote{}, err
+	}
+	for _, wallet := range wallets.Wallets {
+		if !bytes.Equal(wallet.PublicKey, key) {
+			continue
+		}
+		if wallet.IsLocked() {
+			return QCVote{}, errWalletLocked
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, &wallet.PrivateKey, header.Hash())
+		if err != nil {
+			return QCVote{}, err
+		}
+		return QCVote{NodeIPAddress, key, r.Bytes(), s.Bytes()}, nil
+	}
+
+	return QCVote{}, errors.New("the validator key is not in the wallet")
+}
+
+type signheader struct {
+	AddrFrom string
+	Header   []byte
+}
+
+type headersig struct {
+	BlockHash []byte
+	Vote      QCVote
+}
+
+func sendSignHeader(address string, header *BlockHeader) {
+	payload := gobEncode(signheader{nodeAddress, header.Bytes()})
+	sendData(address, append(commandToBytes("signheader"), payload...))
+}
+
+// pendingSignRequests 区块还没有接入本地主链时收到的签名请求，键为节点 ID 和区块哈希，区块接入后由 signPendingHeader 处理
+var pendingSignRequests = struct {
+	sync.Mutex
+	requests map[string]signheader
+	order    []string
+}{requests: make(map[string]signheader)}
+
+// handleSignHeader 为本地主链上的、投过票的命令的区块头签名并发回领导者。区块可能还在同步，
+// 不在主链上的请求先保存，区块接入后再签
+func handleSignHeader(chains *ChainService, request []byte) {
+	var payload signheader
+	decodePayload(request, &payload)
+
+	header, err := decodeHeader(payload.Header)
+	if err != nil {
+		logWarnf("header to sign from %s: %v", payload.AddrFrom, err)
+		return
+	}
+	bc := chains.Blockchain(NodeIPAddress)
+	if bc == nil {
+		return
+	}
+	defer chains.Release(bc)
+
+	if !bc.onMainChain(header) {
+		key := NodeIPAddress + " " + string(header.Hash())
+		pendingSignRequests.Lock()
+		if _, ok := pendingSignRequests.requests[key]; !ok {
+			if len(pendingSignRequests.order) >= maxVotedCommands {
+				delete(pendingSignRequests.requests, pendingSignRequests.order[0])
+				pendingSignRequests.order = pendingSignRequests.order[1:]
+			}
+			pendingSignRequests.order = append(pendingSignRequests.order, key)
+		}
+		pendingSignRequests.requests[key] = payload
+		pendingSignRequests.Unlock()
+		return
+	}
+	answerSignRequest(bc, header, payload.AddrFrom)
+}
+
+// signPendingHeader 区块接入后，为之前收到的这个区块的签名请求签名
+func signPendingHeader(bc *Blockchain, block *Block) {
+	key := NodeIPAddress + " " + string(block.Hash)
+	pendingSignRequests.Lock()
+	request, ok := pendingSignRequests.requests[key]
+	if ok {
+		delete(pendingSignRequests.requests, key)
+		for i, pending := range pendingSignRequests.order {
+			if pending == key {
+				pendingSignRequests.order = append(pendingSignRequests.order[:i], pendingSignRequests.order[i+1:]...)
+				break
+			}
+		}
+	}
+	pendingSignRequests.Unlock()
+	if !ok {
+		return
+	}
+
+	answerSignRequest(bc, block.Header(), request.AddrFrom)
+}
+
+// answerSignRequest 为区块头签名并发回请求的领导者 addrFrom，不能签时只记录日志
+func answerSignRequest(bc *Blockchain, header *BlockHeader, addrFrom string) {
+	vote, err := signMainChainHeader(bc, header)
+	if err != nil {
+		logWarnf("refuse to sign header %x from %s: %v", header.Hash(), addrFrom, err)
+		return
+	}
+
+	sendData(addrFrom, append(commandToBytes("headersig"), gobEncode(headersig{header.Hash(), vote})...))
+}
+
+// handleHeaderSig 收集区块头签名，达到 qcQuorum 时保存证书
+func handleHeaderSig(chains *ChainService, request []byte) {
+	var payload headersig
+	decodePayload(request, &payload)
+
+	chainID := headerSignatures.chainOf(payload.BlockHash)
+	qc := headerSignatures.add(payload.BlockHash, payload.Vote)
+	if qc == nil {
+		return
+	}
+	bc := chains.Blockchain(chainID)
+	if bc == nil {
+		return
+	}
+	defer chains.Release(bc)
+
+	saveBlockQC(bc, payload.BlockHash, qc)
+}
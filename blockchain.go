@@ -3,23 +3,34 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-
-	"github.com/boltdb/bolt"
+	"sync"
 )
 
 const dbFile = "blockchain_%s.db"
 const blocksBucket = "blocks"
 const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+const metaBucket = "meta"
+const storageFormatKey = "format"
+const chainstateVersionKey = "chainstate"
+
+// chainstateVersion UTXO 记录的格式版本，与库中记录的不同时 NewBlockchain 会重建 UTXO 集。
+// 版本 4 起 UTXO 承诺改为滚动承诺，旧版本记录的各区块承诺一并删除
+const chainstateVersion = 4
+
+// storageFormatVersion 区块库的编码格式版本：没有记录的旧库是 gob 编码，1 为 encoding.go 中的规范二进制编码
+const storageFormatVersion = 1
 
 // Blockchain implements interactions with a DB
 type Blockchain struct {
-	tip []byte
-	db  *bolt.DB
+	tip   []byte
+	db    Store
+	tipMu sync.RWMutex // ChainService 让多个 goroutine 共享同一个 Blockchain，tip 的读写要加锁
 }
 
 // CreateBlockchain 这段代码是一个 `CreateBlockchain` 函数，用于创建一个新的区块链，并返回一个指向该区块链的指针。
@@ -32,49 +43,53 @@ type Blockchain struct {
 //7. 在数据库的一个更新事务中，创建一个 bucket（类似于一个命名空间）用于存储区块，并将创世区块的哈希和序列化后的区块数据存储在该 bucket 中。还将创世区块的哈希存储在键为 "l" 的 entry 中，作为链的尖端。
 //8. 最后，返回一个指向新创建的区块链的指针。
 //总之，这个函数用于创建一个新的区块链，包括一个创世区块，并将创世区块的奖励发送到指定的地址。它还会在数据库中存储创世区块以及与之相关的信息。
-func CreateBlockchain(address, nodeID string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+//创世区块由网络参数 params 决定，见 ChainParams.NewGenesisBlock。
+func CreateBlockchain(address, nodeID string, params *ChainParams) *Blockchain {
+	dbFile := nodeDataFile(dbFile, nodeID)
 	if dbExists(dbFile) {
 		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
 	}
 
-	var tip []byte
+	genesis, err := params.NewGenesisBlock(address)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
-	genesis := NewGenesisBlock(cbtx)
+	db, err := openBoltStore(dbFile, 0600, 0)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	db, err := bolt.Open(dbFile, 0600, nil)
+	bc, err := createBlockchain(db, genesis)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucket([]byte(blocksBucket))
-		if err != nil {
-			log.Panic(err)
-		}
+	return bc
+}
 
-		err = b.Put(genesis.Hash, genesis.Serialize())
+// createBlockchain 在空的存储中写入创世区块
+func createBlockchain(db Store, genesis *Block) (*Blockchain, error) {
+	err := db.Update(func(tx StoreTx) error {
+		blocks, err := createBlockStore(tx)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-
-		err = b.Put([]byte("l"), genesis.Hash)
-		if err != nil {
-			log.Panic(err)
+		if err := blocks.Put(genesis); err != nil {
+			return err
+		}
+		if err := blocks.SetTip(genesis.Hash); err != nil {
+			return err
 		}
-		tip = genesis.Hash
 
-		return nil
+		return putStorageFormat(tx)
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	bc := Blockchain{tip, db}
-
-	return &bc
+	return &Blockchain{tip: genesis.Hash, db: db}, nil
 }
 
 // NewBlockchain 这段代码是用于创建新的区块链实例的函数 `NewBlockchain`。
@@ -91,7 +106,7 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 func NewBlockchain(nodeID string) *Blockchain {
 	//fmt.Println("NewBlockchain")
 	//fmt.Println("NewBlockchain-nodeID:", nodeID)
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	dbFile := nodeDataFile(dbFile, nodeID)
 	if dbExists(dbFile) == false {
 		fmt.Println("No existing blockchain found. Create one first.")
 		//os.Exit(1)
@@ -99,14 +114,18 @@ func NewBlockchain(nodeID string) *Blockchain {
 	}
 	fmt.Println("NewBlockchain-dbFile:", dbFile)
 	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := openBoltStore(dbFile, 0600, 0)
 	if err != nil {
 		log.Panic(err.Error())
 	}
 	//fmt.Println("NewBlockchain-db:", db)
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+	legacy := false
+	err = db.View(func(tx StoreTx) error {
+		if storageFormat(tx) != storageFormatVersion {
+			legacy = true
+			return nil
+		}
+		tip = blocksOf(tx).Tip()
 
 		return nil
 	})
@@ -114,8 +133,27 @@ func NewBlockchain(nodeID string) *Blockchain {
 	if err != nil {
 		log.Panic(err)
 	}
+	if legacy {
+		fmt.Println("Blockchain database uses the legacy gob encoding. Run migratedb first.")
+		db.Close()
+		return nil
+	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{tip: tip, db: db}
+	if bc.chainstateVersion() != chainstateVersion {
+		fmt.Println("UTXO set format changed, rebuilding it...")
+		err := db.Update(func(tx StoreTx) error {
+			if err := tx.DeleteBucket(utxoCommitBucket); err != nil && err != errBucketNotFound {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+		UTXOSet{&bc}.Reindex()
+	}
+	bc.repairChainstate()
 
 	return &bc
 }
@@ -125,23 +163,25 @@ func NewBlockchain(nodeID string) *Blockchain {
 func NewBlockchain0400(nodeID string) *Blockchain {
 	//fmt.Println("NewBlockchain0400")
 	//fmt.Println("NewBlockchain0400-nodeID:", nodeID)
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	dbFile := nodeDataFile(dbFile, nodeID)
 	if dbExists(dbFile) == false {
 		fmt.Println("No existing blockchain found. Create one first.")
 		os.Exit(1)
 	}
 	fmt.Println("NewBlockchain0400-dbFile:", dbFile)
 	var tip []byte
-	db, err := bolt.Open(dbFile, 0400, nil)
+	db, err := openBoltStore(dbFile, 0400, 0)
 	if err != nil {
 		fmt.Println("2")
 		log.Panic(err.Error())
 	}
 
 	fmt.Println("NewBlockchain-db:", db)
-	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+	err = db.View(func(tx StoreTx) error {
+		if storageFormat(tx) != storageFormatVersion {
+			return errors.New("blockchain database uses the legacy gob encoding, run migratedb first")
+		}
+		tip = blocksOf(tx).Tip()
 
 		return nil
 	})
@@ -150,7 +190,7 @@ func NewBlockchain0400(nodeID string) *Blockchain {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{tip: tip, db: db}
 
 	return &bc
 }
@@ -163,57 +203,11 @@ func NewBlockchain0400(nodeID string) *Blockchain {
 //5. 将区块数据存储到数据库中，键为区块的哈希值，值为序列化后的区块数据。
 //6. 获取当前链中的最新区块（通过获取名为 "l" 的键来获取最新区块的哈希值）。
 //7. 根据区块高度比较新区块和最新区块的高度，如果新区块的高度更高，则更新最新区块的哈希值。
-//8. 提交数据库事务，将写入的数据永久保存到数据库中。
+//8. 新区块成为链尾时，UTXO 集的改动和撤销数据也在同一个事务中写入（见 chainstate.go），然后提交数据库事务。
 //这个方法的目的是确保区块链中的区块是有序的，并且保持最新区块的引用，以便在添加新区块时更新。
 func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
-
-		if blockInDb != nil {
-			return nil
-		}
+	if bc.connect(block) {
 		fmt.Println("Add New block", hex.EncodeToString(block.Hash))
-		//遍历区块中的交易
-		//for _, tx := range block.Transactions {
-		//	//输出交易
-		//	fmt.Println("tx.ID", hex.EncodeToString(tx.ID))
-		//	//遍历交易中的输入
-		//	for _, vin := range tx.Vin {
-		//		//输出交易中的输入
-		//		fmt.Println("vin.Txid", hex.EncodeToString(vin.Txid))
-		//		fmt.Println("vin.Vout", vin.Vout)
-		//	}
-		//	//遍历交易中的输出
-		//	for _, vout := range tx.Vout {
-		//		//输出交易中的输出
-		//		fmt.Println("vout.Value", vout.Value)
-		//	}
-		//}
-
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
-
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			bc.tip = block.Hash
-		}
-		//UTXOSet := UTXOSet{bc}
-		//UTXOSet.Update(block)
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
 	}
 }
 
@@ -225,6 +219,9 @@ func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 	for {
 		block := bci.Next()
 		//fmt.Println("-----------------block := bci.Next()-----------------------")
+		if block == nil {
+			break
+		}
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, ID) == 0 {
 				return *tx, nil
@@ -236,17 +233,54 @@ func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 		}
 	}
 	fmt.Println("-----------------FindTransaction end----------------------")
+	if tx, ok := bc.snapshotTransaction(ID); ok {
+		return tx, nil
+	}
 	return Transaction{}, errors.New("Transaction is not found")
 }
 
+// TransactionProof 查找交易 txID 所在的区块，并生成它在该区块中的默克尔包含证明
+func (bc *Blockchain) TransactionProof(txID []byte) (*Block, *MerkleProof, error) {
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+		if block == nil {
+			break
+		}
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, txID) {
+				proof, err := block.TransactionProof(txID)
+				return block, proof, err
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil, nil, errors.New("Transaction is not found")
+}
+
 // FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
 func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
+	return bc.findUTXOAt(bc.Tip())
+}
+
+// findUTXOAt 区块 hash 上链之后的 UTXO 集。从快照启动的节点遍历到快照区块后，再加上快照中没有被花费的输出
+func (bc *Blockchain) findUTXOAt(hash []byte) map[string]TXOutputs {
 	UTXO := make(map[string]TXOutputs)
 	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
+	bci := &BlockchainIterator{hash, bc.db}
+	base := bc.snapshotBase()
 
 	for {
 		block := bci.Next()
+		// 快照区块及之前的输出都在快照记录中
+		if block == nil || (base != nil && bytes.Equal(block.Hash, base)) {
+			break
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
@@ -263,7 +297,9 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 				}
 
 				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				outs.Height = block.Height
+				outs.Reward = isReward(tx, block.Data)
+				outs.add(outIdx, out)
 				UTXO[txID] = outs
 			}
 
@@ -280,6 +316,22 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 		}
 	}
 
+	for txID, outs := range bc.snapshotUTXOs() {
+		unspent := TXOutputs{Height: outs.Height, Reward: outs.Reward}
+	Snapshot:
+		for i, out := range outs.Outputs {
+			for _, spentOutIdx := range spentTXOs[txID] {
+				if spentOutIdx == outs.Indexes[i] {
+					continue Snapshot
+				}
+			}
+			unspent.add(outs.Indexes[i], out)
+		}
+		if len(unspent.Outputs) > 0 {
+			UTXO[txID] = unspent
+		}
+	}
+
 	return UTXO
 }
 
@@ -289,20 +341,31 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 //2. 返回创建的 `bci` 区块链迭代器实例。
 //总的来说，这个方法的目的是为当前区块链创建一个迭代器，以便在区块链上进行迭代遍历操作。迭代器是一种常见的设计模式，用于按顺序访问集合中的元素，这里用于遍历区块链上的每个区块。
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	bci := &BlockchainIterator{bc.tip, bc.db}
+	bci := &BlockchainIterator{bc.Tip(), bc.db}
 
 	return bci
 }
 
+// Tip 链尾区块的哈希
+func (bc *Blockchain) Tip() []byte {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	return bc.tip
+}
+
+func (bc *Blockchain) setTip(hash []byte) {
+	bc.tipMu.Lock()
+	bc.tip = hash
+	bc.tipMu.Unlock()
+}
+
 // GetBestHeight returns the height of the latest block
 func (bc *Blockchain) GetBestHeight() int {
 	var lastBlock Block
 
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash := b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		lastBlock = *DeserializeBlock(blockData)
+	err := bc.db.View(func(tx StoreTx) error {
+		lastBlock = *blocksOf(tx).TipBlock()
 
 		return nil
 	})
@@ -317,16 +380,14 @@ func (bc *Blockchain) GetBestHeight() int {
 func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-
-		blockData := b.Get(blockHash)
+	err := bc.db.View(func(tx StoreTx) error {
+		found := blocksOf(tx).Block(blockHash)
 
-		if blockData == nil {
+		if found == nil {
 			return errors.New("Block is not found.")
 		}
 
-		block = *DeserializeBlock(blockData)
+		block = *found
 
 		return nil
 	})
@@ -352,6 +413,9 @@ func (bc *Blockchain) GetBlockHashes() [][]byte {
 
 	for {
 		block := bci.Next()
+		if block == nil {
+			break
+		}
 
 		blocks = append(blocks, block.Hash)
 
@@ -363,12 +427,27 @@ func (bc *Blockchain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
+// newBlockOn 创建接在 prevBlockHash 之后的区块，区块头带上区块接入后 UTXO 集的承诺
+func (bc *Blockchain) newBlockOn(transactions []*Transaction, prevBlockHash []byte, height int, consensusType int, data []byte) (*Block, error) {
+	var commitment []byte
+	err := bc.db.View(func(tx StoreTx) error {
+		var err error
+		commitment, err = utxoCommitmentAfter(tx, &Block{Transactions: transactions, PrevBlockHash: prevBlockHash, Height: height, Data: data})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlock(transactions, prevBlockHash, height, consensusType, data, commitment), nil
+}
+
 // MineBlock 这段代码是 `Blockchain` 结构体的方法 `MineBlock`，用于挖掘一个新的区块并将其添加到区块链中。
 //下面是这个方法的功能和步骤解释：
 //1. 遍历传入的交易列表 `transactions`，对每个交易进行验证。如果交易无效，则触发 Panic。
 //2. 通过读取区块链数据库，获取最后一个区块的哈希和高度。
 //3. 使用 `NewBlock` 函数创建一个新的区块，传入当前待确认的交易列表 `transactions`、最后一个区块的哈希和高度。
-//4. 使用数据库事务更新，将新的区块的序列化数据存储到区块链数据库中，同时更新最后一个区块的哈希。将区块链结构体的 `tip` 指向新挖掘的区块的哈希。
+//4. 使用数据库事务更新，将新的区块的序列化数据存储到区块链数据库中，同时更新最后一个区块的哈希和 UTXO 集。将区块链结构体的 `tip` 指向新挖掘的区块的哈希。
 //5. 返回新挖掘的区块对象。
 //总的来说，这个方法的目的是根据给定的交易列表，挖掘一个新的区块并将其添加到区块链中。在挖掘过程中，它会验证交易的有效性，并将新区块的数据存储到数据库中。
 func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
@@ -382,12 +461,11 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		}
 	}
 
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+	err := bc.db.View(func(tx StoreTx) error {
+		blocks := blocksOf(tx)
+		lastHash = blocks.Tip()
 
-		blockData := b.Get(lastHash) //2. 通过读取区块链数据库，获取最后一个区块的哈希和高度。
-		block := DeserializeBlock(blockData)
+		block := blocks.TipBlock() //2. 通过读取区块链数据库，获取最后一个区块的哈希和高度。
 
 		lastHeight = block.Height //2. 通过读取区块链数据库，获取最后一个区块的哈希和高度。
 
@@ -397,27 +475,14 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		log.Panic(err)
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1, 0, []byte("MineBlock"))
-	//3. 使用 `NewBlock` 函数 进行POW运算，创建一个新的区块，传入当前待确认的交易列表 `transactions`、最后一个区块的哈希和高度。
-	err = bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
-
-		err = b.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		bc.tip = newBlock.Hash
-
-		return nil
-	})
+	newBlock, err := bc.newBlockOn(transactions, lastHash, lastHeight+1, 0, []byte("MineBlock"))
 	if err != nil {
 		log.Panic(err)
 	}
+	//3. 使用 `NewBlock` 函数 进行POW运算，创建一个新的区块，传入当前待确认的交易列表 `transactions`、最后一个区块的哈希和高度。
+	if _, err := bc.acceptBlock(newBlock); err != nil {
+		log.Panic("ERROR: Invalid block: ", err)
+	}
 
 	return newBlock
 }
@@ -441,13 +506,12 @@ func (bc *Blockchain) commitTransaction(transactions []*Transaction, data string
 			log.Panic("ERROR: Invalid transaction  Txid:", hex.EncodeToString(tx.ID))
 		}
 	}
-	fmt.Println("err := bc.db.View(func(tx *bolt.Tx) error {")
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+	fmt.Println("err := bc.db.View(func(tx StoreTx) error {")
+	err := bc.db.View(func(tx StoreTx) error {
+		blocks := blocksOf(tx)
+		lastHash = blocks.Tip()
 
-		blockData := b.Get(lastHash) //2. 通过读取区块链数据库，获取最后一个区块的哈希和高度。
-		block := DeserializeBlock(blockData)
+		block := blocks.TipBlock() //2. 通过读取区块链数据库，获取最后一个区块的哈希和高度。
 
 		lastHeight = block.Height //2. 通过读取区块链数据库，获取最后一个区块的哈希和高度。
 
@@ -457,28 +521,14 @@ func (bc *Blockchain) commitTransaction(transactions []*Transaction, data string
 		log.Panic(err)
 	}
 	fmt.Println("newBlock := NewBlock(transactions, lastHash, lastHeight+1, 1)")
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1, 1, []byte(data))
-	//3. 使用 `NewBlock` 函数 进行POW运算，创建一个新的区块，传入当前待确认的交易列表 `transactions`、最后一个区块的哈希和高度。
-	fmt.Println("err = bc.db.Update")
-	err = bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
-
-		err = b.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		bc.tip = newBlock.Hash
-
-		return nil
-	})
+	newBlock, err := bc.newBlockOn(transactions, lastHash, lastHeight+1, 1, []byte(data))
 	if err != nil {
 		log.Panic(err)
 	}
+	//3. 使用 `NewBlock` 函数 进行POW运算，创建一个新的区块，传入当前待确认的交易列表 `transactions`、最后一个区块的哈希和高度。
+	if _, err := bc.acceptBlock(newBlock); err != nil {
+		log.Panic("ERROR: Invalid block: ", err)
+	}
 	fmt.Println("return newBlock")
 	return newBlock
 }
@@ -529,6 +579,64 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	return tx.Verify(prevTXs)
 }
 
+// storageFormat 读取区块库记录的编码格式版本，旧库没有记录时返回 0
+func storageFormat(tx StoreTx) int {
+	b := tx.Bucket(metaBucket)
+	if b == nil {
+		return 0
+	}
+	v := b.Get([]byte(storageFormatKey))
+	if len(v) != 4 {
+		return 0
+	}
+
+	return int(binary.LittleEndian.Uint32(v))
+}
+
+// chainstateVersion 读取 UTXO 集的格式版本，从未记录过时返回 0
+func (bc *Blockchain) chainstateVersion() int {
+	version := 0
+	err := bc.db.View(func(tx StoreTx) error {
+		b := tx.Bucket(metaBucket)
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(chainstateVersionKey)); len(v) == 4 {
+			version = int(binary.LittleEndian.Uint32(v))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return version
+}
+
+// putChainstateVersion 记录 UTXO 集当前的格式版本
+func putChainstateVersion(tx StoreTx) error {
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	var v [4]byte
+	binary.LittleEndian.PutUint32(v[:], chainstateVersion)
+
+	return b.Put([]byte(chainstateVersionKey), v[:])
+}
+
+// putStorageFormat 记录区块库当前使用的编码格式版本
+func putStorageFormat(tx StoreTx) error {
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	var v [4]byte
+	binary.LittleEndian.PutUint32(v[:], storageFormatVersion)
+
+	return b.Put([]byte(storageFormatKey), v[:])
+}
+
 //dbExists函数接受一个参数 dbFile，表示要检查的数据库文件的路径。
 //使用 os.Stat 函数检查文件是否存在。如果文件不存在，会返回一个 os.IsNotExist 错误，表示文件不存在。
 //如果文件不存在，则返回 false，表示数据库文件不存在。
@@ -2,14 +2,12 @@ package main
 
 import (
 	"log"
-
-	"github.com/boltdb/bolt"
 )
 
 // BlockchainIterator is used to iterate over blockchain blocks
 type BlockchainIterator struct {
 	currentHash []byte
-	db          *bolt.DB
+	db          Store
 }
 
 // Next 这段代码是 `BlockchainIterator` 结构体的 `Next` 方法，用于获取区块链上的下一个区块。
@@ -33,10 +31,8 @@ func (i *BlockchainIterator) Next() *Block {
 		return nil
 	}
 
-	err := i.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		encodedBlock := b.Get(i.currentHash)
-		block = DeserializeBlock(encodedBlock)
+	err := i.db.View(func(tx StoreTx) error {
+		block = blocksOf(tx).Block(i.currentHash)
 
 		return nil
 	})
@@ -45,10 +41,34 @@ func (i *BlockchainIterator) Next() *Block {
 		log.Panic(err)
 	}
 	if block == nil {
-		// Block is nil, skip to the next iteration
-		return i.Next()
+		// 区块不在本地（例如从 UTXO 快照启动的节点没有快照之前的区块），遍历到此结束
+		return nil
 	}
 	i.currentHash = block.PrevBlockHash
 
 	return block
 }
+
+// nextChecked 与 Next 相同，但区块数据无法解码时返回错误而不是退出，hash 为读取的区块哈希。
+// 区块体不在本地时 block 为 nil
+func (i *BlockchainIterator) nextChecked() (hash []byte, block *Block, err error) {
+	hash = i.currentHash
+	if len(hash) == 0 {
+		return hash, nil, nil
+	}
+
+	var data []byte
+	err = i.db.View(func(tx StoreTx) error {
+		data = append([]byte(nil), tx.Bucket(blocksBucket).Get(hash)...)
+		return nil
+	})
+	if err != nil || data == nil {
+		return hash, nil, err
+	}
+	if block, err = decodeBlock(data); err != nil {
+		return hash, nil, err
+	}
+	i.currentHash = block.PrevBlockHash
+
+	return hash, block, nil
+}
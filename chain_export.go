@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 区块导出文件
+//
+// exportchain 把主链上一段连续的区块按高度升序写成一个文件，importchain 逐个读取、验证并接入，
+// 代替在节点之间复制 bolt 文件。文件以流的方式读写，格式见 encoding.go，文件头和每个区块都带校验和。
+// 导入时每个区块都要：哈希与内容一致、接在本地已有的区块之后、有工作量证明或文件中附带的有效证书、
+// 满足共识规则（ValidateBlock），最后重建 UTXO 集。还没有区块库的节点要从创世区块开始导入
+
+// chainFileTag 导出文件的前 4 个字节
+var chainFileTag = []byte("BCHN")
+
+// maxChainRecord 文件中一个区块或证书编码的最大字节数
+const maxChainRecord = 32 << 20
+
+var errChainChecksum = errors.New("checksum mismatch")
+
+// chainFileHeader 导出文件的文件头
+type chainFileHeader struct {
+	Magic   uint32 // 网络 magic
+	Genesis []byte // 创世区块哈希
+	From    int    // 第一个区块的高度
+	Count   int    // 区块个数
+}
+
+func (h *chainFileHeader) encode() []byte {
+	var buff bytes.Buffer
+
+	buff.Write(chainFileTag)
+	writeUint32(&buff, encodingVersion)
+	writeUint32(&buff, h.Magic)
+	buff.Write(h.Genesis)
+	writeVarInt(&buff, uint64(h.From))
+	writeVarInt(&buff, uint64(h.Count))
+	buff.Write(checksum(buff.Bytes()))
+
+	return buff.Bytes()
+}
+
+// encodeChainRecord 文件中的一个区块：区块编码、证书和两者的校验和
+func encodeChainRecord(block *Block, qc *BlockQC) []byte {
+	var buff bytes.Buffer
+	var qcData []byte
+	if qc != nil {
+		qcData = qc.Serialize()
+	}
+
+	blockData := block.Serialize()
+	writeVarBytes(&buff, blockData)
+	writeVarBytes(&buff, qcData)
+	buff.Write(checksum(append(append([]byte(nil), blockData...), qcData...)))
+
+	return buff.Bytes()
+}
+
+// readVarIntFrom 从流中读取 varint，同时返回读到的原始字节
+func readVarIntFrom(r *bufio.Reader) (uint64, []byte, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	raw := make([]byte, 1+map[byte]int{0xfd: 2, 0xfe: 4, 0xff: 8}[prefix])
+	raw[0] = prefix
+	if _, err := io.ReadFull(r, raw[1:]); err != nil {
+		return 0, nil, err
+	}
+
+	return newBinReader(raw).readVarInt(), raw, nil
+}
+
+// readVarBytesFrom 从流中读取 varbytes，长度超过 maxChainRecord 时返回错误
+func readVarBytesFrom(r *bufio.Reader) ([]byte, error) {
+	n, _, err := readVarIntFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxChainRecord {
+		return nil, fmt.Errorf("record of %d bytes is too large", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// readChainFileHeader 读取并检查文件头
+func readChainFileHeader(r *bufio.Reader) (*chainFileHeader, error) {
+	fixed := make([]byte, len(chainFileTag)+4+4+32)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:len(chainFileTag)], chainFileTag) {
+		return nil, errors.New("not a chain export file")
+	}
+	from, rawFrom, err := readVarIntFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	count, rawCount, err := readVarIntFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	sum := make([]byte, addressChecksumLen)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return nil, err
+	}
+	raw := append(append(append([]byte(nil), fixed...), rawFrom...), rawCount...)
+	if !bytes.Equal(sum, checksum(raw)) {
+		return nil, fmt.Errorf("file header: %v", errChainChecksum)
+	}
+
+	fields := newBinReader(fixed[len(chainFileTag):])
+	if v := fields.readUint32(); v != encodingVersion {
+		return nil, fmt.Errorf("unsupported chain file version %d", v)
+	}
+	h := &chainFileHeader{Magic: fields.readUint32(), Genesis: append([]byte(nil), fields.next(32)...)}
+	if from > 1<<31 || count > 1<<31 {
+		return nil, errors.New("invalid block range in the file header")
+	}
+	h.From, h.Count = int(from), int(count)
+
+	return h, nil
+}
+
+// readChainRecord 读取文件中的下一个区块和证书，证书为空时返回 nil
+func readChainRecord(r *bufio.Reader) (*Block, *BlockQC, error) {
+	blockData, err := readVarBytesFrom(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	qcData, err := readVarBytesFrom(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := make([]byte, addressChecksumLen)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(sum, checksum(append(blockData, qcData...))) {
+		return nil, nil, errChainChecksum
+	}
+
+	block, err := decodeBlock(blockData)
+	if err != nil {
+		return nil, nil, err
+	}
+	var qc *BlockQC
+	if len(qcData) > 0 {
+		if qc, err = DeserializeBlockQC(qcData); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return block, qc, nil
+}
+
+// ExportChain 把主链上高度 from 到 to 的区块写入 w，to 为负数时到链尾，返回写入的区块数
+func (bc *Blockchain) ExportChain(w io.Writer, from, to int) (int, error) {
+	tipHeight := bc.GetBestHeight()
+	if to < 0 || to > tipHeight {
+		to = tipHeight
+	}
+	if from < 0 || from > to {
+		return 0, fmt.Errorf("invalid block range %d-%d, the tip is at height %d", from, to, tipHeight)
+	}
+	genesisHash, err := bc.GenesisHash()
+	if err != nil {
+		return 0, err
+	}
+
+	// 从链尾沿区块头往前找到这一段区块，再按高度升序写入
+	var hashes [][]byte
+	for hash := bc.Tip(); len(hash) > 0; {
+		header, err := bc.blockHeader(hash)
+		if err != nil {
+			return 0, err
+		}
+		if header.Height < from {
+			break
+		}
+		if header.Height <= to {
+			hashes = append(hashes, hash)
+		}
+		hash = header.PrevBlockHash
+	}
+	if len(hashes) != to-from+1 {
+		return 0, fmt.Errorf("blocks below height %d are not on this node", to-len(hashes)+1)
+	}
+
+	out := bufio.NewWriter(w)
+	header := &chainFileHeader{Magic: chainParams.Magic, Genesis: genesisHash, From: from, Count: len(hashes)}
+	if _, err := out.Write(header.encode()); err != nil {
+		return 0, err
+	}
+	for i := len(hashes) - 1; i >= 0; i-- {
+		if err := bc.prunedBlock(hashes[i]); err != nil {
+			return 0, err
+		}
+		block, err := bc.GetBlock(hashes[i])
+		if err != nil {
+			return 0, err
+		}
+		qc, err := bc.BlockQC(hashes[i])
+		if err != nil {
+			return 0, err
+		}
+		if _, err := out.Write(encodeChainRecord(&block, qc)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(hashes), out.Flush()
+}
+
+// ImportChain 从 r 读取导出文件并接入节点的区块库，节点还没有区块库时用文件中的创世区块创建，
+// 返回接入的区块数（本地已有的区块不算）
+func ImportChain(nodeID string, r io.Reader) (int, error) {
+	in := bufio.NewReader(r)
+	header, err := readChainFileHeader(in)
+	if err != nil {
+		return 0, err
+	}
+	if header.Magic != chainParams.Magic {
+		return 0, fmt.Errorf("file is for network magic %08x, this node uses %08x", header.Magic, chainParams.Magic)
+	}
+
+	var bc *Blockchain
+	if dbExists(nodeDataFile(dbFile, nodeID)) {
+		if bc = NewBlockchain(nodeID); bc == nil {
+			return 0, errors.New("blockchain database cannot be opened")
+		}
+	} else {
+		if header.From != 0 || header.Count == 0 {
+			return 0, errors.New("the node has no blockchain, the file must start at the genesis block")
+		}
+		genesis, _, err := readChainRecord(in)
+		if err != nil {
+			return 0, fmt.Errorf("block at height 0: %v", err)
+		}
+		if err := checkImportedGenesis(header, genesis); err != nil {
+			return 0, err
+		}
+		db, err := openBoltStore(nodeDataFile(dbFile, nodeID), 0600, 0)
+		if err != nil {
+			return 0, err
+		}
+		if bc, err = createBlockchain(db, genesis); err != nil {
+			db.Close()
+			return 0, err
+		}
+		UTXOSet{bc}.Reindex()
+		header.From, header.Count = 1, header.Count-1
+	}
+	defer bc.db.Close()
+
+	return bc.importChain(in, header)
+}
+
+// checkImportedGenesis 文件中的第一个区块是文件头所说的创世区块
+func checkImportedGenesis(header *chainFileHeader, genesis *Block) error {
+	if genesis.Height != 0 || len(genesis.PrevBlockHash) != 0 {
+		return errors.New("the first block of the file is not a genesis block")
+	}
+	if !bytes.Equal(genesis.Hash, header.Genesis) || !bytes.Equal(genesis.ComputeHash(), genesis.Hash) {
+		return fmt.Errorf("genesis block %x does not match the file header", genesis.Hash)
+	}
+
+	return nil
+}
+
+// importChain 读取文件头之后的区块，逐个验证并接入，最后重建 UTXO 集
+func (bc *Blockchain) importChain(in *bufio.Reader, header *chainFileHeader) (int, error) {
+	genesisHash, err := bc.GenesisHash()
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(genesisHash, header.Genesis) {
+		return 0, fmt.Errorf("file is for genesis block %x, this node has %x", header.Genesis, genesisHash)
+	}
+
+	imported := 0
+	for i := 0; i < header.Count; i++ {
+		height := header.From + i
+		block, qc, err := readChainRecord(in)
+		if err == nil && block.Height != height {
+			err = fmt.Errorf("found block at height %d", block.Height)
+		}
+		if err == nil {
+			var added bool
+			added, err = bc.importBlock(block, qc)
+			if added {
+				imported++
+			}
+		}
+		if err != nil {
+			if imported > 0 {
+				UTXOSet{bc}.Reindex()
+			}
+			return imported, fmt.Errorf("block at height %d: %v", height, err)
+		}
+	}
+	if _, err := in.ReadByte(); err != io.EOF {
+		return imported, errTrailingBytes
+	}
+	UTXOSet{bc}.Reindex()
+	logInfof("imported %d blocks, the tip is at height %d", imported, bc.GetBestHeight())
+
+	return imported, nil
+}
+
+// importBlock 验证并接入文件中的一个区块，本地已有时跳过
+func (bc *Blockchain) importBlock(block *Block, qc *BlockQC) (bool, error) {
+	if bc.hasBlockBody(block.Hash) {
+		return false, nil
+	}
+	if err := bc.verifyBlock(block.Hash, block, nil, verifyLevelHeader); err != nil {
+		return false, err
+	}
+	if len(block.PrevBlockHash) == 0 {
+		return false, errors.New("a second genesis block")
+	}
+	parent, err := bc.blockHeader(block.PrevBlockHash)
+	if err != nil {
+		return false, fmt.Errorf("parent: %v", err)
+	}
+	if block.Height != parent.Height+1 {
+		return false, fmt.Errorf("height %d does not follow the parent at height %d", block.Height, parent.Height)
+	}
+	header := block.Header()
+	if !header.HasValidWork() {
+		if err := qc.Verify(header); err != nil {
+			return false, fmt.Errorf("no proof of work: %v", err)
+		}
+	}
+	if _, err := bc.acceptBlock(block); err != nil {
+		return false, err
+	}
+	if qc != nil {
+		if err := bc.PutBlockQC(block.Hash, qc); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportChain(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	other := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	utxos := UTXOSet{bc}
+	utxos.Reindex()
+	hashes, err := bc.Generate(chainParams.CoinbaseMaturity, other)
+	assert.Nil(t, err)
+	wallets := &Wallets{Wallets: map[string]*Wallet{address: wallet}}
+	tx := NewUTXOTransaction(wallets, address, other, 5, &utxos, DefaultSendOptions())
+	bc.MineBlock([]*Transaction{bc.NewRewardTX(other, []*Transaction{tx}), tx})
+
+	var file bytes.Buffer
+	n, err := bc.ExportChain(&file, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.GetBestHeight()+1, n)
+	_, err = bc.ExportChain(&bytes.Buffer{}, 3, 2)
+	assert.NotNil(t, err)
+
+	// 没有区块库的节点从创世区块开始导入
+	nodeID := "127.0.0.1 3001"
+	n, err = ImportChain(nodeID, bytes.NewReader(file.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, bc.GetBestHeight(), n)
+	n, err = ImportChain(nodeID, bytes.NewReader(file.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "blocks already in the chain are skipped")
+	imported := NewBlockchain(nodeID)
+	assert.NotNil(t, imported)
+	assert.Equal(t, bc.Tip(), imported.Tip())
+	assert.Equal(t, utxos.Commitment(), UTXOSet{imported}.Commitment())
+	check, err := imported.VerifyChain(0, verifyLevelUTXO)
+	assert.Nil(t, err)
+	assert.True(t, check.OK(), check.String())
+	imported.db.Close()
+
+	// 只导出后面的一段，接在已有的区块之后
+	var part bytes.Buffer
+	n, err = bc.ExportChain(&part, 2, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.GetBestHeight()-1, n)
+	first, err := bc.GetBlock(hashes[0])
+	assert.Nil(t, err)
+	partial := testChain(t, genesis, &first)
+	UTXOSet{partial}.Reindex()
+	importBuffer := func(data []byte) (int, error) {
+		in := bufio.NewReader(bytes.NewReader(data))
+		header, err := readChainFileHeader(in)
+		if err != nil {
+			return 0, err
+		}
+		return partial.importChain(in, header)
+	}
+	_, err = importBuffer(file.Bytes()[:len(file.Bytes())-3])
+	assert.NotNil(t, err, "truncated file")
+	corrupted := append([]byte(nil), part.Bytes()...)
+	corrupted[len(corrupted)/2] ^= 1
+	_, err = importBuffer(corrupted)
+	assert.NotNil(t, err)
+	// 前两次导入出错之前接入的区块保留，这次补上剩下的
+	_, err = importBuffer(part.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, bc.Tip(), partial.Tip())
+	assert.Equal(t, utxos.Commitment(), UTXOSet{partial}.Commitment())
+
+	// 其他网络的文件
+	network := regtestChainParams()
+	network.Magic++
+	useChainParams(t, network)
+	_, err = ImportChain(nodeID, bytes.NewReader(file.Bytes()))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,94 @@
+package main
+
+import "sync"
+
+// ChainService 进程内共享的区块库句柄。BoltDB 对文件加独占锁，同一进程里重复打开同一个库会互相阻塞，
+// 所以 P2P 和 HTTP 处理函数都通过它取得 Blockchain：每个库只打开一次，并发的 goroutine 使用同一个句柄。
+// keepOpen 为 false 时，最后一个使用者释放后关闭库，HTTP 接口和节点进程可以轮流打开同一个库
+type ChainService struct {
+	mu       sync.Mutex
+	keepOpen bool
+	closed   bool
+	chains   map[string]*sharedChain
+	owners   map[*Blockchain]*sharedChain
+}
+
+type sharedChain struct {
+	nodeID string
+	bc     *Blockchain
+	refs   int
+}
+
+// NewChainService keepOpen 为 true 时库打开后一直保持打开，直到 Close
+func NewChainService(keepOpen bool) *ChainService {
+	return &ChainService{
+		keepOpen: keepOpen,
+		chains:   make(map[string]*sharedChain),
+		owners:   make(map[*Blockchain]*sharedChain),
+	}
+}
+
+// Blockchain 返回 nodeID 的区块链，用完后调用 Release。与 NewBlockchain 一样，库不存在或服务已经 Close 时返回 nil
+func (s *ChainService) Blockchain(nodeID string) *Blockchain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	shared, ok := s.chains[nodeID]
+	if !ok {
+		bc := NewBlockchain(nodeID)
+		if bc == nil {
+			return nil
+		}
+		shared = &sharedChain{nodeID: nodeID, bc: bc}
+		s.chains[nodeID] = shared
+		s.owners[bc] = shared
+	}
+	shared.refs++
+
+	return shared.bc
+}
+
+// Release 释放 Blockchain 返回的区块链。不是由 ChainService 打开的区块链（例如 CreateBlockchain 的结果）直接关闭
+func (s *ChainService) Release(bc *Blockchain) {
+	if bc == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shared, ok := s.owners[bc]
+	if !ok {
+		bc.db.Close()
+		return
+	}
+	if shared.refs > 0 {
+		shared.refs--
+	}
+	if shared.refs == 0 && (!s.keepOpen || s.closed) {
+		s.close(shared)
+	}
+}
+
+// Close 关闭服务，之后 Blockchain 返回 nil。没有使用者的库立即关闭，仍在使用的库在最后一次 Release 时关闭
+func (s *ChainService) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for _, shared := range s.chains {
+		if shared.refs == 0 {
+			s.close(shared)
+		}
+	}
+}
+
+func (s *ChainService) close(shared *sharedChain) {
+	delete(s.chains, shared.nodeID)
+	delete(s.owners, shared.bc)
+	shared.bc.db.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainServiceSharesHandle(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	nodeID := "127.0.0.1 3000"
+	chains := NewChainService(false)
+	chains.Release(CreateBlockchain(string(NewWallet().GetAddress()), nodeID, chainParams))
+
+	first := chains.Blockchain(nodeID)
+	assert.NotNil(t, first)
+
+	// 并发的处理函数拿到同一个句柄，而不是在文件锁上互相等待
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bc := chains.Blockchain(nodeID)
+			defer chains.Release(bc)
+			assert.Equal(t, first, bc)
+			assert.Equal(t, 0, bc.GetBestHeight())
+		}()
+	}
+	wg.Wait()
+
+	chains.Release(first)
+	assert.Empty(t, chains.chains, "the last release closes the database")
+	db, err := bolt.Open(nodeDataFile(dbFile, nodeID), 0600, &bolt.Options{Timeout: time.Second})
+	assert.Nil(t, err, "another process can open it now")
+	db.Close()
+
+	assert.Nil(t, chains.Blockchain("127.0.0.1 3001"), "no database for this node")
+
+	kept := NewChainService(true)
+	kept.Release(kept.Blockchain(nodeID))
+	assert.Len(t, kept.chains, 1)
+	kept.Close()
+	assert.Empty(t, kept.chains)
+	assert.Nil(t, kept.Blockchain(nodeID), "a closed service hands out no chains")
+}
+
+// Close 时仍在使用的库不能被关闭，等到最后一次 Release 再关闭
+func TestChainServiceCloseWaitsForRelease(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	nodeID := "127.0.0.1 3000"
+	chains := NewChainService(true)
+	chains.Release(CreateBlockchain(string(NewWallet().GetAddress()), nodeID, chainParams))
+
+	first := chains.Blockchain(nodeID)
+	second := chains.Blockchain(nodeID)
+	chains.Close()
+	assert.Len(t, chains.chains, 1, "the chain is still in use")
+	assert.Equal(t, 0, first.GetBestHeight())
+
+	chains.Release(first)
+	assert.Equal(t, 0, second.GetBestHeight())
+	chains.Release(second)
+	assert.Empty(t, chains.chains, "the last release closes the database")
+	db, err := bolt.Open(nodeDataFile(dbFile, nodeID), 0600, &bolt.Options{Timeout: time.Second})
+	assert.Nil(t, err)
+	db.Close()
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// genesisFileEnv 指定创世配置文件的环境变量，没有设置时使用当前目录的 defaultGenesisFile（如果存在）
+const genesisFileEnv = "GENESIS_FILE"
+
+// networkEnv 选择内置网络的环境变量：main、testnet 或 regtest，默认 main
+const networkEnv = "NETWORK"
+
+const defaultGenesisFile = "genesis.json"
+
+// GenesisAllocation 创世区块中的初始分配
+type GenesisAllocation struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// TreasuryConfig 创世配置中的国库设置，见 Treasury
+type TreasuryConfig struct {
+	Threshold int      `json:"threshold"`
+	Addresses []string `json:"addresses"`
+}
+
+// ChainParams 网络参数，所有节点从同一份创世配置文件加载。
+// 创世区块完全由这些参数决定，不同网络的创世区块哈希不同，握手时据此拒绝对方
+type ChainParams struct {
+	Network          string              `json:"network"`
+	Magic            uint32              `json:"magic"`            // 每条网络消息的前 4 个字节
+	AddressVersion   byte                `json:"addressVersion"`   // 地址的版本字节，不同网络的地址互不通用
+	WIFVersion       byte                `json:"wifVersion"`       // 导出私钥的版本字节
+	DataDir          string              `json:"dataDir"`          // 数据库和钱包文件所在的目录，为空时使用当前目录
+	Port             int                 `json:"port"`             // 没有指定端口时节点使用的端口
+	RPCPort          int                 `json:"rpcPort"`          // HTTP 接口监听的端口
+	Timestamp        int64               `json:"timestamp"`        // 创世区块的时间戳
+	CoinbaseData     string              `json:"coinbaseData"`     // 创世区块 coinbase 的输入数据
+	Allocations      []GenesisAllocation `json:"allocations"`      // 为空时创世奖励发给 createblockchain 的 -address
+	Subsidy          int                 `json:"subsidy"`          // 初始区块补贴
+	HalvingInterval  int                 `json:"halvingInterval"`  // 区块补贴减半的间隔
+	CoinbaseMaturity int                 `json:"coinbaseMaturity"` // 奖励输出可以花费前需要等待的区块数
+	TargetBits       int                 `json:"targetBits"`       // 工作量证明难度
+	Consensus        string              `json:"consensus"`        // 创世区块的共识类型：pow 或 hotstuff
+	BlockTime        int                 `json:"blockTime"`        // 目标出块间隔（秒），作为网络参数发布
+	ShardCount       int                 `json:"shardCount"`       // 分片个数上限，0 表示不限制
+	Validators       []string            `json:"validators"`       // 允许作为领导或普通节点加入分片的节点（"IP 端口"），为空时不限制
+	ValidatorKeys    map[string]string   `json:"validatorKeys"`    // 节点（"IP 端口"）签署 hotstuff 区块头的公钥（十六进制 X || Y），见 BlockQC
+	ValidatorShards  map[string]int      `json:"validatorShards"`  // 验证者所在的分片，证书只统计同一分片的签名，没有列出的验证者属于分片 0
+	Treasury         *TreasuryConfig     `json:"treasury"`         // 为空时不能铸币
+	GenesisHash      string              `json:"genesisHash"`      // 创世区块哈希（十六进制），为空时由 allocations 算出，见 configuredGenesis
+}
+
+// chainParams 当前节点使用的网络参数
+var chainParams = defaultChainParams()
+
+// defaultChainParams 没有创世配置文件时使用的参数，即主网
+func defaultChainParams() *ChainParams {
+	return &ChainParams{
+		Network:          "main",
+		Magic:            0xf9beb4d9,
+		AddressVersion:   0x00,
+		WIFVersion:       0x80,
+		Port:             3000,
+		RPCPort:          8088,
+		Timestamp:        1231006505,
+		CoinbaseData:     genesisCoinbaseData,
+		Subsidy:          10,
+		HalvingInterval:  210000,
+		CoinbaseMaturity: 10,
+		TargetBits:       16,
+		Consensus:        "pow",
+		BlockTime:        10,
+	}
+}
+
+// testnetChainParams 测试网：独立的 magic、地址前缀、数据目录、端口和创世区块
+func testnetChainParams() *ChainParams {
+	params := defaultChainParams()
+	params.Network = "testnet"
+	params.Magic = 0x0b110907
+	params.AddressVersion = 0x6f
+	params.WIFVersion = 0xef
+	params.DataDir = "testnet"
+	params.Port = 13000
+	params.RPCPort = 18088
+	params.Timestamp = 1296688602
+	params.CoinbaseData = "testnet genesis"
+
+	return params
+}
+
+// regtestChainParams 回归测试网：难度极低，可以用 generate 立即出块，减半间隔也很短
+func regtestChainParams() *ChainParams {
+	params := testnetChainParams()
+	params.Network = "regtest"
+	params.Magic = 0xfabfb5da
+	params.DataDir = "regtest"
+	params.Port = 23000
+	params.RPCPort = 28088
+	params.CoinbaseData = "regtest genesis"
+	params.HalvingInterval = 150
+	params.TargetBits = 1
+
+	return params
+}
+
+// networkChainParams 内置网络的参数
+func networkChainParams(network string) (*ChainParams, error) {
+	switch network {
+	case "", "main", "mainnet":
+		return defaultChainParams(), nil
+	case "testnet":
+		return testnetChainParams(), nil
+	case "regtest":
+		return regtestChainParams(), nil
+	}
+
+	return nil, fmt.Errorf("unknown network %q, use main, testnet or regtest", network)
+}
+
+// LoadChainParams 读取创世配置文件。network 是内置网络时以它的参数为默认值，
+// 否则以主网参数为默认值，文件中的字段覆盖默认值
+func LoadChainParams(path string) (*ChainParams, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Network string `json:"network"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	params, err := networkChainParams(header.Network)
+	if err != nil {
+		params = defaultChainParams()
+	}
+	if err := json.Unmarshal(data, params); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return params, nil
+}
+
+// initChainParams 按创世配置文件 path 或当前目录的 genesis.json 设置 chainParams，
+// 都没有时使用 network 选择的内置网络。两者默认取自 GENESIS_FILE 和 NETWORK，见 NodeConfig
+func initChainParams(network, path string) error {
+	if path == "" && dbExists(defaultGenesisFile) {
+		path = defaultGenesisFile
+	}
+
+	var params *ChainParams
+	var err error
+	if path != "" {
+		params, err = LoadChainParams(path)
+		if err == nil && network != "" && network != params.Network {
+			err = fmt.Errorf("%s=%s does not match network %s of %s", networkEnv, network, params.Network, path)
+		}
+	} else {
+		params, err = networkChainParams(network)
+		path = "built-in"
+	}
+	if err != nil {
+		return err
+	}
+	chainParams = params
+	fmt.Printf("Network %s, genesis configuration %s\n", params.Network, path)
+
+	return nil
+}
+
+// Validate 检查参数取值
+func (p *ChainParams) Validate() error {
+	if p.Network == "" {
+		return errors.New("network name is empty")
+	}
+	if p.Subsidy <= 0 || p.HalvingInterval <= 0 || p.CoinbaseMaturity < 0 {
+		return errors.New("subsidy and halving interval must be positive, coinbase maturity must not be negative")
+	}
+	if p.TargetBits < 1 || p.TargetBits > 255 {
+		return errors.New("targetBits must be between 1 and 255")
+	}
+	if _, err := p.consensusType(); err != nil {
+		return err
+	}
+	if p.BlockTime < 0 || p.ShardCount < 0 {
+		return errors.New("blockTime and shardCount must not be negative")
+	}
+	if p.AddressVersion == p.WIFVersion {
+		return errors.New("addressVersion and wifVersion must differ")
+	}
+	if p.Port <= 0 || p.Port > 65535 || p.RPCPort <= 0 || p.RPCPort > 65535 {
+		return errors.New("port and rpcPort must be between 1 and 65535")
+	}
+	for _, allocation := range p.Allocations {
+		if !validateAddressFor(p.AddressVersion, allocation.Address) {
+			return fmt.Errorf("allocation address %s is not valid", allocation.Address)
+		}
+		if allocation.Amount <= 0 {
+			return fmt.Errorf("allocation to %s must be positive", allocation.Address)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, validator := range p.Validators {
+		if seen[validator] {
+			return fmt.Errorf("validator %s is listed twice", validator)
+		}
+		seen[validator] = true
+	}
+	for nodeID, key := range p.ValidatorKeys {
+		if len(p.Validators) > 0 && !seen[nodeID] {
+			return fmt.Errorf("validator key of %s, which is not a validator", nodeID)
+		}
+		if pubKey, err := hex.DecodeString(key); err != nil || len(pubKey) != 64 {
+			return fmt.Errorf("validator key of %s must be 64 bytes in hex", nodeID)
+		}
+	}
+	for nodeID, shard := range p.ValidatorShards {
+		if _, ok := p.ValidatorKeys[nodeID]; !ok {
+			return fmt.Errorf("validator shard of %s, which has no validator key", nodeID)
+		}
+		if shard < 0 || (p.ShardCount > 0 && shard >= p.ShardCount) {
+			return fmt.Errorf("validator shard of %s is out of range", nodeID)
+		}
+	}
+	if _, err := p.treasury(); err != nil {
+		return err
+	}
+	if p.GenesisHash != "" {
+		if hash, err := hex.DecodeString(p.GenesisHash); err != nil || len(hash) != 32 {
+			return errors.New("genesisHash must be 32 bytes in hex")
+		}
+	}
+
+	return nil
+}
+
+// consensusType NewBlock 的共识类型：0 是工作量证明，1 是 hotstuff
+func (p *ChainParams) consensusType() (int, error) {
+	switch p.Consensus {
+	case "pow":
+		return 0, nil
+	case "hotstuff":
+		return 1, nil
+	}
+
+	return 0, fmt.Errorf("unknown consensus %q, use pow or hotstuff", p.Consensus)
+}
+
+func (p *ChainParams) treasury() (*Treasury, error) {
+	if p.Treasury == nil {
+		return nil, nil
+	}
+
+	return newTreasuryFor(p.AddressVersion, p.Treasury.Threshold, p.Treasury.Addresses)
+}
+
+// WithTreasury 返回参数的副本，treasuryAddresses 不为空时替换配置中的国库设置
+func (p *ChainParams) WithTreasury(treasuryAddresses string, threshold int) (*ChainParams, error) {
+	params := *p
+	treasury, err := ParseTreasury(treasuryAddresses, threshold)
+	if err != nil {
+		return nil, err
+	}
+	if treasury != nil {
+		params.Treasury = &TreasuryConfig{treasury.Threshold, treasury.Addresses}
+	}
+
+	return &params, nil
+}
+
+// IsValidator 节点是否可以加入分片
+func (p *ChainParams) IsValidator(nodeID string) bool {
+	if len(p.Validators) == 0 {
+		return true
+	}
+	for _, validator := range p.Validators {
+		if validator == nodeID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validatorShard 验证者所在的分片
+func (p *ChainParams) validatorShard(nodeID string) int {
+	return p.ValidatorShards[nodeID]
+}
+
+// validatorKey 节点签署区块头的公钥，不是配置的验证者时返回 nil
+func (p *ChainParams) validatorKey(nodeID string) []byte {
+	key, ok := p.ValidatorKeys[nodeID]
+	if !ok {
+		return nil
+	}
+	pubKey, err := hex.DecodeString(key)
+	if err != nil || len(pubKey) != 64 {
+		return nil
+	}
+
+	return pubKey
+}
+
+// NewGenesisBlock 由网络参数确定地创建创世区块，同一份配置在任何节点上得到同样的哈希。
+// 配置中没有初始分配时，区块补贴发给 address
+func (p *ChainParams) NewGenesisBlock(address string) (*Block, error) {
+	allocations := p.Allocations
+	if len(allocations) == 0 {
+		if !validateAddressFor(p.AddressVersion, address) {
+			return nil, errors.New("the genesis configuration has no allocations, a valid genesis address is required")
+		}
+		allocations = []GenesisAllocation{{address, p.Subsidy}}
+	}
+	treasury, err := p.treasury()
+	if err != nil {
+		return nil, err
+	}
+	consensusType, err := p.consensusType()
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []TXOutput
+	for _, allocation := range allocations {
+		outputs = append(outputs, *NewTXOutput(allocation.Amount, allocation.Address))
+	}
+	coinbase := Transaction{nil, []TXInput{{[]byte{}, -1, nil, []byte(p.CoinbaseData)}}, outputs}
+	coinbase.ID = coinbase.Hash()
+
+	block := &Block{p.Timestamp, []*Transaction{&coinbase}, []byte{}, []byte{}, 0, 0, genesisData(treasury), nil}
+	block.UTXOCommitment, err = utxoCommitmentAfter(nil, block)
+	if err != nil {
+		return nil, err
+	}
+	if consensusType == 0 {
+		block.Nonce, block.Hash = NewProofOfWork(block).Run()
+	} else {
+		block.Hash = block.ComputeHash()
+	}
+
+	return block, nil
+}
+
+// configuredGenesis 配置确定的创世区块哈希，从快照启动的节点据此检查区块头链。
+// 没有 genesisHash 时由初始分配算出；两者都没有时创世区块取决于 createblockchain 的地址，返回错误
+func (p *ChainParams) configuredGenesis() ([]byte, error) {
+	if p.GenesisHash != "" {
+		return hex.DecodeString(p.GenesisHash)
+	}
+	if len(p.Allocations) == 0 {
+		return nil, errors.New("the genesis configuration has no allocations, set genesisHash to fix the genesis block")
+	}
+	genesis, err := p.NewGenesisBlock("")
+	if err != nil {
+		return nil, err
+	}
+
+	return genesis.Hash, nil
+}
+
+// dataPath 数据文件在本网络数据目录（节点数据目录下的子目录）中的路径，目录不存在时创建
+func (p *ChainParams) dataPath(name string) string {
+	dir := p.DataDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(nodeConfig.DataDir, dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Panic(err)
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// magicBytes 网络消息前缀
+func (p *ChainParams) magicBytes() []byte {
+	magic := make([]byte, 4)
+	binary.BigEndian.PutUint32(magic, p.Magic)
+
+	return magic
+}
+
+// wrapMessage 给网络消息加上本网络的 magic
+func (p *ChainParams) wrapMessage(request []byte) []byte {
+	return append(p.magicBytes(), request...)
+}
+
+// unwrapMessage 去掉 magic，来自其他网络或格式错误的消息返回错误
+func (p *ChainParams) unwrapMessage(request []byte) ([]byte, error) {
+	if len(request) < 4+commandLength {
+		return nil, errors.New("message is too short")
+	}
+	if !bytes.Equal(request[:4], p.magicBytes()) {
+		return nil, fmt.Errorf("message magic %x does not match network %s", request[:4], p.Network)
+	}
+
+	return request[4:], nil
+}
+
+// GenesisHash 创世区块的哈希，握手时用来确认对方属于同一个网络
+func (bc *Blockchain) GenesisHash() ([]byte, error) {
+	if hash := bc.snapshotGenesis(); hash != nil {
+		return hash, nil
+	}
+	genesis, err := bc.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return genesis.Hash, nil
+}
+
+// checkVersion 对方的网络名称和创世区块哈希与本链一致时才继续握手
+func (bc *Blockchain) checkVersion(payload Verzion) error {
+	if payload.Network != chainParams.Network {
+		return fmt.Errorf("peer is on network %q, this node is on %q", payload.Network, chainParams.Network)
+	}
+	genesisHash, err := bc.GenesisHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(payload.GenesisHash, genesisHash) {
+		return fmt.Errorf("peer genesis %x does not match %x", payload.GenesisHash, genesisHash)
+	}
+
+	return nil
+}
+
+// GenesisBlock 沿区块头从链尾向前找到创世区块，修剪过的节点也保留创世区块
+func (bc *Blockchain) GenesisBlock() (Block, error) {
+	hash := bc.Tip()
+	for {
+		header, err := bc.blockHeader(hash)
+		if err != nil {
+			return Block{}, err
+		}
+		if len(header.PrevBlockHash) == 0 {
+			return bc.GetBlock(hash)
+		}
+		hash = header.PrevBlockHash
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadChainParams(t *testing.T) {
+	params, err := LoadChainParams("genesis.example.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "shardnet", params.Network)
+	assert.Len(t, params.Allocations, 2)
+	assert.Equal(t, 2, params.Treasury.Threshold)
+	assert.True(t, params.IsValidator("127.0.0.1 3001"))
+	assert.False(t, params.IsValidator("127.0.0.1 4000"))
+	assert.True(t, chainParams.IsValidator("127.0.0.1 4000"), "an empty validator set admits every node")
+
+	// 文件中没有的字段使用默认值
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"network": "dev", "subsidy": 50}`), 0644))
+	params, err = LoadChainParams(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, params.Subsidy)
+	assert.Equal(t, defaultChainParams().TargetBits, params.TargetBits)
+
+	for _, bad := range []string{
+		`{"network": ""}`,
+		`{"consensus": "pos"}`,
+		`{"allocations": [{"address": "1abc", "amount": 5}]}`,
+		`{"validators": ["127.0.0.1 3000", "127.0.0.1 3000"]}`,
+		`{"genesisHash": "abcd"}`,
+	} {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(bad), 0644))
+		_, err = LoadChainParams(path)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestGenesisBlockIsDeterministic(t *testing.T) {
+	params, err := LoadChainParams("genesis.example.json")
+	assert.Nil(t, err)
+
+	first, err := params.NewGenesisBlock("")
+	assert.Nil(t, err)
+	second, err := params.NewGenesisBlock("")
+	assert.Nil(t, err)
+	assert.Equal(t, first.Hash, second.Hash)
+	assert.Equal(t, 2000, outputValue(first.Transactions[0]))
+	assert.True(t, NewProofOfWork(first).Validate())
+
+	treasury, err := parseGenesisData(first.Data)
+	assert.Nil(t, err)
+	assert.Equal(t, params.Treasury.Addresses, treasury.Addresses)
+
+	other := *params
+	other.Network = "testnet"
+	other.CoinbaseData = "testnet genesis"
+	third, err := other.NewGenesisBlock("")
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.Hash, third.Hash)
+
+	bc := testChain(t, first)
+	genesisHash, err := bc.GenesisHash()
+	assert.Nil(t, err)
+	assert.Nil(t, bc.checkVersion(Verzion{Network: chainParams.Network, GenesisHash: genesisHash}))
+	assert.NotNil(t, bc.checkVersion(Verzion{Network: chainParams.Network, GenesisHash: third.Hash}))
+	assert.NotNil(t, bc.checkVersion(Verzion{Network: "testnet", GenesisHash: genesisHash}))
+
+	_, err = chainParams.NewGenesisBlock("")
+	assert.NotNil(t, err, "no allocations and no address")
+
+	configured, err := params.configuredGenesis()
+	assert.Nil(t, err)
+	assert.Equal(t, first.Hash, configured)
+	UTXOSet{bc}.Reindex()
+	assert.Equal(t, first.UTXOCommitment, UTXOSet{bc}.Commitment(), "the genesis header commits to its outputs")
+	_, err = chainParams.configuredGenesis()
+	assert.NotNil(t, err, "the genesis block depends on the createblockchain address")
+}
+
+func TestWrapMessage(t *testing.T) {
+	request := append(commandToBytes("version"), []byte("payload")...)
+	wrapped := chainParams.wrapMessage(request)
+
+	unwrapped, err := chainParams.unwrapMessage(wrapped)
+	assert.Nil(t, err)
+	assert.Equal(t, request, unwrapped)
+
+	other := *chainParams
+	other.Magic++
+	_, err = other.unwrapMessage(wrapped)
+	assert.NotNil(t, err)
+	_, err = chainParams.unwrapMessage(request[:3])
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// 区块接入
+//
+// 区块体、链尾、过滤器、UTXO 集的改动、撤销数据、UTXO 承诺和最佳区块标记在同一个写事务中提交，
+// 任何时候崩溃都不会留下链尾和 UTXO 集不一致的库。最佳区块标记（metaBucket 的 bestBlock 键）
+// 记录 UTXO 集对应的区块，与链尾不同时（例如链尾切换到另一条分叉，或库来自旧版本）
+// 用撤销数据回滚到分叉点再接入新链尾之前的区块，缺少撤销数据或区块体时重建 UTXO 集。
+// 节点启动时同样检查一次。
+//
+// 撤销数据按区块哈希保存在 undo 桶中，记录区块改动过的每条 UTXO 记录在区块之前的值，
+// 编码见 encoding.go，区块体被修剪时一起删除
+
+const (
+	undoBucket   = "undo"      // 区块哈希 -> 撤销数据
+	bestBlockKey = "bestBlock" // metaBucket 中 UTXO 集对应的区块哈希
+)
+
+var errNoChainstate = errors.New("UTXO set is not built")
+
+// utxoUndo 区块改动过的一条 UTXO 记录在区块之前的编码，Data 为空表示原来没有这条记录
+type utxoUndo struct {
+	TxID []byte
+	Data []byte
+}
+
+// bestBlock UTXO 集对应的区块哈希，从未记录过时返回 nil
+func bestBlock(tx StoreTx) []byte {
+	return indexesOf(tx).Get(metaBucket, []byte(bestBlockKey))
+}
+
+func putBestBlock(tx StoreTx, hash []byte) error {
+	return indexesOf(tx).Put(metaBucket, []byte(bestBlockKey), hash)
+}
+
+// applyBlockUTXO 把区块的交易应用到 UTXO 集，同时写入撤销数据、UTXO 承诺和最佳区块标记。
+// 应用后的承诺与区块头中的不一致时返回错误
+func applyBlockUTXO(tx StoreTx, block *Block) error {
+	utxos := chainStateOf(tx)
+	if utxos.b == nil {
+		return errNoChainstate
+	}
+	acc, err := loadUTXOAccumulator(tx)
+	if err != nil {
+		return err
+	}
+
+	undo, err := applyBlockRecords(utxos, acc, block)
+	if err != nil {
+		return err
+	}
+	if commitment := acc.Commitment(); !bytes.Equal(commitment, block.UTXOCommitment) {
+		return fmt.Errorf("block commits to UTXO set %x, the UTXO set after it is %x", block.UTXOCommitment, commitment)
+	}
+
+	if err := indexesOf(tx).Put(undoBucket, block.Hash, encodeUndo(undo)); err != nil {
+		return err
+	}
+	if err := putUTXOAccumulator(tx, acc); err != nil {
+		return err
+	}
+	if err := putUTXOCommitment(tx, block.Hash); err != nil {
+		return err
+	}
+
+	return putBestBlock(tx, block.Hash)
+}
+
+// applyBlockRecords 把区块的交易应用到 UTXO 记录并更新乘积 acc，返回撤销数据
+func applyBlockRecords(utxos chainState, acc *utxoAccumulator, block *Block) ([]utxoUndo, error) {
+	var undo []utxoUndo
+	touched := make(map[string]bool)
+	touch := func(txID []byte) {
+		if touched[string(txID)] {
+			return
+		}
+		touched[string(txID)] = true
+		undo = append(undo, utxoUndo{append([]byte(nil), txID...), append([]byte(nil), utxos.b.Get(txID)...)})
+	}
+
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			for _, vin := range t.Vin {
+				outs, found := utxos.Outputs(vin.Txid)
+				if !found {
+					return nil, fmt.Errorf("outputs of %x are not in the UTXO set", vin.Txid)
+				}
+				touch(vin.Txid)
+
+				updatedOuts := TXOutputs{Height: outs.Height, Reward: outs.Reward}
+				for i, out := range outs.Outputs {
+					if outs.Indexes[i] != vin.Vout {
+						updatedOuts.add(outs.Indexes[i], out)
+					}
+				}
+				if len(updatedOuts.Outputs) == len(outs.Outputs) {
+					return nil, fmt.Errorf("output %x:%d is not in the UTXO set", vin.Txid, vin.Vout)
+				}
+				var err error
+				if len(updatedOuts.Outputs) == 0 {
+					err = utxos.Delete(vin.Txid)
+				} else {
+					err = utxos.Put(vin.Txid, updatedOuts)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		touch(t.ID)
+		newOutputs := TXOutputs{Height: block.Height, Reward: isReward(t, block.Data)}
+		for outIdx, out := range t.Vout {
+			newOutputs.add(outIdx, out)
+		}
+		if err := utxos.Put(t.ID, newOutputs); err != nil {
+			return nil, err
+		}
+	}
+
+	// 承诺只随被改动的记录更新，撤销数据中是改动前的记录
+	for _, entry := range undo {
+		if len(entry.Data) > 0 {
+			acc.remove(entry.TxID, entry.Data)
+		}
+		if data := utxos.b.Get(entry.TxID); data != nil {
+			acc.add(entry.TxID, data)
+		}
+	}
+
+	return undo, nil
+}
+
+// undoBlockUTXO 用撤销数据把 UTXO 集恢复到区块 hash 之前，最佳区块标记退到 prevHash
+func undoBlockUTXO(tx StoreTx, hash, prevHash []byte) error {
+	utxos := chainStateOf(tx)
+	if utxos.b == nil {
+		return errNoChainstate
+	}
+	undo, err := blockUndo(tx, hash)
+	if err != nil {
+		return err
+	}
+	acc, err := loadUTXOAccumulator(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := undoBlockRecords(utxos, acc, undo); err != nil {
+		return err
+	}
+	if err := putUTXOAccumulator(tx, acc); err != nil {
+		return err
+	}
+	if err := indexesOf(tx).Delete(undoBucket, hash); err != nil {
+		return err
+	}
+
+	return putBestBlock(tx, prevHash)
+}
+
+// undoBlockRecords 把撤销数据写回 UTXO 记录并更新乘积 acc
+func undoBlockRecords(utxos chainState, acc *utxoAccumulator, undo []utxoUndo) error {
+	for _, entry := range undo {
+		if current := utxos.b.Get(entry.TxID); current != nil {
+			acc.remove(entry.TxID, current)
+		}
+		var err error
+		if len(entry.Data) == 0 {
+			err = utxos.b.Delete(entry.TxID)
+		} else {
+			acc.add(entry.TxID, entry.Data)
+			err = utxos.b.Put(entry.TxID, entry.Data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blockUndo 区块 hash 的撤销数据
+func blockUndo(tx StoreTx, hash []byte) ([]utxoUndo, error) {
+	data := indexesOf(tx).Get(undoBucket, hash)
+	if data == nil {
+		return nil, fmt.Errorf("no undo data for block %x", hash)
+	}
+
+	return decodeUndo(data)
+}
+
+// utxoCommitmentAfter 区块 block 接入后 UTXO 集的承诺，出块时写入区块头。
+// 父区块不是最佳区块时先在内存中回滚到分叉点、接入到父区块，不改动库中的 UTXO 集
+func utxoCommitmentAfter(tx StoreTx, block *Block) ([]byte, error) {
+	if len(block.PrevBlockHash) == 0 {
+		acc := newUTXOAccumulator()
+		if _, err := applyBlockRecords(chainState{newUTXOOverlay(nil)}, acc, block); err != nil {
+			return nil, err
+		}
+		return acc.Commitment(), nil
+	}
+
+	utxos, acc, err := utxoViewAt(tx, block.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := applyBlockRecords(utxos, acc, block); err != nil {
+		return nil, err
+	}
+
+	return acc.Commitment(), nil
+}
+
+// utxoViewAt 区块 hash 接入后的 UTXO 集和它的乘积：最佳区块不是 hash 时在内存中回滚到分叉点、
+// 再接入到 hash，不改动库中的 UTXO 集
+func utxoViewAt(tx StoreTx, hash []byte) (chainState, *utxoAccumulator, error) {
+	b := tx.Bucket(utxoBucket)
+	if b == nil || bestBlock(tx) == nil {
+		return chainState{}, nil, errNoChainstate
+	}
+	utxos := chainState{newUTXOOverlay(b)}
+	acc, err := loadUTXOAccumulator(tx)
+	if err != nil {
+		return chainState{}, nil, err
+	}
+	detach, attach, err := chainstatePathTo(tx, hash)
+	if err != nil {
+		return chainState{}, nil, err
+	}
+	for _, hash := range detach {
+		undo, err := blockUndo(tx, hash)
+		if err != nil {
+			return chainState{}, nil, err
+		}
+		if err := undoBlockRecords(utxos, acc, undo); err != nil {
+			return chainState{}, nil, err
+		}
+	}
+	for _, block := range attach {
+		if _, err := applyBlockRecords(utxos, acc, block); err != nil {
+			return chainState{}, nil, err
+		}
+	}
+
+	return utxos, acc, nil
+}
+
+// utxoOverlay 只记在内存中的 UTXO 记录改动，其余记录从 base 读取，base 为 nil 时表示空集合
+type utxoOverlay struct {
+	base    StoreBucket
+	changes map[string][]byte // 值为 nil 表示已删除
+}
+
+func newUTXOOverlay(base StoreBucket) *utxoOverlay {
+	return &utxoOverlay{base, make(map[string][]byte)}
+}
+
+func (o *utxoOverlay) Get(key []byte) []byte {
+	if value, ok := o.changes[string(key)]; ok {
+		return value
+	}
+	if o.base == nil {
+		return nil
+	}
+
+	return o.base.Get(key)
+}
+
+func (o *utxoOverlay) Put(key, value []byte) error {
+	o.changes[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (o *utxoOverlay) Delete(key []byte) error {
+	o.changes[string(key)] = nil
+	return nil
+}
+
+// ForEach 按键的顺序遍历改动后的记录
+func (o *utxoOverlay) ForEach(fn func(k, v []byte) error) error {
+	records := make(map[string][]byte)
+	if o.base != nil {
+		o.base.ForEach(func(k, v []byte) error {
+			records[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	}
+	for k, v := range o.changes {
+		if v == nil {
+			delete(records, k)
+		} else {
+			records[k] = v
+		}
+	}
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := fn([]byte(k), records[k]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// headerIn 事务中的区块头，区块体已被修剪时从 headers 桶读取，找不到时返回 nil
+func headerIn(tx StoreTx, hash []byte) *BlockHeader {
+	if block := blocksOf(tx).Block(hash); block != nil {
+		return block.Header()
+	}
+	if data := indexesOf(tx).Get(headersBucket, hash); data != nil {
+		if header, err := decodeHeader(data); err == nil {
+			return header
+		}
+	}
+
+	return nil
+}
+
+// chainstatePath 让 UTXO 集从最佳区块走到链尾需要回滚的区块（从高到低）和接入的区块（从低到高），
+// 缺少撤销数据或区块体时返回错误
+func chainstatePath(tx StoreTx) (detach [][]byte, attach []*Block, err error) {
+	return chainstatePathTo(tx, blocksOf(tx).Tip())
+}
+
+// chainstatePathTo 同 chainstatePath，目标为区块 tip
+func chainstatePathTo(tx StoreTx, tip []byte) (detach [][]byte, attach []*Block, err error) {
+	best := bestBlock(tx)
+	if best == nil {
+		return nil, nil, errNoChainstate
+	}
+
+	bestHeader, tipHeader := headerIn(tx, best), headerIn(tx, tip)
+	for !bytes.Equal(best, tip) {
+		if bestHeader == nil {
+			return nil, nil, fmt.Errorf("block %x of the UTXO set is not found", best)
+		}
+		if tipHeader == nil {
+			return nil, nil, fmt.Errorf("block %x is not found", tip)
+		}
+		if bestHeader.Height >= tipHeader.Height {
+			if indexesOf(tx).Get(undoBucket, best) == nil {
+				return nil, nil, fmt.Errorf("no undo data for block %x", best)
+			}
+			detach = append(detach, best)
+			best = bestHeader.PrevBlockHash
+			bestHeader = headerIn(tx, best)
+		} else {
+			block := blocksOf(tx).Block(tip)
+			if block == nil {
+				return nil, nil, fmt.Errorf("block %x is pruned", tip)
+			}
+			attach = append([]*Block{block}, attach...)
+			tip = tipHeader.PrevBlockHash
+			tipHeader = headerIn(tx, tip)
+		}
+	}
+
+	return detach, attach, nil
+}
+
+// syncChainstate 让 UTXO 集跟上链尾，detach 和 attach 来自 chainstatePath
+func syncChainstate(tx StoreTx, detach [][]byte, attach []*Block) error {
+	for _, hash := range detach {
+		header := headerIn(tx, hash)
+		if err := undoBlockUTXO(tx, hash, header.PrevBlockHash); err != nil {
+			return err
+		}
+	}
+	for _, block := range attach {
+		if err := applyBlockUTXO(tx, block); err != nil {
+			return fmt.Errorf("connect block %x: %v", block.Hash, err)
+		}
+	}
+
+	return nil
+}
+
+// connectBlock 写入区块，区块比链尾高时成为新的链尾并接入 UTXO 集。
+// UTXO 集还没有建立时只写入区块；无法跟上新链尾时返回 stale，由调用方重建
+func connectBlock(tx StoreTx, block *Block) (isTip, stale bool, err error) {
+	blocks := blocksOf(tx)
+	if blocks.Has(block.Hash) {
+		return false, false, nil
+	}
+	if err := blocks.Put(block); err != nil {
+		return false, false, err
+	}
+	if err := putBlockFilter(tx, block); err != nil {
+		return false, false, err
+	}
+	if last := blocks.TipBlock(); last != nil && block.Height <= last.Height {
+		return false, false, nil
+	}
+	if err := blocks.SetTip(block.Hash); err != nil {
+		return false, false, err
+	}
+
+	if bestBlock(tx) == nil {
+		return true, false, nil
+	}
+	detach, attach, err := chainstatePath(tx)
+	if err != nil {
+		logWarnf("UTXO set cannot follow block %x: %v", block.Hash, err)
+		return true, true, nil
+	}
+	if len(detach) > 0 {
+		logInfof("switching to block %x, disconnecting %d blocks", block.Hash, len(detach))
+	}
+
+	return true, false, syncChainstate(tx, detach, attach)
+}
+
+// connect 原子地写入并接入区块，返回区块是否成为新的链尾
+func (bc *Blockchain) connect(block *Block) bool {
+	var isTip, stale bool
+	err := bc.db.Update(func(tx StoreTx) error {
+		var err error
+		isTip, stale, err = connectBlock(tx, block)
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	if isTip {
+		bc.setTip(block.Hash)
+	}
+	if stale {
+		UTXOSet{bc}.Reindex()
+	}
+
+	return isTip
+}
+
+// acceptBlock 在同一个写事务中按共识规则检查并接入区块，检查不通过时什么也不写入，
+// 返回区块是否成为新的链尾。本地已有的区块直接跳过，父区块不在本地时返回 errOrphanBlock
+func (bc *Blockchain) acceptBlock(block *Block) (bool, error) {
+	var isTip, stale bool
+	err := bc.db.Update(func(tx StoreTx) error {
+		blocks := blocksOf(tx)
+		if blocks.Has(block.Hash) {
+			return nil
+		}
+		if len(block.PrevBlockHash) > 0 {
+			if !blocks.Has(block.PrevBlockHash) {
+				return errOrphanBlock
+			}
+			if err := validateBlock(tx, block); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		isTip, stale, err = connectBlock(tx, block)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if isTip {
+		bc.setTip(block.Hash)
+	}
+	if stale {
+		UTXOSet{bc}.Reindex()
+	}
+
+	return isTip, nil
+}
+
+// repairChainstate 检查 UTXO 集是否对应链尾，不对应时回滚或接入区块，做不到时重建
+func (bc *Blockchain) repairChainstate() {
+	rebuild := false
+	err := bc.db.Update(func(tx StoreTx) error {
+		best, tip := bestBlock(tx), blocksOf(tx).Tip()
+		if bytes.Equal(best, tip) {
+			return nil
+		}
+		detach, attach, err := chainstatePath(tx)
+		if err != nil {
+			logWarnf("UTXO set does not match the tip %x and cannot be repaired (%v), rebuilding it", tip, err)
+			rebuild = true
+			return nil
+		}
+		logInfof("UTXO set is at block %x but the tip is %x, disconnecting %d and connecting %d blocks", best, tip, len(detach), len(attach))
+
+		return syncChainstate(tx, detach, attach)
+	})
+	if err != nil {
+		logWarnf("repair UTXO set: %v, rebuilding it", err)
+		rebuild = true
+	}
+	if rebuild {
+		UTXOSet{bc}.Reindex()
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reindexedCommitment 从区块重建 UTXO 集后的承诺
+func reindexedCommitment(bc *Blockchain) []byte {
+	utxos := UTXOSet{bc}
+	utxos.Reindex()
+
+	return utxos.Commitment()
+}
+
+func chainstateBest(bc *Blockchain) []byte {
+	var best []byte
+	bc.db.View(func(tx StoreTx) error {
+		best = bestBlock(tx)
+		return nil
+	})
+
+	return best
+}
+
+// setTipOnly 只移动链尾，相当于旧版本在写入区块和更新 UTXO 集之间崩溃
+func setTipOnly(t *testing.T, bc *Blockchain, block *Block) {
+	assert.Nil(t, bc.db.Update(func(tx StoreTx) error {
+		blocks := blocksOf(tx)
+		if err := blocks.Put(block); err != nil {
+			return err
+		}
+		return blocks.SetTip(block.Hash)
+	}))
+	bc.setTip(block.Hash)
+}
+
+func TestConnectBlock(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	other := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	utxos := UTXOSet{bc}
+	utxos.Reindex()
+	_, err = bc.Generate(chainParams.CoinbaseMaturity, other)
+	assert.Nil(t, err)
+
+	// 区块、UTXO 集和撤销数据一起写入
+	unspent := utxos.Balance(address)
+	wallets := &Wallets{Wallets: map[string]*Wallet{address: wallet}}
+	tx := NewUTXOTransaction(wallets, address, other, 5, &utxos, DefaultSendOptions())
+	spend := bc.MineBlock([]*Transaction{bc.NewRewardTX(other, []*Transaction{tx}), tx})
+	assert.Equal(t, spend.Hash, chainstateBest(bc))
+	commitment := utxos.Commitment()
+	stored, err := bc.UTXOCommitment(spend.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, commitment, stored)
+	utxos.Update(spend)
+	assert.Equal(t, commitment, utxos.Commitment(), "updating with a connected block does nothing")
+	filter, err := bc.BlockFilter(spend.Hash)
+	assert.Nil(t, err)
+	assert.Nil(t, filter, "filters are written once the index exists")
+	assert.Equal(t, commitment, reindexedCommitment(bc))
+	balance := utxos.Balance(address)
+
+	// 链尾退回上一个区块：用撤销数据回滚
+	parent, err := bc.GetBlock(spend.PrevBlockHash)
+	assert.Nil(t, err)
+	setTipOnly(t, bc, &parent)
+	bc.repairChainstate()
+	assert.Equal(t, parent.Hash, chainstateBest(bc))
+	stored, err = bc.UTXOCommitment(parent.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, stored, utxos.Commitment())
+	assert.Equal(t, stored, reindexedCommitment(bc))
+
+	// 区块写入了但 UTXO 集没有更新：启动时接入
+	setTipOnly(t, bc, spend)
+	bc.repairChainstate()
+	assert.Equal(t, spend.Hash, chainstateBest(bc))
+	assert.Equal(t, commitment, utxos.Commitment())
+	assert.Equal(t, balance, utxos.Balance(address))
+
+	// 更长的分叉：回滚花费交易，接入分叉上的区块
+	assert.Nil(t, bc.IndexFilters())
+	fork1 := blockOn(t, bc, []*Transaction{NewCoinbaseTX(other, "fork 1")}, parent.Hash, spend.Height, 0)
+	bc.AddBlock(fork1)
+	assert.Equal(t, spend.Hash, bc.Tip())
+	fork2 := blockOn(t, bc, []*Transaction{NewCoinbaseTX(other, "fork 2")}, fork1.Hash, spend.Height+1, 0)
+	bc.AddBlock(fork2)
+	assert.Equal(t, fork2.Hash, bc.Tip())
+	assert.Equal(t, fork2.Hash, chainstateBest(bc))
+	assert.Equal(t, reindexedCommitment(bc), utxos.Commitment())
+	assert.Equal(t, unspent, utxos.Balance(address), "the spend is undone")
+	filter, err = bc.BlockFilter(fork2.Hash)
+	assert.Nil(t, err)
+	assert.NotNil(t, filter)
+
+	// 没有撤销数据时重建
+	assert.Nil(t, bc.db.Update(func(tx StoreTx) error {
+		return tx.DeleteBucket(undoBucket)
+	}))
+	setTipOnly(t, bc, spend)
+	bc.repairChainstate()
+	assert.Equal(t, spend.Hash, chainstateBest(bc))
+	assert.Equal(t, commitment, utxos.Commitment())
+}
+
+// blockOn 创建接在 prev 之后、区块头带有正确 UTXO 承诺的区块
+func blockOn(t *testing.T, bc *Blockchain, transactions []*Transaction, prev []byte, height, consensusType int) *Block {
+	block, err := bc.newBlockOn(transactions, prev, height, consensusType, []byte("MineBlock"))
+	assert.Nil(t, err)
+
+	return block
+}
+
+// 花费 UTXO 集中没有的输出时不接入区块
+func TestApplyBlockRecordsRejectsMissingOutput(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	prev := NewCoinbaseTX(address, "prev")
+	utxos := chainState{newUTXOOverlay(nil)}
+	assert.Nil(t, utxos.Put(prev.ID, TXOutputs{Indexes: []int{0}, Outputs: prev.Vout}))
+
+	spend := &Transaction{nil, []TXInput{{prev.ID, 1, nil, nil}}, []TXOutput{*NewTXOutput(1, address)}}
+	spend.ID = spend.Hash()
+	_, err := applyBlockRecords(utxos, newUTXOAccumulator(), &Block{Transactions: []*Transaction{spend}, Height: 1})
+	assert.NotNil(t, err)
+
+	spend.Vin[0].Vout = 0
+	spend.ID = spend.Hash()
+	_, err = applyBlockRecords(utxos, newUTXOAccumulator(), &Block{Transactions: []*Transaction{spend}, Height: 1})
+	assert.Nil(t, err)
+	_, err = applyBlockRecords(utxos, newUTXOAccumulator(), &Block{Transactions: []*Transaction{spend}, Height: 2})
+	assert.NotNil(t, err, "the output is already spent")
+}
+
+// 重建 UTXO 集时最佳区块在同一个事务中更新为重建所依据的链尾
+func TestReindexSetsBestBlock(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	UTXOSet{bc}.Reindex()
+	assert.Equal(t, genesis.Hash, chainstateBest(bc))
+
+	block := blockOn(t, bc, []*Transaction{bc.NewRewardTX(address, nil)}, genesis.Hash, 1, 0)
+	setTipOnly(t, bc, block)
+	assert.Equal(t, genesis.Hash, chainstateBest(bc))
+	UTXOSet{bc}.Reindex()
+	assert.Equal(t, block.Hash, chainstateBest(bc))
+	assert.Equal(t, block.UTXOCommitment, UTXOSet{bc}.Commitment())
+}
+
+// 没有最佳区块（例如截断区块链后重建 UTXO 集之前崩溃）时，启动修复重建 UTXO 集
+func TestRepairChainstateWithoutBestBlock(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	UTXOSet{bc}.Reindex()
+	_, err = bc.Generate(2, address)
+	assert.Nil(t, err)
+	commitment := UTXOSet{bc}.Commitment()
+
+	assert.Nil(t, bc.db.Update(func(tx StoreTx) error {
+		return indexesOf(tx).Delete(metaBucket, []byte(bestBlockKey))
+	}))
+	bc.repairChainstate()
+	assert.Equal(t, bc.Tip(), chainstateBest(bc))
+	assert.Equal(t, commitment, UTXOSet{bc}.Commitment())
+}
+
+func TestUndoEncoding(t *testing.T) {
+	undo := []utxoUndo{{[]byte{1, 2}, []byte{3}}, {[]byte{4}, nil}}
+	decoded, err := decodeUndo(encodeUndo(undo))
+	assert.Nil(t, err)
+	assert.Equal(t, undo, decoded)
+	_, err = decodeUndo(encodeUndo(undo)[:3])
+	assert.NotNil(t, err)
+}
+
+// 节点启动时修复链尾和 UTXO 集不一致的库
+func TestNewBlockchainRepairsChainstate(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	nodeID := "127.0.0.1 3000"
+	address := string(NewWallet().GetAddress())
+
+	bc := CreateBlockchain(address, nodeID, chainParams)
+	UTXOSet{bc}.Reindex()
+	_, err := bc.Generate(2, address)
+	assert.Nil(t, err)
+	commitment := UTXOSet{bc}.Commitment()
+	block := blockOn(t, bc, []*Transaction{bc.NewRewardTX(address, nil)}, bc.Tip(), bc.GetBestHeight()+1, 0)
+	setTipOnly(t, bc, block)
+	assert.Equal(t, commitment, UTXOSet{bc}.Commitment())
+	bc.db.Close()
+
+	bc = NewBlockchain(nodeID)
+	defer bc.db.Close()
+	assert.Equal(t, block.Hash, chainstateBest(bc))
+	assert.NotEqual(t, commitment, UTXOSet{bc}.Commitment())
+	assert.Equal(t, UTXOSet{bc}.Commitment(), reindexedCommitment(bc))
+}
+
+// 检查不通过的区块不写入任何数据
+func TestAcceptBlock(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	utxos := UTXOSet{bc}
+	utxos.Reindex()
+
+	greedy := NewBlock([]*Transaction{NewCoinbaseTX2(address, "", BlockSubsidy(1)+1)}, genesis.Hash, 1, 1, nil)
+	_, err = bc.acceptBlock(greedy)
+	assert.NotNil(t, err)
+	_, err = bc.GetBlock(greedy.Hash)
+	assert.NotNil(t, err, "the rejected block is not stored")
+	assert.Equal(t, genesis.Hash, bc.Tip())
+	assert.Equal(t, genesis.Hash, chainstateBest(bc))
+
+	reward := []*Transaction{NewRewardTX(address, 1, 0)}
+	_, err = bc.acceptBlock(NewBlock(reward, genesis.Hash, 1, 1, nil))
+	assert.NotNil(t, err, "the header does not commit to the UTXO set")
+	assert.Equal(t, genesis.Hash, bc.Tip())
+
+	block := blockOn(t, bc, reward, genesis.Hash, 1, 1)
+	isTip, err := bc.acceptBlock(block)
+	assert.Nil(t, err)
+	assert.True(t, isTip)
+	assert.Equal(t, block.Hash, chainstateBest(bc))
+
+	isTip, err = bc.acceptBlock(block)
+	assert.Nil(t, err)
+	assert.False(t, isTip, "a known block is skipped")
+}
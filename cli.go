@@ -10,112 +10,377 @@ import (
 
 // CLI responsible for processing command line arguments
 //负责处理命令行参数的CLI
-type CLI struct{}
+type CLI struct {
+	config *NodeConfig
+}
 
 func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
-	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
+	fmt.Println("Usage: blockchain_go [global flags] COMMAND [command flags]")
+	fmt.Println("Global flags (override the -config file, ./node.json by default):")
+	fmt.Println("  -config FILE -datadir DIR -listen IP:PORT -rpclisten ADDR -miner ADDRESS -shard N -seeds IP:PORT,... -loglevel LEVEL -network NETWORK -genesis FILE -keepopen=false -light -snapshot -prune N")
+	fmt.Println("  -light runs startnode as a light client that syncs block headers from the first seed; getbalance and send then use Merkle proven transactions")
+	fmt.Println("  -snapshot makes startnode bootstrap a node without a blockchain from the UTXO snapshot of the first seed")
+	fmt.Println("  -prune N makes startnode keep only the bodies of the last N blocks, older blocks keep their headers")
+	fmt.Println("  Without a COMMAND the HTTP interface is started on -rpclisten")
+	fmt.Println("Commands:")
+	fmt.Println("  auditsupply - Sum the UTXO set and check it against the chain and the emission schedule")
+	fmt.Println("  createblockchain -address ADDRESS -treasury ADDR1,ADDR2,... -threshold M - Create a blockchain and send genesis block reward to ADDRESS (not needed when the genesis file has allocations); mints need M signatures of the treasury addresses")
+	fmt.Println("  createmint -to ADDRESS -amount AMOUNT (or -to ADDRESS:AMOUNT ..., -csv FILE) -memo MEMO -out FILE - Create an unsigned treasury mint")
+	fmt.Println("  createpsbt -from FROM -to TO -amount AMOUNT -out FILE - Create an unsigned transaction with its previous outputs embedded")
+	fmt.Println("  encryptwallet -passphrase PASSPHRASE - Encrypt the private keys in the wallet file")
+	fmt.Println("  walletpassphrasechange -old OLD -new NEW - Change the wallet passphrase")
+	fmt.Println("  createhdwallet - Generates a mnemonic and turns the wallet file into a HD wallet")
+	fmt.Println("  createwallet -change - Generates a new key-pair (derives the next address of a HD wallet, on the change chain when -change is set) and saves it into the wallet file")
+	fmt.Println("  combinepsbt -in PSBT1,PSBT2 -out FILE - Combine signatures of the same partially signed transaction")
+	fmt.Println("  finalizepsbt -in PSBT -broadcast - Check all signatures and print the final transaction, send it to the network when -broadcast is set")
+	fmt.Println("  exportchain -from N -to M -file FILE - Write the blocks at heights N to M (default: the tip) to a portable file")
+	fmt.Println("  exportsnapshot -height N -out FILE - Write the UTXO set after the block at height N (default: the tip) with the block headers proving it")
+	fmt.Println("  generate -n N -address ADDRESS - Mine N blocks at once and send their rewards to ADDRESS (regtest only)")
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
-	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
+	fmt.Println("  importaddress -address ADDRESS -pubkey HEX -label LABEL - Watch ADDRESS (or the address of a public key) without its private key")
+	fmt.Println("  importchain -file FILE - Validate the blocks of an exportchain file, add them to the blockchain (creating it from the genesis block if needed) and rebuild the UTXO set")
+	fmt.Println("  importsnapshot -in FILE - Verify a UTXO snapshot and create the blockchain from it, later blocks are synced by startnode")
+	fmt.Println("  importprivkey -key KEY -label LABEL - Import a private key exported by dumpprivkey")
+	fmt.Println("  dumpprivkey -address ADDRESS - Print the private key of ADDRESS in Base58Check format")
+	fmt.Println("  listaddresses -balance - Lists all addresses from the wallet file, including watch-only addresses and labels")
+	fmt.Println("  setlabel -address ADDRESS -label LABEL - Label an address of the wallet, an empty label removes it")
+	fmt.Println("  listmints - List all treasury mints of the blockchain")
+	fmt.Println("  pruneblockchain -keep N - Delete the bodies of all blocks but the last N (and the genesis block), keeping their headers")
+	fmt.Println("  migratedb -file PATH - Convert a gob encoded blockchain database to the canonical encoding (default: the node's database)")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
+	fmt.Println("  restorewallet -mnemonic MNEMONIC -gap N - Restore a HD wallet from its mnemonic by rescanning the UTXO set")
 	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("       -to ADDRESS:AMOUNT -to ADDRESS:AMOUNT ... or -csv FILE - Pay several addresses in one transaction")
+	fmt.Println("       -strategy bnb|largest|smallest|random -fee FEE -dust DUST -reusechange -dryrun - Choose coins, fee and change; -dryrun previews the transaction")
+	fmt.Println("  signmessage -address ADDRESS -message MESSAGE - Sign MESSAGE with the key of ADDRESS, prints a compact recoverable signature")
+	fmt.Println("  signmint -in MINT -address ADDRESS -out FILE - Sign a mint with the treasury addresses of the wallet file")
+	fmt.Println("  signpsbt -in PSBT -address ADDRESS -sighash ALL -out FILE - Sign a partially signed transaction with the wallets in the wallet file")
+	fmt.Println("  startnode -miner ADDRESS - Start a node listening on -listen. -miner enables mining")
+	fmt.Println("  submitmint -in MINT -node HOST:PORT - Send a fully signed mint to a node to be added to the blockchain")
 	fmt.Println("  testsend -data ADDRESS - Send test data to ADDRESS")
+	fmt.Println("  verifychain -depth N -level L -truncate - Check the last N blocks (0: all) at level L (0-3, 3 also rebuilds the UTXO set and compares it), -truncate rewinds the tip to the last good block")
+	fmt.Println("  verifymessage -address ADDRESS -signature SIGNATURE -message MESSAGE - Check that MESSAGE was signed by the key of ADDRESS")
+	fmt.Println("NETWORK env. var. selects main (default), testnet or regtest; GENESIS_FILE or ./genesis.json overrides the network parameters")
+	fmt.Println("NODE_ID=\"IP PORT\" env. var. is still accepted when -listen is not set")
 }
 
-func (cli *CLI) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *CLI) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
 		os.Exit(1)
 	}
 }
 
 // Run parses command line arguments and processes commands
-func (cli *CLI) Run() {
+// args 是全局参数之后的命令和命令参数，节点由 cli.config 的监听地址确定
+func (cli *CLI) Run(args []string) {
 	fmt.Println("-==-=-=-=-=-=-=-=-=")
 	fmt.Println("cli.validateArgs()")
 	fmt.Println("-==-=-=-=-=-=-=-=-=")
-	cli.validateArgs()
+	cli.validateArgs(args)
 
-	nodeID := os.Getenv("NODE_ID")
-	if nodeID == "" {
-		fmt.Printf("NODE_ID env. var is not set!")
-		os.Exit(1)
-	}
+	nodeID := cli.config.NodeID()
 	//打印nodeID
 	fmt.Printf("NODE_ID:%s\n", nodeID)
 
+	auditSupplyCmd := flag.NewFlagSet("auditsupply", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	exportSnapshotCmd := flag.NewFlagSet("exportsnapshot", flag.ExitOnError)
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	importSnapshotCmd := flag.NewFlagSet("importsnapshot", flag.ExitOnError)
+	pruneBlockchainCmd := flag.NewFlagSet("pruneblockchain", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
+	createMintCmd := flag.NewFlagSet("createmint", flag.ExitOnError)
+	signMintCmd := flag.NewFlagSet("signmint", flag.ExitOnError)
+	submitMintCmd := flag.NewFlagSet("submitmint", flag.ExitOnError)
+	listMintsCmd := flag.NewFlagSet("listmints", flag.ExitOnError)
+	createPSBTCmd := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+	signPSBTCmd := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+	combinePSBTCmd := flag.NewFlagSet("combinepsbt", flag.ExitOnError)
+	finalizePSBTCmd := flag.NewFlagSet("finalizepsbt", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	walletPassphraseChangeCmd := flag.NewFlagSet("walletpassphrasechange", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	createHDWalletCmd := flag.NewFlagSet("createhdwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	migrateDBCmd := flag.NewFlagSet("migratedb", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 	testsendCmd := flag.NewFlagSet("testsend", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	exportChainFrom := exportChainCmd.Int("from", 0, "Height of the first block to export")
+	exportChainTo := exportChainCmd.Int("to", -1, "Height of the last block to export, the tip when negative")
+	exportChainFile := exportChainCmd.String("file", "", "File to write the blocks to")
+	importChainFile := importChainCmd.String("file", "", "File written by exportchain")
+	exportSnapshotHeight := exportSnapshotCmd.Int("height", -1, "Height of the snapshot block, the tip when negative")
+	exportSnapshotOut := exportSnapshotCmd.String("out", "", "File to write the snapshot to")
+	generateBlocks := generateCmd.Int("n", 1, "Number of blocks to mine")
+	importSnapshotIn := importSnapshotCmd.String("in", "", "Snapshot file written by exportsnapshot")
+	pruneBlockchainKeep := pruneBlockchainCmd.Int("keep", 0, "Number of recent blocks whose bodies are kept")
+	verifyChainDepth := verifyChainCmd.Int("depth", 0, "Number of blocks to check from the tip, 0 checks all of them")
+	verifyChainLevel := verifyChainCmd.Int("level", verifyLevelUTXO, "How thorough the check is, 0-3")
+	verifyChainTruncate := verifyChainCmd.Bool("truncate", false, "Remove the bad blocks and the blocks after them, then rebuild the UTXO set")
+	generateAddress := generateCmd.String("address", "", "The address to send the block rewards to")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainTreasury := createBlockchainCmd.String("treasury", "", "Comma separated treasury addresses that authorize mints")
+	createBlockchainThreshold := createBlockchainCmd.Int("threshold", 0, "Number of treasury signatures a mint needs")
+	migrateDBFile := migrateDBCmd.String("file", "", "Path of the database to migrate")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
+	var sendTo recipientFlags
+	sendCmd.Var(&sendTo, "to", "Destination wallet address, or ADDRESS:AMOUNT; repeat to pay several addresses")
+	sendCSV := sendCmd.String("csv", "", "CSV file of ADDRESS,AMOUNT lines to pay")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
-	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	sendStrategy := sendCmd.String("strategy", defaultCoinSelector, "Coin selection strategy: bnb, largest, smallest or random")
+	sendFee := sendCmd.Int("fee", 0, "Transaction fee")
+	sendDust := sendCmd.Int("dust", defaultDustThreshold, "Change below this amount is added to the fee")
+	sendReuseChange := sendCmd.Bool("reusechange", false, "Send change back to the source address instead of a new one")
+	sendDryRun := sendCmd.Bool("dryrun", false, "Print inputs, outputs and fee without signing or sending")
+	var createMintTo recipientFlags
+	createMintCmd.Var(&createMintTo, "to", "Address to mint to, or ADDRESS:AMOUNT; repeat to pay several addresses")
+	createMintAmount := createMintCmd.Int("amount", 0, "Amount to mint")
+	createMintCSV := createMintCmd.String("csv", "", "CSV file of ADDRESS,AMOUNT lines to mint to")
+	createMintMemo := createMintCmd.String("memo", "", "Reason of the mint, recorded on chain")
+	createMintOut := createMintCmd.String("out", "", "Write the mint to FILE instead of printing it")
+	signMintIn := signMintCmd.String("in", "", "Mint file or base64 text")
+	signMintAddress := signMintCmd.String("address", "", "Sign only with this treasury address")
+	signMintOut := signMintCmd.String("out", "", "Write the mint to FILE instead of printing it")
+	submitMintIn := submitMintCmd.String("in", "", "Mint file or base64 text")
+	submitMintNode := submitMintCmd.String("node", "", "Address of the node, HOST:PORT")
+	createPSBTFrom := createPSBTCmd.String("from", "", "Source wallet address")
+	createPSBTTo := createPSBTCmd.String("to", "", "Destination wallet address")
+	createPSBTAmount := createPSBTCmd.Int("amount", 0, "Amount to send")
+	createPSBTOut := createPSBTCmd.String("out", "", "Write the PSBT to FILE instead of printing it")
+	signPSBTIn := signPSBTCmd.String("in", "", "PSBT file or base64 text")
+	signPSBTAddress := signPSBTCmd.String("address", "", "Sign only with this wallet address")
+	signPSBTSighash := signPSBTCmd.String("sighash", "ALL", "Signature hash type, e.g. ALL, SINGLE|ANYONECANPAY")
+	signPSBTOut := signPSBTCmd.String("out", "", "Write the PSBT to FILE instead of printing it")
+	combinePSBTIn := combinePSBTCmd.String("in", "", "Comma separated PSBT files or base64 texts")
+	combinePSBTOut := combinePSBTCmd.String("out", "", "Write the PSBT to FILE instead of printing it")
+	finalizePSBTIn := finalizePSBTCmd.String("in", "", "PSBT file or base64 text")
+	finalizePSBTBroadcast := finalizePSBTCmd.Bool("broadcast", false, "Send the final transaction to the network")
+	encryptWalletPassphrase := encryptWalletCmd.String("passphrase", "", "Passphrase to encrypt the wallet with")
+	walletPassphraseOld := walletPassphraseChangeCmd.String("old", "", "Current wallet passphrase")
+	walletPassphraseNew := walletPassphraseChangeCmd.String("new", "", "New wallet passphrase")
+	signMessageAddress := signMessageCmd.String("address", "", "Wallet address to sign with")
+	signMessageMessage := signMessageCmd.String("message", "", "Message to sign")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", "Address that signed the message")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", "Base64 signature printed by signmessage")
+	verifyMessageMessage := verifyMessageCmd.String("message", "", "Signed message")
+	listAddressesBalance := listAddressesCmd.Bool("balance", false, "Print the balance of each address")
+	importAddressAddress := importAddressCmd.String("address", "", "Address to watch")
+	importAddressPubKey := importAddressCmd.String("pubkey", "", "Hex encoded public key to watch")
+	importAddressLabel := importAddressCmd.String("label", "", "Label of the address")
+	importPrivKeyKey := importPrivKeyCmd.String("key", "", "Private key printed by dumpprivkey")
+	importPrivKeyLabel := importPrivKeyCmd.String("label", "", "Label of the address")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "Wallet address")
+	setLabelAddress := setLabelCmd.String("address", "", "Wallet or watch-only address")
+	setLabelLabel := setLabelCmd.String("label", "", "New label")
+	createWalletChange := createWalletCmd.Bool("change", false, "Derive a change address of a HD wallet")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "Mnemonic of the HD wallet")
+	restoreWalletGap := restoreWalletCmd.Int("gap", hdDefaultGap, "Stop after N consecutive unused addresses")
+	startNodeMiner := startNodeCmd.String("miner", cli.config.Miner, "Enable mining mode and send reward to ADDRESS")
 	sendaddr := testsendCmd.String("sendaddr", "", "Send test data to ADDRESS")
 	testsendData := testsendCmd.String("data", "", "Send test data to ADDRESS")
 
-	switch os.Args[1] {
+	switch args[0] {
+	case "auditsupply":
+		err := auditSupplyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportsnapshot":
+		err := exportSnapshotCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "generate":
+		err := generateCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importchain":
+		err := importChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importsnapshot":
+		err := importSnapshotCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "pruneblockchain":
+		err := pruneBlockchainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmint":
+		err := createMintCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmint":
+		err := signMintCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "submitmint":
+		err := submitMintCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listmints":
+		err := listMintsCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createpsbt":
+		err := createPSBTCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signpsbt":
+		err := signPSBTCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "combinepsbt":
+		err := combinePSBTCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "finalizepsbt":
+		err := finalizePSBTCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "walletpassphrasechange":
+		err := walletPassphraseChangeCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createhdwallet":
+		err := createHDWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importaddress":
+		err := importAddressCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setlabel":
+		err := setLabelCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "migratedb":
+		err := migrateDBCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "reindexutxo":
-		err := reindexUTXOCmd.Parse(os.Args[2:])
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmessage":
+		err := signMessageCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifymessage":
+		err := verifyMessageCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "startnode":
-		err := startNodeCmd.Parse(os.Args[2:])
+		err := startNodeCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "testsend":
-		err := testsendCmd.Parse(os.Args[2:])
+		err := testsendCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	default:
 		fmt.Println("-==-=-=-=-=-=-=-=-=")
-		fmt.Println(os.Args[1])
+		fmt.Println(args[0])
 		fmt.Println("-==-=-=-=-=-=-=-=-=")
 		cli.printUsage()
 		os.Exit(1)
@@ -130,19 +395,197 @@ func (cli *CLI) Run() {
 	}
 
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
+		if *createBlockchainAddress == "" && len(chainParams.Allocations) == 0 {
 			createBlockchainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+		cli.createBlockchain(*createBlockchainAddress, nodeID, *createBlockchainTreasury, *createBlockchainThreshold)
+	}
+
+	if createPSBTCmd.Parsed() {
+		if *createPSBTFrom == "" || *createPSBTTo == "" || *createPSBTAmount <= 0 {
+			createPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createPSBT(*createPSBTFrom, *createPSBTTo, *createPSBTAmount, nodeID, *createPSBTOut)
+	}
+
+	if signPSBTCmd.Parsed() {
+		if *signPSBTIn == "" {
+			signPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signPSBT(*signPSBTIn, *signPSBTAddress, *signPSBTSighash, nodeID, *signPSBTOut)
+	}
+
+	if combinePSBTCmd.Parsed() {
+		if *combinePSBTIn == "" {
+			combinePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.combinePSBT(*combinePSBTIn, *combinePSBTOut)
+	}
+
+	if finalizePSBTCmd.Parsed() {
+		if *finalizePSBTIn == "" {
+			finalizePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.finalizePSBT(*finalizePSBTIn, *finalizePSBTBroadcast)
+	}
+
+	if encryptWalletCmd.Parsed() {
+		if *encryptWalletPassphrase == "" {
+			encryptWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.encryptWallet(nodeID, *encryptWalletPassphrase)
+	}
+
+	if walletPassphraseChangeCmd.Parsed() {
+		if *walletPassphraseOld == "" || *walletPassphraseNew == "" {
+			walletPassphraseChangeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.changeWalletPassphrase(nodeID, *walletPassphraseOld, *walletPassphraseNew)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		cli.createWallet(nodeID, *createWalletChange)
+	}
+
+	if createHDWalletCmd.Parsed() {
+		cli.createHDWallet(nodeID)
+	}
+
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" {
+			restoreWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.restoreWallet(nodeID, *restoreWalletMnemonic, *restoreWalletGap)
 	}
 
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
+		cli.listAddresses(nodeID, *listAddressesBalance)
+	}
+
+	if importAddressCmd.Parsed() {
+		if *importAddressAddress == "" && *importAddressPubKey == "" {
+			importAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importAddress(nodeID, *importAddressAddress, *importAddressPubKey, *importAddressLabel)
+	}
+
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKeyKey == "" {
+			importPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPrivKey(nodeID, *importPrivKeyKey, *importPrivKeyLabel)
+	}
+
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpPrivKey(nodeID, *dumpPrivKeyAddress)
+	}
+
+	if setLabelCmd.Parsed() {
+		if *setLabelAddress == "" {
+			setLabelCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setLabel(nodeID, *setLabelAddress, *setLabelLabel)
+	}
+
+	if migrateDBCmd.Parsed() {
+		cli.migrateDB(nodeID, *migrateDBFile)
+	}
+
+	if createMintCmd.Parsed() {
+		recipients, err := sendRecipients(createMintTo, *createMintAmount, *createMintCSV)
+		if err != nil {
+			fmt.Println(err)
+			createMintCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMint(recipients, *createMintMemo, nodeID, *createMintOut)
+	}
+
+	if signMintCmd.Parsed() {
+		if *signMintIn == "" {
+			signMintCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMint(*signMintIn, *signMintAddress, nodeID, *signMintOut)
+	}
+
+	if submitMintCmd.Parsed() {
+		if *submitMintIn == "" || *submitMintNode == "" {
+			submitMintCmd.Usage()
+			os.Exit(1)
+		}
+		cli.submitMint(*submitMintIn, *submitMintNode)
+	}
+
+	if listMintsCmd.Parsed() {
+		cli.listMints(nodeID)
+	}
+
+	if auditSupplyCmd.Parsed() {
+		cli.auditSupply(nodeID)
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainFile == "" {
+			exportChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportChain(nodeID, *exportChainFrom, *exportChainTo, *exportChainFile)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainFile == "" {
+			importChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importChain(nodeID, *importChainFile)
+	}
+
+	if exportSnapshotCmd.Parsed() {
+		if *exportSnapshotOut == "" {
+			exportSnapshotCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportSnapshot(nodeID, *exportSnapshotHeight, *exportSnapshotOut)
+	}
+
+	if importSnapshotCmd.Parsed() {
+		if *importSnapshotIn == "" {
+			importSnapshotCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importSnapshot(nodeID, *importSnapshotIn)
+	}
+
+	if pruneBlockchainCmd.Parsed() {
+		if *pruneBlockchainKeep <= 0 {
+			pruneBlockchainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.pruneBlockchain(nodeID, *pruneBlockchainKeep)
+	}
+
+	if generateCmd.Parsed() {
+		if *generateAddress == "" {
+			generateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.generate(nodeID, *generateAddress, *generateBlocks)
 	}
 
 	if printChainCmd.Parsed() {
@@ -153,23 +596,44 @@ func (cli *CLI) Run() {
 		cli.reindexUTXO(nodeID)
 	}
 
+	if verifyChainCmd.Parsed() {
+		cli.verifyChain(nodeID, *verifyChainDepth, *verifyChainLevel, *verifyChainTruncate)
+	}
+
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		recipients, err := sendRecipients(sendTo, *sendAmount, *sendCSV)
+		if *sendFrom == "" || err != nil {
+			if err != nil {
+				fmt.Println(err)
+			}
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		opts := SendOptions{*sendStrategy, *sendFee, *sendDust, *sendReuseChange, *sendDryRun}
+		cli.send(*sendFrom, recipients, nodeID, *sendMine, opts)
 	}
 
-	if startNodeCmd.Parsed() {
-		nodeID := os.Getenv("NODE_ID")
-		if nodeID == "" {
-			startNodeCmd.Usage()
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
 			os.Exit(1)
 		}
+		cli.signMessage(nodeID, *signMessageAddress, *signMessageMessage)
+	}
+
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyMessage(*verifyMessageAddress, *verifyMessageSignature, *verifyMessageMessage)
+	}
+
+	if startNodeCmd.Parsed() {
 		fmt.Println("nodeID:", nodeID)
 		fmt.Println("startNodeMiner:", *startNodeMiner)
+		cli.config.Miner = *startNodeMiner
 		cli.startNode(nodeID, *startNodeMiner)
 	}
 	if testsendCmd.Parsed() {
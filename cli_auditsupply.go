@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// auditSupply 重放整条链，核对 UTXO 集的总额与发行计划，不一致时以状态码 1 退出
+func (cli *CLI) auditSupply(nodeID string) {
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+
+	report, err := UTXOSet.AuditSupply()
+	bc.db.Close()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(report)
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
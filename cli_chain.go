@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// exportChain 把高度 from 到 to 的区块写入导出文件
+func (cli *CLI) exportChain(nodeID string, from, to int, file string) {
+	bc := NewBlockchain(nodeID)
+	if bc == nil {
+		log.Panic("ERROR: No blockchain to export")
+	}
+	defer bc.db.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	n, err := bc.ExportChain(f, from, to)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		log.Panic(err)
+	}
+	fmt.Printf("Exported %d blocks to %s\n", n, file)
+}
+
+// importChain 验证导出文件中的区块并加入节点的区块库
+func (cli *CLI) importChain(nodeID, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	n, err := ImportChain(nodeID, f)
+	if err != nil {
+		log.Panic(fmt.Sprintf("imported %d blocks, then: %v", n, err))
+	}
+	fmt.Printf("Imported %d blocks from %s\n", n, file)
+}
@@ -14,11 +14,15 @@ import (
 //6. 调用 `UTXOSet` 的 `Reindex` 方法，该方法会重新建立 UTXO 集合的索引，以便在以后进行交易时能够快速检索未花费的输出。
 //7. 最后，输出 "Done!" 表示区块链创建过程完成。
 //总之，这个函数用于创建一个新的区块链，并为创世区块奖励发送到指定的地址。在创建过程中，还会重新建立未花费的交易输出集合的索引，以便后续的交易处理。
-func (cli *CLI) createBlockchain(address, nodeID string) {
-	if !ValidateAddress(address) {
+func (cli *CLI) createBlockchain(address, nodeID, treasuryAddresses string, threshold int) {
+	if len(chainParams.Allocations) == 0 && !ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	bc := CreateBlockchain(address, nodeID)
+	params, err := chainParams.WithTreasury(treasuryAddresses, threshold)
+	if err != nil {
+		log.Panic(err)
+	}
+	bc := CreateBlockchain(address, nodeID, params)
 	defer bc.db.Close()
 
 	UTXOSet := UTXOSet{bc}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// createHDWallet 生成助记词并把钱包文件转为 HD 钱包，之后 createwallet 都按顺序派生地址。
+// 备份助记词即可恢复所有派生出的地址，不必在每次 createwallet 后复制钱包文件。
+func (cli *CLI) createHDWallet(nodeID string) {
+	wallets, _ := NewWallets(nodeID)
+	unlockWallets(wallets)
+
+	mnemonic, err := NewMnemonic()
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.InitHD(mnemonic); err != nil {
+		log.Panic(err)
+	}
+	address := wallets.CreateWallet()
+	wallets.SaveToFile(nodeID)
+
+	fmt.Println("Write down the mnemonic, it is the only backup of the HD wallet:")
+	fmt.Println(mnemonic)
+	fmt.Printf("Your new address: %s\n", address)
+}
+
+// restoreWallet 从助记词恢复 HD 钱包，按 UTXO 集找回有余额的地址，已有的钱包文件会被覆盖
+func (cli *CLI) restoreWallet(nodeID, mnemonic string, gap int) {
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	pubKeyHashes := UTXOSet.PubKeyHashes()
+	bc.db.Close()
+
+	wallets, err := RestoreHDWallets(mnemonic, pubKeyHashes, gap)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Restored %d address(es):\n", len(wallets.Wallets))
+	for address, wallet := range wallets.Wallets {
+		fmt.Printf("%s %s\n", address, wallet.Path)
+	}
+}
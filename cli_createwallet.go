@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+)
 
 //这段代码是 `CLI` 结构体的 `createWallet` 方法，它用于创建一个新的钱包并保存到文件中。
 //下面是这个方法的功能和步骤解释：
@@ -8,10 +11,21 @@ import "fmt"
 //2. 调用钱包集合的 `CreateWallet` 方法来创建一个新的钱包，返回一个钱包地址。
 //3. 调用钱包集合的 `SaveToFile` 方法，将钱包集合保存到文件中，文件名以 `nodeID` 命名。
 //4. 使用 `fmt.Printf` 打印出新创建的钱包地址。
+//加密钱包需要先解锁，新私钥才能一起加密保存。HD 钱包按顺序派生地址，change 为 true 时派生找零地址。
 //总的来说，这个方法的目的是创建一个新的钱包，将其保存到文件中，并输出新钱包的地址。钱包是用于存储密钥对和地址的数据结构，在区块链中用于管理账户和签署交易。
-func (cli *CLI) createWallet(nodeID string) {
+func (cli *CLI) createWallet(nodeID string, change bool) {
 	wallets, _ := NewWallets(nodeID)
-	address := wallets.CreateWallet()
+	unlockWallets(wallets)
+	var address string
+	if change {
+		var err error
+		address, err = wallets.NewHDAddress(true)
+		if err != nil {
+			log.Panic(err)
+		}
+	} else {
+		address = wallets.CreateWallet()
+	}
 	wallets.SaveToFile(nodeID)
 
 	fmt.Printf("Your new address: %s\n", address)
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// walletPassphraseEnv 设置后命令行不再提示输入钱包口令
+const walletPassphraseEnv = "WALLET_PASSPHRASE"
+
+// encryptWallet 把明文钱包文件改写为口令加密的钱包文件
+func (cli *CLI) encryptWallet(nodeID, passphrase string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.Encrypt(passphrase); err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Println("Wallet encrypted. Keep the passphrase safe, the private keys cannot be recovered without it.")
+}
+
+// changeWalletPassphrase 修改钱包口令
+func (cli *CLI) changeWalletPassphrase(nodeID, oldPassphrase, newPassphrase string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.ChangePassphrase(oldPassphrase, newPassphrase); err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Println("Wallet passphrase changed.")
+}
+
+// unlockWallets 加密钱包在签名前需要解锁，口令取自 WALLET_PASSPHRASE 环境变量或标准输入
+func unlockWallets(wallets *Wallets) {
+	if !wallets.IsLocked() {
+		return
+	}
+
+	passphrase, ok := os.LookupEnv(walletPassphraseEnv)
+	if !ok {
+		fmt.Print("Wallet passphrase: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Panic(err)
+		}
+		passphrase = strings.TrimRight(line, "\r\n")
+	}
+
+	if err := wallets.Unlock(passphrase); err != nil {
+		log.Panic(err)
+	}
+}
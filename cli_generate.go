@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// generate 回归测试网上立即挖出 n 个区块，供集成测试使用
+func (cli *CLI) generate(nodeID, address string, n int) {
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	hashes, err := bc.Generate(n, address)
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, hash := range hashes {
+		fmt.Printf("%x\n", hash)
+	}
+}
@@ -21,6 +21,10 @@ func (cli *CLI) getBalance(address, nodeID string) {
 	if !ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
+	if cli.config.Light {
+		cli.getLightBalance(address, nodeID)
+		return
+	}
 	bc := NewBlockchain(nodeID)
 	UTXOSet := UTXOSet{bc}
 	defer bc.db.Close()
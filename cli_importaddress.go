@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// importAddress 导入只读地址或公钥，监控节点不需要私钥就能查询余额
+func (cli *CLI) importAddress(nodeID, address, pubKeyHex, label string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if pubKeyHex != "" {
+		pubKey, err := hex.DecodeString(pubKeyHex)
+		if err != nil {
+			log.Panic(err)
+		}
+		imported, err := wallets.ImportPubKey(pubKey, label)
+		if err != nil {
+			log.Panic(err)
+		}
+		if address != "" && address != imported {
+			log.Panicf("ERROR: public key belongs to %s, not %s", imported, address)
+		}
+		address = imported
+	} else if err := wallets.ImportAddress(address, label); err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Watching %s\n", address)
+}
+
+// importPrivKey 导入 dumpprivkey 导出的私钥
+func (cli *CLI) importPrivKey(nodeID, wif, label string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets)
+
+	address, err := wallets.ImportPrivateKey(wif, label)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Imported %s\n", address)
+}
+
+// dumpPrivKey 打印地址的私钥
+func (cli *CLI) dumpPrivKey(nodeID, address string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets)
+
+	wif, err := wallets.ExportPrivateKey(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(wif)
+}
+
+// setLabel 设置地址标签，label 为空时删除
+func (cli *CLI) setLabel(nodeID, address, label string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SetLabel(address, label); err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+}
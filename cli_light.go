@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// getLightBalance 轻节点模式下的 getbalance：只统计经过默克尔证明的交易，需要先用 startnode 同步
+func (cli *CLI) getLightBalance(address, nodeID string) {
+	withLightClient(nodeID, func(lc *LightClient) {
+		balance, err := lc.Balance(address)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Balance of '%s': %d (verified up to height %d)\n", address, balance, lc.Height())
+	})
+}
+
+// sendLight 轻节点模式下的 send：用经过证明的输出构建交易，发送给第一个种子节点
+func (cli *CLI) sendLight(from string, recipients []Recipient, nodeID string, opts SendOptions) {
+	if len(cli.config.Seeds) == 0 {
+		log.Panic("ERROR: A light client needs a full node in -seeds to send transactions")
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	withLightClient(nodeID, func(lc *LightClient) {
+		if opts.DryRun {
+			wallet, ok := wallets.Wallets[from]
+			if !ok {
+				log.Panic(fmt.Errorf("address %s is not in the wallet", from))
+			}
+			utxos, err := lc.SpendableOutputs(HashPubKey(wallet.PublicKey))
+			if err != nil {
+				log.Panic(err)
+			}
+			plan, err := planSend(wallets, from, recipients, utxos, opts)
+			if err != nil {
+				log.Panic(err)
+			}
+			fmt.Println(plan)
+			return
+		}
+
+		unlockWallets(wallets)
+		tx, err := lc.NewPayment(wallets, from, recipients, opts)
+		if err != nil {
+			log.Panic("ERROR: ", err)
+		}
+		wallets.SaveToFile(nodeID)
+
+		nodeAddress = cli.config.Listen
+		sendTx(cli.config.Seeds[0], tx)
+		fmt.Printf("Sent transaction %x to %s\n", tx.ID, cli.config.Seeds[0])
+	})
+}
@@ -5,14 +5,38 @@ import (
 	"log"
 )
 
-func (cli *CLI) listAddresses(nodeID string) {
+// listAddresses 列出钱包中的地址和只读地址，showBalance 为 true 时同时打印余额
+func (cli *CLI) listAddresses(nodeID string, showBalance bool) {
 	wallets, err := NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
-	addresses := wallets.GetAddresses()
 
-	for _, address := range addresses {
-		fmt.Println(address)
+	var utxos *UTXOSet
+	if showBalance {
+		bc := NewBlockchain(nodeID)
+		defer bc.db.Close()
+		utxos = &UTXOSet{bc}
+	}
+
+	print := func(address string, watchOnly bool) {
+		line := address
+		if watchOnly {
+			line += " (watch-only)"
+		}
+		if label := wallets.GetLabel(address); label != "" {
+			line += fmt.Sprintf(" [%s]", label)
+		}
+		if utxos != nil {
+			line += fmt.Sprintf(" %d", utxos.Balance(address))
+		}
+		fmt.Println(line)
+	}
+
+	for _, address := range wallets.GetAddresses() {
+		print(address, false)
+	}
+	for _, address := range wallets.GetWatchOnlyAddresses() {
+		print(address, true)
 	}
 }
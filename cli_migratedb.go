@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// migrateDB 把 gob 编码的旧区块库转换为规范二进制编码，file 为空时迁移当前节点的区块库
+func (cli *CLI) migrateDB(nodeID, file string) {
+	if file == "" {
+		file = nodeDataFile(dbFile, nodeID)
+	}
+
+	count, err := MigrateBlockchainDB(file)
+	if err == errAlreadyMigrated {
+		fmt.Printf("%s already uses the canonical encoding.\n", file)
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("\nDone! Migrated %d blocks, the old database is kept as %s%s\n", count, file, legacyBackupSuffix)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// createMint 创建未签名的铸币交易，交给国库成员用 signmint 签名
+func (cli *CLI) createMint(recipients []Recipient, memo, nodeID, out string) {
+	bc := NewBlockchain(nodeID)
+	treasury, err := bc.Treasury()
+	bc.db.Close()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, err := NewMintTX(recipients, memo)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Mint %x needs %d signature(s) of treasury %s\n", tx.ID, treasury.Threshold, treasury)
+
+	writeMint(tx, out)
+}
+
+// signMint 用钱包中的国库地址为铸币交易签名，address 为空时使用钱包中所有的国库地址
+func (cli *CLI) signMint(in, address, nodeID, out string) {
+	tx := readMint(in)
+
+	bc := NewBlockchain(nodeID)
+	treasury, err := bc.Treasury()
+	bc.db.Close()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets)
+	signed, err := treasury.SignMintWithWallets(tx, wallets, address)
+	if err != nil {
+		log.Panic(err)
+	}
+	signers, err := treasury.MintSigners(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Added %d signature(s), %d of %d required\n", signed, len(signers), treasury.Threshold)
+
+	writeMint(tx, out)
+}
+
+// submitMint 把签名足够的铸币交易发给节点，由节点打包上链
+func (cli *CLI) submitMint(in, node string) {
+	tx := readMint(in)
+	sendTestdata(node, fmt.Sprintf("%s mint %s", node, EncodeMint(tx)), "", "")
+	fmt.Printf("Mint %x sent to %s\n", tx.ID, node)
+}
+
+// listMints 列出链上所有的铸币交易
+func (cli *CLI) listMints(nodeID string) {
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	treasury, err := bc.Treasury()
+	if err == errMintingDisabled {
+		fmt.Println(err)
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	mints, err := bc.ListMints()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Treasury: %s\n", treasury)
+	for _, mint := range mints {
+		fmt.Printf("Mint %s at height %d, block %s\n", mint.TxID, mint.Height, mint.BlockHash)
+		if mint.Memo != "" {
+			fmt.Printf("  Memo:    %s\n", mint.Memo)
+		}
+		for _, out := range mint.Outputs {
+			fmt.Printf("  Pays:    %s  %d\n", out.Address, out.Amount)
+		}
+		for _, signer := range mint.Signers {
+			fmt.Printf("  Signer:  %s\n", signer)
+		}
+	}
+}
+
+// readMint 参数是文件路径时从文件读取，否则当作 base64 文本解析
+func readMint(in string) *Transaction {
+	data := in
+	if _, err := os.Stat(in); err == nil {
+		content, err := ioutil.ReadFile(in)
+		if err != nil {
+			log.Panic(err)
+		}
+		data = string(content)
+	}
+
+	tx, err := DecodeMint(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return tx
+}
+
+// writeMint out 为空时打印 base64，否则写入文件
+func writeMint(tx *Transaction, out string) {
+	if out == "" {
+		fmt.Println(EncodeMint(tx))
+		return
+	}
+
+	err := ioutil.WriteFile(out, []byte(EncodeMint(tx)), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Mint written to %s\n", out)
+}
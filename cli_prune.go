@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// pruneBlockchain 删除链尾 keep 个区块之前的区块体
+func (cli *CLI) pruneBlockchain(nodeID string, keep int) {
+	bc := NewBlockchain(nodeID)
+	if bc == nil {
+		log.Panic("ERROR: No blockchain to prune")
+	}
+	defer bc.db.Close()
+
+	n, err := bc.Prune(keep)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Pruned %d blocks, block bodies are kept from height %d\n", n, bc.PruneHeight())
+}
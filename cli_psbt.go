@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// createPSBT 创建未签名交易并导出，签名可以在没有区块链数据的机器上完成
+func (cli *CLI) createPSBT(from, to string, amount int, nodeID, out string) {
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	psbt, err := NewPSBT(from, to, amount, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	writePSBT(psbt, out)
+}
+
+// signPSBT 用钱包文件中的私钥为部分签名交易签名，address 为空时尝试钱包文件中的所有地址
+func (cli *CLI) signPSBT(in, address, sighash, nodeID, out string) {
+	hashType, err := ParseSigHashType(sighash)
+	if err != nil {
+		log.Panic(err)
+	}
+	psbt := readPSBT(in)
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets)
+	addresses := wallets.GetAddresses()
+	if address != "" {
+		addresses = []string{address}
+	}
+
+	signed := 0
+	for _, addr := range addresses {
+		wallet := wallets.GetWallet(addr)
+		n, err := psbt.Sign(&wallet, hashType)
+		if err != nil {
+			log.Panic(err)
+		}
+		signed += n
+	}
+	fmt.Printf("Signed %d input(s), complete: %t\n", signed, psbt.IsComplete())
+
+	writePSBT(psbt, out)
+}
+
+// combinePSBT 合并多个签名者分别签名的同一笔交易，inputs 用逗号分隔
+func (cli *CLI) combinePSBT(inputs, out string) {
+	var psbt *PSBT
+	for _, in := range strings.Split(inputs, ",") {
+		other := readPSBT(in)
+		if psbt == nil {
+			psbt = other
+			continue
+		}
+		if err := psbt.Combine(other); err != nil {
+			log.Panic(err)
+		}
+	}
+	fmt.Printf("Complete: %t\n", psbt.IsComplete())
+
+	writePSBT(psbt, out)
+}
+
+// finalizePSBT 检查签名并输出最终交易，broadcast 时发送给已知节点
+func (cli *CLI) finalizePSBT(in string, broadcast bool) {
+	tx, err := readPSBT(in).Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Transaction %x\n", tx.ID)
+	fmt.Println(hex.EncodeToString(tx.Serialize()))
+
+	if broadcast {
+		if len(knownNodes) == 0 {
+			log.Panic("ERROR: No known nodes to broadcast to")
+		}
+		sendTx(knownNodes[0], tx)
+		fmt.Println("Success!")
+	}
+}
+
+// readPSBT 参数是文件路径时从文件读取，否则当作 base64 或 JSON 文本解析
+func readPSBT(in string) *PSBT {
+	data := in
+	if _, err := os.Stat(in); err == nil {
+		content, err := ioutil.ReadFile(in)
+		if err != nil {
+			log.Panic(err)
+		}
+		data = string(content)
+	}
+
+	psbt, err := DecodePSBT(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return psbt
+}
+
+// writePSBT out 为空时打印 base64，否则写入文件
+func writePSBT(psbt *PSBT, out string) {
+	if out == "" {
+		fmt.Println(psbt.Base64())
+		return
+	}
+
+	err := ioutil.WriteFile(out, []byte(psbt.Base64()), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("PSBT written to %s\n", out)
+}
@@ -15,13 +15,22 @@ import (
 //6. 如果 `mineNow` 为 `true`，则表示立即挖矿，将创建一个 coinbase 交易和刚刚创建的交易作为交易列表，并通过挖矿产生一个新的区块。新区块产生后，会调用 `UTXOSet.Update` 更新 UTXO 集合。
 //7. 如果 `mineNow` 为 `false`，则表示不立即挖矿，而是将交易发送到已知节点中进行广播。
 //8. 最后，无论是立即挖矿还是广播交易，函数都会打印出成功的信息。
+//`recipients` 可以包含多个收款人，所有付款放在同一笔交易中，只有一个找零输出。
+//`opts` 指定选币策略、手续费和找零方式；`opts.DryRun` 为 `true` 时只打印输入、输出和手续费，不签名也不发送。
 //总之，这个 `send` 函数用于在区块链上执行交易操作，可以选择是立即挖矿产生新区块还是广播交易至其他节点。
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
+func (cli *CLI) send(from string, recipients []Recipient, nodeID string, mineNow bool, opts SendOptions) {
 	if !ValidateAddress(from) {
 		log.Panic("ERROR: Sender address is not valid")
 	}
-	if !ValidateAddress(to) {
-		log.Panic("ERROR: Recipient address is not valid")
+	if err := validateRecipients(recipients); err != nil {
+		log.Panic("ERROR: ", err)
+	}
+	if cli.config.Light {
+		if mineNow {
+			log.Panic("ERROR: A light client cannot mine")
+		}
+		cli.sendLight(from, recipients, nodeID, opts)
+		return
 	}
 
 	bc := NewBlockchain(nodeID)
@@ -32,12 +41,25 @@ func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(from)
 
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	if opts.DryRun {
+		plan, err := PlanSend(wallets, from, recipients, &UTXOSet, opts)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println(plan)
+		return
+	}
+
+	unlockWallets(wallets)
+	tx := NewBatchTransaction(wallets, from, recipients, &UTXOSet, opts)
+	if tx == nil {
+		log.Panic("ERROR: Transaction was not created")
+	}
+	wallets.SaveToFile(nodeID)
 
 	if mineNow {
-		cbTx := NewCoinbaseTX(from, "")
+		cbTx := bc.NewRewardTX(from, []*Transaction{tx})
 		txs := []*Transaction{cbTx, tx}
 
 		newBlock := bc.MineBlock(txs)
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// signMessage 用 address 的私钥对消息签名并打印 base64 紧凑签名
+func (cli *CLI) signMessage(nodeID, address, message string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets)
+
+	signature, err := wallets.SignMessage(address, message)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(signature)
+}
+
+// verifyMessage 从签名恢复公钥并与地址比较，不需要钱包文件；签名无效时以状态码 1 退出
+func (cli *CLI) verifyMessage(address, signature, message string) {
+	valid, err := VerifyMessage(address, signature, message)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !valid {
+		fmt.Println("Signature is NOT valid for", address)
+		os.Exit(1)
+	}
+	fmt.Println("Signature is valid for", address)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// exportSnapshot 把高度 height 的区块之后的 UTXO 集和证明它的区块头写入文件
+func (cli *CLI) exportSnapshot(nodeID string, height int, out string) {
+	bc := NewBlockchain(nodeID)
+	if bc == nil {
+		log.Panic("ERROR: No blockchain to export")
+	}
+	defer bc.db.Close()
+
+	s, err := bc.Snapshot(height)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := ioutil.WriteFile(out, s.Serialize(), 0644); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Snapshot of %d UTXO records at height %d written to %s\n", len(s.Entries), s.Block.Height, out)
+	fmt.Printf("Block %x\nUTXO commitment %x\n", s.Block.Hash, s.Commitment)
+}
+
+// importSnapshot 验证快照文件，为还没有区块库的节点创建从快照区块开始的区块库
+func (cli *CLI) importSnapshot(nodeID, in string) {
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		log.Panic(err)
+	}
+	s, err := DeserializeSnapshot(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := ImportSnapshot(nodeID, s); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Imported %d UTXO records at height %d, UTXO commitment %x\n", len(s.Entries), s.Block.Height, s.Commitment)
+}
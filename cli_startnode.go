@@ -17,5 +17,9 @@ func (cli *CLI) startNode(nodeID, minerAddress string) {
 	fmt.Println("func (cli *CLI) startNode(nodeID, minerAddress string) ")
 	fmt.Println("nodeID:", nodeID)
 	fmt.Println("minerAddress:", minerAddress)
-	StartServer(nodeID, minerAddress)
+	if cli.config.Light {
+		StartLightClient(cli.config)
+		return
+	}
+	StartServer(cli.config)
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// verifyChain 检查区块库，发现问题时以状态码 1 退出；truncate 时删除有问题的区块并重建 UTXO 集
+func (cli *CLI) verifyChain(nodeID string, depth, level int, truncate bool) {
+	bc := NewBlockchain(nodeID)
+	if bc == nil {
+		log.Panic("ERROR: No blockchain to verify")
+	}
+	defer bc.db.Close()
+
+	check, err := bc.VerifyChain(depth, level)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(check)
+	if check.OK() {
+		return
+	}
+	if !truncate {
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	if check.Bad == nil {
+		UTXOSet{bc}.Reindex()
+		fmt.Println("UTXO set rebuilt")
+		return
+	}
+	n, err := bc.TruncateChain(check)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Removed %d blocks, the tip is now %x at height %d\n", n, bc.Tip(), bc.GetBestHeight())
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// defaultDustThreshold 找零低于此值时不生成找零输出，差额并入手续费
+const defaultDustThreshold = 1
+
+// bnbMaxTries 分支定界最多尝试的节点数，超过后改用回退策略
+const bnbMaxTries = 100000
+
+var errInsufficientFunds = errors.New("not enough funds")
+
+// SpendableOutput 一个可以花费的输出及其位置
+type SpendableOutput struct {
+	TxID   []byte
+	Index  int
+	Output TXOutput
+}
+
+// CoinSelector 选币策略：从 utxos 中选出总额不少于 target 的一组输出。
+// dust 是找零的最小值，策略可以据此避免产生零碎的找零。
+type CoinSelector interface {
+	SelectCoins(utxos []SpendableOutput, target, dust int) ([]SpendableOutput, error)
+}
+
+// coinSelectors 按名字注册的选币策略，-strategy 参数取这里的键
+var coinSelectors = map[string]CoinSelector{
+	"bnb":      branchAndBoundSelector{fallback: largestFirstSelector{}},
+	"largest":  largestFirstSelector{},
+	"smallest": smallestFirstSelector{},
+	"random":   randomSelector{},
+}
+
+// defaultCoinSelector 默认先找不需要找零的组合，找不到时按金额从大到小选
+const defaultCoinSelector = "bnb"
+
+// GetCoinSelector 按名字取选币策略
+func GetCoinSelector(name string) (CoinSelector, error) {
+	if name == "" {
+		name = defaultCoinSelector
+	}
+	selector, ok := coinSelectors[name]
+	if !ok {
+		names := make([]string, 0, len(coinSelectors))
+		for n := range coinSelectors {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown coin selection strategy %q, use one of %s", name, strings.Join(names, ", "))
+	}
+
+	return selector, nil
+}
+
+// largestFirstSelector 金额从大到小选，输入个数最少
+type largestFirstSelector struct{}
+
+func (largestFirstSelector) SelectCoins(utxos []SpendableOutput, target, dust int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput(nil), utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value > sorted[j].Output.Value })
+
+	return accumulateCoins(sorted, target)
+}
+
+// smallestFirstSelector 金额从小到大选，顺便合并零碎的输出
+type smallestFirstSelector struct{}
+
+func (smallestFirstSelector) SelectCoins(utxos []SpendableOutput, target, dust int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput(nil), utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value < sorted[j].Output.Value })
+
+	return accumulateCoins(sorted, target)
+}
+
+// randomSelector 随机顺序选，避免输入的组合暴露钱包的余额结构
+type randomSelector struct{}
+
+func (randomSelector) SelectCoins(utxos []SpendableOutput, target, dust int) ([]SpendableOutput, error) {
+	shuffled := append([]SpendableOutput(nil), utxos...)
+	for i := len(shuffled) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return nil, err
+		}
+		shuffled[i], shuffled[j.Int64()] = shuffled[j.Int64()], shuffled[i]
+	}
+
+	return accumulateCoins(shuffled, target)
+}
+
+// branchAndBoundSelector 分支定界搜索总额落在 [target, target+dust) 的组合，
+// 这样不需要找零输出；找不到时交给 fallback
+type branchAndBoundSelector struct {
+	fallback CoinSelector
+}
+
+func (s branchAndBoundSelector) SelectCoins(utxos []SpendableOutput, target, dust int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput(nil), utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value > sorted[j].Output.Value })
+
+	// remaining[i] 是 sorted[i:] 的总额，用于剪枝
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Output.Value
+	}
+
+	var best []int
+	bestWaste := -1
+	tries := 0
+	var selected []int
+
+	var search func(i, sum int)
+	search = func(i, sum int) {
+		tries++
+		if tries > bnbMaxTries || bestWaste == 0 {
+			return
+		}
+		if sum >= target {
+			if waste := sum - target; waste < dust && (bestWaste < 0 || waste < bestWaste) {
+				best = append([]int(nil), selected...)
+				bestWaste = waste
+			}
+			return
+		}
+		if i == len(sorted) || sum+remaining[i] < target {
+			return
+		}
+
+		selected = append(selected, i)
+		search(i+1, sum+sorted[i].Output.Value)
+		selected = selected[:len(selected)-1]
+		search(i+1, sum)
+	}
+	search(0, 0)
+
+	if best == nil {
+		if s.fallback == nil {
+			return nil, errInsufficientFunds
+		}
+		return s.fallback.SelectCoins(utxos, target, dust)
+	}
+
+	coins := make([]SpendableOutput, len(best))
+	for i, idx := range best {
+		coins[i] = sorted[idx]
+	}
+
+	return coins, nil
+}
+
+// accumulateCoins 按给定顺序累加直到总额不少于 target
+func accumulateCoins(ordered []SpendableOutput, target int) ([]SpendableOutput, error) {
+	var coins []SpendableOutput
+	sum := 0
+	for _, utxo := range ordered {
+		if sum >= target {
+			break
+		}
+		coins = append(coins, utxo)
+		sum += utxo.Output.Value
+	}
+	if sum < target {
+		return nil, errInsufficientFunds
+	}
+
+	return coins, nil
+}
+
+// SendOptions 转账时的选币和找零设置
+type SendOptions struct {
+	Strategy    string // 选币策略名，见 coinSelectors
+	Fee         int    // 手续费，不属于任何输出
+	Dust        int    // 找零低于此值时并入手续费
+	ReuseChange bool   // 找零返回发送地址，而不是钱包中的新地址
+	DryRun      bool   // 只预览，不修改钱包，不锁定 UTXO
+}
+
+// DefaultSendOptions 默认选币策略和粉尘阈值
+func DefaultSendOptions() SendOptions {
+	return SendOptions{Strategy: defaultCoinSelector, Dust: defaultDustThreshold}
+}
+
+// TransactionPlan 选币的结果，签名之前可以预览
+type TransactionPlan struct {
+	Inputs        []SpendableOutput
+	Recipients    []Recipient
+	Change        int // 0 表示没有找零输出
+	ChangeAddress string
+	Fee           int
+}
+
+// PlanTransaction 从 utxos 中选出支付所有收款人和手续费的输入，计算找零。
+// 找零地址由调用者在 Change 大于 0 时填写。
+func PlanTransaction(utxos []SpendableOutput, recipients []Recipient, opts SendOptions) (*TransactionPlan, error) {
+	if err := validateRecipients(recipients); err != nil {
+		return nil, err
+	}
+	if opts.Fee < 0 || opts.Dust < 0 {
+		return nil, errors.New("fee and dust threshold must not be negative")
+	}
+	selector, err := GetCoinSelector(opts.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	target := TotalAmount(recipients) + opts.Fee
+	coins, err := selector.SelectCoins(utxos, target, opts.Dust)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &TransactionPlan{Inputs: coins, Recipients: recipients, Fee: opts.Fee}
+	change := plan.InputValue() - target
+	if change < opts.Dust {
+		plan.Fee += change
+	} else {
+		plan.Change = change
+	}
+
+	return plan, nil
+}
+
+// InputValue 所选输入的总额
+func (p *TransactionPlan) InputValue() int {
+	sum := 0
+	for _, in := range p.Inputs {
+		sum += in.Output.Value
+	}
+
+	return sum
+}
+
+// Outputs 收款输出按收款人顺序在前，找零输出（如果有）在最后
+func (p *TransactionPlan) Outputs() []TXOutput {
+	var outputs []TXOutput
+	for _, recipient := range p.Recipients {
+		outputs = append(outputs, *NewTXOutput(recipient.Amount, recipient.Address))
+	}
+	if p.Change > 0 {
+		outputs = append(outputs, *NewTXOutput(p.Change, p.ChangeAddress))
+	}
+
+	return outputs
+}
+
+// UnsignedTransaction 按计划构造未签名交易，pubKey 是发送地址的公钥
+func (p *TransactionPlan) UnsignedTransaction(pubKey []byte) *Transaction {
+	var inputs []TXInput
+	for _, in := range p.Inputs {
+		inputs = append(inputs, TXInput{in.TxID, in.Index, nil, pubKey})
+	}
+	tx := Transaction{nil, inputs, p.Outputs()}
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// String 预览输入、输出和手续费
+func (p *TransactionPlan) String() string {
+	var lines []string
+
+	lines = append(lines, "Inputs:")
+	for _, in := range p.Inputs {
+		lines = append(lines, fmt.Sprintf("  %x:%d  %d", in.TxID, in.Index, in.Output.Value))
+	}
+	lines = append(lines, "Outputs:")
+	for _, recipient := range p.Recipients {
+		lines = append(lines, fmt.Sprintf("  %s  %d", recipient.Address, recipient.Amount))
+	}
+	if p.Change > 0 {
+		changeAddress := p.ChangeAddress
+		if changeAddress == "" {
+			changeAddress = "(new change address)"
+		}
+		lines = append(lines, fmt.Sprintf("  %s  %d (change)", changeAddress, p.Change))
+	}
+	lines = append(lines, fmt.Sprintf("Fee: %d", p.Fee))
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCoins(values ...int) []SpendableOutput {
+	var utxos []SpendableOutput
+	for i, value := range values {
+		utxos = append(utxos, SpendableOutput{[]byte{byte(i)}, 0, TXOutput{Value: value}})
+	}
+	return utxos
+}
+
+func coinValues(coins []SpendableOutput) []int {
+	var values []int
+	for _, coin := range coins {
+		values = append(values, coin.Output.Value)
+	}
+	return values
+}
+
+func TestCoinSelectors(t *testing.T) {
+	utxos := newCoins(5, 1, 8, 3)
+
+	largest, _ := GetCoinSelector("largest")
+	coins, err := largest.SelectCoins(utxos, 9, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{8, 5}, coinValues(coins))
+
+	smallest, _ := GetCoinSelector("smallest")
+	coins, err = smallest.SelectCoins(utxos, 9, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 3, 5}, coinValues(coins))
+
+	random, _ := GetCoinSelector("random")
+	coins, err = random.SelectCoins(utxos, 17, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(coins))
+
+	bnb, _ := GetCoinSelector("")
+	coins, err = bnb.SelectCoins(utxos, 9, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{8, 1}, coinValues(coins), "exact match needs no change")
+
+	coins, err = bnb.SelectCoins(newCoins(4, 4), 7, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{4, 4}, coinValues(coins), "falls back to largest-first")
+
+	_, err = largest.SelectCoins(utxos, 18, 1)
+	assert.Equal(t, errInsufficientFunds, err)
+	_, err = GetCoinSelector("oldest")
+	assert.NotNil(t, err)
+}
+
+func TestPlanTransaction(t *testing.T) {
+	to := string(NewWallet().GetAddress())
+	utxos := newCoins(10, 6)
+
+	plan, err := PlanTransaction(utxos, []Recipient{{to, 7}}, SendOptions{Strategy: "largest", Fee: 1, Dust: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, plan.Change)
+	assert.Equal(t, 1, plan.Fee)
+
+	plan, err = PlanTransaction(utxos, []Recipient{{to, 7}}, SendOptions{Strategy: "largest", Fee: 1, Dust: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, plan.Change, "dust change is dropped")
+	assert.Equal(t, 3, plan.Fee)
+	assert.Equal(t, 1, len(plan.Outputs()))
+
+	_, err = PlanTransaction(utxos, []Recipient{{to, 16}}, SendOptions{Fee: 1, Dust: 1})
+	assert.Equal(t, errInsufficientFunds, err)
+
+	other := string(NewWallet().GetAddress())
+	plan, err = PlanTransaction(utxos, []Recipient{{to, 5}, {other, 7}}, SendOptions{Strategy: "largest", Dust: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(plan.Inputs))
+	assert.Equal(t, 4, plan.Change)
+	plan.ChangeAddress = to
+	outputs := plan.Outputs()
+	assert.Equal(t, 3, len(outputs), "one output per recipient and one change output")
+	assert.Equal(t, 7, outputs[1].Value)
+}
+
+func TestPlanSendChangeAddress(t *testing.T) {
+	mnemonic, _ := NewMnemonic()
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	assert.Nil(t, ws.InitHD(mnemonic))
+	from := ws.CreateWallet()
+
+	preview := ws.PeekChangeAddress()
+	assert.Equal(t, uint32(0), ws.HD.Next[hdChangeChain], "peeking does not advance the change chain")
+	change, err := ws.NewChangeAddress()
+	assert.Nil(t, err)
+	assert.Equal(t, preview, change)
+	assert.NotEqual(t, from, change)
+	assert.Equal(t, "m/44'/0'/0'/1/0", ws.Wallets[change].Path)
+}
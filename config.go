@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultNodeConfigFile 没有 -config 时，当前目录存在这个文件就读取它
+const defaultNodeConfigFile = "node.json"
+
+// NodeConfig 节点配置。优先级从低到高：默认值、配置文件、环境变量、命令行参数
+type NodeConfig struct {
+	DataDir     string   `json:"dataDir"`     // 数据库和钱包文件的根目录，网络的 dataDir 是它的子目录
+	Listen      string   `json:"listen"`      // P2P 监听地址 IP:PORT，为空时使用 127.0.0.1 和网络的默认端口
+	RPCListen   string   `json:"rpcListen"`   // HTTP 监听地址，为空时使用网络的默认端口
+	Miner       string   `json:"miner"`       // 挖矿奖励地址，为空时不挖矿
+	Shard       int      `json:"shard"`       // 节点所属分片，-1 表示由 HTTP 接口分配
+	Seeds       []string `json:"seeds"`       // 启动时加入已知节点列表的节点 IP:PORT
+	LogLevel    string   `json:"logLevel"`    // debug、info、warn 或 error
+	Network     string   `json:"network"`     // main、testnet 或 regtest，见 NETWORK
+	GenesisFile string   `json:"genesisFile"` // 创世配置文件，见 GENESIS_FILE
+	KeepOpen    bool     `json:"keepOpen"`    // 区块库打开后一直保持打开，默认打开；同一数据目录上还有其他进程（如 HTTP 接口）时设为 false，见 ChainService
+	Light       bool     `json:"light"`       // 轻节点：只同步区块头，余额和付款使用经过默克尔证明的交易，见 LightClient
+	Snapshot    bool     `json:"snapshot"`    // 还没有区块库时从第一个种子节点的 UTXO 快照启动，见 UTXOSnapshot
+	Prune       int      `json:"prune"`       // 大于 0 时只保留链尾这么多个区块的区块体，见 Blockchain.Prune
+}
+
+// nodeConfig 当前进程使用的节点配置
+var nodeConfig = defaultNodeConfig()
+
+func defaultNodeConfig() *NodeConfig {
+	return &NodeConfig{
+		DataDir:     ".",
+		Shard:       -1,
+		LogLevel:    "info",
+		Network:     os.Getenv(networkEnv),
+		GenesisFile: os.Getenv(genesisFileEnv),
+		KeepOpen:    true,
+	}
+}
+
+// LoadNodeConfig 读取 JSON 配置文件，文件中没有的字段使用默认值
+func LoadNodeConfig(path string) (*NodeConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := defaultNodeConfig()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// ParseNodeConfig 解析命令之前的全局参数，例如
+// blockchain_go -config node.json -listen 127.0.0.1:3000 startnode，返回配置和剩下的参数
+func ParseNodeConfig(args []string) (*NodeConfig, []string, error) {
+	fs := flag.NewFlagSet("blockchain_go", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Node configuration file (default: ./"+defaultNodeConfigFile+" if it exists)")
+	dataDir := fs.String("datadir", "", "Directory of the databases and wallet files")
+	listen := fs.String("listen", "", "P2P listen address IP:PORT")
+	rpcListen := fs.String("rpclisten", "", "HTTP listen address")
+	miner := fs.String("miner", "", "Address to send mining rewards to")
+	shard := fs.Int("shard", -1, "Shard the node belongs to")
+	seeds := fs.String("seeds", "", "Comma separated peers IP:PORT to connect to")
+	logLevel := fs.String("loglevel", "", "Log level: debug, info, warn or error")
+	network := fs.String("network", "", "Network: main, testnet or regtest")
+	genesisFile := fs.String("genesis", "", "Genesis configuration file")
+	keepOpen := fs.Bool("keepopen", true, "Keep the databases open instead of closing them when idle, -keepopen=false to share the data directory with another process")
+	light := fs.Bool("light", false, "Run as a light client that syncs only block headers from -seeds")
+	snapshot := fs.Bool("snapshot", false, "Bootstrap an empty node from the UTXO snapshot of the first seed")
+	prune := fs.Int("prune", 0, "Keep only the bodies of the last N blocks, 0 keeps every block")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	path := *configFile
+	if path == "" && dbExists(defaultNodeConfigFile) {
+		path = defaultNodeConfigFile
+	}
+	config := defaultNodeConfig()
+	if path != "" {
+		var err error
+		if config, err = LoadNodeConfig(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// 兼容旧的 NODE_ID="IP PORT" 环境变量
+	if nodeID := os.Getenv("NODE_ID"); nodeID != "" && config.Listen == "" {
+		config.Listen = strings.Replace(nodeID, " ", ":", 1)
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "datadir":
+			config.DataDir = *dataDir
+		case "listen":
+			config.Listen = *listen
+		case "rpclisten":
+			config.RPCListen = *rpcListen
+		case "miner":
+			config.Miner = *miner
+		case "shard":
+			config.Shard = *shard
+		case "seeds":
+			config.Seeds = splitList(*seeds)
+		case "loglevel":
+			config.LogLevel = *logLevel
+		case "network":
+			config.Network = *network
+		case "genesis":
+			config.GenesisFile = *genesisFile
+		case "keepopen":
+			config.KeepOpen = *keepOpen
+		case "light":
+			config.Light = *light
+		case "snapshot":
+			config.Snapshot = *snapshot
+		case "prune":
+			config.Prune = *prune
+		}
+	})
+
+	return config, fs.Args(), nil
+}
+
+// splitList 拆分逗号分隔的列表，忽略空项
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Apply 加载网络参数，补全默认的监听地址，检查配置并设为当前节点的配置
+func (c *NodeConfig) Apply() error {
+	level, err := parseLogLevel(c.LogLevel)
+	if err != nil {
+		return err
+	}
+	if err := initChainParams(c.Network, c.GenesisFile); err != nil {
+		return err
+	}
+	// 没有端口时使用本网络的默认端口
+	if c.Listen == "" {
+		c.Listen = "127.0.0.1"
+	}
+	if !strings.Contains(c.Listen, ":") {
+		c.Listen = net.JoinHostPort(c.Listen, strconv.Itoa(chainParams.Port))
+	}
+	if c.RPCListen == "" {
+		c.RPCListen = fmt.Sprintf(":%d", chainParams.RPCPort)
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	currentLogLevel = level
+	nodeConfig = c
+
+	return nil
+}
+
+// Validate 检查地址格式
+func (c *NodeConfig) Validate() error {
+	if c.DataDir == "" {
+		return errors.New("dataDir is empty")
+	}
+	for _, addr := range append([]string{c.Listen}, c.Seeds...) {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("%q is not an IP:PORT address: %v", addr, err)
+		}
+	}
+	if _, _, err := net.SplitHostPort(c.RPCListen); err != nil {
+		return fmt.Errorf("rpcListen %q: %v", c.RPCListen, err)
+	}
+	if c.Miner != "" && !ValidateAddress(c.Miner) {
+		return fmt.Errorf("miner address %s is not valid", c.Miner)
+	}
+	if c.Shard < -1 {
+		return fmt.Errorf("shard %d is not valid", c.Shard)
+	}
+	if c.Light && c.Miner != "" {
+		return errors.New("a light client cannot mine")
+	}
+	if c.Snapshot && len(c.Seeds) == 0 {
+		return errors.New("snapshot sync needs a peer in seeds")
+	}
+	if c.Prune != 0 && c.Prune < minPruneDepth {
+		return fmt.Errorf("prune must keep at least %d blocks", minPruneDepth)
+	}
+
+	return nil
+}
+
+// NodeID 节点在分片列表和数据文件中使用的标识 "IP PORT"
+func (c *NodeConfig) NodeID() string {
+	return strings.Replace(c.Listen, ":", " ", 1)
+}
+
+// nodeArgs 启动同一网络、同一数据目录的另一个节点进程时使用的全局参数
+func (c *NodeConfig) nodeArgs(listen string) string {
+	args := fmt.Sprintf(`-datadir "%s" -listen "%s" -loglevel %s`, c.DataDir, listen, c.LogLevel)
+	if c.Network != "" {
+		args += fmt.Sprintf(` -network "%s"`, c.Network)
+	}
+	if c.GenesisFile != "" {
+		args += fmt.Sprintf(` -genesis "%s"`, c.GenesisFile)
+	}
+
+	return args
+}
+
+// nodeDataFile 节点的数据文件路径，文件名中不含空格和冒号。
+// 旧版本按 "IP PORT" 命名的文件仍然存在时继续使用它
+func nodeDataFile(format, nodeID string) string {
+	path := chainParams.dataPath(fmt.Sprintf(format, strings.NewReplacer(" ", "_", ":", "_").Replace(nodeID)))
+	legacy := chainParams.dataPath(fmt.Sprintf(format, nodeID))
+	if !dbExists(path) && dbExists(legacy) {
+		return legacy
+	}
+
+	return path
+}
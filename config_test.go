@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useNodeConfig 测试结束后恢复节点配置和网络参数
+func useNodeConfig(t *testing.T) {
+	previousConfig, previousParams, previousLevel := nodeConfig, chainParams, currentLogLevel
+	t.Cleanup(func() {
+		nodeConfig, chainParams, currentLogLevel = previousConfig, previousParams, previousLevel
+	})
+}
+
+func TestParseNodeConfig(t *testing.T) {
+	useNodeConfig(t)
+	t.Setenv("NODE_ID", "")
+	t.Setenv(networkEnv, "")
+	t.Setenv(genesisFileEnv, "")
+
+	config, args, err := ParseNodeConfig([]string{"-config", "node.example.json", "-listen", "10.0.0.1:4000", "-loglevel", "debug", "getbalance", "-address", "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"getbalance", "-address", "x"}, args)
+	assert.Equal(t, "10.0.0.1:4000", config.Listen, "flags override the file")
+	assert.Equal(t, "data", config.DataDir)
+	assert.Equal(t, 0, config.Shard)
+	assert.Equal(t, []string{"127.0.0.1:3001", "127.0.0.1:3002"}, config.Seeds)
+
+	assert.Nil(t, config.Apply())
+	assert.Equal(t, "10.0.0.1 4000", config.NodeID())
+	assert.Equal(t, levelDebug, currentLogLevel)
+	assert.Equal(t, config, nodeConfig)
+
+	// 没有配置文件时使用 NODE_ID 和网络的默认端口
+	t.Setenv("NODE_ID", "192.168.1.2")
+	config, args, err = ParseNodeConfig([]string{"-network", "testnet"})
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+	assert.Nil(t, config.Apply())
+	assert.Equal(t, "192.168.1.2:13000", config.Listen)
+	assert.Equal(t, ":18088", config.RPCListen)
+	assert.True(t, config.KeepOpen, "each database is opened once by default")
+
+	config, _, err = ParseNodeConfig([]string{"-keepopen=false"})
+	assert.Nil(t, err)
+	assert.False(t, config.KeepOpen)
+
+	config, _, err = ParseNodeConfig([]string{"-seeds", "localhost"})
+	assert.Nil(t, err)
+	assert.NotNil(t, config.Apply(), "a seed needs a port")
+	config, _, err = ParseNodeConfig([]string{"-loglevel", "loud"})
+	assert.Nil(t, err)
+	assert.NotNil(t, config.Apply())
+}
+
+func TestNodeDataFile(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+
+	path := nodeDataFile(walletFile, "127.0.0.1 3000")
+	assert.Equal(t, filepath.Join(nodeConfig.DataDir, "wallet_127.0.0.1_3000.dat"), path)
+
+	// 旧版本按 "IP PORT" 命名的文件继续使用
+	legacy := filepath.Join(nodeConfig.DataDir, "wallet_127.0.0.1 3000.dat")
+	assert.Nil(t, ioutil.WriteFile(legacy, nil, 0600))
+	assert.Equal(t, legacy, nodeDataFile(walletFile, "127.0.0.1 3000"))
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// BlockSubsidy 高度为 height 的区块可以新发行的币数：初始为 chainParams.Subsidy，
+// 每 chainParams.HalvingInterval 个区块减半
+func BlockSubsidy(height int) int {
+	halvings := height / chainParams.HalvingInterval
+	if height < 0 || halvings >= 63 {
+		return 0
+	}
+
+	return chainParams.Subsidy >> uint(halvings)
+}
+
+// ScheduledSupply 高度 0 到 height（含）的区块补贴之和，即此高度时允许的最大发行量
+func ScheduledSupply(height int) int {
+	total := 0
+	interval := chainParams.HalvingInterval
+	for start := 0; start <= height; start += interval {
+		reward := BlockSubsidy(start)
+		if reward == 0 {
+			break
+		}
+		end := start + interval - 1
+		if end > height {
+			end = height
+		}
+		total += reward * (end - start + 1)
+	}
+
+	return total
+}
+
+// MaxSupply 按发行计划最终的总发行量
+func MaxSupply() int {
+	total := 0
+	for halvings := 0; halvings < 63 && chainParams.Subsidy>>uint(halvings) > 0; halvings++ {
+		total += (chainParams.Subsidy >> uint(halvings)) * chainParams.HalvingInterval
+	}
+
+	return total
+}
+
+// NewRewardTX 高度为 height 的区块的奖励交易，向 to 支付区块补贴加上手续费 fees。
+// 输入数据带上高度，保证不同区块的奖励交易 ID 不同
+func NewRewardTX(to string, height, fees int) *Transaction {
+	randData := make([]byte, 8)
+	_, err := rand.Read(randData)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return NewCoinbaseTX2(to, fmt.Sprintf("height %d %x", height, randData), BlockSubsidy(height)+fees)
+}
+
+// NewRewardTX 为接在链尾的新区块创建奖励交易，手续费由 txs 计算
+func (bc *Blockchain) NewRewardTX(to string, txs []*Transaction) *Transaction {
+	fees := 0
+	for _, tx := range txs {
+		fee, err := bc.TransactionFee(tx)
+		if err != nil {
+			log.Panic(err)
+		}
+		fees += fee
+	}
+
+	return NewRewardTX(to, bc.GetBestHeight()+1, fees)
+}
+
+// TransactionFee 交易的输入总额减去输出总额，奖励交易的手续费为 0
+func (bc *Blockchain) TransactionFee(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	in := 0
+	for _, vin := range tx.Vin {
+		prevTx, err := bc.FindTransaction(vin.Txid)
+		if err != nil {
+			return 0, err
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return 0, fmt.Errorf("transaction %x spends missing output %d of %x", tx.ID, vin.Vout, vin.Txid)
+		}
+		in += prevTx.Vout[vin.Vout].Value
+	}
+
+	fee := in - outputValue(tx)
+	if fee < 0 {
+		return 0, fmt.Errorf("transaction %x spends more than its inputs", tx.ID)
+	}
+
+	return fee, nil
+}
+
+func outputValue(tx *Transaction) int {
+	sum := 0
+	for _, out := range tx.Vout {
+		sum += out.Value
+	}
+
+	return sum
+}
+
+// parseSendCommand 从共识命令 "X send -from FROM -to TO -amount AMOUNT" 中取出付款地址、收款地址和金额
+func parseSendCommand(command string) (string, string, int, bool) {
+	fields := strings.Split(command, " ")
+	if len(fields) < 8 || fields[1] != "send" {
+		return "", "", 0, false
+	}
+	amount, err := strconv.Atoi(fields[7])
+	if err != nil {
+		return "", "", 0, false
+	}
+
+	return fields[3], fields[5], amount, true
+}
+
+// isCrossShardCredit 跨分片转账在目标分片上的入账交易：数据就是区块提交的转账命令，
+// 并带有发起分片已经扣款的证明（见 creditSource）。这些币在发起分片上已经扣除，不算新发行
+func isCrossShardCredit(tx *Transaction, blockData []byte) bool {
+	_, err := creditSource(tx, blockData)
+
+	return err == nil
+}
+
+// isReward 是否为区块奖励交易，这样的输出需要等待 chainParams.CoinbaseMaturity 个区块；
+// 跨分片入账和国库铸币不是区块奖励
+func isReward(tx *Transaction, blockData []byte) bool {
+	return tx.IsCoinbase() && !tx.IsMint() && !isCrossShardCredit(tx, blockData)
+}
+
+// addressPubKeyHash 地址中的公钥哈希，地址格式错误时返回 nil
+func addressPubKeyHash(address string) []byte {
+	decoded := Base58Decode([]byte(address))
+	if len(decoded) <= 1+addressChecksumLen {
+		return nil
+	}
+
+	return decoded[1 : len(decoded)-addressChecksumLen]
+}
+
+// spentOutput 被花费的输出以及它所在区块的高度
+type spentOutput struct {
+	out    TXOutput
+	height int
+	reward bool
+}
+
+// ValidateBlock 按共识规则检查接在 block.PrevBlockHash 之后的区块：
+// 普通交易的输出不能超过输入，不能花费未成熟的奖励输出，
+// 奖励交易的总额不能超过该高度的区块补贴加上区块内的手续费，
+// 铸币交易要有足够的国库签名并且没有上过链，
+// 跨分片入账要带发起分片的扣款证明，每个区块最多一笔，同一个扣款区块只能入账一次
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	return bc.db.View(func(tx StoreTx) error {
+		return validateBlock(tx, block)
+	})
+}
+
+// validateBlock 在存储事务 tx 中检查区块，见 ValidateBlock。
+// 接入区块时检查和写入在同一个写事务中完成，检查时看到的链就是区块接入的链。
+// 输入只能花费父区块之后 UTXO 集中的输出或区块内前面交易的输出，同一个输出在区块中只能花费一次，
+// 每个输入的签名都要验证
+func validateBlock(dbTx StoreTx, block *Block) error {
+	utxos, _, err := utxoViewAt(dbTx, block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	fees := 0
+	minted := 0
+	var treasury *Treasury
+	mints := make(map[string]bool)
+	credited := false
+	created := make(map[string]spentOutput)
+	used := make(map[string]bool)
+	addOutputs := func(tx *Transaction, reward bool) {
+		for i, out := range tx.Vout {
+			created[outpointKey(tx.ID, i)] = spentOutput{out, block.Height, reward}
+		}
+	}
+	for _, tx := range block.Transactions {
+		if tx.IsMint() {
+			if treasury == nil {
+				if treasury, err = treasuryIn(dbTx); err != nil {
+					return err
+				}
+			}
+			if err := treasury.VerifyMint(tx); err != nil {
+				return fmt.Errorf("mint %x: %v", tx.ID, err)
+			}
+			mintHash := tx.mintHash()
+			if mints[string(mintHash)] || hasMintBefore(dbTx, block.PrevBlockHash, mintHash) {
+				return fmt.Errorf("mint %x is already in the chain", tx.ID)
+			}
+			mints[string(mintHash)] = true
+			addOutputs(tx, false)
+			continue
+		}
+		if tx.IsCoinbase() {
+			source, err := creditSource(tx, block.Data)
+			if err != nil {
+				minted += outputValue(tx)
+				addOutputs(tx, true)
+				continue
+			}
+			if credited || hasCreditBefore(dbTx, block.PrevBlockHash, source) {
+				return fmt.Errorf("source block %x of credit %x is already credited", source, tx.ID)
+			}
+			credited = true
+			addOutputs(tx, false)
+			continue
+		}
+
+		in := 0
+		prevOuts := make([]TXOutput, len(tx.Vin))
+		for i, vin := range tx.Vin {
+			key := outpointKey(vin.Txid, vin.Vout)
+			if used[key] {
+				return fmt.Errorf("transaction %x spends output %x:%d that is already spent in the block", tx.ID, vin.Txid, vin.Vout)
+			}
+			used[key] = true
+			prev, ok := created[key]
+			if !ok {
+				prev, ok = unspentOutput(utxos, vin.Txid, vin.Vout)
+			}
+			if !ok {
+				return fmt.Errorf("transaction %x spends missing or spent output %x:%d", tx.ID, vin.Txid, vin.Vout)
+			}
+			if prev.reward && prev.height > 0 && block.Height-prev.height < chainParams.CoinbaseMaturity {
+				return fmt.Errorf("transaction %x spends coinbase %x of height %d before it matures at height %d",
+					tx.ID, vin.Txid, prev.height, prev.height+chainParams.CoinbaseMaturity)
+			}
+			prevOuts[i] = prev.out
+			in += prev.out.Value
+		}
+		if !tx.VerifyOutputs(prevOuts) {
+			return fmt.Errorf("transaction %x has an invalid signature", tx.ID)
+		}
+		out := outputValue(tx)
+		if out > in {
+			return fmt.Errorf("transaction %x spends %d but its inputs are only %d", tx.ID, out, in)
+		}
+		fees += in - out
+		addOutputs(tx, false)
+	}
+
+	if allowed := BlockSubsidy(block.Height) + fees; minted > allowed {
+		return fmt.Errorf("block %d pays %d in coinbase, more than subsidy %d plus fees %d",
+			block.Height, minted, BlockSubsidy(block.Height), fees)
+	}
+
+	return nil
+}
+
+func outpointKey(txID []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txID, vout)
+}
+
+// unspentOutput UTXO 集中交易 txID 的第 vout 个输出，已被花费或不存在时返回 false
+func unspentOutput(utxos chainState, txID []byte, vout int) (spentOutput, bool) {
+	outs, found := utxos.Outputs(txID)
+	if !found {
+		return spentOutput{}, false
+	}
+	for i, out := range outs.Outputs {
+		if outs.Indexes[i] == vout {
+			return spentOutput{out, outs.Height, outs.Reward}, true
+		}
+	}
+
+	return spentOutput{}, false
+}
+
+// SupplyReport 对链和 UTXO 集的发行量审计
+type SupplyReport struct {
+	Height     int
+	UTXOTotal  int // UTXO 集中所有输出的总额
+	Genesis    int // 创世区块按创世配置分配的总额
+	Minted     int // 奖励交易发行的总额（含手续费）
+	CrossShard int // 跨分片转账入账的总额
+	Mints      int // 国库铸币的总额
+	Fees       int // 普通交易支付的手续费总额
+	Scheduled  int // 发行计划允许的总额（高度 1 起，创世区块的分配由创世配置决定）
+}
+
+// Expected 按链上交易推算的 UTXO 总额：奖励、铸币和跨分片入账增加流通量，手续费从流通量中扣除后再由奖励交易发出
+func (r SupplyReport) Expected() int {
+	return r.Genesis + r.Minted + r.Mints + r.CrossShard - r.Fees
+}
+
+// Issued 扣除手续费后按发行计划新发行的币数，不含国库铸币
+func (r SupplyReport) Issued() int {
+	return r.Minted - r.Fees
+}
+
+// OK UTXO 集与链一致，并且没有超过发行计划
+func (r SupplyReport) OK() bool {
+	return r.UTXOTotal == r.Expected() && r.Issued() <= r.Scheduled
+}
+
+// String 打印审计结果
+func (r SupplyReport) String() string {
+	rows := []struct {
+		name  string
+		value interface{}
+	}{
+		{"Height", r.Height},
+		{"UTXO set total", r.UTXOTotal},
+		{"Expected from chain", r.Expected()},
+		{"Genesis allocations", r.Genesis},
+		{"Issued (net of fees)", r.Issued()},
+		{"Scheduled emission", r.Scheduled},
+		{"Cross-shard credits", r.CrossShard},
+		{"Treasury mints", r.Mints},
+		{"Max supply", MaxSupply()},
+		{"OK", r.OK()},
+	}
+	var lines []string
+	for _, row := range rows {
+		lines = append(lines, fmt.Sprintf("%-22s%v", row.name+":", row.value))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// AuditSupply 从创世区块开始重放整条链，统计发行量和手续费，并与 UTXO 集的总额比较
+func (u UTXOSet) AuditSupply() (SupplyReport, error) {
+	var report SupplyReport
+	var blocks []*Block
+	if height := u.Blockchain.PruneHeight(); height > 0 {
+		return report, fmt.Errorf("blocks before height %d are pruned or came from a snapshot, the audit needs every block", height)
+	}
+
+	bci := u.Blockchain.Iterator()
+	for {
+		block := bci.Next()
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	if len(blocks) > 0 {
+		report.Height = blocks[0].Height
+	}
+	report.Scheduled = ScheduledSupply(report.Height) - BlockSubsidy(0)
+
+	outputs := make(map[string]int)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		for _, tx := range block.Transactions {
+			for j, out := range tx.Vout {
+				outputs[outpointKey(tx.ID, j)] = out.Value
+			}
+			if tx.IsCoinbase() {
+				switch {
+				case block.Height == 0:
+					report.Genesis += outputValue(tx)
+				case tx.IsMint():
+					report.Mints += outputValue(tx)
+				case isReward(tx, block.Data):
+					report.Minted += outputValue(tx)
+				default:
+					report.CrossShard += outputValue(tx)
+				}
+				continue
+			}
+
+			in := 0
+			for _, vin := range tx.Vin {
+				value, ok := outputs[outpointKey(vin.Txid, vin.Vout)]
+				if !ok {
+					return report, fmt.Errorf("transaction %x in block %d spends unknown output %x:%d",
+						tx.ID, block.Height, vin.Txid, vin.Vout)
+				}
+				in += value
+			}
+			report.Fees += in - outputValue(tx)
+		}
+	}
+
+	report.UTXOTotal = u.TotalValue()
+
+	return report, nil
+}
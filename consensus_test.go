@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockSubsidy(t *testing.T) {
+	subsidy := chainParams.Subsidy
+	assert.Equal(t, subsidy, BlockSubsidy(0))
+	assert.Equal(t, subsidy, BlockSubsidy(chainParams.HalvingInterval-1))
+	assert.Equal(t, subsidy/2, BlockSubsidy(chainParams.HalvingInterval))
+	assert.Equal(t, 0, BlockSubsidy(64*chainParams.HalvingInterval))
+
+	assert.Equal(t, subsidy*3, ScheduledSupply(2))
+	assert.Equal(t, subsidy*chainParams.HalvingInterval+subsidy/2, ScheduledSupply(chainParams.HalvingInterval))
+	assert.Equal(t, ScheduledSupply(100*chainParams.HalvingInterval), MaxSupply())
+}
+
+// testChain 在内存存储中保存 blocks，最后一个区块为链尾
+func testChain(t *testing.T, blocks ...*Block) *Blockchain {
+	db := NewMemStore()
+	t.Cleanup(func() { db.Close() })
+
+	err := db.Update(func(tx StoreTx) error {
+		b, err := createBlockStore(tx)
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if err := b.Put(block); err != nil {
+				return err
+			}
+		}
+		return b.SetTip(blocks[len(blocks)-1].Hash)
+	})
+	assert.Nil(t, err)
+
+	return &Blockchain{tip: blocks[len(blocks)-1].Hash, db: db}
+}
+
+func TestValidateBlock(t *testing.T) {
+	miner := NewWallet()
+	address := string(miner.GetAddress())
+	payee := NewWallet()
+	other := string(payee.GetAddress())
+
+	subsidy := chainParams.Subsidy
+
+	genesisTx := NewCoinbaseTX(address, genesisCoinbaseData)
+	genesis := NewBlock([]*Transaction{genesisTx}, []byte{}, 0, 1, nil)
+	rewardTx := NewRewardTX(address, 1, 0)
+	tip := NewBlock([]*Transaction{rewardTx}, genesis.Hash, 1, 1, nil)
+	bc := testChain(t, genesis, tip)
+	UTXOSet{bc}.Reindex()
+
+	spend := func(prev *Transaction, value int) *Transaction {
+		tx := &Transaction{nil, []TXInput{{prev.ID, 0, nil, miner.PublicKey}}, []TXOutput{*NewTXOutput(value, other)}}
+		tx.ID = tx.Hash()
+		tx.Sign(miner.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+		return tx
+	}
+	candidate := func(data string, txs ...*Transaction) *Block {
+		return &Block{Transactions: txs, PrevBlockHash: tip.Hash, Height: 2, Data: []byte(data)}
+	}
+
+	assert.Nil(t, bc.ValidateBlock(candidate("", NewRewardTX(address, 2, 0))))
+	assert.NotNil(t, bc.ValidateBlock(candidate("", NewCoinbaseTX2(address, "", BlockSubsidy(2)+1))), "reward above subsidy")
+
+	// 手续费可以由奖励交易领取，但不能多领
+	fee := 3
+	payment := spend(genesisTx, subsidy-fee)
+	assert.Nil(t, bc.ValidateBlock(candidate("", NewRewardTX(address, 2, fee), payment)))
+	assert.NotNil(t, bc.ValidateBlock(candidate("", NewRewardTX(address, 2, fee+1), payment)))
+	assert.NotNil(t, bc.ValidateBlock(candidate("", spend(genesisTx, subsidy+1))), "outputs above inputs")
+
+	err := bc.ValidateBlock(candidate("", spend(rewardTx, subsidy)))
+	assert.NotNil(t, err, "block 1 reward is not mature at height 2")
+	mature := &Block{Transactions: []*Transaction{spend(rewardTx, subsidy)}, PrevBlockHash: tip.Hash, Height: 1 + chainParams.CoinbaseMaturity}
+	assert.Nil(t, bc.ValidateBlock(mature))
+
+	// 没有发起分片扣款证明的入账按区块奖励计算，见 TestCrossShardCredit
+	command := "X send -from " + address + " -to " + other + " -amount 500"
+	assert.NotNil(t, bc.ValidateBlock(candidate(command, NewCoinbaseTX2(other, command, 500))))
+
+	// 输入要有有效的签名，同一个输出只能花费一次
+	unsigned := spend(genesisTx, subsidy)
+	unsigned.Vin[0].Signature = nil
+	assert.NotNil(t, bc.ValidateBlock(candidate("", unsigned)), "no signature")
+	thief := NewWallet()
+	stolen := &Transaction{nil, []TXInput{{genesisTx.ID, 0, nil, thief.PublicKey}}, []TXOutput{*NewTXOutput(subsidy, other)}}
+	stolen.ID = stolen.Hash()
+	stolen.Sign(thief.PrivateKey, map[string]Transaction{hex.EncodeToString(genesisTx.ID): *genesisTx})
+	assert.NotNil(t, bc.ValidateBlock(candidate("", stolen)), "signed by a key that does not own the output")
+	twice := &Transaction{nil, []TXInput{{genesisTx.ID, 0, nil, miner.PublicKey}, {genesisTx.ID, 0, nil, miner.PublicKey}}, []TXOutput{*NewTXOutput(subsidy, other)}}
+	twice.ID = twice.Hash()
+	twice.Sign(miner.PrivateKey, map[string]Transaction{hex.EncodeToString(genesisTx.ID): *genesisTx})
+	err = bc.ValidateBlock(candidate("", NewRewardTX(address, 2, subsidy), twice))
+	assert.NotNil(t, err, "the same output twice in one transaction")
+	assert.Contains(t, err.Error(), "already spent in the block")
+	assert.NotNil(t, bc.ValidateBlock(candidate("", spend(genesisTx, subsidy), spend(genesisTx, subsidy-1))), "the same output in two transactions")
+
+	// 区块内可以花费前面交易的输出
+	chained := &Transaction{nil, []TXInput{{payment.ID, 0, nil, payee.PublicKey}}, []TXOutput{*NewTXOutput(subsidy-fee, address)}}
+	chained.ID = chained.Hash()
+	chained.Sign(payee.PrivateKey, map[string]Transaction{hex.EncodeToString(payment.ID): *payment})
+	assert.Nil(t, bc.ValidateBlock(candidate("", payment, chained)))
+	assert.NotNil(t, bc.ValidateBlock(candidate("", chained, payment)), "spends an output created later in the block")
+
+	// 已经被前面的区块花费的输出不能再花费
+	spent := NewBlock([]*Transaction{NewRewardTX(address, 2, 0), spend(genesisTx, subsidy)}, tip.Hash, 2, 1, nil)
+	bc = testChain(t, genesis, tip, spent)
+	UTXOSet{bc}.Reindex()
+	next := &Block{Transactions: []*Transaction{spend(genesisTx, subsidy-1)}, PrevBlockHash: spent.Hash, Height: 3}
+	err = bc.ValidateBlock(next)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "missing or spent output")
+}
+
+func TestAuditSupply(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	genesisTx := NewCoinbaseTX(address, genesisCoinbaseData)
+	genesis := NewBlock([]*Transaction{genesisTx}, []byte{}, 0, 1, nil)
+	next := NewBlock([]*Transaction{NewRewardTX(address, 1, 0)}, genesis.Hash, 1, 1, nil)
+	bc := testChain(t, genesis, next)
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	report, err := UTXOSet.AuditSupply()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Height)
+	assert.Equal(t, 2*chainParams.Subsidy, report.UTXOTotal)
+	assert.Equal(t, chainParams.Subsidy, report.Genesis)
+	assert.Equal(t, BlockSubsidy(1), report.Scheduled)
+	assert.True(t, report.OK())
+
+	// 创世区块的输出可以直接花费，高度 1 的奖励还未成熟
+	outs := UTXOSet.SpendableOutputs(HashPubKey(wallet.PublicKey))
+	assert.Len(t, outs, 1)
+	assert.Equal(t, genesisTx.ID, outs[0].TxID)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// CreditProof 跨分片入账的来源证明：发起分片上提交转账命令的区块头、该区块的法定人数证书，
+// 以及区块中的扣款交易和它的默克尔证明。编码见 encoding.go，放在入账交易 coinbase 输入的 Signature 中
+type CreditProof struct {
+	Header *BlockHeader
+	QC     *BlockQC
+	Tx     *Transaction
+	Proof  *MerkleProof
+}
+
+// Serialize 证明的规范编码
+func (p *CreditProof) Serialize() []byte {
+	return encodeCreditProof(p)
+}
+
+// NewCreditTX 目标分片上的入账交易：按转账命令向收款地址支付，coinbase 输入的 PubKey 是命令，Signature 是来源证明
+func NewCreditTX(command string, proof []byte) (*Transaction, error) {
+	_, to, amount, ok := parseSendCommand(command)
+	if !ok || !ValidateAddress(to) {
+		return nil, fmt.Errorf("invalid transfer command %q", command)
+	}
+	tx := NewCoinbaseTX2(to, command, amount)
+	tx.Vin[0].Signature = proof
+
+	return tx, nil
+}
+
+// creditSource 检查 tx 是否为区块数据 blockData 对应的跨分片入账交易，返回发起分片扣款区块的哈希：
+// 入账恰好向命令中的收款地址支付命令中的金额；扣款区块提交的是同一条命令并且有验证者的法定人数证书；
+// 扣款交易在该区块中，只花费命令中付款地址的输出，并向收款地址支付了命令中的金额
+func creditSource(tx *Transaction, blockData []byte) ([]byte, error) {
+	if !tx.IsCoinbase() || len(blockData) == 0 || !bytes.Equal(tx.Vin[0].PubKey, blockData) {
+		return nil, errors.New("not a cross-shard credit")
+	}
+	from, to, amount, ok := parseSendCommand(string(blockData))
+	if !ok || len(tx.Vout) != 1 || tx.Vout[0].Value != amount || !tx.Vout[0].IsLockedWithKey(addressPubKeyHash(to)) {
+		return nil, errors.New("credit does not pay the transfer command")
+	}
+
+	proof, err := decodeCreditProof(tx.Vin[0].Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cross-shard proof: %v", err)
+	}
+	dataHash := sha256.Sum256(blockData)
+	if !bytes.Equal(proof.Header.DataHash, dataHash[:]) {
+		return nil, errors.New("source block does not commit the transfer command")
+	}
+	if err := proof.QC.Verify(proof.Header); err != nil {
+		return nil, fmt.Errorf("source block: %v", err)
+	}
+	if err := VerifyMerkleProof(proof.Header.MerkleRoot, MerkleLeafHash(proof.Tx.Serialize()), proof.Proof); err != nil {
+		return nil, fmt.Errorf("debit transaction is not in the source block: %v", err)
+	}
+	if !isDebit(proof.Tx, from, to, amount) {
+		return nil, fmt.Errorf("transaction %x does not debit %d from %s", proof.Tx.ID, amount, from)
+	}
+
+	return proof.Header.Hash(), nil
+}
+
+// isDebit 交易是否只花费 from 的输出，并向 to 支付 amount
+func isDebit(tx *Transaction, from, to string, amount int) bool {
+	fromHash := addressPubKeyHash(from)
+	if tx.IsCoinbase() || len(tx.Vin) == 0 || fromHash == nil {
+		return false
+	}
+	for _, vin := range tx.Vin {
+		if !bytes.Equal(HashPubKey(vin.PubKey), fromHash) {
+			return false
+		}
+	}
+	for _, out := range tx.Vout {
+		if out.Value == amount && out.IsLockedWithKey(addressPubKeyHash(to)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// creditSourceHash 链上入账交易的扣款区块哈希，只解码证明不检查，不是入账交易时返回 nil
+func creditSourceHash(tx *Transaction, blockData []byte) []byte {
+	if !tx.IsCoinbase() || len(blockData) == 0 || !bytes.Equal(tx.Vin[0].PubKey, blockData) {
+		return nil
+	}
+	proof, err := decodeCreditProof(tx.Vin[0].Signature)
+	if err != nil {
+		return nil
+	}
+
+	return proof.Header.Hash()
+}
+
+// hasCreditBefore 从 hash 开始往前的链上是否已经有扣款区块为 source 的入账
+func hasCreditBefore(dbTx StoreTx, hash, source []byte) bool {
+	blocks := blocksOf(dbTx)
+	for len(hash) > 0 {
+		block := blocks.Block(hash)
+		if block == nil {
+			return false
+		}
+		for _, tx := range block.Transactions {
+			if bytes.Equal(creditSourceHash(tx, block.Data), source) && isCrossShardCredit(tx, block.Data) {
+				return true
+			}
+		}
+		hash = block.PrevBlockHash
+	}
+
+	return false
+}
+
+// checkCredit 检查入账交易 tx 能否作为区块数据 command 接在链尾：来源证明有效，扣款区块还没有入过账
+func (bc *Blockchain) checkCredit(tx *Transaction, command string) error {
+	source, err := creditSource(tx, []byte(command))
+	if err != nil {
+		return err
+	}
+
+	return bc.db.View(func(dbTx StoreTx) error {
+		if hasCreditBefore(dbTx, blocksOf(dbTx).Tip(), source) {
+			return fmt.Errorf("source block %x is already credited", source)
+		}
+		return nil
+	})
+}
+
+// pendingCredit 发起分片的扣款区块，证书收齐后把入账证明发给目标分片的领导者
+type pendingCredit struct {
+	target  string
+	command string
+	header  *BlockHeader
+	debit   *Transaction
+	proof   *MerkleProof
+}
+
+// pendingCredits 等待证书的扣款区块，按区块哈希索引，最多 maxPendingQCs 个
+var pendingCredits = struct {
+	sync.Mutex
+	credits map[string]pendingCredit
+	order   []string
+}{credits: make(map[string]pendingCredit)}
+
+// awaitCredit 在为扣款区块 block 收集证书之前调用：证书收齐后由 releaseCredit 把扣款交易 debit 的证明发给节点 target
+func awaitCredit(target, command string, block *Block, debit *Transaction) {
+	proof, err := block.TransactionProof(debit.ID)
+	if err != nil {
+		logWarnf("cross-shard credit of block %x: %v", block.Hash, err)
+		return
+	}
+
+	pendingCredits.Lock()
+	defer pendingCredits.Unlock()
+	key := string(block.Hash)
+	if _, ok := pendingCredits.credits[key]; ok {
+		return
+	}
+	if len(pendingCredits.order) >= maxPendingQCs {
+		delete(pendingCredits.credits, pendingCredits.order[0])
+		pendingCredits.order = pendingCredits.order[1:]
+	}
+	pendingCredits.credits[key] = pendingCredit{target, command, block.Header(), debit, proof}
+	pendingCredits.order = append(pendingCredits.order, key)
+}
+
+// releaseCredit 区块 hash 的证书收齐后，区块是扣款区块时把入账证明发给目标分片的领导者
+func releaseCredit(hash []byte, qc *BlockQC) {
+	pendingCredits.Lock()
+	pending, ok := pendingCredits.credits[string(hash)]
+	if ok {
+		delete(pendingCredits.credits, string(hash))
+		for i, key := range pendingCredits.order {
+			if key == string(hash) {
+				pendingCredits.order = append(pendingCredits.order[:i], pendingCredits.order[i+1:]...)
+				break
+			}
+		}
+	}
+	pendingCredits.Unlock()
+	if !ok {
+		return
+	}
+
+	proof := &CreditProof{pending.header, qc, pending.debit, pending.proof}
+	payload := gobEncode(credit{nodeAddress, pending.command, proof.Serialize()})
+	sendData(strings.Replace(pending.target, " ", ":", -1), append(commandToBytes("credit"), payload...))
+}
+
+type credit struct {
+	AddrFrom string
+	Command  string
+	Proof    []byte
+}
+
+// handleCredit 目标分片领导者收到入账证明，检查通过后把入账交易上链，收集证书并同步给本分片的节点
+func handleCredit(chains *ChainService, request []byte) {
+	var payload credit
+	decodePayload(request, &payload)
+
+	bc := chains.Blockchain(NodeIPAddress)
+	if bc == nil {
+		return
+	}
+	defer chains.Release(bc)
+
+	tx, err := NewCreditTX(payload.Command, payload.Proof)
+	if err == nil {
+		err = bc.checkCredit(tx, payload.Command)
+	}
+	if err != nil {
+		logWarnf("reject cross-shard credit from %s: %v", payload.AddrFrom, err)
+		return
+	}
+
+	block := bc.commitTransaction([]*Transaction{tx}, payload.Command)
+	fmt.Printf("----Added block %x\n", block.Hash)
+	var voters []string
+	for _, nodes := range knownShardingNodes {
+		voters = append(voters, nodes...)
+	}
+	certifyBlock(bc, NodeIPAddress, block, voters)
+
+	for _, node := range knownShardingNodes[belongToInt] {
+		if node != NodeIPAddress {
+			BlockSyncnum = 0
+			sendVersion(strings.Replace(node, " ", ":", -1), bc, belongToInt)
+		}
+	}
+}
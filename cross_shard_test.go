@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 跨分片入账要带发起分片扣款区块的证书和扣款交易的默克尔证明，同一个扣款区块只能入账一次
+func TestCrossShardCredit(t *testing.T) {
+	params := *chainParams
+	useChainParams(t, &params)
+	validator := NewWallet()
+	chainParams.ValidatorKeys = map[string]string{"v1": hex.EncodeToString(validator.PublicKey)}
+
+	sender := NewWallet()
+	from := string(sender.GetAddress())
+	to := string(NewWallet().GetAddress())
+	command := "X send -from " + from + " -to " + to + " -amount 500"
+
+	// 发起分片上提交命令的扣款区块，每次接在不同的父区块上
+	sources := 0
+	debitFrom := func(wallet *Wallet) *Transaction {
+		tx := &Transaction{nil, []TXInput{{[]byte{1}, 0, nil, wallet.PublicKey}}, []TXOutput{*NewTXOutput(500, to)}}
+		tx.ID = tx.Hash()
+		return tx
+	}
+	proofOf := func(data string, debit *Transaction, signer *Wallet) []byte {
+		sources++
+		source := NewBlock([]*Transaction{NewRewardTX(from, 5, 0), debit}, []byte{byte(sources)}, 5, 1, []byte(data))
+		header := source.Header()
+		qc := newBlockQC(map[string]QCVote{"v1": signQCVote(t, "v1", signer, header.Hash())})
+		merkle, err := source.TransactionProof(debit.ID)
+		assert.Nil(t, err)
+		return (&CreditProof{header, qc, debit, merkle}).Serialize()
+	}
+	creditOf := func(proof []byte) *Transaction {
+		tx, err := NewCreditTX(command, proof)
+		assert.Nil(t, err)
+		return tx
+	}
+
+	proof := proofOf(command, debitFrom(sender), validator)
+	decoded, err := decodeCreditProof(proof)
+	assert.Nil(t, err)
+	assert.Equal(t, proof, decoded.Serialize())
+
+	credit := creditOf(proof)
+	assert.False(t, isReward(credit, []byte(command)))
+	assert.True(t, isReward(credit, []byte("other command")))
+
+	genesis := NewBlock([]*Transaction{NewCoinbaseTX(from, genesisCoinbaseData)}, []byte{}, 0, 1, nil)
+	bc := testChain(t, genesis)
+	UTXOSet{bc}.Reindex()
+	candidate := func(prev *Block, txs ...*Transaction) *Block {
+		return &Block{Transactions: txs, PrevBlockHash: prev.Hash, Height: prev.Height + 1, Data: []byte(command)}
+	}
+
+	assert.Nil(t, bc.ValidateBlock(candidate(genesis, credit)))
+	assert.NotNil(t, bc.ValidateBlock(candidate(genesis, credit, creditOf(proof))), "two credits in one block")
+	assert.NotNil(t, bc.ValidateBlock(candidate(genesis, NewCoinbaseTX2(to, command, 500))), "no proof")
+	assert.NotNil(t, bc.ValidateBlock(candidate(genesis, creditOf(proofOf(command+" ", debitFrom(sender), validator)))), "source block commits another command")
+	assert.NotNil(t, bc.ValidateBlock(candidate(genesis, creditOf(proofOf(command, debitFrom(sender), NewWallet())))), "not signed by a validator")
+	assert.NotNil(t, bc.ValidateBlock(candidate(genesis, creditOf(proofOf(command, debitFrom(NewWallet()), validator)))), "debit does not spend the sender's outputs")
+
+	// 入账上链后，同一个扣款区块不能再入账
+	credited := NewBlock([]*Transaction{credit}, genesis.Hash, 1, 1, []byte(command))
+	bc = testChain(t, genesis, credited)
+	UTXOSet{bc}.Reindex()
+	err = bc.ValidateBlock(candidate(credited, creditOf(proof)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "already credited")
+	assert.NotNil(t, bc.checkCredit(creditOf(proof), command))
+	assert.Nil(t, bc.ValidateBlock(candidate(credited, creditOf(proofOf(command, debitFrom(sender), validator)))), "a new transfer with the same command")
+}
+
+// 验证者只为本地主链上的扣款区块签名，用这些签名为另一个提交同一命令的区块头凑出的证书不能入账
+func TestCreditRejectsUnsignedSourceHeader(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	chainParams = regtestChainParams()
+	previousNode := NodeIPAddress
+	NodeIPAddress = "127.0.0.1 3000"
+	resetSigningState()
+	t.Cleanup(func() {
+		NodeIPAddress = previousNode
+		resetSigningState()
+	})
+
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	validator := wallets.CreateWallet()
+	wallets.SaveToFile(NodeIPAddress)
+	chainParams.ValidatorKeys = map[string]string{NodeIPAddress: hex.EncodeToString(wallets.Wallets[validator].PublicKey)}
+
+	sender, thief := NewWallet(), NewWallet()
+	from := string(sender.GetAddress())
+	to := string(NewWallet().GetAddress())
+	command := "X send -from " + from + " -to " + to + " -amount 500"
+	debitFrom := func(wallet *Wallet) *Transaction {
+		tx := &Transaction{nil, []TXInput{{[]byte{1}, 0, nil, wallet.PublicKey}}, []TXOutput{*NewTXOutput(500, to)}}
+		tx.ID = tx.Hash()
+		return tx
+	}
+	genesis := NewBlock([]*Transaction{NewCoinbaseTX(validator, "")}, []byte{}, 0, 1, nil)
+	debit, forgedDebit := debitFrom(sender), debitFrom(thief)
+	source := NewBlock([]*Transaction{NewRewardTX(from, 5, 0), debit}, genesis.Hash, 1, 1, []byte(command))
+	forged := NewBlock([]*Transaction{NewRewardTX(from, 5, 0), forgedDebit}, genesis.Hash, 1, 1, []byte(command))
+	bc := testChain(t, genesis, source)
+
+	rememberVote(command)
+	rememberVote(command)
+	_, err := signMainChainHeader(bc, forged.Header())
+	assert.Equal(t, errNotOnMainChain, err)
+	vote, err := signMainChainHeader(bc, source.Header())
+	assert.Nil(t, err)
+	qc := newBlockQC(map[string]QCVote{NodeIPAddress: vote})
+
+	creditOf := func(block *Block, debit *Transaction) *Transaction {
+		merkle, err := block.TransactionProof(debit.ID)
+		assert.Nil(t, err)
+		tx, err := NewCreditTX(command, (&CreditProof{block.Header(), qc, debit, merkle}).Serialize())
+		assert.Nil(t, err)
+		return tx
+	}
+	_, err = creditSource(creditOf(source, debit), []byte(command))
+	assert.Nil(t, err)
+	_, err = creditSource(creditOf(forged, forgedDebit), []byte(command))
+	assert.NotNil(t, err, "the certificate signs the connected block, not the forged header")
+}
@@ -0,0 +1,724 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// 规范二进制编码
+//
+// 交易、区块、UTXO 记录都使用下面这套确定性的二进制格式序列化，交易 ID 和区块哈希
+// 都基于这份编码计算，非 Go 客户端只要按本说明实现即可得到相同的结果。
+//
+// 基本类型：
+//
+//	uint32 / int64  固定长度，小端序
+//	varint          比特币 CompactSize：<0xfd 为 1 字节；0xfd+uint16；0xfe+uint32；0xff+uint64
+//	varbytes        varint 长度 + 原始字节
+//
+// 交易（Transaction.Serialize）：
+//
+//	uint32    编码版本，当前为 1
+//	varbytes  ID（计算 ID 时此字段为空）
+//	varint    输入个数，每个输入：
+//	            varbytes Txid
+//	            uint32   Vout（coinbase 的 -1 编码为 0xffffffff）
+//	            varbytes Signature
+//	            varbytes PubKey
+//	varint    输出个数，每个输出：
+//	            int64    Value
+//	            varbytes PubKeyHash
+//
+// 交易 ID = SHA256(ID 和各输入的 Signature 置空、非 coinbase 输入的 PubKey 也置空后的交易编码)，
+// 签名前后和改写签名都不改变交易 ID，见 Transaction.Hash。
+//
+// 区块头（Block.HeaderBytes，区块哈希 = SHA256(区块头)，PoW 也对这份数据求解）：
+//
+//	uint32    编码版本，当前为 1
+//	varbytes  PrevBlockHash
+//	32 字节   交易默克尔根
+//	int64     Timestamp
+//	uint32    targetBits
+//	int64     Nonce
+//	int64     Height
+//	32 字节   SHA256(Data)
+//	32 字节   区块接入后 UTXO 集的承诺
+//
+// 交易默克尔根：叶子为 SHA256(交易编码)，父节点为 SHA256(左 || 右)；任何一层节点数为奇数时
+// 复制该层最后一个节点（只有一笔交易时同样复制），直到只剩一个节点。包含证明（MerkleProof）
+// 给出叶子下标和自下而上的兄弟节点哈希，下标的第 i 位为 0 表示第 i 层的当前节点在左边。
+//
+// 区块（Block.Serialize）：
+//
+//	uint32    编码版本，当前为 1
+//	varbytes  PrevBlockHash
+//	varbytes  Hash
+//	int64     Timestamp
+//	int64     Nonce
+//	int64     Height
+//	varbytes  Data
+//	32 字节   UTXO 承诺
+//	varint    交易个数，随后每笔交易为 varbytes(交易编码)
+//
+// 法定人数证书（BlockQC.Serialize），hotstuff 区块的投票，每张票都是验证者对区块头哈希的签名，
+// PubKey 必须是 validatorKeys 中为该 NodeID 配置的公钥：
+//
+//	varint    投票个数，每张投票：
+//	            varbytes NodeID
+//	            varbytes PubKey（X || Y，各 32 字节）
+//	            varbytes R
+//	            varbytes S
+//
+// hotstuff 投票（Vote，共识消息中的投票），S、R 为大端序整数，没有的字段为空：
+//
+//	varbytes  Votetype
+//	varbytes  NodeID
+//	varbytes  投票钱包地址
+//	varbytes  S
+//	varbytes  R
+//	varbytes  PublicKey（X || Y，各 32 字节）
+//	varbytes  交易编码
+//
+// 跨分片入账证明（CreditProof，放在入账交易 coinbase 输入的 Signature 中，不参与交易 ID）：
+//
+//	varbytes  发起分片扣款区块的区块头编码
+//	varbytes  该区块的法定人数证书
+//	varbytes  扣款交易编码
+//	varint    扣款交易在区块中的下标
+//	varint    兄弟节点个数，随后每个为 varbytes 哈希
+//
+// 区块过滤器（NewBlockFilter，元素见 block_filter.go）：
+//
+//	varint    元素个数 N
+//	位流      元素用 SipHash-2-4（key 为区块哈希的前 16 字节）映射到 [0, N*784931) 后排序，
+//	          相邻差值依次做 P=19 的 Golomb-Rice 编码：商为若干个 1 加一个 0，余数 19 位，
+//	          高位在前，最后一个字节不足 8 位时补 0
+//
+// UTXO 记录（TXOutputs.Serialize），格式变化时 chainstateVersion 加一，节点启动时重建：
+//
+//	varint    交易所在区块的高度
+//	1 字节    1 表示奖励交易（输出需要等待 chainParams.CoinbaseMaturity 个区块），否则为 0
+//	varint    输出个数，每个输出：
+//	            uint32   输出在交易中的下标
+//	            int64    Value
+//	            varbytes PubKeyHash
+//
+// 撤销数据（encodeUndo，见 chainstate.go），区块改动过的 UTXO 记录在区块之前的值：
+//
+//	varint    记录个数，每条记录：
+//	            varbytes 交易 ID
+//	            varbytes UTXO 记录，区块之前没有这条记录时为空
+//
+// UTXO 承诺：每条记录的 交易 ID || UTXO 记录 映射为模 2^3072 - 1103717 的元素，承诺为全部元素乘积
+// （384 字节大端序）的 SHA256，与记录的顺序无关，空集合为 32 个零字节，见 utxo_accumulator.go。
+// 每个区块头都带有该区块接入后的承诺，接入时不一致的区块被拒绝。
+//
+// UTXO 快照（UTXOSnapshot.Serialize）：
+//
+//	uint32    编码版本，当前为 1
+//	varbytes  快照所在的区块（区块编码）
+//	varint    区块头个数（创世区块到快照区块），每个区块头：
+//	            varbytes 区块头编码
+//	            varbytes 法定人数证书，没有时为空
+//	32 字节   UTXO 承诺
+//	varint    记录个数，每条记录：
+//	            varbytes 交易 ID
+//	            varbytes UTXO 记录
+//
+// 区块导出文件（exportchain，见 chain_export.go），校验和为两次 SHA256 的前 4 字节：
+//
+//	4 字节    "BCHN"
+//	uint32    编码版本，当前为 1
+//	uint32    网络 magic
+//	32 字节   创世区块哈希
+//	varint    第一个区块的高度
+//	varint    区块个数
+//	4 字节    以上内容的校验和
+//	随后按高度升序，每个区块：
+//	            varbytes 区块编码
+//	            varbytes 法定人数证书，没有时为空
+//	            4 字节   区块编码 || 证书 的校验和
+
+const encodingVersion = 1
+
+var errTrailingBytes = errors.New("unexpected trailing bytes")
+
+// writeVarInt 以比特币 CompactSize 格式写入无符号整数
+func writeVarInt(buff *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xfd:
+		buff.WriteByte(byte(n))
+	case n <= 0xffff:
+		buff.WriteByte(0xfd)
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(n))
+		buff.Write(b[:])
+	case n <= 0xffffffff:
+		buff.WriteByte(0xfe)
+		writeUint32(buff, uint32(n))
+	default:
+		buff.WriteByte(0xff)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], n)
+		buff.Write(b[:])
+	}
+}
+
+// writeVarBytes 写入 varint 长度前缀和字节内容
+func writeVarBytes(buff *bytes.Buffer, data []byte) {
+	writeVarInt(buff, uint64(len(data)))
+	buff.Write(data)
+}
+
+// writeUint32 以小端序写入 uint32
+func writeUint32(buff *bytes.Buffer, n uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], n)
+	buff.Write(b[:])
+}
+
+// writeInt64 以小端序写入 int64
+func writeInt64(buff *bytes.Buffer, n int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(n))
+	buff.Write(b[:])
+}
+
+// writeCommitment 写入 32 字节的 UTXO 承诺，没有承诺时写入 32 个零字节
+func writeCommitment(buff *bytes.Buffer, commitment []byte) {
+	var b [32]byte
+	copy(b[:], commitment)
+	buff.Write(b[:])
+}
+
+// binReader 顺序读取规范编码，第一次出错后后续读取都返回零值，最后统一检查 err
+type binReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newBinReader(data []byte) *binReader {
+	return &binReader{data: data}
+}
+
+func (r *binReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data)-r.pos < n {
+		r.err = fmt.Errorf("unexpected end of data at offset %d", r.pos)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+
+	return b
+}
+
+func (r *binReader) readByte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *binReader) readUint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *binReader) readInt64() int64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+// readVarInt 只接受最短编码，保证每个值只有一种编码，交易也就只有一种编码
+func (r *binReader) readVarInt() uint64 {
+	var n, min uint64
+	switch prefix := r.readByte(); prefix {
+	case 0xfd:
+		b := r.next(2)
+		if b == nil {
+			return 0
+		}
+		n, min = uint64(binary.LittleEndian.Uint16(b)), 0xfd
+	case 0xfe:
+		n, min = uint64(r.readUint32()), 0x10000
+	case 0xff:
+		n, min = uint64(r.readInt64()), 0x100000000
+	default:
+		return uint64(prefix)
+	}
+	if r.err == nil && n < min {
+		r.err = fmt.Errorf("non-minimal varint at offset %d", r.pos)
+		return 0
+	}
+
+	return n
+}
+
+// readCount 读取元素个数，并用剩余字节数做上限检查，防止恶意数据导致巨量分配
+func (r *binReader) readCount() int {
+	n := r.readVarInt()
+	if r.err == nil && n > uint64(len(r.data)-r.pos) {
+		r.err = fmt.Errorf("count %d exceeds remaining data", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binReader) readVarBytes() []byte {
+	n := r.readCount()
+	b := r.next(n)
+	if b == nil || n == 0 {
+		return nil
+	}
+	out := make([]byte, n)
+	copy(out, b)
+
+	return out
+}
+
+// finish 检查数据是否恰好读完
+func (r *binReader) finish() error {
+	if r.err == nil && r.pos != len(r.data) {
+		r.err = errTrailingBytes
+	}
+	return r.err
+}
+
+func writeTXInput(buff *bytes.Buffer, in TXInput) {
+	writeVarBytes(buff, in.Txid)
+	writeUint32(buff, uint32(int32(in.Vout)))
+	writeVarBytes(buff, in.Signature)
+	writeVarBytes(buff, in.PubKey)
+}
+
+func readTXInput(r *binReader) TXInput {
+	var in TXInput
+	in.Txid = r.readVarBytes()
+	in.Vout = int(int32(r.readUint32()))
+	in.Signature = r.readVarBytes()
+	in.PubKey = r.readVarBytes()
+
+	return in
+}
+
+func readTXOutput(r *binReader) TXOutput {
+	var out TXOutput
+	out.Value = int(r.readInt64())
+	out.PubKeyHash = r.readVarBytes()
+
+	return out
+}
+
+func encodeTransaction(tx *Transaction) []byte {
+	var buff bytes.Buffer
+
+	writeUint32(&buff, encodingVersion)
+	writeVarBytes(&buff, tx.ID)
+	writeVarInt(&buff, uint64(len(tx.Vin)))
+	for _, in := range tx.Vin {
+		writeTXInput(&buff, in)
+	}
+	writeVarInt(&buff, uint64(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		writeOutput(&buff, out)
+	}
+
+	return buff.Bytes()
+}
+
+func readTransaction(r *binReader) Transaction {
+	var tx Transaction
+
+	if v := r.readUint32(); r.err == nil && v != encodingVersion {
+		r.err = fmt.Errorf("unsupported transaction encoding version %d", v)
+	}
+	tx.ID = r.readVarBytes()
+	nIn := r.readCount()
+	for i := 0; i < nIn && r.err == nil; i++ {
+		tx.Vin = append(tx.Vin, readTXInput(r))
+	}
+	nOut := r.readCount()
+	for i := 0; i < nOut && r.err == nil; i++ {
+		tx.Vout = append(tx.Vout, readTXOutput(r))
+	}
+
+	return tx
+}
+
+func decodeTransaction(data []byte) (Transaction, error) {
+	r := newBinReader(data)
+	tx := readTransaction(r)
+
+	return tx, r.finish()
+}
+
+func encodeBlock(b *Block) []byte {
+	var buff bytes.Buffer
+
+	writeUint32(&buff, encodingVersion)
+	writeVarBytes(&buff, b.PrevBlockHash)
+	writeVarBytes(&buff, b.Hash)
+	writeInt64(&buff, b.Timestamp)
+	writeInt64(&buff, int64(b.Nonce))
+	writeInt64(&buff, int64(b.Height))
+	writeVarBytes(&buff, b.Data)
+	writeCommitment(&buff, b.UTXOCommitment)
+	writeVarInt(&buff, uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		writeVarBytes(&buff, tx.Serialize())
+	}
+
+	return buff.Bytes()
+}
+
+func decodeBlock(data []byte) (*Block, error) {
+	r := newBinReader(data)
+	b := &Block{}
+
+	if v := r.readUint32(); r.err == nil && v != encodingVersion {
+		return nil, fmt.Errorf("unsupported block encoding version %d", v)
+	}
+	b.PrevBlockHash = r.readVarBytes()
+	b.Hash = r.readVarBytes()
+	b.Timestamp = r.readInt64()
+	b.Nonce = int(r.readInt64())
+	b.Height = int(r.readInt64())
+	b.Data = r.readVarBytes()
+	b.UTXOCommitment = append([]byte(nil), r.next(32)...)
+	nTx := r.readCount()
+	for i := 0; i < nTx && r.err == nil; i++ {
+		tx, err := decodeTransaction(r.readVarBytes())
+		if r.err == nil && err != nil {
+			r.err = err
+		}
+		b.Transactions = append(b.Transactions, &tx)
+	}
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func decodeHeader(data []byte) (*BlockHeader, error) {
+	r := newBinReader(data)
+	h := &BlockHeader{}
+
+	if v := r.readUint32(); r.err == nil && v != encodingVersion {
+		return nil, fmt.Errorf("unsupported header encoding version %d", v)
+	}
+	h.PrevBlockHash = r.readVarBytes()
+	h.MerkleRoot = append([]byte(nil), r.next(32)...)
+	h.Timestamp = r.readInt64()
+	h.TargetBits = int(r.readUint32())
+	h.Nonce = int(r.readInt64())
+	h.Height = int(r.readInt64())
+	h.DataHash = append([]byte(nil), r.next(32)...)
+	h.UTXOCommitment = append([]byte(nil), r.next(32)...)
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func encodeQC(qc *BlockQC) []byte {
+	var buff bytes.Buffer
+
+	writeVarInt(&buff, uint64(len(qc.Votes)))
+	for _, vote := range qc.Votes {
+		writeVarBytes(&buff, []byte(vote.NodeID))
+		writeVarBytes(&buff, vote.PubKey)
+		writeVarBytes(&buff, vote.R)
+		writeVarBytes(&buff, vote.S)
+	}
+
+	return buff.Bytes()
+}
+
+func decodeQC(data []byte) (*BlockQC, error) {
+	r := newBinReader(data)
+	qc := &BlockQC{}
+
+	n := r.readCount()
+	for i := 0; i < n && r.err == nil; i++ {
+		qc.Votes = append(qc.Votes, QCVote{
+			NodeID: string(r.readVarBytes()),
+			PubKey: r.readVarBytes(),
+			R:      r.readVarBytes(),
+			S:      r.readVarBytes(),
+		})
+	}
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+
+	return qc, nil
+}
+
+func encodeVote(v Vote) []byte {
+	var buff bytes.Buffer
+
+	writeVarBytes(&buff, []byte(v.Votetype))
+	writeVarBytes(&buff, []byte(v.NodeID))
+	writeVarBytes(&buff, []byte(v.Addresss))
+	writeBigInt(&buff, v.S)
+	writeBigInt(&buff, v.R)
+	var pubKey, tx []byte
+	if v.PublicKey.X != nil && v.PublicKey.Y != nil {
+		pubKey = marshalPubKey(v.PublicKey)
+	}
+	if v.Tx != nil {
+		tx = v.Tx.Serialize()
+	}
+	writeVarBytes(&buff, pubKey)
+	writeVarBytes(&buff, tx)
+
+	return buff.Bytes()
+}
+
+func writeBigInt(buff *bytes.Buffer, n *big.Int) {
+	var data []byte
+	if n != nil {
+		data = n.Bytes()
+	}
+	writeVarBytes(buff, data)
+}
+
+func decodeVote(data []byte) (Vote, error) {
+	r := newBinReader(data)
+	var v Vote
+
+	v.Votetype = string(r.readVarBytes())
+	v.NodeID = string(r.readVarBytes())
+	v.Addresss = string(r.readVarBytes())
+	sBytes := r.readVarBytes()
+	rBytes := r.readVarBytes()
+	pubKey := r.readVarBytes()
+	txData := r.readVarBytes()
+	if err := r.finish(); err != nil {
+		return Vote{}, err
+	}
+
+	if len(sBytes) > 0 {
+		v.S = new(big.Int).SetBytes(sBytes)
+	}
+	if len(rBytes) > 0 {
+		v.R = new(big.Int).SetBytes(rBytes)
+	}
+	if len(pubKey) > 0 {
+		if len(pubKey) != 64 {
+			return Vote{}, fmt.Errorf("invalid vote public key length %d", len(pubKey))
+		}
+		v.PublicKey = unmarshalPubKey(pubKey)
+	}
+	if len(txData) > 0 {
+		tx, err := decodeTransaction(txData)
+		if err != nil {
+			return Vote{}, err
+		}
+		v.Tx = &tx
+	}
+
+	return v, nil
+}
+
+func encodeCreditProof(p *CreditProof) []byte {
+	var buff bytes.Buffer
+
+	writeVarBytes(&buff, p.Header.Bytes())
+	writeVarBytes(&buff, p.QC.Serialize())
+	writeVarBytes(&buff, p.Tx.Serialize())
+	writeVarInt(&buff, uint64(p.Proof.Index))
+	writeVarInt(&buff, uint64(len(p.Proof.Siblings)))
+	for _, sibling := range p.Proof.Siblings {
+		writeVarBytes(&buff, sibling)
+	}
+
+	return buff.Bytes()
+}
+
+func decodeCreditProof(data []byte) (*CreditProof, error) {
+	r := newBinReader(data)
+	headerData := r.readVarBytes()
+	qcData := r.readVarBytes()
+	txData := r.readVarBytes()
+	proof := &MerkleProof{Index: int(r.readVarInt())}
+	n := r.readCount()
+	for i := 0; i < n && r.err == nil; i++ {
+		proof.Siblings = append(proof.Siblings, r.readVarBytes())
+	}
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+
+	header, err := decodeHeader(headerData)
+	if err != nil {
+		return nil, err
+	}
+	qc, err := decodeQC(qcData)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := decodeTransaction(txData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreditProof{header, qc, &tx, proof}, nil
+}
+
+func encodeOutputs(outs TXOutputs) []byte {
+	var buff bytes.Buffer
+
+	writeVarInt(&buff, uint64(outs.Height))
+	if outs.Reward {
+		buff.WriteByte(1)
+	} else {
+		buff.WriteByte(0)
+	}
+	writeVarInt(&buff, uint64(len(outs.Outputs)))
+	for i, out := range outs.Outputs {
+		writeUint32(&buff, uint32(outs.Indexes[i]))
+		writeOutput(&buff, out)
+	}
+
+	return buff.Bytes()
+}
+
+func decodeOutputs(data []byte) (TXOutputs, error) {
+	var outs TXOutputs
+	r := newBinReader(data)
+
+	outs.Height = int(r.readVarInt())
+	switch r.readByte() {
+	case 0:
+	case 1:
+		outs.Reward = true
+	default:
+		if r.err == nil {
+			r.err = errors.New("invalid coinbase flag")
+		}
+	}
+	n := r.readCount()
+	for i := 0; i < n && r.err == nil; i++ {
+		index := int(r.readUint32())
+		outs.add(index, readTXOutput(r))
+	}
+
+	return outs, r.finish()
+}
+
+func encodeUndo(undo []utxoUndo) []byte {
+	var buff bytes.Buffer
+
+	writeVarInt(&buff, uint64(len(undo)))
+	for _, entry := range undo {
+		writeVarBytes(&buff, entry.TxID)
+		writeVarBytes(&buff, entry.Data)
+	}
+
+	return buff.Bytes()
+}
+
+func decodeUndo(data []byte) ([]utxoUndo, error) {
+	var undo []utxoUndo
+	r := newBinReader(data)
+
+	for n := r.readCount(); r.err == nil && len(undo) < n; {
+		undo = append(undo, utxoUndo{r.readVarBytes(), r.readVarBytes()})
+	}
+
+	return undo, r.finish()
+}
+
+func encodeSnapshot(s *UTXOSnapshot) []byte {
+	var buff bytes.Buffer
+
+	writeUint32(&buff, encodingVersion)
+	writeVarBytes(&buff, encodeBlock(s.Block))
+	writeVarInt(&buff, uint64(len(s.Headers)))
+	for i, header := range s.Headers {
+		writeVarBytes(&buff, header.Bytes())
+		var qc []byte
+		if i < len(s.QCs) && s.QCs[i] != nil {
+			qc = encodeQC(s.QCs[i])
+		}
+		writeVarBytes(&buff, qc)
+	}
+	buff.Write(s.Commitment)
+	writeVarInt(&buff, uint64(len(s.Entries)))
+	for _, entry := range s.Entries {
+		writeVarBytes(&buff, entry.TxID)
+		writeVarBytes(&buff, encodeOutputs(entry.Outputs))
+	}
+
+	return buff.Bytes()
+}
+
+func decodeSnapshot(data []byte) (*UTXOSnapshot, error) {
+	r := newBinReader(data)
+	s := &UTXOSnapshot{}
+
+	if v := r.readUint32(); r.err == nil && v != encodingVersion {
+		return nil, fmt.Errorf("unsupported snapshot encoding version %d", v)
+	}
+	block, err := decodeBlock(r.readVarBytes())
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Block = block
+
+	for n := r.readCount(); r.err == nil && len(s.Headers) < n; {
+		header, err := decodeHeader(r.readVarBytes())
+		if r.err != nil {
+			return nil, r.err
+		}
+		if err != nil {
+			return nil, err
+		}
+		var qc *BlockQC
+		if data := r.readVarBytes(); len(data) > 0 {
+			if qc, err = decodeQC(data); err != nil {
+				return nil, err
+			}
+		}
+		s.Headers = append(s.Headers, header)
+		s.QCs = append(s.QCs, qc)
+	}
+	s.Commitment = append([]byte(nil), r.next(32)...)
+
+	for n := r.readCount(); r.err == nil && len(s.Entries) < n; {
+		txID := append([]byte(nil), r.readVarBytes()...)
+		outs, err := decodeOutputs(r.readVarBytes())
+		if r.err != nil {
+			return nil, r.err
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.Entries = append(s.Entries, SnapshotEntry{txID, outs})
+	}
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionEncodingRoundTrip(t *testing.T) {
+	_, _, _, tx := newSighashFixture()
+
+	encoded := tx.Serialize()
+	decoded := DeserializeTransaction(encoded)
+	assert.Equal(t, *tx, decoded)
+	assert.Equal(t, encoded, decoded.Serialize(), "encoding is deterministic")
+
+	_, err := decodeTransaction(append(encoded, 0))
+	assert.Equal(t, errTrailingBytes, err)
+	_, err = decodeTransaction(encoded[:len(encoded)-1])
+	assert.NotNil(t, err, "truncated data is rejected")
+}
+
+// 投票在 hotstuff 消息中随 gob 发送，公钥、签名和交易都要完整往返
+func TestVoteEncodingRoundTrip(t *testing.T) {
+	wallet := NewWallet()
+	_, _, _, tx := newSighashFixture()
+	qc := QuorumCertificate{NodeSignatures: map[int]Vote{
+		0: {Votetype: "agree", NodeID: "127.0.0.1 3000", Addresss: string(wallet.GetAddress()), S: big.NewInt(7), R: big.NewInt(9), PublicKey: wallet.PrivateKey.PublicKey, Tx: tx},
+		1: {Votetype: "agree", NodeID: "127.0.0.1 3001"},
+	}}
+
+	var buff bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buff).Encode(qc))
+	var decoded QuorumCertificate
+	assert.Nil(t, gob.NewDecoder(&buff).Decode(&decoded))
+
+	vote := decoded.NodeSignatures[0]
+	assert.Equal(t, "127.0.0.1 3000", vote.NodeID)
+	assert.Equal(t, 0, vote.S.Cmp(big.NewInt(7)))
+	assert.Equal(t, 0, vote.R.Cmp(big.NewInt(9)))
+	assert.Equal(t, wallet.PublicKey, marshalPubKey(vote.PublicKey))
+	assert.Equal(t, tx.Serialize(), vote.Tx.Serialize())
+	assert.Nil(t, decoded.NodeSignatures[1].PublicKey.X, "a vote without a key stays without one")
+	assert.Nil(t, decoded.NodeSignatures[1].Tx)
+
+	_, err := decodeVote(append(encodeVote(vote), 0))
+	assert.Equal(t, errTrailingBytes, err)
+}
+
+func TestReadVarIntRejectsNonMinimal(t *testing.T) {
+	for _, n := range []uint64{0, 0xfc, 0xfd, 0xffff, 0x10000, 0xffffffff, 0x100000000} {
+		var buff bytes.Buffer
+		writeVarInt(&buff, n)
+		r := newBinReader(buff.Bytes())
+		assert.Equal(t, n, r.readVarInt())
+		assert.Nil(t, r.err)
+	}
+
+	for _, encoded := range [][]byte{
+		{0xfd, 0x05, 0x00},
+		{0xfe, 0xff, 0xff, 0x00, 0x00},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00},
+	} {
+		r := newBinReader(encoded)
+		r.readVarInt()
+		assert.NotNil(t, r.err, "%x", encoded)
+	}
+}
+
+func TestBlockEncodingRoundTrip(t *testing.T) {
+	coinbase := NewCoinbaseTX2(string(NewWallet().GetAddress()), "genesis", 10)
+	block := NewBlock([]*Transaction{coinbase}, []byte{1, 2, 3}, 7, 1, []byte("data"))
+
+	decoded := DeserializeBlock(block.Serialize())
+	assert.Equal(t, block.Serialize(), decoded.Serialize())
+	assert.Equal(t, block.Hash, decoded.ComputeHash(), "hash is recomputed from the decoded header")
+	assert.Equal(t, 7, decoded.Height)
+
+	outs := TXOutputs{Outputs: coinbase.Vout, Indexes: []int{3}, Height: 7, Reward: true}
+	assert.Equal(t, outs, DeserializeOutputs(outs.Serialize()))
+}
+
+func TestMigrateBlockchainDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockchain_test.db")
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+
+	// 按旧格式构造两个区块：第二个区块的交易花费创世区块的 coinbase
+	genesisTx := NewCoinbaseTX2(address, "genesis", 10)
+	spend := &Transaction{nil, []TXInput{{genesisTx.ID, 0, nil, wallet.PublicKey}}, []TXOutput{*NewTXOutput(10, address)}}
+	spend.ID = spend.Hash()
+	genesis := &Block{1, []*Transaction{genesisTx}, []byte{}, []byte("legacy-genesis"), 0, 0, nil, nil}
+	next := &Block{2, []*Transaction{spend}, genesis.Hash, []byte("legacy-next"), 0, 1, nil, nil}
+
+	db, err := bolt.Open(path, 0600, nil)
+	assert.Nil(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
+		}
+		for _, block := range []*Block{genesis, next} {
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(block); err != nil {
+				return err
+			}
+			if err := b.Put(block.Hash, encoded.Bytes()); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte("l"), next.Hash)
+	})
+	assert.Nil(t, err)
+	db.Close()
+
+	count, err := MigrateBlockchainDB(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.True(t, dbExists(path+legacyBackupSuffix))
+
+	_, err = MigrateBlockchainDB(path)
+	assert.Equal(t, errAlreadyMigrated, err)
+
+	store, err := openBoltStore(path, 0600, 0)
+	assert.Nil(t, err)
+	defer store.Close()
+	var tip []byte
+	store.View(func(tx StoreTx) error {
+		tip = blocksOf(tx).Tip()
+		return nil
+	})
+	bc := &Blockchain{tip: tip, db: store}
+
+	tipBlock := bc.Iterator().Next()
+	genesisBlock, err := bc.GetBlock(tipBlock.PrevBlockHash)
+	assert.Nil(t, err)
+	assert.Equal(t, genesisBlock.Transactions[0].ID, tipBlock.Transactions[0].Vin[0].Txid, "spent txid is remapped")
+	assert.Equal(t, genesisBlock.Transactions[0].Hash(), genesisBlock.Transactions[0].ID)
+
+	UTXOSet := UTXOSet{bc}
+	assert.Equal(t, 1, UTXOSet.CountTransactions())
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// Golomb-Rice 编码集合（GCS），参数与 BIP158 的 basic 过滤器相同：
+// 每个元素用 SipHash-2-4 哈希后映射到 [0, N*M)，排序后对相邻差值做 Golomb-Rice 编码，误报率约 1/M
+const (
+	gcsP = 19
+	gcsM = 784931
+)
+
+// sipHash SipHash-2-4
+func sipHash(k0, k1 uint64, p []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	last := uint64(len(p)) << 56
+	for ; len(p) >= 8; p = p[8:] {
+		m := binary.LittleEndian.Uint64(p)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	for i := len(p) - 1; i >= 0; i-- {
+		last |= uint64(p[i]) << (8 * uint(i))
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// gcsHashes 元素映射到 [0, n*M) 后排序
+func gcsHashes(key []byte, items [][]byte, n uint64) []uint64 {
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+
+	var values []uint64
+	for _, item := range items {
+		hi, _ := bits.Mul64(sipHash(k0, k1, item), n*gcsM)
+		values = append(values, hi)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	return values
+}
+
+type bitWriter struct {
+	buff  bytes.Buffer
+	cur   byte
+	nbits uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if bit {
+		w.cur |= 1 << (7 - w.nbits)
+	}
+	w.nbits++
+	if w.nbits == 8 {
+		w.buff.WriteByte(w.cur)
+		w.cur, w.nbits = 0, 0
+	}
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(v&(1<<(i-1)) != 0)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buff.WriteByte(w.cur)
+		w.cur, w.nbits = 0, 0
+	}
+	return w.buff.Bytes()
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+var errGCSTruncated = errors.New("truncated filter")
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= uint(len(r.data))*8 {
+		return false, errGCSTruncated
+	}
+	bit := r.data[r.pos/8]&(1<<(7-r.pos%8)) != 0
+	r.pos++
+
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n uint) (uint64, error) {
+	var v uint64
+	for i := uint(0); i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+
+	return v, nil
+}
+
+// readGolomb 读取一个 Golomb-Rice 编码的差值：商为一串 1 加一个 0，余数为 P 位
+func (r *bitReader) readGolomb() (uint64, error) {
+	var q uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		q++
+	}
+	rem, err := r.readBits(gcsP)
+
+	return q<<gcsP | rem, err
+}
+
+// BuildGCS 用 16 字节的 key 为 items 构建过滤器：varint 元素个数 + Golomb-Rice 编码的差值
+func BuildGCS(key []byte, items [][]byte) []byte {
+	var buff bytes.Buffer
+	writeVarInt(&buff, uint64(len(items)))
+	if len(items) == 0 {
+		return buff.Bytes()
+	}
+
+	var w bitWriter
+	var last uint64
+	for _, value := range gcsHashes(key, items, uint64(len(items))) {
+		delta := value - last
+		last = value
+		for q := delta >> gcsP; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, gcsP)
+	}
+	buff.Write(w.bytes())
+
+	return buff.Bytes()
+}
+
+// MatchGCS 过滤器是否可能包含 items 中的任何一个（可能误报，不会漏报）
+func MatchGCS(key, filter []byte, items [][]byte) (bool, error) {
+	r := newBinReader(filter)
+	n := r.readVarInt()
+	if r.err != nil {
+		return false, r.err
+	}
+	if n == 0 || len(items) == 0 {
+		return false, nil
+	}
+	if n > uint64(len(filter))*8 {
+		return false, errGCSTruncated
+	}
+
+	targets := gcsHashes(key, items, n)
+	br := &bitReader{data: filter[r.pos:]}
+	var value uint64
+	t := 0
+	for i := uint64(0); i < n; i++ {
+		delta, err := br.readGolomb()
+		if err != nil {
+			return false, err
+		}
+		value += delta
+		for t < len(targets) && targets[t] < value {
+			t++
+		}
+		if t == len(targets) {
+			return false, nil
+		}
+		if targets[t] == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// HD 钱包按 SLIP-0010 在 P-256 曲线上派生密钥（BIP32 的 NIST P-256 版本），
+// 派生路径为 m/44'/0'/0'/chain/index，chain 为 0 时是收款地址，为 1 时是找零地址。
+const (
+	hdMasterKey     = "Nist256p1 seed"
+	hdHardened      = uint32(0x80000000)
+	hdPurpose       = 44
+	hdCoinType      = 0
+	hdAccount       = 0
+	hdMnemonicBits  = 128
+	hdDefaultGap    = 20
+	hdReceiveChain  = 0
+	hdChangeChain   = 1
+	hdChainCount    = 2
+	hdPathFormat    = "m/%d'/%d'/%d'/%d/%d"
+	hdKeyLen        = 32
+	hdSeedMinLength = 16
+)
+
+// HDChain 钱包文件中保存的 HD 种子和每条链下一个未使用的下标
+type HDChain struct {
+	Seed []byte
+	Next [hdChainCount]uint32
+}
+
+// hdKey 扩展私钥：私钥加链码
+type hdKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+// NewMnemonic 生成 12 个单词的助记词
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(hdMnemonicBits)
+	if err != nil {
+		return "", err
+	}
+
+	return bip39.NewMnemonic(entropy)
+}
+
+// NewHDChain 从助记词得到 HD 种子，助记词校验和错误时返回错误
+func NewHDChain(mnemonic string) (*HDChain, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &HDChain{Seed: seed}, nil
+}
+
+// DeriveWallet 派生指定链和下标上的钱包，地址格式与随机生成的钱包相同
+func (hd *HDChain) DeriveWallet(chain, index uint32) (*Wallet, error) {
+	if len(hd.Seed) < hdSeedMinLength {
+		return nil, errWalletLocked
+	}
+
+	key := newHDMasterKey(hd.Seed)
+	path := []uint32{hdPurpose + hdHardened, hdCoinType + hdHardened, hdAccount + hdHardened, chain, index}
+	for _, i := range path {
+		key = key.child(i)
+	}
+
+	curve := elliptic.P256()
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(key.key)}
+	private.Curve = curve
+	private.X, private.Y = curve.ScalarBaseMult(key.key)
+	pubKey := marshalPubKey(private.PublicKey)
+
+	return &Wallet{private, pubKey, fmt.Sprintf(hdPathFormat, hdPurpose, hdCoinType, hdAccount, chain, index)}, nil
+}
+
+// newHDMasterKey 由种子计算主扩展私钥
+func newHDMasterKey(seed []byte) *hdKey {
+	n := elliptic.P256().Params().N
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(hdMasterKey))
+		mac.Write(data)
+		I := mac.Sum(nil)
+
+		IL := new(big.Int).SetBytes(I[:hdKeyLen])
+		if IL.Sign() != 0 && IL.Cmp(n) < 0 {
+			return &hdKey{I[:hdKeyLen], I[hdKeyLen:]}
+		}
+		data = I
+	}
+}
+
+// child 派生第 i 个子私钥，i >= 0x80000000 时为强化派生
+func (k *hdKey) child(i uint32) *hdKey {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	parent := new(big.Int).SetBytes(k.key)
+
+	var data []byte
+	if i >= hdHardened {
+		data = append([]byte{0}, k.key...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.key)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+
+	for {
+		var index [4]byte
+		binary.BigEndian.PutUint32(index[:], i)
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		mac.Write(index[:])
+		I := mac.Sum(nil)
+
+		IL := new(big.Int).SetBytes(I[:hdKeyLen])
+		child := new(big.Int).Add(IL, parent)
+		child.Mod(child, n)
+		if IL.Cmp(n) < 0 && child.Sign() != 0 {
+			key := make([]byte, hdKeyLen)
+			child.FillBytes(key)
+			return &hdKey{key, I[hdKeyLen:]}
+		}
+		data = append([]byte{1}, I[hdKeyLen:]...)
+	}
+}
+
+// InitHD 给钱包集合加上 HD 种子，已有的随机钱包保留不变
+func (ws *Wallets) InitHD(mnemonic string) error {
+	if ws.HD != nil {
+		return errors.New("wallet already has a HD seed")
+	}
+	if ws.IsLocked() {
+		return errWalletLocked
+	}
+	hd, err := NewHDChain(mnemonic)
+	if err != nil {
+		return err
+	}
+	ws.HD = hd
+
+	return nil
+}
+
+// NewHDAddress 在收款链或找零链上派生下一个地址并加入钱包集合
+func (ws *Wallets) NewHDAddress(change bool) (string, error) {
+	if ws.HD == nil {
+		return "", errors.New("wallet is not a HD wallet")
+	}
+	chain := uint32(hdReceiveChain)
+	if change {
+		chain = hdChangeChain
+	}
+
+	wallet, err := ws.HD.DeriveWallet(chain, ws.HD.Next[chain])
+	if err != nil {
+		return "", err
+	}
+	ws.HD.Next[chain]++
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
+
+// RestoreHDWallets 从助记词恢复钱包：在两条链上依次派生地址，
+// 连续 gap 个地址都不在 fundedPubKeyHashes（通常取自 UTXOSet.PubKeyHashes）中时停止。
+// 已经全部花掉的地址不会出现在 UTXO 集中，如果钱包曾经跳过较多地址，需要加大 gap。
+func RestoreHDWallets(mnemonic string, fundedPubKeyHashes [][]byte, gap int) (*Wallets, error) {
+	hd, err := NewHDChain(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	if gap <= 0 {
+		gap = hdDefaultGap
+	}
+
+	funded := make(map[string]bool)
+	for _, pubKeyHash := range fundedPubKeyHashes {
+		funded[string(pubKeyHash)] = true
+	}
+
+	ws := &Wallets{Wallets: make(map[string]*Wallet), HD: hd}
+	for chain := uint32(0); chain < hdChainCount; chain++ {
+		unused := 0
+		for index := uint32(0); unused < gap; index++ {
+			wallet, err := hd.DeriveWallet(chain, index)
+			if err != nil {
+				return nil, err
+			}
+			if !funded[string(HashPubKey(wallet.PublicKey))] {
+				unused++
+				continue
+			}
+			unused = 0
+			ws.Wallets[fmt.Sprintf("%s", wallet.GetAddress())] = wallet
+			hd.Next[chain] = index + 1
+		}
+	}
+
+	return ws, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SLIP-0010 nist256p1 测试向量 1
+func TestHDKeyDerivationVector(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	master := newHDMasterKey(seed)
+	assert.Equal(t, "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", hex.EncodeToString(master.chainCode))
+	assert.Equal(t, "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2", hex.EncodeToString(master.key))
+
+	child := master.child(hdHardened)
+	assert.Equal(t, "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", hex.EncodeToString(child.chainCode))
+	assert.Equal(t, "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c", hex.EncodeToString(child.key))
+}
+
+func TestHDAddressesAreDeterministic(t *testing.T) {
+	mnemonic, err := NewMnemonic()
+	assert.Nil(t, err)
+
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	assert.Nil(t, ws.InitHD(mnemonic))
+	first := ws.CreateWallet()
+	change, err := ws.NewHDAddress(true)
+	assert.Nil(t, err)
+	assert.True(t, ValidateAddress(first))
+	assert.Equal(t, "m/44'/0'/0'/1/0", ws.Wallets[change].Path)
+
+	other := &Wallets{Wallets: make(map[string]*Wallet)}
+	assert.Nil(t, other.InitHD(mnemonic))
+	assert.Equal(t, first, other.CreateWallet(), "same mnemonic derives the same addresses")
+
+	_, err = NewHDChain("abandon abandon abandon")
+	assert.NotNil(t, err, "invalid mnemonic is rejected")
+}
+
+func TestRestoreHDWallets(t *testing.T) {
+	mnemonic, _ := NewMnemonic()
+	hd, _ := NewHDChain(mnemonic)
+
+	// 收款链第 0、3 个地址和找零链第 1 个地址有余额
+	var funded [][]byte
+	for _, p := range [][2]uint32{{hdReceiveChain, 0}, {hdReceiveChain, 3}, {hdChangeChain, 1}} {
+		wallet, err := hd.DeriveWallet(p[0], p[1])
+		assert.Nil(t, err)
+		funded = append(funded, HashPubKey(wallet.PublicKey))
+	}
+
+	restored, err := RestoreHDWallets(mnemonic, funded, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(restored.Wallets))
+	assert.Equal(t, [hdChainCount]uint32{4, 2}, restored.HD.Next, "new addresses continue after the last used index")
+
+	restored, _ = RestoreHDWallets(mnemonic, funded, 2)
+	assert.Equal(t, 2, len(restored.Wallets), "gap limit stops the scan before receive index 3")
+	assert.Equal(t, [hdChainCount]uint32{1, 2}, restored.HD.Next)
+}
@@ -8,7 +8,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 )
 
 //Vote 表示HotStuff中的投票包含投票者的地址和签名数据
@@ -22,6 +21,22 @@ type Vote struct {
 	Tx        *Transaction
 }
 
+// GobEncode 投票在网络消息中使用规范编码（见 encoding.go），gob 无法直接编码 ecdsa.PublicKey 中的 P256 曲线
+func (v Vote) GobEncode() ([]byte, error) {
+	return encodeVote(v), nil
+}
+
+// GobDecode 见 GobEncode
+func (v *Vote) GobDecode(data []byte) error {
+	decoded, err := decodeVote(data)
+	if err != nil {
+		return err
+	}
+	*v = decoded
+
+	return nil
+}
+
 // VoteCollector 用于收集投票信息
 type VoteCollector struct {
 	mu                   sync.Mutex
@@ -78,7 +93,7 @@ var IndexOfCbtx = 0
 func CreateLeaf(node *Node, command string, wallet string) *Proposal {
 	//获取当前时间
 	//currentTime := time.Now().Format(time.RFC3339Nano)
-	currentTime := time.Now().UTC().Format("2006-01-02T15:04:05.999999999")
+	currentTime := now().UTC().Format("2006-01-02T15:04:05.999999999")
 	//fmt.Println("currentTime：", currentTime)
 	// 创建提案
 	proposal := &Proposal{
@@ -142,7 +157,7 @@ func NewVoteCollector(requiredAgrees int, totalVotes int, leaderAgress int, requ
 }
 
 // AddVote 添加一个投票到收集器中
-func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, proposal Proposal, shardID int, targetShardID int) {
+func (vc *VoteCollector) AddVote(chains *ChainService, vote Vote, command string, bc *Blockchain, proposal Proposal, shardID int, targetShardID int) {
 	vc.mu.Lock()
 	defer vc.mu.Unlock()
 
@@ -193,8 +208,6 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 				fmt.Println("if !ValidateAddress(to) ")
 				//UTXOSet := UTXOSet{shardIDbc}
 
-				cbTx := NewCoinbaseTX(from, "这是系统奖励，开发新区块10元")
-
 				IndexOfCbtx++
 				//fmt.Println("-=-=-=-=-=-==-=-=IndexOfCbtx-=-=-=-=-=-==-=-=", IndexOfCbtx)
 				if vote.Tx == nil {
@@ -202,6 +215,7 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 					return
 				}
 
+				cbTx := bc.NewRewardTX(from, []*Transaction{vote.Tx})
 				sourcetxs := []*Transaction{cbTx, vote.Tx}
 
 				var newSourceBlock *Block
@@ -209,6 +223,7 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 				sourceUTXOSet := UTXOSet{bc} //发起分片
 
 				newSourceBlock = bc.commitTransaction(sourcetxs, command)
+				vc.certify(bc, NodeIPAddress, newSourceBlock)
 
 				fmt.Println("----UTXOSet.Update(newSourceBlock)")
 				sourceUTXOSet.Update(newSourceBlock)
@@ -249,12 +264,10 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 				fmt.Println("关联分片交易")
 
 				var sourceShardIDbc *Blockchain
-				var targetShardIDbc *Blockchain
 				fmt.Println("dbFile", dbFile)
 				fmt.Println("创建分片", shardID, "数据库连接", knownShardingNodes[shardID][0])
-				sourceShardIDbc = NewBlockchain(knownShardingNodes[shardID][0]) //发起分片的数据库
-				fmt.Println("创建分片", targetShardID, "数据库连接", knownShardingNodes[targetShardID][0])
-				targetShardIDbc = NewBlockchain(knownShardingNodes[targetShardID][0]) //发起分片的数据库
+				sourceShardIDbc = chains.Blockchain(knownShardingNodes[shardID][0]) //发起分片的数据库
+				defer chains.Release(sourceShardIDbc)
 				completeproposal[proposal.ID] = &proposal
 				// 在这里执行达成共识后的操作
 				substrings := strings.Split(command, " ")
@@ -266,7 +279,6 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 					from := substrings[3]
 					to := substrings[5]
 
-					amount, _ := strconv.Atoi(substrings[7])
 					if !ValidateAddress(from) {
 						log.Panic("ERROR: Sender address is not valid")
 					}
@@ -276,8 +288,6 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 					fmt.Println("if !ValidateAddress(to) ")
 					//UTXOSet := UTXOSet{shardIDbc}
 
-					cbTx := NewCoinbaseTX(from, "")
-					tarGetcbTx := NewCoinbaseTX2(to, "", amount)
 					IndexOfCbtx++
 					//fmt.Println("-=-=-=-=-=-==-=-=IndexOfCbtx-=-=-=-=-=-==-=-=", IndexOfCbtx)
 					if vote.Tx == nil {
@@ -285,30 +295,24 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 						return
 					}
 
+					cbTx := sourceShardIDbc.NewRewardTX(from, []*Transaction{vote.Tx})
 					sourcetxs := []*Transaction{cbTx, vote.Tx}
-					targGettxs := []*Transaction{tarGetcbTx}
 					//打印交易
 					//遍历cbTx.Vin
 
 					var newSourceBlock *Block
-					var newTargGetBlock *Block
 
-					sourceUTXOSet := UTXOSet{sourceShardIDbc}  //发起分片
-					targGetUTXOSet := UTXOSet{targetShardIDbc} //目标分片
+					sourceUTXOSet := UTXOSet{sourceShardIDbc} //发起分片
 					fmt.Println("----newSourceBlock = bc.commitTransaction(sourcetxs)")
 					newSourceBlock = sourceShardIDbc.commitTransaction(sourcetxs, command)
+					//目标分片的入账等扣款区块的证书收齐后，由目标分片领导者凭扣款证明上链
+					awaitCredit(knownShardingNodes[targetShardID][0], command, newSourceBlock, vote.Tx)
+					vc.certify(sourceShardIDbc, knownShardingNodes[shardID][0], newSourceBlock)
 					fmt.Printf("----Added block %x\n", newSourceBlock.Hash)
 
-					fmt.Println("----newTargGetBlock = shardIDbc.commitTransaction(targGettxs)")
-					newTargGetBlock = targetShardIDbc.commitTransaction(targGettxs, command)
-					fmt.Printf("----Added block %x\n", newTargGetBlock.Hash)
-
 					fmt.Println("----UTXOSet.Update(newSourceBlock)")
 					sourceUTXOSet.Update(newSourceBlock)
 
-					fmt.Println("----UTXOSet.Update(newTargGetBlock)")
-					targGetUTXOSet.Update(newTargGetBlock)
-
 					fmt.Println("区块链上链成功!")
 
 					fmt.Println("更新发起分片数据")
@@ -321,16 +325,6 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 						//广播区块
 						sendVersion(node, sourceShardIDbc, shardID)
 					}
-					fmt.Println("更新目标分片数据")
-					for _, node := range knownShardingNodes[targetShardID] {
-						node = strings.Replace(node, " ", ":", -1)
-						fmt.Println("给子节点更新区块：", node)
-						BlockSyncnum = 0
-						//广播区块
-						sendVersion(node, targetShardIDbc, targetShardID)
-					}
-					defer sourceShardIDbc.db.Close()
-					defer targetShardIDbc.db.Close()
 				}
 
 			} else {
@@ -360,7 +354,6 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 
 						//UTXOSet := UTXOSet{shardIDbc}
 
-						cbTx := NewCoinbaseTX(from, "")
 						//tarGetcbTx := NewCoinbaseTX2(to, "", amount)
 						IndexOfCbtx++
 						//fmt.Println("-=-=-=-=-=-==-=-=IndexOfCbtx-=-=-=-=-=-==-=-=", IndexOfCbtx)
@@ -369,6 +362,7 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 							return
 						}
 
+						cbTx := bc.NewRewardTX(from, []*Transaction{vote.Tx})
 						sourcetxs := []*Transaction{cbTx, vote.Tx}
 						//targGettxs := []*Transaction{tarGetcbTx}
 						//打印交易
@@ -381,6 +375,9 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 						//targGetUTXOSet := UTXOSet{targetShardIDbc} //目标分片
 						fmt.Println("----newSourceBlock = bc.commitTransaction(sourcetxs)")
 						newSourceBlock = bc.commitTransaction(sourcetxs, command)
+						//目标分片的入账等扣款区块的证书收齐后，由目标分片领导者凭扣款证明上链
+						awaitCredit(knownShardingNodes[targetShardID][0], command, newSourceBlock, vote.Tx)
+						vc.certify(bc, NodeIPAddress, newSourceBlock)
 						//fmt.Println("----newTargGetBlock = shardIDbc.commitTransaction(targGettxs)")
 						//newTargGetBlock = targetShardIDbc.commitTransaction(targGettxs)
 						fmt.Println("----UTXOSet.Update(newSourceBlock)")
@@ -414,65 +411,9 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 					fmt.Println("command", command)
 					switch substrings[1] {
 					case "send":
-
-						//达成共识后数据上链
-						from := substrings[3]
-						to := substrings[5]
-
-						amount, _ := strconv.Atoi(substrings[7])
-						if !ValidateAddress(from) {
-							log.Panic("ERROR: Sender address is not valid")
-						}
-						if !ValidateAddress(to) {
-							log.Panic("ERROR: Recipient address is not valid")
-						}
-
-						//UTXOSet := UTXOSet{shardIDbc}
-
-						//cbTx := NewCoinbaseTX(from, "")
-						tarGetcbTx := NewCoinbaseTX2(to, command, amount)
-						IndexOfCbtx++
-						//fmt.Println("-=-=-=-=-=-==-=-=IndexOfCbtx-=-=-=-=-=-==-=-=", IndexOfCbtx)
-						if vote.Tx == nil {
-							fmt.Println("ERROR: Received vote with nil transaction")
-							return
-						}
-
-						//sourcetxs := []*Transaction{cbTx, vote.Tx}
-						targGettxs := []*Transaction{tarGetcbTx}
-						//打印交易
-						//遍历cbTx.Vin
-
-						//var newSourceBlock *Block
-						var newTargGetBlock *Block
-
-						//sourceUTXOSet := UTXOSet{shardIDbc} //发起分片
-						targGetUTXOSet := UTXOSet{bc} //目标分片
-						//fmt.Println("----newSourceBlock = bc.commitTransaction(sourcetxs)")
-						//newSourceBlock = shardIDbc.commitTransaction(sourcetxs)
-						fmt.Println("----newTargGetBlock = shardIDbc.commitTransaction(targGettxs)")
-						newTargGetBlock = bc.commitTransaction(targGettxs, command)
-						//fmt.Println("----UTXOSet.Update(newSourceBlock)")
-						//sourceUTXOSet.Update(newSourceBlock)
-						fmt.Println("----UTXOSet.Update(newTargGetBlock)")
-						targGetUTXOSet.Update(newTargGetBlock)
-
-						fmt.Println("区块链上链成功!")
-						fmt.Println("belongToInt", belongToInt)
-						//shardIDbc.db.Close()
-						for _, node := range knownShardingNodes[belongToInt] {
-							if node == knownShardingNodes[belongToInt][0] {
-								//fmt.Println("我是领导者")
-							} else {
-
-								node = strings.Replace(node, " ", ":", -1)
-								//fmt.Println("给子节点更新区块：", node)
-								BlockSyncnum = 0
-								//广播区块
-								sendVersion(node, bc, belongToInt)
-							}
-						}
-
+						//入账不在这里上链：发起分片领导者收齐扣款区块的证书后把扣款证明发给本分片领导者，
+						//由 handleCredit 检查后上链并同步给本分片的节点
+						fmt.Println("等待发起分片的扣款证明")
 					}
 				}
 			}
@@ -534,7 +475,7 @@ func (vc *VoteCollector) AddVote(vote Vote, command string, bc *Blockchain, prop
 }
 
 // createOrUpdateVoteCollector 根据节点消息动态创建或更新 VoteCollector
-func createOrUpdateVoteCollector(vote Vote, command string, bc *Blockchain, proposal Proposal, requiredAgree int, shardID int, targetShardID int) {
+func createOrUpdateVoteCollector(chains *ChainService, vote Vote, command string, bc *Blockchain, proposal Proposal, requiredAgree int, shardID int, targetShardID int) {
 	fmt.Println("-===================-----------------------=========================")
 	fmt.Println("createOrUpdateVoteCollector-proposal.ID", proposal.ID)
 	fmt.Println("-===================-----------------------=========================")
@@ -542,7 +483,7 @@ func createOrUpdateVoteCollector(vote Vote, command string, bc *Blockchain, prop
 	if collector, ok := voteCollectors[proposal.ID]; ok {
 		// 如果已经存在，直接添加投票
 		fmt.Println("")
-		collector.AddVote(vote, command, bc, proposal, shardID, targetShardID)
+		collector.AddVote(chains, vote, command, bc, proposal, shardID, targetShardID)
 	} else {
 		// 如果不存在，创建新的 VoteCollector
 		fmt.Println("创建新的 VoteCollector")
@@ -552,7 +493,7 @@ func createOrUpdateVoteCollector(vote Vote, command string, bc *Blockchain, prop
 			voteCollectors[proposal.ID] = newCollector
 
 			fmt.Println("进行投票1")
-			newCollector.AddVote(vote, command, bc, proposal, shardID, targetShardID)
+			newCollector.AddVote(chains, vote, command, bc, proposal, shardID, targetShardID)
 		} else {
 			fmt.Println("跨分片交易")
 			result := strconv.Itoa(shardID) + "-" + strconv.Itoa(targetShardID)
@@ -568,7 +509,7 @@ func createOrUpdateVoteCollector(vote Vote, command string, bc *Blockchain, prop
 			voteCollectors[proposal.ID] = newCollector
 
 			fmt.Println("进行投票2")
-			newCollector.AddVote(vote, command, bc, proposal, shardID, targetShardID)
+			newCollector.AddVote(chains, vote, command, bc, proposal, shardID, targetShardID)
 		}
 
 	}
@@ -598,7 +539,7 @@ func preparePhase(leaderID string, curView int, node *Node, command string, wall
 		vote.Addresss = wallet
 		vote.R = r1
 		vote.S = s1
-		vote.PublicKey = publicKey
+		vote.PublicKey = unmarshalPubKey(wallets.Wallets[wallet].PublicKey)
 		vote.Tx = tx
 		QC := CreateQC(vote, *proposal)
 		targetShardID := -1
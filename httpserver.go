@@ -2,11 +2,9 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"github.com/boltdb/bolt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -17,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type RequestBodyData struct {
@@ -47,7 +46,83 @@ func handleGetRequest(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Received param: %s", paramValue)
 }
 
-func handlePostRequest(w http.ResponseWriter, r *http.Request) {
+// handleTxProof GET /tx/{id}/proof?node=IP+PORT，返回交易所在区块的区块头和交易的默克尔包含证明。
+// 不指定 node 时使用本节点的区块库
+func handleTxProof(chains *ChainService, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tx/"), "/proof")
+	if !strings.HasSuffix(r.URL.Path, "/proof") || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	txID, err := hex.DecodeString(id)
+	if err != nil || len(txID) == 0 {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	nodeID := r.URL.Query().Get("node")
+	if nodeID == "" {
+		nodeID = nodeConfig.NodeID()
+	}
+	bc := chains.Blockchain(nodeID)
+	if bc == nil {
+		http.Error(w, "No existing blockchain found for node "+nodeID, http.StatusNotFound)
+		return
+	}
+	defer chains.Release(bc)
+
+	block, proof, err := bc.TransactionProof(txID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var tx *Transaction
+	for _, candidate := range block.Transactions {
+		if bytes.Equal(candidate.ID, txID) {
+			tx = candidate
+		}
+	}
+	var siblings []string
+	for _, sibling := range proof.Siblings {
+		siblings = append(siblings, hex.EncodeToString(sibling))
+	}
+	header := block.Header()
+	responseData := map[string]interface{}{
+		"TxID":      id,
+		"Tx":        hex.EncodeToString(tx.Serialize()),
+		"BlockHash": hex.EncodeToString(block.Hash),
+		"Index":     proof.Index,
+		"Siblings":  siblings,
+		"Header": map[string]interface{}{
+			"PrevBlock":  hex.EncodeToString(header.PrevBlockHash),
+			"MerkleRoot": hex.EncodeToString(header.MerkleRoot),
+			"Timestamp":  header.Timestamp,
+			"TargetBits": header.TargetBits,
+			"Nonce":      header.Nonce,
+			"Height":     header.Height,
+			"DataHash":   hex.EncodeToString(header.DataHash),
+		},
+		"HeaderBytes": hex.EncodeToString(header.Bytes()),
+	}
+
+	jsonData, err := json.Marshal(responseData)
+	if err != nil {
+		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+func handlePostRequest(chains *ChainService, w http.ResponseWriter, r *http.Request) {
 	//w.Header().Set("Access-Control-Allow-Origin", "http://192.168.254.129") // 替换为你的前端应用的域名或 IP 地址
 	w.Header().Set("Access-Control-Allow-Origin", "*") //允许任何源访问，解决跨域问题
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
@@ -145,6 +220,9 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unsupported Content-Type", http.StatusBadRequest)
 		return
 	}
+	if requestBodyData.Port == "" {
+		requestBodyData.Port = strconv.Itoa(chainParams.Port)
+	}
 	NodeIP = requestBodyData.IP + ":" + requestBodyData.Port
 	NodeIPAddress = requestBodyData.IP + " " + requestBodyData.Port
 	fmt.Println("Command:", requestBodyData.Command)
@@ -188,7 +266,8 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 			log.Panic("ERROR: Address is not valid")
 		}
 
-		bc := NewBlockchain(nodeID)
+		bc := chains.Blockchain(nodeID)
+		defer chains.Release(bc)
 		UTXOSet := UTXOSet{bc}
 
 		//defer bc.db.Close()
@@ -205,17 +284,28 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 
 		fmt.Printf("Balance of '%s': %d\n", address, balance)
 		fmt.Fprintf(w, "Balance of '%s': %d\n", address, balance)
-		bc.db.Close()
 	case "createblockchain":
 		fmt.Println("createblockchain")
-		fmt.Println(substrings[1])
-		address := substrings[1]
+		// 创世配置有初始分配时可以不给地址：createblockchain -treasury ...
+		address := ""
+		args := substrings[1:]
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			address, args = args[0], args[1:]
+		}
+		fmt.Println(address)
 		nodeID := requestBodyData.IP + " " + requestBodyData.Port
-		if !ValidateAddress(address) {
+		if len(chainParams.Allocations) == 0 && !ValidateAddress(address) {
 			log.Panic("ERROR: Address is not valid")
 		}
-		bc := CreateBlockchain(address, nodeID)
-		defer bc.db.Close()
+		flags := commandFlags(args)
+		threshold, _ := strconv.Atoi(flags["threshold"])
+		params, err := chainParams.WithTreasury(flags["treasury"], threshold)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bc := CreateBlockchain(address, nodeID, params)
+		defer chains.Release(bc)
 
 		UTXOSet := UTXOSet{bc}
 		UTXOSet.Reindex()
@@ -225,10 +315,19 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("createsubblockchain")
 		nodeID := requestBodyData.IP + " " + requestBodyData.Port
 
-		dbFile := fmt.Sprintf(dbFile, nodeID)
-		if !dbExists(dbFile) {
+		dbFile := nodeDataFile(dbFile, nodeID)
+		if !dbExists(dbFile) && len(chainParams.Allocations) > 0 {
+			// 创世配置有初始分配时，每个分片都能独立生成同样的创世区块
+			bc := CreateBlockchain("", nodeID, chainParams)
+			UTXOSet := UTXOSet{bc}
+			UTXOSet.Reindex()
+			chains.Release(bc)
+
+			fmt.Println("Blockchain created from the genesis configuration.")
+			fmt.Fprintf(w, "Blockchain created from the genesis configuration.")
+		} else if !dbExists(dbFile) {
 			fmt.Println("Blockchain not exists.")
-			genesisdbFile := "blockchain_genesis.db"
+			genesisdbFile := chainParams.dataPath("blockchain_genesis.db")
 			if !dbExists(genesisdbFile) {
 				fmt.Println("blockchain_genesis.db not exists.")
 				os.Exit(1)
@@ -274,6 +373,10 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 			walletstr = substrings[1]
 		}
 		wallets, _ := NewWallets(walletstr)
+		if wallets.IsLocked() {
+			http.Error(w, "Wallet is locked, run walletunlock first", http.StatusForbidden)
+			return
+		}
 		address := wallets.CreateWallet()
 		wallets.SaveToFile(walletstr)
 
@@ -304,6 +407,8 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 
 		singleData := map[string]interface{}{
 			"walletaddress": addresses,
+			"watchonly":     wallets.GetWatchOnlyAddresses(),
+			"labels":        wallets.Labels,
 		}
 		//打印输出singleData
 		fmt.Println("singleData", singleData)
@@ -316,10 +421,51 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write(jsonData)
 
+	case "getchainparams":
+		fmt.Println("getchainparams")
+		jsonData, err := json.Marshal(chainParams)
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+	case "auditsupply":
+		fmt.Println("auditsupply")
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
+		UTXOSet := UTXOSet{bc}
+		report, err := UTXOSet.AuditSupply()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		singleData := map[string]interface{}{
+			"height":     report.Height,
+			"utxototal":  report.UTXOTotal,
+			"expected":   report.Expected(),
+			"genesis":    report.Genesis,
+			"issued":     report.Issued(),
+			"scheduled":  report.Scheduled,
+			"crossshard": report.CrossShard,
+			"mints":      report.Mints,
+			"fees":       report.Fees,
+			"maxsupply":  MaxSupply(),
+			"ok":         report.OK(),
+		}
+		jsonData, err := json.Marshal(singleData)
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+
 	case "printchain":
 		fmt.Println("printchain")
-		bc := NewBlockchain(requestBodyData.IP + " " + requestBodyData.Port)
-		defer bc.db.Close()
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
 
 		bci := bc.Iterator()
 		fmt.Println("bci:", bci)
@@ -327,6 +473,9 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 
 		for {
 			block := bci.Next()
+			if block == nil {
+				break
+			}
 
 			blockInfo := map[string]interface{}{
 				"Hash":      hex.EncodeToString(block.Hash),
@@ -383,28 +532,67 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		if !ValidateAddress(to) {
 			log.Panic("ERROR: Recipient address is not valid")
 		}
-		bc := NewBlockchain(nodeID)
-		UTXOSet := UTXOSet{bc}
-		fmt.Println("UTXOSet", UTXOSet)
-		defer bc.db.Close()
+		opts, err := sendOptionsFromFlags(commandFlags(substrings[1:]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !writeSendResponse(chains, w, nodeID, from, []Recipient{{to, amount}}, mineNow, opts) {
+			return
+		}
 
-		wallets, err := NewWallets(nodeID)
+		fmt.Println("Success!")
+	case "generate":
+		// generate N -address ADDRESS，只能在回归测试网上使用
+		fmt.Println("generate")
+		n, err := strconv.Atoi(substrings[1])
 		if err != nil {
-			log.Panic(err)
+			http.Error(w, "Number of blocks is not valid", http.StatusBadRequest)
+			return
 		}
-		fmt.Println("wallets", wallets)
-		wallet := wallets.GetWallet(from)
-		fmt.Println("wallet", wallet)
-		tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
-		fmt.Println("tx", tx)
-		if mineNow {
-			cbTx := NewCoinbaseTX(from, "")
-			txs := []*Transaction{cbTx, tx}
-
-			newBlock := bc.MineBlock(txs)
-			UTXOSet.Update(newBlock)
-		} else {
-			sendTx(knownShardingNodes[0][0], tx)
+		flags := commandFlags(substrings[2:])
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
+		hashes, err := bc.Generate(n, flags["address"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var blocks []string
+		for _, hash := range hashes {
+			blocks = append(blocks, hex.EncodeToString(hash))
+		}
+		jsonData, err := json.Marshal(map[string]interface{}{"blocks": blocks})
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+	case "sendmany":
+		// 收款人放在 Data 中，每行 ADDRESS,AMOUNT
+		fmt.Println("sendmany")
+		flags := commandFlags(substrings[1:])
+		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		from := flags["from"]
+		_, mineNow := flags["mine"]
+		if !ValidateAddress(from) {
+			http.Error(w, "Sender address is not valid", http.StatusBadRequest)
+			return
+		}
+		recipients, err := ReadRecipientsCSV(strings.NewReader(requestBodyData.Data))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := sendOptionsFromFlags(flags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !writeSendResponse(chains, w, nodeID, from, recipients, mineNow, opts) {
+			return
 		}
 
 		fmt.Println("Success!")
@@ -418,25 +606,34 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 
 		fmt.Println("startnode-IP", requestBodyData.IP+" "+requestBodyData.Port)
 		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		// 领导和普通节点必须在创世配置的验证者集合中，分片个数不能超过配置的上限
+		if (substrings[1] == "-leader" || substrings[1] == "-normal") && !chainParams.IsValidator(nodeID) {
+			http.Error(w, nodeID+" is not in the validator set of network "+chainParams.Network, http.StatusForbidden)
+			return
+		}
+		if substrings[1] == "-leader" && chainParams.ShardCount > 0 && len(knownShardingNodes) >= chainParams.ShardCount {
+			http.Error(w, fmt.Sprintf("network %s allows at most %d shards", chainParams.Network, chainParams.ShardCount), http.StatusBadRequest)
+			return
+		}
 		binary := "./blockchain_go.exe"
 		//binary := "./go_build_blockchain_go.exe"
+		// 新节点与本进程使用同样的数据目录和网络，监听请求中的地址
+		nodeArgs := nodeConfig.nodeArgs(requestBodyData.IP + ":" + requestBodyData.Port)
 		script := fmt.Sprintf(`$BINARY = "%s"
 						function startNode {
 							 Write-Host "====>startNode"
-						  & $BINARY startnode
+						  & $BINARY %s startnode
 						}
-					Set-Item -Path "env:NODE_ID" -Value "%s"
-					startNode`, binary, nodeID)
+					startNode`, binary, nodeArgs)
 		if substrings[1] == "-miner" {
 			Isleader = 2
 
 			script = fmt.Sprintf(`$BINARY = "%s"
 						function startNode {
 							 Write-Host "====>startNode"
-						  & $BINARY startnode -miner "%s"
+						  & $BINARY %s startnode -miner "%s"
 						}
-					Set-Item -Path "env:NODE_ID" -Value "%s"
-					startNode`, binary, substrings[2], nodeID)
+					startNode`, binary, nodeArgs, substrings[2])
 		}
 		if substrings[1] == "-leader" {
 			fmt.Println("startnode leader")
@@ -543,27 +740,131 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		//fmt.Println(knownShardingNodes[1][0])
 		//fmt.Println(knownShardingNodes[1][1])
 		//输出knownNodes
-	case "verifySignature":
-		fmt.Println("verifySignature")
-		address := substrings[2]
-		message := substrings[4]
+	case "importaddress":
+		fmt.Println("importaddress")
 		nodeID := requestBodyData.IP + " " + requestBodyData.Port
-		fmt.Println(address)
-		fmt.Println(message)
-		//message string 转[]byte
-		messageByte := []byte(message)
+		flags := commandFlags(substrings[1:])
 		wallets, err := NewWallets(nodeID)
 		if err != nil {
 			log.Panic(err)
 		}
-		//创建一个*big.Int类型变量
-		var r1, s1 *big.Int
-		address, messageByte, r1, s1, _ = wallets.Sign(address, messageByte)
-		if wallets.Verify(address, message, r1, s1) {
-			fmt.Println("签名验证成功")
+		address := flags["address"]
+		if flags["pubkey"] != "" {
+			pubKey, err := hex.DecodeString(flags["pubkey"])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			address, err = wallets.ImportPubKey(pubKey, flags["label"])
 		} else {
-			fmt.Println("签名验证失败")
+			err = wallets.ImportAddress(address, flags["label"])
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wallets.SaveToFile(nodeID)
+		writeAddressResponse(w, wallets, address)
+	case "importprivkey":
+		// 私钥放在 Data 中
+		fmt.Println("importprivkey")
+		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		wallets, err := NewWallets(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		address, err := wallets.ImportPrivateKey(strings.TrimSpace(requestBodyData.Data), commandFlags(substrings[1:])["label"])
+		if err == errWalletLocked {
+			http.Error(w, "Wallet is locked, run walletunlock first", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wallets.SaveToFile(nodeID)
+		writeAddressResponse(w, wallets, address)
+	case "dumpprivkey":
+		fmt.Println("dumpprivkey")
+		wallets, err := NewWallets(requestBodyData.IP + " " + requestBodyData.Port)
+		if err != nil {
+			log.Panic(err)
+		}
+		key, err := wallets.ExportPrivateKey(commandFlags(substrings[1:])["address"])
+		if err == errWalletLocked {
+			http.Error(w, "Wallet is locked, run walletunlock first", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"privatekey": key,
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.Write(jsonData)
+	case "setlabel":
+		// 标签放在 Data 中，可以包含空格
+		fmt.Println("setlabel")
+		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		address := commandFlags(substrings[1:])["address"]
+		wallets, err := NewWallets(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		if err := wallets.SetLabel(address, requestBodyData.Data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		wallets.SaveToFile(nodeID)
+		writeAddressResponse(w, wallets, address)
+	case "signmessage":
+		// 消息放在 Data 中，可以包含空格
+		fmt.Println("signmessage")
+		address := commandFlags(substrings[1:])["address"]
+		wallets, err := NewWallets(requestBodyData.IP + " " + requestBodyData.Port)
+		if err != nil {
+			log.Panic(err)
+		}
+		signature, err := wallets.SignMessage(address, requestBodyData.Data)
+		if err == errWalletLocked {
+			http.Error(w, "Wallet is locked, run walletunlock first", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"address":   address,
+			"signature": signature,
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.Write(jsonData)
+	case "verifymessage":
+		// 不需要钱包文件，消息放在 Data 中
+		fmt.Println("verifymessage")
+		flags := commandFlags(substrings[1:])
+		valid, err := VerifyMessage(flags["address"], flags["signature"], requestBodyData.Data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"valid": valid,
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.Write(jsonData)
 	case "createLeaf":
 		fmt.Println("createLeaf")
 		fmt.Println("Command:", requestBodyData.Command)
@@ -638,29 +939,20 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		//}
 
 	case "UTXOSet":
-		bc := NewBlockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
 		UTXOSet := UTXOSet{bc}
 		db := UTXOSet.Blockchain.db
-		err := db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(utxoBucket))
+		err := db.Update(func(tx StoreTx) error {
+			b := tx.Bucket(utxoBucket)
 			//打印b中的数据
 			err := b.ForEach(func(k, v []byte) error {
 
 				value := b.Get(k)
 				//fmt.Printf("Key: %s, Value: %s\n", hex.EncodeToString(k), hex.EncodeToString(value))
-				fmt.Printf("Key: %s, Value: %s\n", hex.EncodeToString(k))
+				fmt.Printf("Key: %s\n", hex.EncodeToString(k))
 				//fmt.Printf(" %s\n", hex.EncodeToString(k))
-				var outputs TXOutputs
-
-				if len(value) == 0 {
-					log.Panic("DeserializeOutputs: Empty data")
-				}
-
-				dec := gob.NewDecoder(bytes.NewReader(value))
-				err := dec.Decode(&outputs)
-				if err != nil {
-					log.Panic("err := dec.Decode(&outputs)", err)
-				}
+				outputs := DeserializeOutputs(value)
 				fmt.Println("outputs", outputs)
 				//遍历打印outputs
 				for _, output := range outputs.Outputs {
@@ -669,7 +961,6 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 				}
 				return nil
 			})
-			return nil
 			if err != nil {
 				log.Panic(err)
 			}
@@ -678,9 +969,9 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Panic(err)
 		}
-		bc.db.Close()
 	case "vin.Txid":
-		bc := NewBlockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
 		UTXOSet := UTXOSet{bc}
 		db := UTXOSet.Blockchain.db
 		//[]byte类型变量转string
@@ -703,22 +994,12 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		//TXid := []byte(strData)
-		err = db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(utxoBucket))
+		err = db.Update(func(tx StoreTx) error {
+			b := tx.Bucket(utxoBucket)
 			outsBytes := b.Get(byteData)
 			//fmt.Printf("UTXOSet.Update - vin.Txid: %s, outsBytes: %v\n", hex.EncodeToString(Base58Decode(byteData)), hex.EncodeToString(Base58Decode(outsBytes)))
 			fmt.Printf("UTXOSet.Update - vin.Txid: %s, outsBytes: %v\n", hex.EncodeToString(byteData), hex.EncodeToString(outsBytes))
-			var outputs TXOutputs
-
-			if len(outsBytes) == 0 {
-				log.Panic("DeserializeOutputs: Empty data")
-			}
-
-			dec := gob.NewDecoder(bytes.NewReader(outsBytes))
-			err := dec.Decode(&outputs)
-			if err != nil {
-				log.Panic("err := dec.Decode(&outputs)", err)
-			}
+			outputs := DeserializeOutputs(outsBytes)
 			fmt.Println("outputs", outputs)
 			//遍历打印outputs
 			for _, output := range outputs.Outputs {
@@ -730,8 +1011,6 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Panic(err)
 		}
-		//关闭数据库
-		bc.db.Close()
 	case "initKnownShardingNodes":
 		fmt.Println("initKnownShardingNodes")
 		knownShardingNodes = requestBodyData.KnownShardingNodes
@@ -751,78 +1030,103 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		w.Write(jsonData)
 		//knownShardingNodes = append(knownShardingNodes, "
 
-	case "DistributeRewards":
-		fmt.Println("DistributeRewards")
-		bc := NewBlockchain(requestBodyData.IP + " " + requestBodyData.Port)
-		fmt.Println("1")
-		address := substrings[2]
-		amount, err := strconv.Atoi(substrings[3])
-		//nodeID := requestBodyData.IP + " " + requestBodyData.Port
-		var newSourceBlock *Block
+	case "createmint":
+		fmt.Println("createmint")
+		flags := commandFlags(substrings[1:])
+		amount, err := strconv.Atoi(flags["amount"])
+		if err != nil {
+			http.Error(w, "Invalid amount", http.StatusBadRequest)
+			return
+		}
+		tx, err := NewMintTX([]Recipient{{flags["to"], amount}}, requestBodyData.Data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
+		treasury, err := bc.Treasury()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeMintResponse(w, treasury, tx)
+	case "signmint":
+		fmt.Println("signmint")
+		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		tx, err := DecodeMint(requestBodyData.Data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bc := chains.Blockchain(nodeID)
+		defer chains.Release(bc)
+		treasury, err := bc.Treasury()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wallets, err := NewWallets(nodeID)
 		if err != nil {
 			log.Panic(err)
 		}
-		if !ValidateAddress(address) {
-			fmt.Println("ERROR: Address is not valid")
-		} else {
-			cbtx := NewCoinbaseTX2(address, requestBodyData.Command, amount)
-
-			fmt.Println("2")
-			sourcetxs := []*Transaction{cbtx}
-
-			sourceUTXOSet := UTXOSet{bc} //发起分片
+		if wallets.IsLocked() {
+			http.Error(w, "Wallet is locked, run walletunlock first", http.StatusForbidden)
+			return
+		}
+		if _, err := treasury.SignMintWithWallets(tx, wallets, commandFlags(substrings[1:])["address"]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeMintResponse(w, treasury, tx)
+	case "mint":
+		fmt.Println("mint")
+		tx, err := DecodeMint(requestBodyData.Data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
+		newBlock, err := bc.CommitMint(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Printf("----Added block %x\n", newBlock.Hash)
 
-			newSourceBlock = bc.commitTransaction(sourcetxs, requestBodyData.Data)
-			fmt.Println("3")
-			sourceUTXOSet.Update(newSourceBlock)
-			fmt.Println("4")
-			fmt.Printf("----Added block %x\n", newSourceBlock.Hash)
-			fmt.Printf("----Added block %x\n", string(newSourceBlock.Hash))
-			fmt.Printf("----Added block %x\n", hex.EncodeToString(newSourceBlock.Hash))
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"Hash": hex.EncodeToString(newBlock.Hash),
+			"txid": hex.EncodeToString(tx.ID),
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+	case "listmints":
+		fmt.Println("listmints")
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
+		mints, err := bc.ListMints()
+		treasury, _ := bc.Treasury()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		fmt.Println("区块链上链成功!")
-		//返回json数据
+
 		singleData := map[string]interface{}{
-			"Hash": hex.EncodeToString(newSourceBlock.Hash),
+			"treasury": treasury,
+			"mints":    mints,
 		}
-		// 将单条 JSON 数据编码为 JSON 格式的字节数组
 		jsonData, err := json.Marshal(singleData)
 		if err != nil {
 			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
-		//打印json数据
-
 		w.Write(jsonData)
-		//关闭数据库
-		fmt.Println("5")
-		bc.db.Close()
-		//var shardID int
-		////遍历knownShardingNodes
-		//for index, knownShardingNode := range knownShardingNodes {
-		//	//遍历knownShardingNode
-		//	for _, node := range knownShardingNode {
-		//		//查找nodeID
-		//		if node == nodeID {
-		//			shardID = index
-		//		}
-		//	}
-		//}
-		//
-		//
-		//for _, node := range knownShardingNodes[shardID] {
-		//	if node == knownShardingNodes[shardID][0] {
-		//		//fmt.Println("我是领导者")
-		//	} else {
-		//
-		//		node = strings.Replace(node, " ", ":", -1)
-		//		//fmt.Println("给子节点更新区块：", node)
-		//		BlockSyncnum = 0
-		//		//广播区块
-		//		sendVersion(node, bc, shardID)
-		//	}
-		//}
 
 	case "StatisticalBalance":
 		fmt.Println("-=-=-=StatisticalBalance-=-=-=-")
@@ -832,8 +1136,8 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		varChangeNotify <- requestFlag // 通知变量改变
 	case "getBlock":
 		fmt.Println("getBlock")
-		bc := NewBlockchain(requestBodyData.IP + " " + requestBodyData.Port)
-		defer bc.db.Close()
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
 
 		bci := bc.Iterator()
 		fmt.Println("bci:", bci)
@@ -841,6 +1145,9 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 
 		for {
 			block := bci.Next()
+			if block == nil {
+				break
+			}
 
 			if len(block.PrevBlockHash) == 0 || hex.EncodeToString(block.Hash) == substrings[1] {
 				blockInfo = map[string]interface{}{
@@ -879,6 +1186,207 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 
 		w.WriteHeader(http.StatusOK)
 		w.Write(jsonData)
+	case "createpsbt":
+		fmt.Println("createpsbt")
+		flags := commandFlags(substrings[1:])
+		amount, err := strconv.Atoi(flags["amount"])
+		if err != nil {
+			http.Error(w, "Invalid amount", http.StatusBadRequest)
+			return
+		}
+		bc := chains.Blockchain(requestBodyData.IP + " " + requestBodyData.Port)
+		defer chains.Release(bc)
+		UTXOSet := UTXOSet{bc}
+		psbt, err := NewPSBT(flags["from"], flags["to"], amount, &UTXOSet)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writePSBTResponse(w, psbt)
+	case "signpsbt":
+		fmt.Println("signpsbt")
+		flags := commandFlags(substrings[1:])
+		sighash := flags["sighash"]
+		if sighash == "" {
+			sighash = SigHashAll.String()
+		}
+		hashType, err := ParseSigHashType(sighash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		psbt, err := DecodePSBT(requestBodyData.Data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wallets, err := NewWallets(requestBodyData.IP + " " + requestBodyData.Port)
+		if err != nil {
+			log.Panic(err)
+		}
+		if wallets.IsLocked() {
+			http.Error(w, "Wallet is locked, run walletunlock first", http.StatusForbidden)
+			return
+		}
+		addresses := wallets.GetAddresses()
+		if flags["address"] != "" {
+			addresses = []string{flags["address"]}
+		}
+		for _, address := range addresses {
+			wallet := wallets.GetWallet(address)
+			if _, err := psbt.Sign(&wallet, hashType); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writePSBTResponse(w, psbt)
+	case "combinepsbt":
+		fmt.Println("combinepsbt")
+		var psbt *PSBT
+		for _, data := range strings.Split(requestBodyData.Data, ",") {
+			other, err := DecodePSBT(data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if psbt == nil {
+				psbt = other
+			} else if err := psbt.Combine(other); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writePSBTResponse(w, psbt)
+	case "finalizepsbt":
+		fmt.Println("finalizepsbt")
+		psbt, err := DecodePSBT(requestBodyData.Data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tx, err := psbt.Finalize()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, broadcast := commandFlags(substrings[1:])["broadcast"]
+		if broadcast {
+			if len(knownShardingNodes) == 0 || len(knownShardingNodes[0]) == 0 {
+				http.Error(w, "knownShardingNodes is empty 分片领导者节点为空", http.StatusInternalServerError)
+				return
+			}
+			sendTx(strings.Replace(knownShardingNodes[0][0], " ", ":", -1), tx)
+		}
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"txid":        hex.EncodeToString(tx.ID),
+			"tx":          hex.EncodeToString(tx.Serialize()),
+			"broadcasted": broadcast,
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.Write(jsonData)
+	case "createhdwallet":
+		fmt.Println("createhdwallet")
+		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		wallets, _ := NewWallets(nodeID)
+		mnemonic, err := NewMnemonic()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := wallets.InitHD(mnemonic); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		address := wallets.CreateWallet()
+		wallets.SaveToFile(nodeID)
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"mnemonic":      mnemonic,
+			"walletaddress": address,
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.Write(jsonData)
+	case "restorewallet":
+		fmt.Println("restorewallet")
+		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		gap, _ := strconv.Atoi(commandFlags(substrings[1:])["gap"])
+		bc := chains.Blockchain(nodeID)
+		defer chains.Release(bc)
+		UTXOSet := UTXOSet{bc}
+		pubKeyHashes := UTXOSet.PubKeyHashes()
+		wallets, err := RestoreHDWallets(requestBodyData.Data, pubKeyHashes, gap)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wallets.SaveToFile(nodeID)
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"walletaddress": wallets.GetAddresses(),
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+		w.Write(jsonData)
+	case "encryptwallet":
+		fmt.Println("encryptwallet")
+		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		wallets, err := NewWallets(nodeID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := wallets.Encrypt(requestBodyData.Data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wallets.SaveToFile(nodeID)
+		fmt.Fprintf(w, "Wallet encrypted.")
+	case "walletunlock":
+		fmt.Println("walletunlock")
+		timeout, err := strconv.Atoi(commandFlags(substrings[1:])["timeout"])
+		if err != nil || timeout <= 0 {
+			http.Error(w, "walletunlock -timeout SECONDS", http.StatusBadRequest)
+			return
+		}
+		err = UnlockWallets(requestBodyData.IP+" "+requestBodyData.Port, requestBodyData.Data, time.Duration(timeout)*time.Second)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, "Wallet unlocked for %d seconds.", timeout)
+	case "walletlock":
+		fmt.Println("walletlock")
+		LockWallets(requestBodyData.IP + " " + requestBodyData.Port)
+		fmt.Fprintf(w, "Wallet locked.")
+	case "walletpassphrasechange":
+		fmt.Println("walletpassphrasechange")
+		var passphrases struct {
+			Old string `json:"old"`
+			New string `json:"new"`
+		}
+		if err := json.Unmarshal([]byte(requestBodyData.Data), &passphrases); err != nil {
+			http.Error(w, `data must be {"old":"...","new":"..."}`, http.StatusBadRequest)
+			return
+		}
+		nodeID := requestBodyData.IP + " " + requestBodyData.Port
+		wallets, err := NewWallets(nodeID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := wallets.ChangePassphrase(passphrases.Old, passphrases.New); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		wallets.SaveToFile(nodeID)
+		LockWallets(nodeID)
+		fmt.Fprintf(w, "Wallet passphrase changed.")
 	default:
 		fmt.Println("default")
 		fmt.Fprintf(w, "Received param (default): %s,", substrings[0])
@@ -892,6 +1400,158 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
 		fmt.Fprintf(w, "startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
 		fmt.Fprintf(w, "testsend -data ADDRESS - Send test data to ADDRESS")
+		fmt.Fprintf(w, "createhdwallet - Generate a mnemonic and turn the wallet file into a HD wallet")
+		fmt.Fprintf(w, "restorewallet -gap N - Restore a HD wallet from the mnemonic in data by rescanning the UTXO set")
+		fmt.Fprintf(w, "encryptwallet - Encrypt the wallet file with the passphrase in data")
+		fmt.Fprintf(w, "walletunlock -timeout SECONDS - Unlock the wallet with the passphrase in data")
+		fmt.Fprintf(w, "walletlock - Lock the wallet")
+		fmt.Fprintf(w, "walletpassphrasechange - Change the wallet passphrase, data is {\"old\":\"...\",\"new\":\"...\"}")
+		fmt.Fprintf(w, "createpsbt -from FROM -to TO -amount AMOUNT - Create an unsigned transaction, returned as base64")
+		fmt.Fprintf(w, "signpsbt -address ADDRESS -sighash ALL - Sign the PSBT in data with the node's wallets")
+		fmt.Fprintf(w, "combinepsbt - Combine the comma separated PSBTs in data")
+		fmt.Fprintf(w, "finalizepsbt -broadcast - Finalize the PSBT in data, send it to the network when -broadcast is set")
+		fmt.Fprintf(w, "generate N -address ADDRESS - Mine N blocks at once and send their rewards to ADDRESS (regtest only)")
 
 	}
 }
+
+// commandFlags 把 "-from A -to B -mine" 这样的参数解析为 map，没有值的开关映射为空字符串
+func commandFlags(args []string) map[string]string {
+	flags := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		name := strings.TrimPrefix(args[i], "-")
+		if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			flags[name] = args[i+1]
+			i++
+		} else {
+			flags[name] = ""
+		}
+	}
+
+	return flags
+}
+
+// sendOptionsFromFlags 从 send 命令的 -strategy、-fee、-dust、-reusechange、-dryrun 参数得到转账设置
+func sendOptionsFromFlags(flags map[string]string) (SendOptions, error) {
+	opts := DefaultSendOptions()
+	if strategy, ok := flags["strategy"]; ok {
+		opts.Strategy = strategy
+	}
+	for name, value := range map[string]*int{"fee": &opts.Fee, "dust": &opts.Dust} {
+		if flags[name] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(flags[name])
+		if err != nil {
+			return opts, fmt.Errorf("invalid -%s: %v", name, err)
+		}
+		*value = n
+	}
+	_, opts.ReuseChange = flags["reusechange"]
+	_, opts.DryRun = flags["dryrun"]
+
+	return opts, nil
+}
+
+// writeSendResponse 创建并签名付款交易，立即挖矿或发送给分片领导节点；
+// DryRun 时以 JSON 返回交易计划。出错时写入错误响应并返回 false
+func writeSendResponse(chains *ChainService, w http.ResponseWriter, nodeID, from string, recipients []Recipient, mineNow bool, opts SendOptions) bool {
+	if err := validateRecipients(recipients); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	bc := chains.Blockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer chains.Release(bc)
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if opts.DryRun {
+		plan, err := PlanSend(wallets, from, recipients, &UTXOSet, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		jsonData, err := json.Marshal(plan)
+		if err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return false
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+		return true
+	}
+	if wallets.IsLocked() {
+		http.Error(w, "Wallet is locked, run walletunlock first", http.StatusForbidden)
+		return false
+	}
+	tx := NewBatchTransaction(wallets, from, recipients, &UTXOSet, opts)
+	if tx == nil {
+		http.Error(w, "Transaction was not created, not enough funds", http.StatusBadRequest)
+		return false
+	}
+	wallets.SaveToFile(nodeID)
+	if mineNow {
+		cbTx := bc.NewRewardTX(from, []*Transaction{tx})
+		txs := []*Transaction{cbTx, tx}
+
+		newBlock := bc.MineBlock(txs)
+		UTXOSet.Update(newBlock)
+	} else {
+		sendTx(knownShardingNodes[0][0], tx)
+	}
+
+	return true
+}
+
+// writeAddressResponse 以 JSON 返回地址及其标签
+func writeAddressResponse(w http.ResponseWriter, wallets *Wallets, address string) {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"address": address,
+		"label":   wallets.GetLabel(address),
+	})
+	if err != nil {
+		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+// writePSBTResponse 以 JSON 返回部分签名交易及其是否已全部签名
+func writePSBTResponse(w http.ResponseWriter, psbt *PSBT) {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"txid":     hex.EncodeToString(psbt.Tx.ID),
+		"psbt":     psbt.Base64(),
+		"complete": psbt.IsComplete(),
+	})
+	if err != nil {
+		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+// writeMintResponse 返回铸币交易的 base64 文本、已签名的国库地址以及签名是否足够
+func writeMintResponse(w http.ResponseWriter, treasury *Treasury, tx *Transaction) {
+	signers, err := treasury.MintSigners(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"txid":     hex.EncodeToString(tx.ID),
+		"mint":     EncodeMint(tx),
+		"signers":  signers,
+		"complete": len(signers) >= treasury.Threshold,
+	})
+	if err != nil {
+		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
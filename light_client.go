@@ -0,0 +1,939 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const lightDBFile = "light_%s.db"
+
+const (
+	lightHeadersBucket = "headers"       // 高度（8 字节大端序）-> 区块头编码
+	lightHashesBucket  = "headerHashes"  // 区块哈希 -> 高度
+	lightTxBucket      = "transactions"  // 交易 ID -> 经过证明的交易
+	lightFilterBucket  = "filterHeaders" // 高度 -> 区块过滤器头
+	lightMetaBucket    = "meta"
+	lightForkBucket    = "forkHeaders" // 区块哈希 -> 不在主链上的区块头编码，只用于工作量证明网络
+)
+
+// lightSyncInterval 轻节点向全节点请求新区块头的间隔
+const lightSyncInterval = 10 * time.Second
+
+// LightClient 轻节点（SPV）的本地数据：只保存区块头，以及涉及钱包地址、经过默克尔证明验证的交易。
+// 第一个区块头必须是配置确定的创世区块（见 ChainParams.configuredGenesis），之后每个区块头必须接在已保存的区块头之后，
+// 工作量证明网络上满足工作量证明，hotstuff 网络上带有足够的验证者签名（见 BlockQC）。
+// 工作量证明网络上分叉的区块头也保存，累计工作量更大的分支成为主链；hotstuff 网络的区块头有证书即为最终，冲突的区块头被拒绝
+type LightClient struct {
+	db *bolt.DB
+}
+
+// lightTx 经过证明的交易及其所在区块的高度
+type lightTx struct {
+	Height int
+	Reward bool
+	Tx     Transaction
+}
+
+// OpenLightClient 打开节点的轻节点库，不存在时创建。库被其他进程占用时最多等待几秒
+func OpenLightClient(nodeID string) (*LightClient, error) {
+	db, err := bolt.Open(nodeDataFile(lightDBFile, nodeID), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{lightHeadersBucket, lightHashesBucket, lightTxBucket, lightFilterBucket, lightMetaBucket, lightForkBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &LightClient{db}, nil
+}
+
+// Close 关闭轻节点库
+func (lc *LightClient) Close() {
+	lc.db.Close()
+}
+
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
+
+// Tip 最新的区块头，还没有区块头时返回 nil
+func (lc *LightClient) Tip() (*BlockHeader, error) {
+	var data []byte
+	err := lc.db.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket([]byte(lightHeadersBucket)).Cursor().Last()
+		data = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	return decodeHeader(data)
+}
+
+// Height 最新区块头的高度，还没有区块头时为 -1
+func (lc *LightClient) Height() int {
+	tip, err := lc.Tip()
+	if err != nil {
+		log.Panic(err)
+	}
+	if tip == nil {
+		return -1
+	}
+
+	return tip.Height
+}
+
+// Header 按区块哈希查找区块头，不存在时返回 nil
+func (lc *LightClient) Header(hash []byte) (*BlockHeader, error) {
+	var data []byte
+	err := lc.db.View(func(tx *bolt.Tx) error {
+		height := tx.Bucket([]byte(lightHashesBucket)).Get(hash)
+		if height != nil {
+			data = append([]byte(nil), tx.Bucket([]byte(lightHeadersBucket)).Get(height)...)
+		}
+		return nil
+	})
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	return decodeHeader(data)
+}
+
+// knownHeader 主链或分叉上已经保存的区块头，没有时返回 nil
+func knownHeader(tx *bolt.Tx, hash []byte) (*BlockHeader, error) {
+	data := tx.Bucket([]byte(lightForkBucket)).Get(hash)
+	if height := tx.Bucket([]byte(lightHashesBucket)).Get(hash); height != nil {
+		data = tx.Bucket([]byte(lightHeadersBucket)).Get(height)
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	return decodeHeader(data)
+}
+
+// AddHeaders 验证并保存按高度升序排列的区块头，qcs 与 headers 一一对应（可以为 nil）。
+// 已经保存过的区块头跳过；遇到无效的区块头时返回错误，之前的区块头仍然保存
+func (lc *LightClient) AddHeaders(headers []*BlockHeader, qcs []*BlockQC) error {
+	consensus, err := chainParams.consensusType()
+	if err != nil {
+		return err
+	}
+
+	for i, header := range headers {
+		hash := header.Hash()
+		var qc *BlockQC
+		if i < len(qcs) {
+			qc = qcs[i]
+		}
+
+		err := lc.db.Update(func(tx *bolt.Tx) error {
+			if known, err := knownHeader(tx, hash); err != nil || known != nil {
+				return err
+			}
+			var tip *BlockHeader
+			if _, data := tx.Bucket([]byte(lightHeadersBucket)).Cursor().Last(); data != nil {
+				var err error
+				if tip, err = decodeHeader(data); err != nil {
+					return err
+				}
+			}
+			if tip == nil {
+				if err := verifyHeader(nil, header, qc); err != nil {
+					return err
+				}
+				return putMainHeader(tx, header)
+			}
+
+			prev, err := knownHeader(tx, header.PrevBlockHash)
+			if err != nil {
+				return err
+			}
+			extendsTip := bytes.Equal(header.PrevBlockHash, tip.Hash())
+			if prev == nil || (!extendsTip && consensus == 1) {
+				return fmt.Errorf("conflicts with the saved chain at height %d", tip.Height)
+			}
+			if err := verifyHeader(prev, header, qc); err != nil {
+				return err
+			}
+			if extendsTip {
+				return putMainHeader(tx, header)
+			}
+			if err := tx.Bucket([]byte(lightForkBucket)).Put(hash, header.Bytes()); err != nil {
+				return err
+			}
+			if chainWork(header).Cmp(chainWork(tip)) <= 0 {
+				return nil
+			}
+			return reorganizeLight(tx, header)
+		})
+		if err != nil {
+			return fmt.Errorf("header %x at height %d: %v", hash, header.Height, err)
+		}
+	}
+
+	return nil
+}
+
+func putMainHeader(tx *bolt.Tx, header *BlockHeader) error {
+	key := heightKey(header.Height)
+	if err := tx.Bucket([]byte(lightHeadersBucket)).Put(key, header.Bytes()); err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(lightHashesBucket)).Put(header.Hash(), key)
+}
+
+// chainWork 从创世区块到 header 的累计工作量。每个区块头的难度都是网络参数中的 TargetBits，
+// 每个区块的工作量是 2^TargetBits 次哈希的期望值
+func chainWork(header *BlockHeader) *big.Int {
+	work := new(big.Int).Lsh(big.NewInt(1), uint(chainParams.TargetBits))
+
+	return work.Mul(work, big.NewInt(int64(header.Height+1)))
+}
+
+// reorganizeLight 把 tip 所在的分支切换为主链：主链上分叉点之后的区块头移到分叉桶，
+// 分叉点之后的交易证明和过滤器头删除，扫描高度退回分叉点，之后重新请求
+func reorganizeLight(tx *bolt.Tx, tip *BlockHeader) error {
+	mainHeaders := tx.Bucket([]byte(lightHeadersBucket))
+	hashes := tx.Bucket([]byte(lightHashesBucket))
+	forks := tx.Bucket([]byte(lightForkBucket))
+
+	branch := []*BlockHeader{tip}
+	for prev := tip.PrevBlockHash; hashes.Get(prev) == nil; prev = branch[len(branch)-1].PrevBlockHash {
+		data := forks.Get(prev)
+		if data == nil {
+			return fmt.Errorf("branch of %x does not reach the main chain", tip.Hash())
+		}
+		header, err := decodeHeader(data)
+		if err != nil {
+			return err
+		}
+		branch = append(branch, header)
+	}
+	fork := branch[len(branch)-1].Height - 1
+
+	disconnected := 0
+	cursor := mainHeaders.Cursor()
+	for k, v := cursor.Seek(heightKey(fork + 1)); k != nil; k, v = cursor.Next() {
+		old, err := decodeHeader(v)
+		if err != nil {
+			return err
+		}
+		if err := forks.Put(old.Hash(), v); err != nil {
+			return err
+		}
+		if err := hashes.Delete(old.Hash()); err != nil {
+			return err
+		}
+		disconnected++
+	}
+	if err := deleteFrom(mainHeaders, heightKey(fork+1)); err != nil {
+		return err
+	}
+	if err := deleteFrom(tx.Bucket([]byte(lightFilterBucket)), heightKey(fork+1)); err != nil {
+		return err
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		if err := forks.Delete(branch[i].Hash()); err != nil {
+			return err
+		}
+		if err := putMainHeader(tx, branch[i]); err != nil {
+			return err
+		}
+	}
+
+	txs := tx.Bucket([]byte(lightTxBucket))
+	var stale [][]byte
+	err := txs.ForEach(func(k, v []byte) error {
+		record, err := decodeLightTx(v)
+		if err == nil && record.Height > fork {
+			stale = append(stale, append([]byte(nil), k...))
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := txs.Delete(k); err != nil {
+			return err
+		}
+	}
+	meta := tx.Bucket([]byte(lightMetaBucket))
+	if scanned := meta.Get([]byte("scanned")); scanned != nil && int(binary.BigEndian.Uint64(scanned)) > fork {
+		if err := meta.Put([]byte("scanned"), heightKey(fork)); err != nil {
+			return err
+		}
+	}
+	logInfof("light client switched to header %x, disconnecting %d headers", tip.Hash(), disconnected)
+
+	return nil
+}
+
+// deleteFrom 删除按高度排序的桶中键不小于 from 的记录
+func deleteFrom(b *bolt.Bucket, from []byte) error {
+	var keys [][]byte
+	cursor := b.Cursor()
+	for k, _ := cursor.Seek(from); k != nil; k, _ = cursor.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyHeader 检查 header 能否接在 prev 之后：工作量证明网络上区块头必须满足工作量证明，
+// hotstuff 网络上必须带有有效的证书。prev 为 nil 时 header 必须是配置确定的创世区块
+// （见 ChainParams.configuredGenesis）
+func verifyHeader(prev, header *BlockHeader, qc *BlockQC) error {
+	if prev == nil {
+		if header.Height != 0 || len(header.PrevBlockHash) != 0 {
+			return errors.New("the first header must be the genesis block")
+		}
+		genesis, err := chainParams.configuredGenesis()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(header.Hash(), genesis) {
+			return fmt.Errorf("genesis header %x does not match the configured genesis block %x", header.Hash(), genesis)
+		}
+		return nil
+	}
+	if !bytes.Equal(header.PrevBlockHash, prev.Hash()) || header.Height != prev.Height+1 {
+		return fmt.Errorf("does not extend the header at height %d", prev.Height)
+	}
+	consensus, err := chainParams.consensusType()
+	if err != nil {
+		return err
+	}
+	if consensus == 1 {
+		return qc.Verify(header)
+	}
+	if !header.HasValidWork() {
+		return errors.New("header does not have valid proof of work")
+	}
+
+	return nil
+}
+
+// locator 主链上的区块哈希，全节点从其中第一个在它的主链上的区块之后发送区块头：
+// 从链尾往前，前 10 个逐个列出，之后间隔加倍，最后是创世区块
+func (lc *LightClient) locator() [][]byte {
+	var hashes [][]byte
+	height, step := lc.Height(), 1
+	for ; height > 0; height -= step {
+		hashes = append(hashes, lc.blockHashAt(height))
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+	}
+	if genesis := lc.blockHashAt(0); genesis != nil {
+		hashes = append(hashes, genesis)
+	}
+
+	return hashes
+}
+
+// AddProofs 验证并保存交易证明，返回保存的交易数。区块头还没有同步到的证明跳过；
+// 遇到无效的证明时返回错误，之前的交易仍然保存
+func (lc *LightClient) AddProofs(items []TxProof) (int, error) {
+	added := 0
+	for _, item := range items {
+		header, err := lc.Header(item.BlockHash)
+		if err != nil {
+			return added, err
+		}
+		if header == nil {
+			continue
+		}
+		proof := &MerkleProof{item.Index, item.Siblings}
+		if err := VerifyMerkleProof(header.MerkleRoot, MerkleLeafHash(item.Tx), proof); err != nil {
+			return added, fmt.Errorf("proof of a transaction in block %x: %v", item.BlockHash, err)
+		}
+		tx, err := decodeTransaction(item.Tx)
+		if err != nil {
+			return added, err
+		}
+
+		// 跨分片入账的区块命令就是奖励交易输入中的数据，用区块头里的 SHA256(Data) 确认
+		var blockData []byte
+		if tx.IsCoinbase() {
+			if hash := sha256.Sum256(tx.Vin[0].PubKey); bytes.Equal(hash[:], header.DataHash) {
+				blockData = tx.Vin[0].PubKey
+			}
+		}
+		record := lightTx{header.Height, isReward(&tx, blockData), tx}
+		err = lc.db.Update(func(btx *bolt.Tx) error {
+			return btx.Bucket([]byte(lightTxBucket)).Put(tx.ID, encodeLightTx(record))
+		})
+		if err != nil {
+			return added, err
+		}
+		added++
+	}
+
+	return added, nil
+}
+
+// FilterHeight 已经验证过滤器头的最高高度，还没有时为 -1
+func (lc *LightClient) FilterHeight() int {
+	height := -1
+	err := lc.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket([]byte(lightFilterBucket)).Cursor().Last(); k != nil {
+			height = int(binary.BigEndian.Uint64(k))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return height
+}
+
+// filterHeaderAt 高度 height 的过滤器头，height 为 -1 时是 32 个零字节，没有时返回 nil
+func (lc *LightClient) filterHeaderAt(height int) []byte {
+	if height < 0 {
+		return make([]byte, sha256.Size)
+	}
+
+	var header []byte
+	err := lc.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(lightFilterBucket)).Get(heightKey(height)); v != nil {
+			header = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return header
+}
+
+// blockHashAt 高度 height 的区块头的哈希，没有时返回 nil
+func (lc *LightClient) blockHashAt(height int) []byte {
+	var hash []byte
+	err := lc.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket([]byte(lightHeadersBucket)).Get(heightKey(height)); data != nil {
+			header, err := decodeHeader(data)
+			if err != nil {
+				return err
+			}
+			hash = header.Hash()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return hash
+}
+
+// AddFilters 验证并保存 cfilter 消息中的过滤器头：消息必须接在已保存的过滤器头之后（可以重叠），
+// 每个过滤器对应的区块必须是本地同高度的区块头，重叠部分的过滤器头必须和已保存的相同
+func (lc *LightClient) AddFilters(msg *cfilter) error {
+	if len(msg.BlockHashes) != len(msg.Filters) {
+		return errors.New("filters and block hashes do not match")
+	}
+	if msg.Height > lc.FilterHeight() {
+		return fmt.Errorf("filters start after height %d, have %d", msg.Height, lc.FilterHeight())
+	}
+	if !bytes.Equal(msg.PrevHeader, lc.filterHeaderAt(msg.Height)) {
+		return fmt.Errorf("filter header at height %d does not match", msg.Height)
+	}
+	headers, err := verifyFilterChain(msg.PrevHeader, msg.Filters)
+	if err != nil {
+		return err
+	}
+
+	for i, header := range headers {
+		height := msg.Height + 1 + i
+		if !bytes.Equal(lc.blockHashAt(height), msg.BlockHashes[i]) {
+			return fmt.Errorf("filter for unknown block %x at height %d", msg.BlockHashes[i], height)
+		}
+		if saved := lc.filterHeaderAt(height); saved != nil {
+			if !bytes.Equal(saved, header) {
+				return fmt.Errorf("filter header at height %d conflicts with the saved chain", height)
+			}
+			continue
+		}
+		err := lc.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(lightFilterBucket)).Put(heightKey(height), header)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filterItems 在区块过滤器中查找的元素：钱包公钥哈希，以及属于它们、还没有花费的输出点
+func (lc *LightClient) filterItems(pubKeyHashes [][]byte) ([][]byte, error) {
+	items := append([][]byte(nil), pubKeyHashes...)
+	for _, pubKeyHash := range pubKeyHashes {
+		utxos, err := lc.unspentOutputs(pubKeyHash, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, utxo := range utxos {
+			items = append(items, outpointBytes(utxo.TxID, utxo.Index))
+		}
+	}
+
+	return items, nil
+}
+
+// MatchFilters 消息中高度大于 after、第一个可能涉及 pubKeyHashes 的区块的高度，都不涉及时为 -1
+func (lc *LightClient) MatchFilters(msg *cfilter, pubKeyHashes [][]byte, after int) (int, error) {
+	items, err := lc.filterItems(pubKeyHashes)
+	if err != nil {
+		return -1, err
+	}
+	for i, filter := range msg.Filters {
+		if msg.Height+1+i <= after {
+			continue
+		}
+		matched, err := MatchBlockFilter(msg.BlockHashes[i], filter, items)
+		if err != nil {
+			return -1, err
+		}
+		if matched {
+			return msg.Height + 1 + i, nil
+		}
+	}
+
+	return -1, nil
+}
+
+func encodeLightTx(record lightTx) []byte {
+	var buff bytes.Buffer
+
+	writeVarInt(&buff, uint64(record.Height))
+	if record.Reward {
+		buff.WriteByte(1)
+	} else {
+		buff.WriteByte(0)
+	}
+	writeVarBytes(&buff, record.Tx.Serialize())
+
+	return buff.Bytes()
+}
+
+func decodeLightTx(data []byte) (lightTx, error) {
+	r := newBinReader(data)
+	record := lightTx{Height: int(r.readVarInt()), Reward: r.readByte() == 1}
+	encoded := r.readVarBytes()
+	if err := r.finish(); err != nil {
+		return record, err
+	}
+
+	tx, err := decodeTransaction(encoded)
+	record.Tx = tx
+
+	return record, err
+}
+
+func (lc *LightClient) transactions() ([]lightTx, error) {
+	var records []lightTx
+	err := lc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(lightTxBucket)).ForEach(func(k, v []byte) error {
+			record, err := decodeLightTx(v)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+// Transaction 按 ID 查找经过证明的交易
+func (lc *LightClient) Transaction(ID []byte) (Transaction, error) {
+	var data []byte
+	err := lc.db.View(func(tx *bolt.Tx) error {
+		data = append([]byte(nil), tx.Bucket([]byte(lightTxBucket)).Get(ID)...)
+		return nil
+	})
+	if err != nil {
+		return Transaction{}, err
+	}
+	if len(data) == 0 {
+		return Transaction{}, errors.New("Transaction is not found")
+	}
+	record, err := decodeLightTx(data)
+
+	return record.Tx, err
+}
+
+// unspentOutputs 经过证明的交易中属于 pubKeyHash、没有被其他经过证明的交易花费的输出。
+// mature 为 true 时跳过未成熟的奖励输出
+func (lc *LightClient) unspentOutputs(pubKeyHash []byte, mature bool) ([]SpendableOutput, error) {
+	records, err := lc.transactions()
+	if err != nil {
+		return nil, err
+	}
+	bestHeight := lc.Height()
+
+	spent := make(map[string]bool)
+	for _, record := range records {
+		if record.Tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range record.Tx.Vin {
+			spent[outpointKey(in.Txid, in.Vout)] = true
+		}
+	}
+
+	var utxos []SpendableOutput
+	for _, record := range records {
+		outs := TXOutputs{Height: record.Height, Reward: record.Reward}
+		if mature && !outs.Mature(bestHeight) {
+			continue
+		}
+		for i, out := range record.Tx.Vout {
+			if out.IsLockedWithKey(pubKeyHash) && !spent[outpointKey(record.Tx.ID, i)] {
+				utxos = append(utxos, SpendableOutput{record.Tx.ID, i, out})
+			}
+		}
+	}
+	sort.Slice(utxos, func(i, j int) bool {
+		if c := bytes.Compare(utxos[i].TxID, utxos[j].TxID); c != 0 {
+			return c < 0
+		}
+		return utxos[i].Index < utxos[j].Index
+	})
+
+	return utxos, nil
+}
+
+// SpendableOutputs 可以花费的经过证明的输出，与 UTXOSet.SpendableOutputs 一样跳过未成熟的奖励输出
+func (lc *LightClient) SpendableOutputs(pubKeyHash []byte) ([]SpendableOutput, error) {
+	return lc.unspentOutputs(pubKeyHash, true)
+}
+
+// Balance 地址在经过证明的交易中的余额
+func (lc *LightClient) Balance(address string) (int, error) {
+	pubKeyHash := addressPubKeyHash(address)
+	if pubKeyHash == nil {
+		return 0, fmt.Errorf("address %s is not valid", address)
+	}
+	utxos, err := lc.unspentOutputs(pubKeyHash, false)
+	if err != nil {
+		return 0, err
+	}
+
+	balance := 0
+	for _, utxo := range utxos {
+		balance += utxo.Output.Value
+	}
+
+	return balance, nil
+}
+
+// NewPayment 用经过证明的输出构建并签名交易，选币、手续费和找零与 NewBatchTransaction 相同
+func (lc *LightClient) NewPayment(wallets *Wallets, from string, recipients []Recipient, opts SendOptions) (*Transaction, error) {
+	wallet, ok := wallets.Wallets[from]
+	if !ok {
+		return nil, fmt.Errorf("address %s is not in the wallet", from)
+	}
+	utxos, err := lc.SpendableOutputs(HashPubKey(wallet.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	opts.DryRun = false
+	plan, err := planSend(wallets, from, recipients, utxos, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := plan.UnsignedTransaction(wallet.PublicKey)
+	prevTXs := make(map[string]Transaction)
+	for _, vin := range tx.Vin {
+		prevTX, err := lc.Transaction(vin.Txid)
+		if err != nil {
+			return nil, err
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+	tx.Sign(wallet.PrivateKey, prevTXs)
+
+	return tx, nil
+}
+
+// watchKey 钱包公钥哈希集合的摘要，集合变化（例如新建了地址）后需要从头扫描
+func watchKey(pubKeyHashes [][]byte) []byte {
+	sorted := append([][]byte(nil), pubKeyHashes...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	hash := sha256.Sum256(bytes.Join(sorted, nil))
+
+	return hash[:]
+}
+
+// ScanHeight 已经为 pubKeyHashes 取得交易证明的高度，集合变化后为 -1
+func (lc *LightClient) ScanHeight(pubKeyHashes [][]byte) int {
+	height := -1
+	err := lc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(lightMetaBucket))
+		if data := b.Get([]byte("scanned")); data != nil && bytes.Equal(b.Get([]byte("watch")), watchKey(pubKeyHashes)) {
+			height = int(binary.BigEndian.Uint64(data))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return height
+}
+
+// SetScanHeight 记录已经为 pubKeyHashes 取得交易证明的高度
+func (lc *LightClient) SetScanHeight(pubKeyHashes [][]byte, height int) error {
+	return lc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(lightMetaBucket))
+		if err := b.Put([]byte("watch"), watchKey(pubKeyHashes)); err != nil {
+			return err
+		}
+		return b.Put([]byte("scanned"), heightKey(height))
+	})
+}
+
+// walletPubKeyHashes 钱包文件中全部地址（包括只读地址）的公钥哈希
+func walletPubKeyHashes(nodeID string) [][]byte {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var pubKeyHashes [][]byte
+	for _, address := range wallets.GetAddresses() {
+		pubKeyHashes = append(pubKeyHashes, HashPubKey(wallets.Wallets[address].PublicKey))
+	}
+	for address := range wallets.WatchOnly {
+		if pubKeyHash := addressPubKeyHash(address); pubKeyHash != nil {
+			pubKeyHashes = append(pubKeyHashes, pubKeyHash)
+		}
+	}
+
+	return pubKeyHashes
+}
+
+// lightMu 同一进程中的消息处理依次打开轻节点库
+var lightMu sync.Mutex
+
+// withLightClient 打开轻节点库执行 fn，然后关闭，其他进程（例如 getbalance）可以在间隙打开它
+func withLightClient(nodeID string, fn func(lc *LightClient)) {
+	lightMu.Lock()
+	defer lightMu.Unlock()
+
+	lc, err := OpenLightClient(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer lc.Close()
+
+	fn(lc)
+}
+
+// StartLightClient 启动轻节点：定期向第一个种子节点请求新的区块头，区块头同步完成后用区块过滤器筛选并请求钱包交易的证明
+func StartLightClient(config *NodeConfig) {
+	if len(config.Seeds) == 0 {
+		log.Panic("A light client needs a full node in -seeds")
+	}
+	nodeID := config.NodeID()
+	nodeAddress = config.Listen
+	NodeIP = nodeAddress
+	NodeIPAddress = nodeID
+	peer := config.Seeds[0]
+	logInfof("light client %s on network %s, syncing from %s, data in %s", nodeID, chainParams.Network, peer, chainParams.dataPath(""))
+
+	ln, err := net.Listen(protocol, nodeAddress)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			withLightClient(nodeID, func(lc *LightClient) {
+				sendGetHeaders(peer, lc.Height(), lc.locator())
+			})
+			time.Sleep(lightSyncInterval)
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Panic(err)
+		}
+		go handleLightConnection(nodeID, conn)
+	}
+}
+
+func handleLightConnection(nodeID string, conn net.Conn) {
+	defer conn.Close()
+
+	request, err := ioutil.ReadAll(conn)
+	if err != nil {
+		log.Panic(err)
+	}
+	request, err = chainParams.unwrapMessage(request)
+	if err != nil {
+		logWarnf("drop message from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	switch command := bytesToCommand(request[:commandLength]); command {
+	case "headers":
+		handleHeaders(nodeID, request)
+	case "proofs":
+		handleProofs(nodeID, request)
+	case "cfilter":
+		handleCFilter(nodeID, request)
+	default:
+		logDebugf("light client ignores %q from %s", command, conn.RemoteAddr())
+	}
+}
+
+// handleHeaders 保存收到的区块头；收到满额时继续请求，否则请求钱包交易的证明
+func handleHeaders(nodeID string, request []byte) {
+	var payload headers
+	decodePayload(request, &payload)
+
+	withLightClient(nodeID, func(lc *LightClient) {
+		var list []*BlockHeader
+		var qcs []*BlockQC
+		for i, data := range payload.Headers {
+			header, err := decodeHeader(data)
+			if err != nil {
+				logWarnf("bad header from %s: %v", payload.AddrFrom, err)
+				return
+			}
+			var qc *BlockQC
+			if i < len(payload.QCs) && len(payload.QCs[i]) > 0 {
+				if qc, err = DeserializeBlockQC(payload.QCs[i]); err != nil {
+					logWarnf("bad quorum certificate from %s: %v", payload.AddrFrom, err)
+					return
+				}
+			}
+			list = append(list, header)
+			qcs = append(qcs, qc)
+		}
+		if err := lc.AddHeaders(list, qcs); err != nil {
+			logWarnf("headers from %s: %v", payload.AddrFrom, err)
+			return
+		}
+		logInfof("synced headers to height %d", lc.Height())
+
+		// 收到的区块头可能还在累计工作量较小的分支上，从最后一个接着请求
+		if len(list) == maxHeadersPerMessage {
+			last := list[len(list)-1]
+			sendGetHeaders(payload.AddrFrom, last.Height, append([][]byte{last.Hash()}, lc.locator()...))
+			return
+		}
+		// 钱包地址变化后从头请求证明，其余区块先用过滤器筛选
+		pubKeyHashes := walletPubKeyHashes(nodeID)
+		if len(pubKeyHashes) > 0 && lc.ScanHeight(pubKeyHashes) < 0 {
+			sendGetProofs(payload.AddrFrom, pubKeyHashes, -1)
+		}
+		sendGetCFilters(payload.AddrFrom, lc.FilterHeight())
+	})
+}
+
+// handleProofs 验证并保存钱包交易，记录扫描到的高度
+func handleProofs(nodeID string, request []byte) {
+	var payload proofs
+	decodePayload(request, &payload)
+
+	withLightClient(nodeID, func(lc *LightClient) {
+		added, err := lc.AddProofs(payload.Items)
+		if err != nil {
+			logWarnf("proofs from %s: %v", payload.AddrFrom, err)
+			return
+		}
+		// 全节点可能已经有更新的区块，只记到本地区块头的高度，剩下的下次再取
+		height := payload.Height
+		if tip := lc.Height(); tip < height {
+			height = tip
+		}
+		if err := lc.SetScanHeight(payload.PubKeyHashes, height); err != nil {
+			log.Panic(err)
+		}
+		logInfof("verified %d wallet transactions up to height %d", added, height)
+	})
+}
+
+// handleCFilter 验证并保存过滤器头；过滤器命中钱包时从命中的区块开始请求交易证明，
+// 都没有命中时直接推进扫描高度（过滤器不会漏报）
+func handleCFilter(nodeID string, request []byte) {
+	var payload cfilter
+	decodePayload(request, &payload)
+
+	withLightClient(nodeID, func(lc *LightClient) {
+		if err := lc.AddFilters(&payload); err != nil {
+			logWarnf("filters from %s: %v", payload.AddrFrom, err)
+			return
+		}
+		last := payload.Height + len(payload.Filters)
+		logInfof("verified filter headers to height %d", lc.FilterHeight())
+
+		pubKeyHashes := walletPubKeyHashes(nodeID)
+		if scanned := lc.ScanHeight(pubKeyHashes); len(pubKeyHashes) > 0 && scanned >= 0 && scanned < last {
+			matched, err := lc.MatchFilters(&payload, pubKeyHashes, scanned)
+			if err != nil {
+				logWarnf("filters from %s: %v", payload.AddrFrom, err)
+				return
+			}
+			switch {
+			case matched >= 0:
+				sendGetProofs(payload.AddrFrom, pubKeyHashes, matched-1)
+			case scanned >= payload.Height:
+				if err := lc.SetScanHeight(pubKeyHashes, last); err != nil {
+					log.Panic(err)
+				}
+			}
+		}
+
+		if len(payload.Filters) == maxFiltersPerMessage {
+			sendGetCFilters(payload.AddrFrom, last)
+		}
+	})
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLightClient(t *testing.T) *LightClient {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	lc, err := OpenLightClient("127.0.0.1 3000")
+	assert.Nil(t, err)
+	t.Cleanup(lc.Close)
+
+	return lc
+}
+
+// syncLightClient 不经过网络，直接用全节点的数据同步轻节点
+func syncLightClient(t *testing.T, lc *LightClient, bc *Blockchain, pubKeyHashes [][]byte) {
+	headers, qcs, err := bc.HeadersAfter(lc.Height(), maxHeadersPerMessage)
+	assert.Nil(t, err)
+	assert.Nil(t, lc.AddHeaders(headers, qcs))
+
+	items, err := bc.FindProofs(pubKeyHashes, lc.ScanHeight(pubKeyHashes))
+	assert.Nil(t, err)
+	_, err = lc.AddProofs(items)
+	assert.Nil(t, err)
+	assert.Nil(t, lc.SetScanHeight(pubKeyHashes, lc.Height()))
+}
+
+func TestLightClientSync(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+	lc := testLightClient(t)
+
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	pubKeyHashes := [][]byte{HashPubKey(wallet.PublicKey)}
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	chainParams.GenesisHash = hex.EncodeToString(genesis.Hash)
+	bc := testChain(t, genesis)
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+	_, err = bc.Generate(chainParams.CoinbaseMaturity, address)
+	assert.Nil(t, err)
+
+	syncLightClient(t, lc, bc, pubKeyHashes)
+	assert.Equal(t, bc.GetBestHeight(), lc.Height())
+	balance, err := lc.Balance(address)
+	assert.Nil(t, err)
+	assert.Equal(t, UTXOSet.Balance(address), balance)
+	outs, err := lc.SpendableOutputs(pubKeyHashes[0])
+	assert.Nil(t, err)
+	assert.Equal(t, UTXOSet.SpendableOutputs(pubKeyHashes[0]), outs, "the same outputs are mature")
+
+	// 用经过证明的输出付款，全节点接受这笔交易
+	wallets := &Wallets{Wallets: map[string]*Wallet{address: wallet}}
+	other := string(NewWallet().GetAddress())
+	opts := DefaultSendOptions()
+	opts.ReuseChange = true
+	tx, err := lc.NewPayment(wallets, address, []Recipient{{other, 7}}, opts)
+	assert.Nil(t, err)
+	assert.True(t, bc.VerifyTransaction(tx))
+	block := bc.MineBlock([]*Transaction{bc.NewRewardTX(address, []*Transaction{tx}), tx})
+	UTXOSet.Update(block)
+
+	syncLightClient(t, lc, bc, pubKeyHashes)
+	balance, err = lc.Balance(address)
+	assert.Nil(t, err)
+	assert.Equal(t, UTXOSet.Balance(address), balance)
+	_, err = lc.Transaction(tx.ID)
+	assert.Nil(t, err)
+
+	// 重复同步不改变结果
+	headers, qcs, err := bc.HeadersAfter(-1, maxHeadersPerMessage)
+	assert.Nil(t, err)
+	assert.Nil(t, lc.AddHeaders(headers, qcs))
+}
+
+func TestLightClientRejects(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+	lc := testLightClient(t)
+
+	address := string(NewWallet().GetAddress())
+	pubKeyHashes := [][]byte{addressPubKeyHash(address)}
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	chainParams.GenesisHash = hex.EncodeToString(genesis.Hash)
+	bc := testChain(t, genesis)
+	UTXOSet{bc}.Reindex()
+	_, err = bc.Generate(2, address)
+	assert.Nil(t, err)
+
+	headers, _, err := bc.HeadersAfter(-1, maxHeadersPerMessage)
+	assert.Nil(t, err)
+	assert.NotNil(t, lc.AddHeaders(headers[1:], nil), "the first header must be the genesis block")
+	chainParams.GenesisHash = hex.EncodeToString(make([]byte, 32))
+	assert.NotNil(t, lc.AddHeaders(headers[:1], nil), "another genesis block")
+	chainParams.GenesisHash = ""
+	assert.NotNil(t, lc.AddHeaders(headers[:1], nil), "the configuration does not fix the genesis block")
+	chainParams.GenesisHash = hex.EncodeToString(genesis.Hash)
+	assert.Nil(t, lc.AddHeaders(headers[:1], nil))
+
+	unlinked := *headers[2]
+	assert.NotNil(t, lc.AddHeaders([]*BlockHeader{&unlinked}, nil), "skips a header")
+	assert.Equal(t, 0, lc.Height())
+	assert.Nil(t, lc.AddHeaders(headers, nil))
+	assert.Equal(t, 2, lc.Height())
+
+	items, err := bc.FindProofs(pubKeyHashes, -1)
+	assert.Nil(t, err)
+	assert.Len(t, items, 3)
+	tampered := items[0]
+	tx := DeserializeTransaction(tampered.Tx)
+	tx.Vout[0].Value++
+	tampered.Tx = tx.Serialize()
+	_, err = lc.AddProofs([]TxProof{tampered})
+	assert.NotNil(t, err)
+	assert.Equal(t, -1, lc.ScanHeight(pubKeyHashes), "nothing scanned yet")
+
+	moved := items[0]
+	moved.BlockHash = items[1].BlockHash
+	_, err = lc.AddProofs([]TxProof{moved})
+	assert.NotNil(t, err, "the proof does not match the header of another block")
+
+	// 钱包地址变化后从头扫描
+	assert.Nil(t, lc.SetScanHeight(pubKeyHashes, 2))
+	assert.Equal(t, 2, lc.ScanHeight(pubKeyHashes))
+	assert.Equal(t, -1, lc.ScanHeight(append(pubKeyHashes, addressPubKeyHash(string(NewWallet().GetAddress())))))
+}
+
+// 工作量证明网络上累计工作量更大的分支成为主链，分叉点之后的交易证明作废；hotstuff 网络上拒绝冲突的区块头
+func TestLightClientReorg(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+	lc := testLightClient(t)
+
+	address := string(NewWallet().GetAddress())
+	pubKeyHashes := [][]byte{addressPubKeyHash(address)}
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	chainParams.GenesisHash = hex.EncodeToString(genesis.Hash)
+	bc := testChain(t, genesis)
+	UTXOSet{bc}.Reindex()
+	_, err = bc.Generate(2, address)
+	assert.Nil(t, err)
+	heavier := testChain(t, genesis)
+	UTXOSet{heavier}.Reindex()
+	_, err = heavier.Generate(3, string(NewWallet().GetAddress()))
+	assert.Nil(t, err)
+
+	syncLightClient(t, lc, bc, pubKeyHashes)
+	balance, err := lc.Balance(address)
+	assert.Nil(t, err)
+	assert.Equal(t, UTXOSet{bc}.Balance(address), balance)
+	assert.Equal(t, 2, bc.locatorHeight(lc.locator()))
+	assert.Equal(t, 0, heavier.locatorHeight(lc.locator()), "the chains share only the genesis block")
+
+	headers, _, err := heavier.HeadersAfter(-1, maxHeadersPerMessage)
+	assert.Nil(t, err)
+	assert.Nil(t, lc.AddHeaders(headers[:3], nil), "a branch with the same work is kept aside")
+	assert.Equal(t, bc.Tip(), lc.blockHashAt(2))
+	assert.Nil(t, lc.AddHeaders(headers, nil))
+	assert.Equal(t, heavier.Tip(), lc.blockHashAt(3))
+	assert.Equal(t, 0, lc.ScanHeight(pubKeyHashes), "scan again from the fork point")
+	balance, err = lc.Balance(address)
+	assert.Nil(t, err)
+	assert.Equal(t, UTXOSet{heavier}.Balance(address), balance, "rewards of the old branch are gone")
+
+	lighter, _, err := bc.HeadersAfter(-1, maxHeadersPerMessage)
+	assert.Nil(t, err)
+	assert.Nil(t, lc.AddHeaders(lighter, nil))
+	assert.Equal(t, heavier.Tip(), lc.blockHashAt(3))
+
+	chainParams.Consensus = "hotstuff"
+	fork := NewBlock([]*Transaction{NewCoinbaseTX(address, "fork")}, genesis.Hash, 1, 1, nil)
+	err = lc.AddHeaders([]*BlockHeader{fork.Header()}, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "conflicts with the saved chain")
+}
+
+func TestBlockQC(t *testing.T) {
+	params := *chainParams
+	params.Consensus = "hotstuff"
+	useChainParams(t, &params)
+
+	// hotstuff 区块没有工作量证明，选一个哈希恰好不满足难度的命令
+	var block *Block
+	for i := 0; block == nil || block.Header().HasValidWork(); i++ {
+		block = NewBlock([]*Transaction{NewCoinbaseTX(string(NewWallet().GetAddress()), "")}, []byte{1}, 1, 1, []byte(fmt.Sprintf("send %d", i)))
+	}
+	header := block.Header()
+	tip := &BlockHeader{Height: 0, MerkleRoot: make([]byte, 32), DataHash: make([]byte, 32), TargetBits: chainParams.TargetBits}
+	header.PrevBlockHash = tip.Hash()
+	assert.NotNil(t, verifyHeader(tip, header, nil), "neither work nor votes")
+
+	wallets := make(map[string]*Wallet)
+	chainParams.ValidatorKeys = make(map[string]string)
+	for _, nodeID := range []string{"n1", "n2", "n3", "n4"} {
+		wallets[nodeID] = NewWallet()
+		chainParams.ValidatorKeys[nodeID] = hex.EncodeToString(wallets[nodeID].PublicKey)
+	}
+	votes := make(map[string]QCVote)
+	for _, nodeID := range []string{"n1", "n2", "n3"} {
+		votes[nodeID] = signQCVote(t, nodeID, wallets[nodeID], header.Hash())
+	}
+	qc := newBlockQC(votes)
+	decoded, err := DeserializeBlockQC(qc.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, qc, decoded)
+	assert.Nil(t, verifyHeader(tip, header, decoded), "3 of 4 validators")
+	chainParams.Consensus = "pow"
+	assert.NotNil(t, verifyHeader(tip, header, decoded), "votes do not replace work on a pow network")
+	chainParams.Consensus = "hotstuff"
+	mined := *header
+	for !mined.HasValidWork() {
+		mined.Nonce++
+	}
+	assert.NotNil(t, verifyHeader(tip, &mined, nil), "work does not replace votes on a hotstuff network")
+
+	delete(votes, "n3")
+	assert.NotNil(t, newBlockQC(votes).Verify(header), "2 of 4 validators")
+	votes["n3"] = signQCVote(t, "n3", NewWallet(), header.Hash())
+	assert.NotNil(t, newBlockQC(votes).Verify(header), "n3 did not sign with its configured key")
+	command := sha256.Sum256(block.Data)
+	votes["n3"] = signQCVote(t, "n3", wallets["n3"], command[:])
+	assert.NotNil(t, newBlockQC(votes).Verify(header), "votes sign the header hash, not the command")
+
+	other := *header
+	other.Timestamp++
+	assert.NotNil(t, qc.Verify(&other), "votes are for another header with the same command")
+
+	chainParams.ValidatorKeys = nil
+	assert.NotNil(t, qc.Verify(header), "no validator keys are configured")
+}
+
+func signQCVote(t *testing.T, nodeID string, wallet *Wallet, hash []byte) QCVote {
+	r, s, err := ecdsa.Sign(rand.Reader, &wallet.PrivateKey, hash)
+	assert.Nil(t, err)
+
+	return QCVote{nodeID, wallet.PublicKey, r.Bytes(), s.Bytes()}
+}
+
+// 领导者收集区块头签名，达到法定人数时只生成一次证书
+func TestHeaderSigPool(t *testing.T) {
+	params := *chainParams
+	useChainParams(t, &params)
+
+	wallets := make(map[string]*Wallet)
+	chainParams.ValidatorKeys = make(map[string]string)
+	for _, nodeID := range []string{"n1", "n2", "n3", "n4"} {
+		wallets[nodeID] = NewWallet()
+		chainParams.ValidatorKeys[nodeID] = hex.EncodeToString(wallets[nodeID].PublicKey)
+	}
+	header := &BlockHeader{PrevBlockHash: []byte{1}, MerkleRoot: make([]byte, 32), Height: 1, DataHash: make([]byte, 32)}
+	hash := header.Hash()
+
+	pool := &headerSigPool{pending: make(map[string]*pendingQC)}
+	assert.Nil(t, pool.add(hash, signQCVote(t, "n1", wallets["n1"], hash)), "not collecting signatures for the header")
+	pool.start("127.0.0.1 3000", header)
+	assert.Equal(t, "127.0.0.1 3000", pool.chainOf(hash))
+	assert.Nil(t, pool.add(hash, signQCVote(t, "n1", wallets["n1"], hash)))
+	assert.Nil(t, pool.add(hash, signQCVote(t, "n2", NewWallet(), hash)), "not the key of n2")
+	assert.Nil(t, pool.add(hash, signQCVote(t, "n2", wallets["n2"], hash)))
+	qc := pool.add(hash, signQCVote(t, "n3", wallets["n3"], hash))
+	assert.NotNil(t, qc)
+	assert.Nil(t, qc.Verify(header))
+	assert.Nil(t, pool.add(hash, signQCVote(t, "n4", wallets["n4"], hash)), "the certificate is already saved")
+}
+
+// 证书只统计同一分片验证者的签名，每个分片的法定人数按本分片的验证者计算
+func TestQCShardQuorum(t *testing.T) {
+	params := *chainParams
+	useChainParams(t, &params)
+
+	wallets := make(map[string]*Wallet)
+	chainParams.ValidatorKeys = make(map[string]string)
+	for _, nodeID := range []string{"n1", "n2", "n3", "n4"} {
+		wallets[nodeID] = NewWallet()
+		chainParams.ValidatorKeys[nodeID] = hex.EncodeToString(wallets[nodeID].PublicKey)
+	}
+	chainParams.ValidatorShards = map[string]int{"n3": 1, "n4": 1}
+	assert.Nil(t, chainParams.Validate())
+	header := &BlockHeader{PrevBlockHash: []byte{1}, MerkleRoot: make([]byte, 32), Height: 1, DataHash: make([]byte, 32)}
+	hash := header.Hash()
+
+	votes := map[string]QCVote{
+		"n1": signQCVote(t, "n1", wallets["n1"], hash),
+		"n3": signQCVote(t, "n3", wallets["n3"], hash),
+	}
+	assert.NotNil(t, newBlockQC(votes).Verify(header), "one vote from each shard")
+	votes["n4"] = signQCVote(t, "n4", wallets["n4"], hash)
+	assert.Nil(t, newBlockQC(votes).Verify(header), "both validators of shard 1")
+
+	chainParams.ValidatorShards["n5"] = 1
+	assert.NotNil(t, chainParams.Validate(), "n5 has no validator key")
+}
+
+// 验证者只为本地主链上的区块头签名，每张同意票只签一个区块头，同一高度只签一个区块头
+func TestSignMainChainHeader(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	chainParams = regtestChainParams()
+	previousNode := NodeIPAddress
+	NodeIPAddress = "127.0.0.1 3000"
+	resetSigningState()
+	t.Cleanup(func() {
+		NodeIPAddress = previousNode
+		resetSigningState()
+	})
+
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := wallets.CreateWallet()
+	wallets.SaveToFile(NodeIPAddress)
+	chainParams.ValidatorKeys = map[string]string{NodeIPAddress: hex.EncodeToString(wallets.Wallets[address].PublicKey)}
+
+	command := "X send -from a -to b -amount 1"
+	genesis := NewBlock([]*Transaction{NewCoinbaseTX(address, "")}, []byte{}, 0, 1, nil)
+	block := NewBlock([]*Transaction{NewCoinbaseTX(address, "1")}, genesis.Hash, 1, 1, []byte(command))
+	fork := NewBlock([]*Transaction{NewCoinbaseTX(address, "2")}, genesis.Hash, 1, 1, []byte(command))
+	bc := testChain(t, genesis, block)
+
+	_, err := signMainChainHeader(bc, block.Header())
+	assert.NotNil(t, err, "did not vote for the command")
+	rememberVote(command)
+	_, err = signMainChainHeader(bc, fork.Header())
+	assert.Equal(t, errNotOnMainChain, err)
+	vote, err := signMainChainHeader(bc, block.Header())
+	assert.Nil(t, err)
+	assert.True(t, vote.verify(block.Header().Hash()))
+	_, err = signMainChainHeader(bc, block.Header())
+	assert.Nil(t, err, "the same header can be signed again")
+
+	// 分叉成为主链后也不再签同一高度的另一个区块头
+	rememberVote(command)
+	bc = testChain(t, genesis, fork)
+	_, err = signMainChainHeader(bc, fork.Header())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "already signed")
+
+	// 第二张票留给下一个提交同一命令的区块
+	next := NewBlock([]*Transaction{NewCoinbaseTX(address, "3")}, fork.Hash, 2, 1, []byte(command))
+	last := NewBlock([]*Transaction{NewCoinbaseTX(address, "4")}, next.Hash, 3, 1, []byte(command))
+	bc = testChain(t, genesis, fork, next, last)
+	_, err = signMainChainHeader(bc, next.Header())
+	assert.Nil(t, err)
+	_, err = signMainChainHeader(bc, last.Header())
+	assert.NotNil(t, err, "every vote signs one header")
+}
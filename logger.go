@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// logLevel 日志级别，低于当前级别的日志不输出
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+var currentLogLevel = levelInfo
+
+// parseLogLevel 解析 debug、info、warn 或 error
+func parseLogLevel(name string) (logLevel, error) {
+	for i, levelName := range logLevelNames {
+		if levelName == name {
+			return logLevel(i), nil
+		}
+	}
+
+	return levelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
+func logf(level logLevel, format string, v ...interface{}) {
+	if level < currentLogLevel {
+		return
+	}
+	log.Printf("["+logLevelNames[level]+"] "+format, v...)
+}
+
+func logDebugf(format string, v ...interface{}) { logf(levelDebug, format, v...) }
+
+func logInfof(format string, v ...interface{}) { logf(levelInfo, format, v...) }
+
+func logWarnf(format string, v ...interface{}) { logf(levelWarn, format, v...) }
+
+func logErrorf(format string, v ...interface{}) { logf(levelError, format, v...) }
@@ -2,18 +2,35 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 )
 
+// main 命令之前是全局参数（见 ParseNodeConfig）。给出命令时作为命令行工具运行，否则启动 HTTP 接口
 func main() {
-	http.HandleFunc("/post", handlePostRequest)
+	config, args, err := ParseNodeConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := config.Apply(); err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 {
+		cli := CLI{config}
+		cli.Run(args)
+		return
+	}
+
+	chains := NewChainService(config.KeepOpen)
+	defer chains.Close()
+	http.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
+		handlePostRequest(chains, w, r)
+	})
 	http.HandleFunc("/get", handleGetRequest)
-	fmt.Println("Server listening on :8088")
-	http.ListenAndServe(":8088", nil)
+	http.HandleFunc("/tx/", func(w http.ResponseWriter, r *http.Request) {
+		handleTxProof(chains, w, r)
+	})
+	fmt.Println("Server listening on", config.RPCListen)
+	log.Fatal(http.ListenAndServe(config.RPCListen, nil))
 }
-
-//func main() {
-//	cli := CLI{}
-//	cli.Run()
-//
-//}
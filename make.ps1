@@ -97,6 +97,18 @@ function copyDB3002 {
     Write-Host "====>copyDB3002"
     Copy-Item -Path .\blockchain_genesis.db -Destination .\blockchain_3002.db
 }# 复制数据库3002
+# 导出区块（可移植的文件格式，代替复制数据库文件）
+function exportChain {
+    Write-Host "====>exportChain"
+    Set-Item -Path "env:NODE_ID" -Value "3000"
+    & $BINARY exportchain -file .\blockchain_genesis.chain
+}
+# 验证并导入区块到 3001
+function importChain3001 {
+    Write-Host "====>importChain3001"
+    Set-Item -Path "env:NODE_ID" -Value "3001"
+    & $BINARY importchain -file .\blockchain_genesis.chain
+}
 function testscrip {
     Set-Item -Path "env:NODE_ID" -Value "3001"
     Write-Host "Hello from PowerShell"
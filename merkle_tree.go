@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 )
 
 // MerkleTree represent a Merkle tree
 type MerkleTree struct {
 	RootNode *MerkleNode
+	// levels[0] 是叶子层，最后一层只有根节点；奇数个节点的层已补上最后一个节点的副本
+	levels [][]*MerkleNode
+	leaves int
 }
 
 // MerkleNode represent a Merkle tree node
@@ -16,31 +22,41 @@ type MerkleNode struct {
 	Data  []byte
 }
 
+// MerkleProof 交易的默克尔包含证明：叶子下标和从叶子到根依次经过的兄弟节点哈希
+type MerkleProof struct {
+	Index    int
+	Siblings [][]byte
+}
+
 // NewMerkleTree creates a new Merkle tree from a sequence of data
+// 每一层节点数为奇数时复制最后一个节点（叶子层只有一个节点时也复制），直到只剩根节点
 func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
-	}
+	var nodes []*MerkleNode
 
 	for _, datum := range data {
-		node := NewMerkleNode(nil, nil, datum)
-		nodes = append(nodes, *node)
+		nodes = append(nodes, NewMerkleNode(nil, nil, datum))
 	}
 
-	for i := 0; i < len(data)/2; i++ {
-		var newLevel []MerkleNode
+	var levels [][]*MerkleNode
+	for {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+		levels = append(levels, nodes)
 
+		var newLevel []*MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
-			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
-			newLevel = append(newLevel, *node)
+			newLevel = append(newLevel, NewMerkleNode(nodes[j], nodes[j+1], nil))
 		}
-
 		nodes = newLevel
+
+		if len(nodes) <= 1 {
+			break
+		}
 	}
+	levels = append(levels, nodes)
 
-	mTree := MerkleTree{&nodes[0]}
+	mTree := MerkleTree{nodes[0], levels, len(data)}
 
 	return &mTree
 }
@@ -53,9 +69,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 		hash := sha256.Sum256(data)
 		mNode.Data = hash[:]
 	} else {
-		prevHashes := append(left.Data, right.Data...)
-		hash := sha256.Sum256(prevHashes)
-		mNode.Data = hash[:]
+		mNode.Data = hashMerklePair(left.Data, right.Data)
 	}
 
 	mNode.Left = left
@@ -63,3 +77,57 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 
 	return &mNode
 }
+
+// MerkleLeafHash 数据（交易编码）对应的叶子哈希，VerifyMerkleProof 的 txHash 参数
+func MerkleLeafHash(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// Proof 第 index 个叶子的包含证明
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+
+	proof := &MerkleProof{Index: index}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		proof.Siblings = append(proof.Siblings, level[index^1].Data)
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof 检查 txHash（见 MerkleLeafHash）是否按 proof 给出的位置包含在根为 root 的默克尔树中
+func VerifyMerkleProof(root, txHash []byte, proof *MerkleProof) error {
+	if proof == nil || proof.Index < 0 {
+		return errors.New("invalid merkle proof")
+	}
+
+	hash := txHash
+	index := proof.Index
+	for _, sibling := range proof.Siblings {
+		if index%2 == 0 {
+			hash = hashMerklePair(hash, sibling)
+		} else {
+			hash = hashMerklePair(sibling, hash)
+		}
+		index /= 2
+	}
+	if index != 0 {
+		return fmt.Errorf("leaf index %d does not fit a proof of depth %d", proof.Index, len(proof.Siblings))
+	}
+	if !bytes.Equal(hash, root) {
+		return errors.New("merkle root mismatch")
+	}
+
+	return nil
+}
+
+func hashMerklePair(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+
+	return hash[:]
+}
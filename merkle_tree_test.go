@@ -73,3 +73,86 @@ func TestNewMerkleTree(t *testing.T) {
 
 	assert.Equal(t, rootHash, fmt.Sprintf("%x", mTree.RootNode.Data), "Merkle tree root hash is correct")
 }
+
+func TestMerkleTreeOddLevels(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		var data [][]byte
+		for i := 0; i < n; i++ {
+			data = append(data, []byte(fmt.Sprintf("node%d", i+1)))
+		}
+
+		// 逐层计算，奇数层复制最后一个节点
+		level := [][]byte{}
+		for _, datum := range data {
+			level = append(level, MerkleLeafHash(datum))
+		}
+		for {
+			if len(level)%2 != 0 {
+				level = append(level, level[len(level)-1])
+			}
+			var next [][]byte
+			for i := 0; i < len(level); i += 2 {
+				next = append(next, hashMerklePair(level[i], level[i+1]))
+			}
+			level = next
+			if len(level) == 1 {
+				break
+			}
+		}
+
+		mTree := NewMerkleTree(data)
+		assert.Equal(t, level[0], mTree.RootNode.Data, "root of %d leaves", n)
+
+		for i, datum := range data {
+			proof, err := mTree.Proof(i)
+			assert.Nil(t, err)
+			assert.Nil(t, VerifyMerkleProof(mTree.RootNode.Data, MerkleLeafHash(datum), proof), "leaf %d of %d", i, n)
+
+			other := []byte("other")
+			assert.NotNil(t, VerifyMerkleProof(mTree.RootNode.Data, MerkleLeafHash(other), proof))
+			// 与复制出来的节点相邻时左右交换结果不变，其余情况下标错误必须验证失败
+			if fmt.Sprintf("%x", proof.Siblings[0]) != fmt.Sprintf("%x", MerkleLeafHash(datum)) {
+				moved := &MerkleProof{i ^ 1, proof.Siblings}
+				assert.NotNil(t, VerifyMerkleProof(mTree.RootNode.Data, MerkleLeafHash(datum), moved), "wrong index")
+			}
+		}
+		_, err := mTree.Proof(n)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestVerifyMerkleProofIndexOutOfDepth(t *testing.T) {
+	data := [][]byte{[]byte("node1"), []byte("node2")}
+	mTree := NewMerkleTree(data)
+	proof, err := mTree.Proof(0)
+	assert.Nil(t, err)
+
+	assert.NotNil(t, VerifyMerkleProof(mTree.RootNode.Data, MerkleLeafHash(data[0]), &MerkleProof{2, proof.Siblings}))
+	assert.NotNil(t, VerifyMerkleProof(mTree.RootNode.Data, MerkleLeafHash(data[0]), nil))
+}
+
+func TestTransactionProof(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+
+	var txs []*Transaction
+	for i := 0; i < 5; i++ {
+		txs = append(txs, NewCoinbaseTX(address, fmt.Sprintf("tx %d", i)))
+	}
+	block := NewBlock(txs, genesis.Hash, 1, 1, nil)
+	bc := testChain(t, genesis, block)
+
+	found, proof, err := bc.TransactionProof(txs[4].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, found.Hash)
+	assert.Equal(t, 4, proof.Index)
+
+	header := found.Header()
+	assert.Equal(t, found.Hash, header.Hash(), "header hashes to the block hash")
+	assert.Nil(t, VerifyMerkleProof(header.MerkleRoot, MerkleLeafHash(txs[4].Serialize()), proof))
+	assert.NotNil(t, VerifyMerkleProof(header.MerkleRoot, MerkleLeafHash(txs[3].Serialize()), proof))
+
+	_, _, err = bc.TransactionProof([]byte("missing"))
+	assert.NotNil(t, err)
+}
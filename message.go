@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// signedMessageMagic 签名消息的前缀，避免消息签名被当作交易签名使用
+const signedMessageMagic = "blockchain_go Signed Message:\n"
+
+// compactSignatureLen 紧凑签名：1 字节头部（27 + recid）加上各补齐到 32 字节的 r 和 s
+const compactSignatureLen = 1 + ecdsaSignatureLen
+
+// compactSignatureHeader 头部减去此值得到 recid（0-3）
+const compactSignatureHeader = 27
+
+var errInvalidCompactSignature = errors.New("invalid compact signature")
+
+// messageHash 消息签名的摘要：对带前缀的消息做双 SHA256
+func messageHash(message string) []byte {
+	var buff bytes.Buffer
+	writeVarBytes(&buff, []byte(signedMessageMagic))
+	writeVarBytes(&buff, []byte(message))
+
+	return doubleSHA256(buff.Bytes())
+}
+
+// SignMessage 用钱包私钥对消息签名，返回 base64 编码的紧凑可恢复签名。
+// 验证者只需要地址、消息和签名，不需要钱包文件或公钥。
+func (w Wallet) SignMessage(message string) (string, error) {
+	signature, err := w.signCompact(messageHash(message))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// signCompact 对摘要签名，返回可以恢复出公钥的紧凑签名
+func (w Wallet) signCompact(hash []byte) ([]byte, error) {
+	if w.IsLocked() {
+		return nil, errWalletLocked
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, &w.PrivateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只使用较小的 s，(r, N-s) 同样有效，不规定的话签名可以被第三方改写
+	if n := w.PrivateKey.Curve.Params().N; s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+
+	signature := make([]byte, compactSignatureLen)
+	r.FillBytes(signature[1 : 1+ecdsaSignatureLen/2])
+	s.FillBytes(signature[1+ecdsaSignatureLen/2:])
+	for recid := 0; recid < 4; recid++ {
+		signature[0] = byte(compactSignatureHeader + recid)
+		pubKey, err := RecoverPubKey(hash, signature)
+		if err == nil && bytes.Equal(pubKey, w.PublicKey) {
+			return signature, nil
+		}
+	}
+
+	return nil, errors.New("cannot compute recovery id")
+}
+
+// SignMessage 用钱包中 address 的私钥对消息签名
+func (ws Wallets) SignMessage(address, message string) (string, error) {
+	wallet := ws.Wallets[address]
+	if wallet == nil {
+		return "", fmt.Errorf("address %s is not in the wallet", address)
+	}
+
+	return wallet.SignMessage(message)
+}
+
+// VerifyMessage 从签名中恢复公钥，检查它的哈希与地址一致
+func VerifyMessage(address, signature, message string) (bool, error) {
+	if !ValidateAddress(address) {
+		return false, errors.New("address is not valid")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, err
+	}
+
+	pubKey, err := RecoverPubKey(messageHash(message), sig)
+	if err != nil {
+		return false, err
+	}
+	pubKeyHash := Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+
+	return bytes.Equal(HashPubKey(pubKey), pubKeyHash), nil
+}
+
+// RecoverPubKey 由摘要和紧凑签名恢复签名者的公钥（X 和 Y 各 32 字节）：
+// R 的 x 坐标为 r + (recid/2)·n，y 坐标的奇偶由 recid 的最低位决定，公钥 Q = r⁻¹(sR - eG)。
+// s 大于 n/2 的签名不接受，每个签名只有一种写法
+func RecoverPubKey(hash, signature []byte) ([]byte, error) {
+	if len(signature) != compactSignatureLen {
+		return nil, errInvalidCompactSignature
+	}
+	recid := int(signature[0]) - compactSignatureHeader
+	if recid < 0 || recid > 3 {
+		return nil, errInvalidCompactSignature
+	}
+
+	curve := elliptic.P256()
+	params := curve.Params()
+	r := new(big.Int).SetBytes(signature[1 : 1+ecdsaSignatureLen/2])
+	s := new(big.Int).SetBytes(signature[1+ecdsaSignatureLen/2:])
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(params.N) >= 0 || s.Cmp(new(big.Int).Rsh(params.N, 1)) > 0 {
+		return nil, errInvalidCompactSignature
+	}
+
+	x := new(big.Int).Set(r)
+	if recid&2 != 0 {
+		x.Add(x, params.N)
+	}
+	if x.Cmp(params.P) >= 0 {
+		return nil, errInvalidCompactSignature
+	}
+	y := decompressY(params, x, uint(recid&1))
+	if y == nil {
+		return nil, errInvalidCompactSignature
+	}
+
+	e := new(big.Int).SetBytes(hash)
+	e.Mod(e, params.N)
+	negE := new(big.Int).Sub(params.N, e)
+	negE.Mod(negE, params.N)
+
+	sRx, sRy := curve.ScalarMult(x, y, s.Bytes())
+	eGx, eGy := curve.ScalarBaseMult(negE.Bytes())
+	sumX, sumY := curve.Add(sRx, sRy, eGx, eGy)
+	rInv := new(big.Int).ModInverse(r, params.N)
+	qx, qy := curve.ScalarMult(sumX, sumY, rInv.Bytes())
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		return nil, errInvalidCompactSignature
+	}
+
+	return marshalPubKey(ecdsa.PublicKey{Curve: curve, X: qx, Y: qy}), nil
+}
+
+// decompressY 求曲线上横坐标为 x、奇偶为 odd 的点的纵坐标；P-256 的 p ≡ 3 (mod 4)，
+// 平方根为 a^((p+1)/4)。x 不在曲线上时返回 nil
+func decompressY(params *elliptic.CurveParams, x *big.Int, odd uint) *big.Int {
+	// y² = x³ - 3x + b
+	y2 := new(big.Int).Exp(x, big.NewInt(3), params.P)
+	threeX := new(big.Int).Mul(x, big.NewInt(3))
+	y2.Sub(y2, threeX)
+	y2.Add(y2, params.B)
+	y2.Mod(y2, params.P)
+
+	exp := new(big.Int).Add(params.P, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(y2, exp, params.P)
+	if new(big.Int).Exp(y, big.NewInt(2), params.P).Cmp(y2) != 0 {
+		return nil
+	}
+	if y.Bit(0) != odd {
+		y.Sub(params.P, y)
+	}
+
+	return y
+}
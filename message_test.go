@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignVerifyMessage(t *testing.T) {
+	for i := 0; i < 8; i++ {
+		wallet := NewWallet()
+		address := string(wallet.GetAddress())
+
+		signature, err := wallet.SignMessage("hello")
+		assert.Nil(t, err)
+
+		valid, err := VerifyMessage(address, signature, "hello")
+		assert.Nil(t, err)
+		assert.True(t, valid)
+
+		valid, err = VerifyMessage(address, signature, "hello!")
+		assert.False(t, valid, "a different message recovers a different key")
+
+		other := string(NewWallet().GetAddress())
+		valid, err = VerifyMessage(other, signature, "hello")
+		assert.Nil(t, err)
+		assert.False(t, valid)
+	}
+}
+
+func TestVerifyMessageRejectsMalformedSignature(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+
+	_, err := VerifyMessage(address, "not base64!", "hello")
+	assert.NotNil(t, err)
+
+	short := base64.StdEncoding.EncodeToString(make([]byte, 10))
+	_, err = VerifyMessage(address, short, "hello")
+	assert.Equal(t, errInvalidCompactSignature, err)
+
+	zero := base64.StdEncoding.EncodeToString(append([]byte{compactSignatureHeader}, make([]byte, ecdsaSignatureLen)...))
+	_, err = VerifyMessage(address, zero, "hello")
+	assert.Equal(t, errInvalidCompactSignature, err)
+
+	locked := Wallet{PublicKey: NewWallet().PublicKey}
+	_, err = locked.SignMessage("hello")
+	assert.Equal(t, errWalletLocked, err)
+}
+
+func TestCompactSignatureRejectsHighS(t *testing.T) {
+	wallet := NewWallet()
+	hash := doubleSHA256([]byte("hello"))
+	half := new(big.Int).Rsh(wallet.PrivateKey.Curve.Params().N, 1)
+
+	for i := 0; i < 8; i++ {
+		signature, err := wallet.signCompact(hash)
+		assert.Nil(t, err)
+		s := new(big.Int).SetBytes(signature[1+ecdsaSignatureLen/2:])
+		assert.True(t, s.Cmp(half) <= 0)
+
+		// (r, N-s) 配上翻转的 recid 恢复出同一个公钥，必须拒绝
+		flipped := append([]byte(nil), signature...)
+		flipped[0] ^= 1
+		new(big.Int).Sub(wallet.PrivateKey.Curve.Params().N, s).FillBytes(flipped[1+ecdsaSignatureLen/2:])
+		_, err = RecoverPubKey(hash, flipped)
+		assert.Equal(t, errInvalidCompactSignature, err)
+	}
+}
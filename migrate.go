@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// legacyBackupSuffix 迁移完成后旧数据库保留的备份文件后缀
+const legacyBackupSuffix = ".legacy.bak"
+
+// legacyTargetBits 旧格式数据库的区块使用的工作量证明难度
+const legacyTargetBits = 16
+
+var errAlreadyMigrated = errors.New("database already uses the canonical encoding")
+
+// MigrateBlockchainDB 把 gob 编码的旧区块库转换为规范二进制编码。
+//
+// 从 tip 沿 PrevBlockHash 回溯到创世区块，再从创世区块开始按新格式重新编码：
+// 交易 ID 按 Transaction.Hash 的规则重新计算，后续交易输入中的 Txid 同步替换；
+// 区块头写入区块接入后 UTXO 集的承诺，原本满足工作量证明的区块按新的区块头重新挖矿，hotstuff 区块直接取区块头哈希。
+// 只迁移主链，旧库中不在主链上的区块会被丢弃。
+// 旧签名原样保留：输入引用的 Txid 变了，旧签名在新库里无法再通过验证，历史交易不会被重新验证。
+// 新库写完后重建 UTXO 集，旧库改名为 path+".legacy.bak" 保留。
+func MigrateBlockchainDB(path string) (int, error) {
+	if !dbExists(path) {
+		return 0, fmt.Errorf("%s does not exist", path)
+	}
+
+	blocks, err := readLegacyChain(path)
+	if err != nil {
+		return 0, err
+	}
+
+	newPath := path + ".migrating"
+	os.Remove(newPath)
+	db, err := openBoltStore(newPath, 0600, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	txids := make(map[string][]byte)
+	utxos, acc := chainState{newUTXOOverlay(nil)}, newUTXOAccumulator()
+	var prevHash []byte
+	err = db.Update(func(tx StoreTx) error {
+		b, err := createBlockStore(tx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(blocks) - 1; i >= 0; i-- {
+			block := blocks[i]
+			minedByPoW := legacyPoWValid(block)
+
+			for _, t := range block.Transactions {
+				for j := range t.Vin {
+					if newID, ok := txids[hex.EncodeToString(t.Vin[j].Txid)]; ok {
+						t.Vin[j].Txid = newID
+					}
+				}
+				oldID := hex.EncodeToString(t.ID)
+				t.ID = t.Hash()
+				txids[oldID] = t.ID
+			}
+
+			if prevHash != nil {
+				block.PrevBlockHash = prevHash
+			}
+			if _, err := applyBlockRecords(utxos, acc, block); err != nil {
+				return fmt.Errorf("block at height %d: %v", block.Height, err)
+			}
+			block.UTXOCommitment = acc.Commitment()
+			if minedByPoW {
+				block.Nonce, block.Hash = NewProofOfWork(block).Run()
+			} else {
+				block.Nonce = 0
+				block.Hash = block.ComputeHash()
+			}
+
+			if err := b.Put(block); err != nil {
+				return err
+			}
+			prevHash = block.Hash
+		}
+
+		if err := b.SetTip(prevHash); err != nil {
+			return err
+		}
+
+		return putStorageFormat(tx)
+	})
+	if err != nil {
+		db.Close()
+		os.Remove(newPath)
+		return 0, err
+	}
+
+	UTXOSet := UTXOSet{&Blockchain{tip: prevHash, db: db}}
+	UTXOSet.Reindex()
+	db.Close()
+
+	if err := os.Rename(path, path+legacyBackupSuffix); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(newPath, path); err != nil {
+		return 0, err
+	}
+
+	return len(blocks), nil
+}
+
+// readLegacyChain 读出旧库的主链，顺序为 tip 到创世区块
+func readLegacyChain(path string) ([]*Block, error) {
+	db, err := openBoltStore(path, 0600, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var blocks []*Block
+	err = db.View(func(tx StoreTx) error {
+		if storageFormat(tx) == storageFormatVersion {
+			return errAlreadyMigrated
+		}
+		b := tx.Bucket(blocksBucket)
+		if b == nil {
+			return errors.New("no blocks bucket in database")
+		}
+
+		hash := b.Get([]byte(tipKey))
+		for len(hash) > 0 {
+			data := b.Get(hash)
+			if data == nil {
+				return fmt.Errorf("block %x is missing", hash)
+			}
+			var block Block
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&block); err != nil {
+				return fmt.Errorf("decode legacy block %x: %v", hash, err)
+			}
+			blocks = append(blocks, &block)
+			hash = block.PrevBlockHash
+		}
+
+		return nil
+	})
+
+	return blocks, err
+}
+
+// legacyPoWValid 按旧的区块头格式（gob 序列化交易的默克尔根）检查区块是否满足工作量证明
+func legacyPoWValid(block *Block) bool {
+	var transactions [][]byte
+	for _, tx := range block.Transactions {
+		var encoded bytes.Buffer
+		if err := gob.NewEncoder(&encoded).Encode(*tx); err != nil {
+			return false
+		}
+		transactions = append(transactions, encoded.Bytes())
+	}
+	if len(transactions) == 0 {
+		return false
+	}
+
+	data := bytes.Join(
+		[][]byte{
+			block.PrevBlockHash,
+			NewMerkleTree(transactions).RootNode.Data,
+			IntToHex(block.Timestamp),
+			IntToHex(int64(legacyTargetBits)),
+			IntToHex(int64(block.Nonce)),
+		},
+		[]byte{},
+	)
+	hash := sha256.Sum256(data)
+
+	var hashInt big.Int
+	hashInt.SetBytes(hash[:])
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-legacyTargetBits))
+
+	return hashInt.Cmp(target) == -1 && bytes.Equal(hash[:], block.Hash)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// maxOrphanBlocks 孤块池最多保留的区块数，超出时丢弃最早加入的
+const maxOrphanBlocks = 100
+
+var errOrphanBlock = errors.New("parent block is not known")
+
+// orphanBlock 父区块还不在本地的区块，连同对方发来的证书一起保存
+type orphanBlock struct {
+	block *Block
+	qc    []byte
+}
+
+// orphanPool 等待父区块的孤块。父区块接入后由 take 取出，孤块不经检查不会写入区块库
+type orphanPool struct {
+	mu     sync.Mutex
+	blocks map[string]orphanBlock
+	order  []string
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{blocks: make(map[string]orphanBlock)}
+}
+
+// add 加入孤块，已经在池中时忽略
+func (p *orphanPool) add(orphan orphanBlock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := string(orphan.block.Hash)
+	if _, ok := p.blocks[key]; ok {
+		return
+	}
+	if len(p.order) >= maxOrphanBlocks {
+		delete(p.blocks, p.order[0])
+		p.order = p.order[1:]
+	}
+	p.blocks[key] = orphan
+	p.order = append(p.order, key)
+}
+
+// take 取出以 parent 为父区块的孤块
+func (p *orphanPool) take(parent []byte) []orphanBlock {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var children []orphanBlock
+	var order []string
+	for _, key := range p.order {
+		orphan := p.blocks[key]
+		if bytes.Equal(orphan.block.PrevBlockHash, parent) {
+			children = append(children, orphan)
+			delete(p.blocks, key)
+			continue
+		}
+		order = append(order, key)
+	}
+	p.order = order
+
+	return children
+}
+
+// size 池中孤块的个数
+func (p *orphanPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.order)
+}
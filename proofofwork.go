@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"math"
@@ -12,7 +11,6 @@ var (
 	maxNonce = math.MaxInt64
 )
 
-const targetBits = 16
 
 // ProofOfWork represents a proof-of-work
 type ProofOfWork struct {
@@ -31,7 +29,7 @@ type ProofOfWork struct {
 //在比特币和类似的区块链系统中，工作量证明是用于保证区块链安全性的机制之一。
 func NewProofOfWork(b *Block) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target.Lsh(target, uint(256-chainParams.TargetBits))
 
 	pow := &ProofOfWork{b, target}
 
@@ -39,18 +37,7 @@ func NewProofOfWork(b *Block) *ProofOfWork {
 }
 
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
-			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+	return pow.block.HeaderBytes(nonce)
 }
 
 // Run 这段代码是工作量证明（Proof of Work）的核心算法，用于挖矿寻找有效的 `Nonce` 和区块哈希。以下是这个函数 `Run` 的关键部分：
@@ -102,3 +89,14 @@ func (pow *ProofOfWork) Validate() bool {
 
 	return isValid
 }
+
+// HasValidWork 区块头的哈希是否满足本网络的难度目标，轻节点只凭区块头验证 PoW
+func (h *BlockHeader) HasValidWork() bool {
+	var hashInt big.Int
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-chainParams.TargetBits))
+
+	hashInt.SetBytes(h.Hash())
+
+	return h.TargetBits == chainParams.TargetBits && hashInt.Cmp(target) == -1
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 区块修剪
+//
+// 修剪模式只保存链尾附近的区块体：修剪点（链尾之前第 keep-1 个区块）之前的区块体被删除，
+// 区块头移到 headers 桶，创世区块（国库定义和网络标识）始终保留。修剪点之后的 UTXO 集
+// 记为快照记录，修剪点记为快照区块（见 utxo_snapshot.go），因此查找更早的输出、验证花费它们的交易
+// 和重建 UTXO 集都与从快照启动的节点相同。撤销数据随区块体一起删除，回滚不会越过 minPruneDepth 个区块。
+//
+// 修剪后的节点照常提供区块头、过滤器和修剪点之后的区块，拒绝提供已删除的区块，
+// 并在 version 消息中告知对方自己保存区块体的最低高度
+
+const (
+	headersBucket  = "headers" // 区块哈希 -> 已删除区块体的区块头编码
+	minPruneDepth  = 10        // 至少保留的区块数
+	pruneInterval  = time.Minute
+	pruneBatchSize = 500 // 每个数据库事务删除的区块体数
+)
+
+// blockHeader 区块头，区块体已被修剪时从 headers 桶读取
+func (bc *Blockchain) blockHeader(hash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+	err := bc.db.View(func(tx StoreTx) error {
+		if block := blocksOf(tx).Block(hash); block != nil {
+			header = block.Header()
+			return nil
+		}
+		if data := indexesOf(tx).Get(headersBucket, hash); data != nil {
+			var err error
+			header, err = decodeHeader(data)
+			return err
+		}
+		return fmt.Errorf("block %x is not found", hash)
+	})
+
+	return header, err
+}
+
+// hasBlockBody 区块体是否在本地
+func (bc *Blockchain) hasBlockBody(hash []byte) bool {
+	found := false
+	bc.db.View(func(tx StoreTx) error {
+		found = blocksOf(tx).Has(hash)
+		return nil
+	})
+
+	return found
+}
+
+// PruneHeight 修剪过或从快照启动的节点保存区块体的最低高度（不计创世区块），完整节点返回 0
+func (bc *Blockchain) PruneHeight() int {
+	base := bc.snapshotBase()
+	if base == nil {
+		return 0
+	}
+	header, err := bc.blockHeader(base)
+	if err != nil {
+		return 0
+	}
+
+	return header.Height
+}
+
+// Prune 只保留链尾 keep 个区块的区块体，返回这次删除的区块体数
+func (bc *Blockchain) Prune(keep int) (int, error) {
+	if keep < minPruneDepth {
+		return 0, fmt.Errorf("keep at least %d blocks", minPruneDepth)
+	}
+	// 过滤器由区块体建立，删除之前先补齐
+	if err := bc.IndexFilters(); err != nil {
+		return 0, err
+	}
+
+	tip, err := bc.blockHeader(bc.Tip())
+	if err != nil {
+		return 0, err
+	}
+	height := tip.Height - keep + 1
+	if height <= 1 || height <= bc.PruneHeight() {
+		return 0, nil
+	}
+
+	// 找到修剪点和它之前还有区块体的区块，遇到已删除的区块为止
+	var base []byte
+	var bodies [][]byte
+	for hash := bc.Tip(); len(hash) > 0; {
+		header, err := bc.blockHeader(hash)
+		if err != nil {
+			return 0, err
+		}
+		if header.Height == 0 {
+			break
+		}
+		if header.Height == height {
+			base = hash
+		}
+		if header.Height < height {
+			if !bc.hasBlockBody(hash) {
+				break
+			}
+			bodies = append(bodies, hash)
+		}
+		hash = header.PrevBlockHash
+	}
+	if base == nil {
+		return 0, fmt.Errorf("no block at height %d", height)
+	}
+
+	entries := snapshotEntries(bc.findUTXOAt(base))
+	baseHeader, err := bc.blockHeader(base)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(baseHeader.UTXOCommitment, entriesCommitment(entries)) {
+		return 0, fmt.Errorf("UTXO set at height %d does not match the commitment %x in the block header", height, baseHeader.UTXOCommitment)
+	}
+
+	// 先换掉快照记录和快照区块，再删除区块体
+	err = bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket(utxoSnapshotBucket) != nil {
+			if err := tx.DeleteBucket(utxoSnapshotBucket); err != nil {
+				return err
+			}
+		}
+		records, err := tx.CreateBucket(utxoSnapshotBucket)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := records.Put(entry.TxID, entry.Outputs.Serialize()); err != nil {
+				return err
+			}
+		}
+
+		return indexesOf(tx).Put(metaBucket, []byte(snapshotBaseKey), base)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// 从最低的区块开始删除，中断后剩下的区块体仍与修剪点相连，下次修剪时继续删除
+	for end := len(bodies); end > 0; end -= pruneBatchSize {
+		start := end - pruneBatchSize
+		if start < 0 {
+			start = 0
+		}
+		if err := bc.db.Update(func(tx StoreTx) error {
+			return moveToHeaders(tx, bodies[start:end])
+		}); err != nil {
+			return len(bodies) - end, err
+		}
+	}
+	logInfof("pruned %d blocks, block bodies are kept from height %d", len(bodies), height)
+
+	return len(bodies), nil
+}
+
+// moveToHeaders 删除区块体和撤销数据，只把区块头留在 headers 桶
+func moveToHeaders(tx StoreTx, hashes [][]byte) error {
+	blocks := blocksOf(tx)
+	indexes := indexesOf(tx)
+	for _, hash := range hashes {
+		block := blocks.Block(hash)
+		if block == nil {
+			continue
+		}
+		if err := indexes.Put(headersBucket, hash, block.Header().Bytes()); err != nil {
+			return err
+		}
+		if err := blocks.Delete(hash); err != nil {
+			return err
+		}
+		if err := indexes.Delete(undoBucket, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// errBlockPruned 请求的区块体已被修剪
+var errBlockPruned = errors.New("block is pruned")
+
+// prunedBlock 区块体不在本地但区块头在时返回 errBlockPruned
+func (bc *Blockchain) prunedBlock(hash []byte) error {
+	if bc.hasBlockBody(hash) {
+		return nil
+	}
+	if _, err := bc.blockHeader(hash); err != nil {
+		return err
+	}
+
+	return errBlockPruned
+}
+
+// pruneBlocks 修剪模式的节点定期删除旧的区块体
+func pruneBlocks(chains *ChainService, nodeID string, keep int) {
+	for {
+		time.Sleep(pruneInterval)
+		bc := chains.Blockchain(nodeID)
+		if bc == nil {
+			continue
+		}
+		if _, err := bc.Prune(keep); err != nil {
+			logWarnf("prune: %v", err)
+		}
+		chains.Release(bc)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrune(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	other := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	utxos := UTXOSet{bc}
+	utxos.Reindex()
+	hashes, err := bc.Generate(chainParams.CoinbaseMaturity+minPruneDepth, other)
+	assert.Nil(t, err)
+	tip := bc.GetBestHeight()
+	commitment := utxos.Commitment()
+	balance := utxos.Balance(other)
+
+	_, err = bc.Prune(minPruneDepth - 1)
+	assert.NotNil(t, err)
+	n, err := bc.Prune(minPruneDepth)
+	assert.Nil(t, err)
+	height := tip - minPruneDepth + 1
+	assert.Equal(t, height-1, n, "bodies between the genesis block and the prune point are deleted")
+	assert.Equal(t, height, bc.PruneHeight())
+	n, err = bc.Prune(minPruneDepth)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// 区块头、创世区块和修剪点之后的区块都还在
+	_, err = bc.GetBlock(hashes[0])
+	assert.NotNil(t, err)
+	assert.Equal(t, errBlockPruned, bc.prunedBlock(hashes[0]))
+	assert.Nil(t, bc.prunedBlock(hashes[height-1]))
+	_, err = bc.GetBlock(genesis.Hash)
+	assert.Nil(t, err)
+	genesisHash, err := bc.GenesisHash()
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Hash, genesisHash)
+	headers, _, err := bc.HeadersAfter(-1, maxHeadersPerMessage)
+	assert.Nil(t, err)
+	assert.Len(t, headers, tip+1)
+	assert.Equal(t, hashes[0], headers[1].Hash())
+	filter, err := bc.BlockFilter(hashes[0])
+	assert.Nil(t, err)
+	assert.NotNil(t, filter, "filters are built before pruning")
+
+	// UTXO 集不变，重建后也相同
+	assert.Equal(t, commitment, utxos.Commitment())
+	utxos.Reindex()
+	assert.Equal(t, commitment, utxos.Commitment())
+	assert.Equal(t, balance, utxos.Balance(other))
+	_, err = utxos.AuditSupply()
+	assert.NotNil(t, err, "the audit needs every block")
+	_, err = bc.Snapshot(1)
+	assert.NotNil(t, err)
+	chainParams.GenesisHash = hex.EncodeToString(genesis.Hash)
+	s, err := bc.Snapshot(-1)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Verify())
+
+	// 花费修剪点之前的创世区块输出，新区块照常验证
+	wallets := &Wallets{Wallets: map[string]*Wallet{address: wallet}}
+	tx := NewUTXOTransaction(wallets, address, other, 5, &utxos, DefaultSendOptions())
+	assert.True(t, bc.VerifyTransaction(tx))
+	spend := bc.MineBlock([]*Transaction{bc.NewRewardTX(other, []*Transaction{tx}), tx})
+	utxos.Update(spend)
+	_, err = bc.Generate(3, other)
+	assert.Nil(t, err)
+	n, err = bc.Prune(minPruneDepth)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	commitment = utxos.Commitment()
+	utxos.Reindex()
+	assert.Equal(t, commitment, utxos.Commitment())
+}
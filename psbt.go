@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// psbtVersion 部分签名交易 JSON 格式的版本
+const psbtVersion = 1
+
+// PSBT 部分签名交易：未签名交易连同每个输入所花费的输出一起传递，
+// 签名者只需要私钥和这份数据，不需要访问区块链或节点的钱包文件。
+// 签名保存在 Tx.Vin 中，PrevOuts 与 Tx.Vin 一一对应。
+type PSBT struct {
+	Tx       Transaction
+	PrevOuts []TXOutput
+}
+
+type psbtJSON struct {
+	Version  int           `json:"version"`
+	Tx       string        `json:"tx"`
+	PrevOuts []psbtPrevOut `json:"prevouts"`
+}
+
+type psbtPrevOut struct {
+	Value      int    `json:"value"`
+	PubKeyHash string `json:"pubkeyhash"`
+}
+
+// NewPSBT 用 UTXO 集为 from 地址创建一笔未签名的转账交易，找零返回 from
+func NewPSBT(from, to string, amount int, UTXOSet *UTXOSet) (*PSBT, error) {
+	if !ValidateAddress(from) {
+		return nil, errors.New("sender address is not valid")
+	}
+	if !ValidateAddress(to) {
+		return nil, errors.New("recipient address is not valid")
+	}
+	if amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+
+	pubKeyHash := Base58Decode([]byte(from))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	if acc < amount {
+		return nil, errors.New("not enough funds")
+	}
+
+	txids := make([]string, 0, len(validOutputs))
+	for txid := range validOutputs {
+		txids = append(txids, txid)
+	}
+	sort.Strings(txids)
+
+	psbt := &PSBT{}
+	for _, txid := range txids {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			return nil, err
+		}
+		prevTx, err := UTXOSet.Blockchain.FindTransaction(txID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, out := range validOutputs[txid] {
+			if out >= len(prevTx.Vout) {
+				return nil, fmt.Errorf("previous transaction %s has no output %d", txid, out)
+			}
+			psbt.Tx.Vin = append(psbt.Tx.Vin, TXInput{txID, out, nil, nil})
+			psbt.PrevOuts = append(psbt.PrevOuts, prevTx.Vout[out])
+		}
+	}
+
+	psbt.Tx.Vout = append(psbt.Tx.Vout, *NewTXOutput(amount, to))
+	if acc > amount {
+		psbt.Tx.Vout = append(psbt.Tx.Vout, *NewTXOutput(acc-amount, from)) // a change
+	}
+	psbt.Tx.ID = psbt.Tx.Hash()
+
+	return psbt, nil
+}
+
+// Sign 用钱包私钥为所有能解锁、且尚未签名的输入签名，返回本次签名的输入个数
+func (p *PSBT) Sign(wallet *Wallet, hashType SigHashType) (int, error) {
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	signed := 0
+
+	for i := range p.Tx.Vin {
+		if len(p.Tx.Vin[i].Signature) != 0 || !p.PrevOuts[i].IsLockedWithKey(pubKeyHash) {
+			continue
+		}
+		p.Tx.Vin[i].PubKey = wallet.PublicKey
+		if err := p.Tx.SignInput(wallet.PrivateKey, i, p.PrevOuts, hashType); err != nil {
+			return signed, err
+		}
+		signed++
+	}
+
+	return signed, nil
+}
+
+// Combine 把其他签名者签过的同一笔交易中的签名合并进来
+func (p *PSBT) Combine(other *PSBT) error {
+	if !bytes.Equal(p.Tx.ID, other.Tx.ID) || len(p.Tx.Vin) != len(other.Tx.Vin) {
+		return errors.New("cannot combine different transactions")
+	}
+
+	for i, vin := range other.Tx.Vin {
+		if len(vin.Signature) == 0 || len(p.Tx.Vin[i].Signature) != 0 {
+			continue
+		}
+		p.Tx.Vin[i].Signature = vin.Signature
+		p.Tx.Vin[i].PubKey = vin.PubKey
+	}
+
+	return nil
+}
+
+// IsComplete 所有输入是否都已签名
+func (p *PSBT) IsComplete() bool {
+	for _, vin := range p.Tx.Vin {
+		if len(vin.Signature) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Finalize 检查所有签名后返回可以广播的交易
+func (p *PSBT) Finalize() (*Transaction, error) {
+	if !p.IsComplete() {
+		return nil, errors.New("transaction is not fully signed")
+	}
+	if !bytes.Equal(p.Tx.ID, p.Tx.Hash()) {
+		return nil, errors.New("transaction ID does not match the unsigned transaction")
+	}
+	for i := range p.Tx.Vin {
+		if !p.Tx.VerifyInput(i, p.PrevOuts) {
+			return nil, fmt.Errorf("invalid signature for input %d", i)
+		}
+	}
+
+	tx := p.Tx
+	return &tx, nil
+}
+
+// MarshalJSON 交易以规范编码的 hex 保存，签名随交易一起导出
+func (p PSBT) MarshalJSON() ([]byte, error) {
+	out := psbtJSON{Version: psbtVersion, Tx: hex.EncodeToString(p.Tx.Serialize())}
+	for _, prevOut := range p.PrevOuts {
+		out.PrevOuts = append(out.PrevOuts, psbtPrevOut{prevOut.Value, hex.EncodeToString(prevOut.PubKeyHash)})
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON 解析 MarshalJSON 导出的格式
+func (p *PSBT) UnmarshalJSON(data []byte) error {
+	var in psbtJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if in.Version != psbtVersion {
+		return fmt.Errorf("unsupported psbt version %d", in.Version)
+	}
+
+	raw, err := hex.DecodeString(in.Tx)
+	if err != nil {
+		return err
+	}
+	tx, err := decodeTransaction(raw)
+	if err != nil {
+		return err
+	}
+	if len(in.PrevOuts) != len(tx.Vin) {
+		return errors.New("previous outputs do not match inputs")
+	}
+
+	prevOuts := make([]TXOutput, len(in.PrevOuts))
+	for i, prevOut := range in.PrevOuts {
+		pubKeyHash, err := hex.DecodeString(prevOut.PubKeyHash)
+		if err != nil {
+			return err
+		}
+		prevOuts[i] = TXOutput{prevOut.Value, pubKeyHash}
+	}
+
+	p.Tx = tx
+	p.PrevOuts = prevOuts
+	return nil
+}
+
+// Base64 导出为 base64 编码的 JSON，便于复制粘贴
+func (p *PSBT) Base64() string {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// DecodePSBT 解析 JSON 或 base64 编码的部分签名交易
+func DecodePSBT(s string) (*PSBT, error) {
+	s = strings.TrimSpace(s)
+	data := []byte(s)
+	if !strings.HasPrefix(s, "{") {
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("psbt is neither JSON nor base64: %v", err)
+		}
+		data = decoded
+	}
+
+	var p PSBT
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newPSBTFixture 用 sighash 的测试交易构造一份未签名的 PSBT
+func newPSBTFixture() (*Wallet, *Wallet, *PSBT) {
+	alice, bob, prevTXs, tx := newSighashFixture()
+	for i := range tx.Vin {
+		tx.Vin[i].PubKey = nil
+	}
+	tx.ID = tx.Hash()
+	prevOuts, _ := tx.prevOutputs(prevTXs)
+
+	return alice, bob, &PSBT{*tx, prevOuts}
+}
+
+func TestPSBTSignCombineFinalize(t *testing.T) {
+	alice, bob, psbt := newPSBTFixture()
+
+	aliceCopy, err := DecodePSBT(psbt.Base64())
+	assert.Nil(t, err)
+	bobCopy, err := DecodePSBT(psbt.Base64())
+	assert.Nil(t, err)
+
+	n, err := aliceCopy.Sign(alice, SigHashAll)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n, "alice can only sign her own input")
+	n, err = bobCopy.Sign(bob, SigHashAll)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = aliceCopy.Finalize()
+	assert.NotNil(t, err, "a partially signed transaction cannot be finalized")
+
+	combined, err := DecodePSBT(aliceCopy.Base64())
+	assert.Nil(t, err)
+	assert.Nil(t, combined.Combine(bobCopy))
+	assert.True(t, combined.IsComplete())
+
+	tx, err := combined.Finalize()
+	assert.Nil(t, err)
+	assert.Equal(t, psbt.Tx.ID, tx.ID)
+	assert.True(t, tx.VerifyInput(0, psbt.PrevOuts))
+	assert.True(t, tx.VerifyInput(1, psbt.PrevOuts))
+}
+
+func TestPSBTRejectsTampering(t *testing.T) {
+	alice, bob, psbt := newPSBTFixture()
+	psbt.Sign(alice, SigHashAll)
+	psbt.Sign(bob, SigHashAll)
+
+	psbt.Tx.Vout[0].Value++
+	_, err := psbt.Finalize()
+	assert.NotNil(t, err)
+
+	_, _, other := newPSBTFixture()
+	assert.NotNil(t, psbt.Combine(other), "signatures of different transactions are not combined")
+
+	_, err = DecodePSBT("not a psbt")
+	assert.NotNil(t, err)
+}
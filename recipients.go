@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Recipient 一笔付款的收款地址和金额
+type Recipient struct {
+	Address string
+	Amount  int
+}
+
+// ParseRecipient 解析 "ADDRESS:AMOUNT" 形式的收款人
+func ParseRecipient(s string) (Recipient, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return Recipient{}, fmt.Errorf("recipient %q is not in ADDRESS:AMOUNT form", s)
+	}
+
+	return newRecipient(s[:i], s[i+1:])
+}
+
+// ReadRecipientsCSV 读取每行 "ADDRESS,AMOUNT" 的收款人列表，空行和 # 开头的行被忽略
+func ReadRecipientsCSV(r io.Reader) ([]Recipient, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var recipients []Recipient
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		recipient, err := newRecipient(record[0], record[1])
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
+
+// ReadRecipientsFile 从 CSV 文件读取收款人列表
+func ReadRecipientsFile(path string) ([]Recipient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadRecipientsCSV(file)
+}
+
+// TotalAmount 所有收款人的金额之和
+func TotalAmount(recipients []Recipient) int {
+	total := 0
+	for _, recipient := range recipients {
+		total += recipient.Amount
+	}
+
+	return total
+}
+
+// validateRecipients 收款人列表不能为空，地址必须有效，金额必须为正
+func validateRecipients(recipients []Recipient) error {
+	if len(recipients) == 0 {
+		return errors.New("no recipients")
+	}
+	for _, recipient := range recipients {
+		if !ValidateAddress(recipient.Address) {
+			return fmt.Errorf("recipient address %s is not valid", recipient.Address)
+		}
+		if recipient.Amount <= 0 {
+			return fmt.Errorf("amount for %s must be positive", recipient.Address)
+		}
+	}
+
+	return nil
+}
+
+func newRecipient(address, amount string) (Recipient, error) {
+	address = strings.TrimSpace(address)
+	value, err := strconv.Atoi(strings.TrimSpace(amount))
+	if err != nil {
+		return Recipient{}, fmt.Errorf("invalid amount for %s: %v", address, err)
+	}
+
+	return Recipient{address, value}, nil
+}
+
+// sendRecipients 合并 send 命令的参数：不带金额的 -to 只能有一个，金额取 -amount；
+// 带金额的 -to 和 -csv 文件中的收款人依次追加
+func sendRecipients(to []string, amount int, csvPath string) ([]Recipient, error) {
+	var recipients []Recipient
+	for _, s := range to {
+		if !strings.Contains(s, ":") {
+			if len(to) != 1 || amount <= 0 {
+				return nil, errors.New("-to ADDRESS needs -amount and cannot be combined with other recipients")
+			}
+			recipients = append(recipients, Recipient{s, amount})
+			continue
+		}
+		recipient, err := ParseRecipient(s)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if csvPath != "" {
+		fromFile, err := ReadRecipientsFile(csvPath)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, fromFile...)
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients, use -to or -csv")
+	}
+
+	return recipients, nil
+}
+
+// recipientFlags 可以重复的 -to ADDRESS:AMOUNT 命令行参数
+type recipientFlags []string
+
+func (f *recipientFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *recipientFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
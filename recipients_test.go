@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecipients(t *testing.T) {
+	a := string(NewWallet().GetAddress())
+	b := string(NewWallet().GetAddress())
+
+	recipient, err := ParseRecipient(a + ":5")
+	assert.Nil(t, err)
+	assert.Equal(t, Recipient{a, 5}, recipient)
+	_, err = ParseRecipient(a)
+	assert.NotNil(t, err)
+
+	recipients, err := ReadRecipientsCSV(strings.NewReader("# rewards\n" + a + ",5\n\n" + b + ", 7\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Recipient{{a, 5}, {b, 7}}, recipients)
+	assert.Equal(t, 12, TotalAmount(recipients))
+
+	_, err = ReadRecipientsCSV(strings.NewReader(a + ",five\n"))
+	assert.NotNil(t, err)
+
+	recipients, err = sendRecipients([]string{a}, 3, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []Recipient{{a, 3}}, recipients)
+	_, err = sendRecipients([]string{a, b + ":1"}, 3, "")
+	assert.NotNil(t, err, "a plain address cannot be mixed with other recipients")
+
+	assert.NotNil(t, validateRecipients([]Recipient{{a, 0}}))
+	assert.NotNil(t, validateRecipients([]Recipient{{"bogus", 1}}))
+}
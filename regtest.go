@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errNotRegtest generate 只能在回归测试网上使用
+var errNotRegtest = errors.New("generate is only available on regtest")
+
+// Generate 在回归测试网上立即挖出 n 个只包含奖励交易的区块，奖励发给 address，返回新区块的哈希
+func (bc *Blockchain) Generate(n int, address string) ([][]byte, error) {
+	if chainParams.Network != "regtest" {
+		return nil, errNotRegtest
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("number of blocks must be positive, got %d", n)
+	}
+	if !ValidateAddress(address) {
+		return nil, fmt.Errorf("address %s is not valid on %s", address, chainParams.Network)
+	}
+
+	UTXOSet := UTXOSet{bc}
+	var hashes [][]byte
+	for i := 0; i < n; i++ {
+		block := bc.MineBlock([]*Transaction{bc.NewRewardTX(address, nil)})
+		UTXOSet.Update(block)
+		hashes = append(hashes, block.Hash)
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useChainParams 在测试期间切换网络参数
+func useChainParams(t *testing.T, params *ChainParams) {
+	previous := chainParams
+	chainParams = params
+	t.Cleanup(func() { chainParams = previous })
+}
+
+func TestNetworkAddressVersions(t *testing.T) {
+	wallet := NewWallet()
+	mainAddress := string(wallet.GetAddress())
+	key := EncodePrivateKey(wallet.PrivateKey)
+	main, err := chainParams.NewGenesisBlock(mainAddress)
+	assert.Nil(t, err)
+
+	useChainParams(t, testnetChainParams())
+	testAddress := string(wallet.GetAddress())
+	assert.NotEqual(t, mainAddress, testAddress)
+	assert.True(t, ValidateAddress(testAddress))
+	assert.False(t, ValidateAddress(mainAddress), "a mainnet address is not valid on testnet")
+	_, err = DecodePrivateKey(key)
+	assert.NotNil(t, err, "a mainnet private key is not valid on testnet")
+
+	test, err := chainParams.NewGenesisBlock(testAddress)
+	assert.Nil(t, err)
+	assert.NotEqual(t, main.Hash, test.Hash)
+
+	// 网络参数中的地址按参数自己的版本字节检查，与当前使用的网络无关
+	mainParams := defaultChainParams()
+	mainParams.Allocations = []GenesisAllocation{{mainAddress, 5}}
+	assert.Nil(t, mainParams.Validate())
+	_, err = mainParams.NewGenesisBlock("")
+	assert.Nil(t, err)
+	mainParams.Allocations = []GenesisAllocation{{testAddress, 5}}
+	assert.NotNil(t, mainParams.Validate(), "a testnet allocation in mainnet parameters")
+	_, err = defaultChainParams().NewGenesisBlock(testAddress)
+	assert.NotNil(t, err)
+	mainParams.Allocations = []GenesisAllocation{{mainAddress, 5}}
+	mainParams.Treasury = &TreasuryConfig{1, []string{mainAddress}}
+	assert.Nil(t, mainParams.Validate())
+	_, err = mainParams.NewGenesisBlock("")
+	assert.Nil(t, err)
+	mainParams.Treasury = &TreasuryConfig{1, []string{testAddress}}
+	assert.NotNil(t, mainParams.Validate(), "a testnet treasury in mainnet parameters")
+
+	dir := filepath.Join(t.TempDir(), "testnet")
+	chainParams.DataDir = dir
+	assert.Equal(t, filepath.Join(dir, "wallet_x.dat"), chainParams.dataPath("wallet_x.dat"))
+	assert.DirExists(t, dir)
+
+	_, err = networkChainParams("simnet")
+	assert.NotNil(t, err)
+}
+
+func TestGenerate(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	_, err = bc.Generate(1, address)
+	assert.Equal(t, errNotRegtest, err)
+
+	useChainParams(t, regtestChainParams())
+	address = string(NewWallet().GetAddress())
+	genesis, err = chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc = testChain(t, genesis)
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	hashes, err := bc.Generate(chainParams.CoinbaseMaturity, address)
+	assert.Nil(t, err)
+	assert.Len(t, hashes, chainParams.CoinbaseMaturity)
+	assert.Equal(t, chainParams.CoinbaseMaturity, bc.GetBestHeight())
+
+	// 下一个区块可以花费创世输出和第一个奖励
+	outs := UTXOSet.SpendableOutputs(addressPubKeyHash(address))
+	assert.Len(t, outs, 2)
+
+	report, err := UTXOSet.AuditSupply()
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+}
@@ -33,6 +33,7 @@ var knownShardingNodes = [][]string{} //分片节点信息列表、
 //var knownShardingList = []string{}
 var RelatedSharding = make(map[string]int) //关联分片信息 因为map没赋值的默认为0,为避免与分片0冲突，所以都+1，实际用时要-1
 var blocksInTransit = [][]byte{}
+var orphanBlocks = newOrphanPool()
 var mempool = make(map[string]Transaction)
 var firsthandleproposal = 0
 var initversionflag = 0
@@ -55,6 +56,38 @@ var belongToInt = -1   //节点所属分片
 var startTime time.Time
 var endTime time.Time
 
+// Transport 节点之间发送消息的方式，默认每条消息建立一个 TCP 连接。测试中的 SimNetwork 换成进程内的模拟网络
+type Transport interface {
+	// Send 把 data 发给 addr，对方不可达时返回错误
+	Send(addr string, data []byte) error
+}
+
+// tcpTransport 每条消息建立一个 TCP 连接
+type tcpTransport struct{}
+
+func (tcpTransport) Send(addr string, data []byte) error {
+	conn, err := net.Dial(protocol, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = io.Copy(conn, bytes.NewReader(data))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return nil
+}
+
+var transport Transport = tcpTransport{}
+
+// runAsync 在新的 goroutine 中运行 f。模拟网络把它换成虚拟时钟上的事件，执行顺序由随机种子决定
+var runAsync = func(f func()) { go f() }
+
+// now 当前时间，模拟网络换成虚拟时钟
+var now = time.Now
+
 type addr struct {
 	AddrList []string
 }
@@ -63,6 +96,7 @@ type block struct {
 	AddrFrom string
 	Block    []byte
 	ShardID  int
+	QC       []byte // hotstuff 区块的法定人数证书，没有时为空，见 BlockQC
 }
 
 type getblocks struct {
@@ -92,10 +126,13 @@ type tx struct {
 }
 
 type Verzion struct {
-	Version    int
-	BestHeight int
-	ShardID    int
-	AddrFrom   string
+	Version     int
+	BestHeight  int
+	ShardID     int
+	AddrFrom    string
+	Network     string
+	GenesisHash []byte // 创世区块哈希不同的节点属于不同的网络，握手时互相拒绝
+	PruneHeight int    // 对方只保存这个高度及之后的区块体，0 表示完整节点，见 Blockchain.Prune
 }
 
 //type Fragmentation struct {
@@ -162,8 +199,11 @@ func sendInit(address string) {
 	sendData(address, request)
 }
 
-func sendBlock(addr string, b *Block, shardID int) {
-	data := block{nodeAddress, b.Serialize(), shardID}
+func sendBlock(addr string, b *Block, qc *BlockQC, shardID int) {
+	data := block{nodeAddress, b.Serialize(), shardID, nil}
+	if qc != nil {
+		data.QC = qc.Serialize()
+	}
 	payload := gobEncode(data)
 	request := append(commandToBytes("block"), payload...)
 
@@ -178,10 +218,10 @@ func sendBlock(addr string, b *Block, shardID int) {
 //4. 在完成数据传输后，关闭连接，释放资源。
 //总的来说，这个函数用于发送数据到指定的网络地址，如果连接失败，则更新已知节点列表以排除不可用的节点。
 //这在区块链网络中的节点之间进行通信时非常重要，以确保数据的传输和同步。
+//每条消息前加上本网络的 magic，其他网络的节点会丢弃它。
 func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
-	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
+	if err := transport.Send(addr, chainParams.wrapMessage(data)); err != nil {
+		logWarnf("%s is not available", addr)
 		var updatedNodes []string
 
 		for _, node := range knownNodes {
@@ -191,14 +231,6 @@ func sendData(addr string, data []byte) {
 		}
 
 		knownNodes = updatedNodes
-
-		return
-	}
-	defer conn.Close()
-
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		log.Panic(err)
 	}
 }
 
@@ -259,7 +291,12 @@ func sendVersion(addr string, bc *Blockchain, shardID int) {
 	bestHeight := bc.GetBestHeight()
 	fmt.Println("NodeIPAddress", NodeIPAddress)
 
-	Verzion := Verzion{nodeVersion, bestHeight, shardID, NodeIPAddress}
+	genesisHash, err := bc.GenesisHash()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	Verzion := Verzion{nodeVersion, bestHeight, shardID, NodeIPAddress, chainParams.Network, genesisHash, bc.PruneHeight()}
 	payload := gobEncode(Verzion)
 	request := append(commandToBytes("version"), payload...)
 	fmt.Println("sendData(addr, request):", addr)
@@ -430,12 +467,12 @@ func handleAddr(request []byte) {
 	requestBlocks()
 }
 
-func handleBlock(request []byte) {
+func handleBlock(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload block
 
-	bc := NewBlockchain(NodeIPAddress)
-	defer bc.db.Close()
+	bc := chains.Blockchain(NodeIPAddress)
+	defer chains.Release(bc)
 	buff.Write(request[commandLength:])
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
@@ -447,10 +484,7 @@ func handleBlock(request []byte) {
 	block := DeserializeBlock(blockData)
 
 	fmt.Println("Recevied a new block!")
-	bc.AddBlock(block)
-	UTXOSet := UTXOSet{bc}
-	UTXOSet.Update(block)
-	fmt.Printf("Added block %x\n", block.Hash)
+	acceptReceivedBlock(bc, orphanBlock{block, payload.QC})
 
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
@@ -474,6 +508,39 @@ func handleBlock(request []byte) {
 	//}
 }
 
+// acceptReceivedBlock 检查并接入收到的区块。父区块还不在本地时放进孤块池，
+// 区块接入后再接入池中等待它的孤块
+func acceptReceivedBlock(bc *Blockchain, received orphanBlock) {
+	queue := []orphanBlock{received}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		block := next.block
+
+		_, err := bc.acceptBlock(block)
+		if err == errOrphanBlock {
+			fmt.Printf("Parent of block %x is not known yet, keeping it as an orphan\n", block.Hash)
+			orphanBlocks.add(next)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Rejected block %x: %v\n", block.Hash, err)
+			continue
+		}
+		fmt.Printf("Added block %x\n", block.Hash)
+		// 保存证书，本节点也可以为轻节点提供这个区块的区块头
+		if len(next.qc) > 0 {
+			if qc, err := DeserializeBlockQC(next.qc); err != nil || qc.Verify(block.Header()) != nil {
+				logWarnf("drop invalid quorum certificate of block %x", block.Hash)
+			} else if err := bc.PutBlockQC(block.Hash, qc); err != nil {
+				logWarnf("save quorum certificate of block %x: %v", block.Hash, err)
+			}
+		}
+		signPendingHeader(bc, block)
+		queue = append(queue, orphanBlocks.take(block.Hash)...)
+	}
+}
+
 //这段代码是一个处理区块链网络消息（inventory）的函数。它的主要功能是解码接收到的消息，然后根据消息类型执行相应的操作。
 //以下是该函数的主要步骤和功能：
 //1. 首先，它创建一个`bytes.Buffer`类型的缓冲区，并将接收到的消息内容写入缓冲区，跳过命令部分。
@@ -497,7 +564,12 @@ func handleInv(request []byte) {
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+		// 清单从链尾排到创世区块，倒过来从最早的区块开始请求，父区块先到
+		blocksInTransit = nil
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			blocksInTransit = append(blocksInTransit, payload.Items[i])
+		}
+		payload.Items = blocksInTransit
 
 		blockHash := payload.Items[0]
 		fmt.Println("sendGetData to", payload.AddrFrom, "block", blockHash, payload.ShardID)
@@ -534,7 +606,7 @@ func handleInv(request []byte) {
 //8. 调用 `sendInv` 函数，向 `payload.AddrFrom` 地址发送 `block` 类型的 `inv` 消息，携带区块哈希值列表 `blocks`。
 //总体来说，这段代码的作用是处理 `getblocks` 命令，解码其中的数据，然后通过 `sendInv` 函数向指定地址发送区块哈希值列表。
 //在区块链网络中，`getblocks` 命令用于请求其他节点发送它们所拥有的区块的哈希值列表。
-func handleGetBlocks(request []byte) {
+func handleGetBlocks(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload getblocks
 
@@ -549,15 +621,15 @@ func handleGetBlocks(request []byte) {
 		fmt.Println("---------------------")
 		fmt.Println("Receive from", payload.AddrFrom, "的getblocks请求 1")
 		fmt.Println("payload.belongTo:", payload.belongTo)
-		bc := NewBlockchain(NodeIPAddress)
+		bc := chains.Blockchain(NodeIPAddress)
 		//区块高度从0开始，要+1
 		fmt.Println("payload.BlockchainHeight:", payload.BlockchainHeight+1)
 		blocks := bc.GetBlockHashes()
-		defer bc.db.Close()
+		defer chains.Release(bc)
 		fmt.Println("len(blocks):", len(blocks))
-		//截取blocks的前payload.BlockchainHeight个区块
-		if len(blocks) > payload.BlockchainHeight {
-			blocks = blocks[:len(blocks)-(payload.BlockchainHeight+1)]
+		//截取高于payload.BlockchainHeight的区块，修剪过的链只有部分区块
+		if n := bc.GetBestHeight() - payload.BlockchainHeight; n >= 0 && n < len(blocks) {
+			blocks = blocks[:n]
 		}
 		//fmt.Println("截取后的len(blocks):", len(blocks))
 		//sendInv(payload.AddrFrom, "block", [][]byte{blocks[0]})
@@ -569,16 +641,16 @@ func handleGetBlocks(request []byte) {
 		fmt.Println("Receive from", payload.AddrFrom, "的getblocks请求 2")
 		fmt.Println("payload.ShardID:", payload.ShardID)
 		//defer bc.db.Close()
-		newbc := NewBlockchain(knownShardingNodes[payload.ShardID][0])
-		defer newbc.db.Close()
+		newbc := chains.Blockchain(knownShardingNodes[payload.ShardID][0])
+		defer chains.Release(newbc)
 		//区块高度从0开始，要+1
 		fmt.Println("payload.BlockchainHeight:", payload.BlockchainHeight+1)
 		blocks := newbc.GetBlockHashes()
 
 		fmt.Println("len(blocks):", len(blocks))
-		//截取blocks的前payload.BlockchainHeight个区块
-		if len(blocks) > payload.BlockchainHeight {
-			blocks = blocks[:len(blocks)-(payload.BlockchainHeight+1)]
+		//截取高于payload.BlockchainHeight的区块，修剪过的链只有部分区块
+		if n := newbc.GetBestHeight() - payload.BlockchainHeight; n >= 0 && n < len(blocks) {
+			blocks = blocks[:n]
 		}
 		//fmt.Println("截取后的len(blocks):", len(blocks))
 		//sendInv(payload.AddrFrom, "block", [][]byte{blocks[0]})
@@ -598,7 +670,7 @@ func handleGetBlocks(request []byte) {
 //- 如果请求的类型是 "block"，则调用区块链的 `GetBlock` 函数，根据传入的区块 ID 获取相应的区块。然后，使用 `sendBlock` 函数将该区块发送回请求的节点。
 //- 如果请求的类型是 "tx"，则从内存池中查找相应的交易（使用交易 ID），然后使用 `sendTx` 函数将该交易发送回请求的节点。
 //总之，这段代码用于处理 "getdata" 请求，根据请求的类型发送相应的数据（区块或交易）给请求的节点，以满足区块链网络中节点之间的数据同步需求。
-func handleGetData(request []byte) {
+func handleGetData(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload getdata
 
@@ -613,23 +685,31 @@ func handleGetData(request []byte) {
 	fmt.Println("payload.Type", payload.Type)
 	var bc *Blockchain
 	if payload.ShardID != belongToInt {
-		bc = NewBlockchain(knownShardingNodes[payload.ShardID][0])
+		bc = chains.Blockchain(knownShardingNodes[payload.ShardID][0])
 	} else {
-		bc = NewBlockchain(NodeIPAddress)
+		bc = chains.Blockchain(NodeIPAddress)
 	}
-	defer bc.db.Close()
+	defer chains.Release(bc)
 	if payload.Type == "block" {
 		fmt.Println("block, err := bc.GetBlock([]byte(payload.ID)) start")
 
 		block, err := bc.GetBlock([]byte(payload.ID))
 		fmt.Println("block, err := bc.GetBlock([]byte(payload.ID)) end")
+		if err != nil && bc.prunedBlock(payload.ID) == errBlockPruned {
+			logInfof("refuse block %x to %s, it is pruned", payload.ID, payload.AddrFrom)
+			return
+		}
 		if err != nil {
 			fmt.Println("err != nil:", err)
 			return
 		}
 		fmt.Println("payload.AddrFrom:", payload.AddrFrom)
 		fmt.Println("sendBlock(payload.AddrFrom, &block)")
-		sendBlock(payload.AddrFrom, &block, payload.ShardID)
+		qc, err := bc.BlockQC(block.Hash)
+		if err != nil {
+			logWarnf("quorum certificate of block %x: %v", block.Hash, err)
+		}
+		sendBlock(payload.AddrFrom, &block, qc, payload.ShardID)
 	}
 
 	if payload.Type == "tx" {
@@ -659,7 +739,7 @@ func handleGetData(request []byte) {
 //- 向其他节点广播新挖矿的区块信息。
 //- 如果内存池中还有其他交易，则继续挖矿，直到内存池为空或没有足够的交易。
 //总的来说，这个函数用于处理交易，包括将交易添加到内存池、进行挖矿并创建新区块，以及向其他节点广播相关信息。它是区块链网络中的一个关键部分，用于维护交易的流动和区块的生成。
-func handleTx(request []byte) {
+func handleTx(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload tx
 	fmt.Println("handleTx")
@@ -669,7 +749,8 @@ func handleTx(request []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	bc := NewBlockchain(NodeIPAddress)
+	bc := chains.Blockchain(NodeIPAddress)
+	defer chains.Release(bc)
 	fmt.Println("payload.AddFrom:", payload.AddFrom)
 	txData := payload.Transaction
 	tx := DeserializeTransaction(txData)
@@ -700,7 +781,7 @@ func handleTx(request []byte) {
 			}
 			fmt.Println("len(txs):", len(txs))
 
-			cbTx := NewCoinbaseTX(miningAddress, "")
+			cbTx := bc.NewRewardTX(miningAddress, txs)
 			txs = append(txs, cbTx)
 
 			fmt.Println("newBlock := bc.MineBlock(txs)")
@@ -751,7 +832,7 @@ func handleTx(request []byte) {
 //- 如果本地节点的最佳高度大于对方节点的最佳高度，向对方节点发送版本消息以告知本地节点的最新信息。
 //10. 如果对方节点不在已知节点列表中，将其添加到已知节点列表中。
 //总的来说，这个函数用于处理版本消息，进行节点间的握手和信息交换，以保持区块链网络的同步和一致性。
-func handleVersion(request []byte) {
+func handleVersion(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload Verzion
 
@@ -769,24 +850,37 @@ func handleVersion(request []byte) {
 	fmt.Println("payload.ShardID:", payload.ShardID)
 
 	if payload.ShardID != belongToInt {
-		newbc := NewBlockchain(knownShardingNodes[payload.ShardID][0])
-		defer newbc.db.Close()
+		newbc := chains.Blockchain(knownShardingNodes[payload.ShardID][0])
+		defer chains.Release(newbc)
+		if err := newbc.checkVersion(payload); err != nil {
+			logWarnf("refuse %s: %v", payload.AddrFrom, err)
+			return
+		}
 		foreignerBestHeight := payload.BestHeight
 		myBestHeight = newbc.GetBestHeight()
 		node := strings.Replace(payload.AddrFrom, " ", ":", -1)
-		if myBestHeight < foreignerBestHeight {
+		if myBestHeight < foreignerBestHeight && payload.PruneHeight > myBestHeight+1 {
+			logWarnf("%s has pruned the blocks after height %d", payload.AddrFrom, myBestHeight)
+		} else if myBestHeight < foreignerBestHeight {
 			sendGetBlocks(node, myBestHeight, payload.ShardID)
 		} else if myBestHeight > foreignerBestHeight {
 			sendVersion(node, newbc, payload.ShardID)
 		}
 	} else {
-		bc := NewBlockchain(NodeIPAddress)
-		defer bc.db.Close()
+		bc := chains.Blockchain(NodeIPAddress)
+		defer chains.Release(bc)
+		if err := bc.checkVersion(payload); err != nil {
+			logWarnf("refuse %s: %v", payload.AddrFrom, err)
+			return
+		}
 		foreignerBestHeight := payload.BestHeight
 		myBestHeight = bc.GetBestHeight()
 		fmt.Println("myBestHeight:", myBestHeight)
 		fmt.Println("foreignerBestHeight:", foreignerBestHeight)
-		if myBestHeight < foreignerBestHeight {
+		if myBestHeight < foreignerBestHeight && payload.PruneHeight > myBestHeight+1 {
+			// 对方没有紧接本地链尾的区块，只能从其他节点同步
+			logWarnf("%s has pruned the blocks after height %d", payload.AddrFrom, myBestHeight)
+		} else if myBestHeight < foreignerBestHeight {
 			fmt.Println("myBestHeight < foreignerBestHeight")
 			node := strings.Replace(payload.AddrFrom, " ", ":", -1)
 			fmt.Println("sendGetBlocks to ", node)
@@ -807,7 +901,7 @@ func handleVersion(request []byte) {
 
 }
 
-func handleSendTestdata(request []byte) {
+func handleSendTestdata(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload Testdata
 
@@ -831,9 +925,9 @@ func handleSendTestdata(request []byte) {
 		LastProposalID: "",
 		Mutex:          sync.Mutex{},
 	}
-	fmt.Println("node", node)
-	bc := NewBlockchain(NodeIPAddress)
-	defer bc.db.Close()
+	fmt.Println("node", node.ID)
+	bc := chains.Blockchain(NodeIPAddress)
+	defer chains.Release(bc)
 	switch substrings[1] {
 	case "send":
 		from := substrings[3]
@@ -866,44 +960,34 @@ func handleSendTestdata(request []byte) {
 		if err != nil {
 			log.Panic(err)
 		}
-		wallet := wallets.GetWallet(from)
-		fmt.Println("wallet", wallet)
+		if wallets.IsLocked() {
+			fmt.Println("Wallet is locked, run walletunlock first")
+			return
+		}
+		fmt.Println("from", from)
 		fmt.Println("to", to)
 		fmt.Println("amount", amount)
-		tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+		tx := NewUTXOTransaction(wallets, from, to, amount, &UTXOSet, DefaultSendOptions())
 		//fmt.Println("tx", tx)
 		if tx != nil {
+			wallets.SaveToFile(nodeID)
 			preparePhase(leaderID, 0, &node, payload.Data, from, tx, payload.From, payload.To)
 		} else {
 			fmt.Println("tx is nil,可能是钱不够")
 		}
-	case "DistributeRewards":
-		fmt.Println("DistributeRewards")
-		//nodeID := NodeIPAddress
-		//bc := NewBlockchain(nodeID)
-		fmt.Println("1")
-		address := substrings[3]
-		amount, err := strconv.Atoi(substrings[4])
-
+	case "mint":
+		fmt.Println("mint")
+		tx, err := DecodeMint(substrings[2])
 		if err != nil {
-			log.Panic(err)
+			fmt.Println("ERROR:", err)
+			return
 		}
-		if !ValidateAddress(address) {
-			fmt.Println("ERROR: Address is not valid")
-		} else {
-			cbtx := NewCoinbaseTX2(address, payload.Data, amount)
-			fmt.Println("2")
-			sourcetxs := []*Transaction{cbtx}
-			var newSourceBlock *Block
-
-			sourceUTXOSet := UTXOSet{bc} //发起分片
-
-			newSourceBlock = bc.commitTransaction(sourcetxs, payload.Data)
-			fmt.Println("3")
-			sourceUTXOSet.Update(newSourceBlock)
-			fmt.Println("4")
-			fmt.Printf("----Added block %x\n", newSourceBlock.Hash)
+		newBlock, err := bc.CommitMint(tx)
+		if err != nil {
+			fmt.Println("ERROR:", err)
+			return
 		}
+		fmt.Printf("----Added block %x\n", newBlock.Hash)
 
 		fmt.Println("区块链上链成功!")
 		for _, node := range knownShardingNodes[belongToInt] {
@@ -919,7 +1003,6 @@ func handleSendTestdata(request []byte) {
 				sendVersion(node, bc, belongToInt)
 			}
 		}
-		fmt.Println("5")
 	case "getbalance":
 		fmt.Println("getbalance")
 		fmt.Println("AddrFrom:", payload.AddrFrom)
@@ -990,7 +1073,7 @@ func handleSendTestdata(request []byte) {
 	}
 
 }
-func handleSendknownShardingNodes(request []byte) {
+func handleSendknownShardingNodes(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload FragmentationData
 
@@ -1032,8 +1115,8 @@ func handleSendknownShardingNodes(request []byte) {
 
 	if nodeAddress != knownShardingNodes[belongToInt][0] && initversionflag == 0 { //如果不是领导者节点
 		fmt.Println("sendVersion to", knownShardingNodes[belongToInt][0])
-		bc := NewBlockchain(NodeIPAddress)
-		defer bc.db.Close()
+		bc := chains.Blockchain(NodeIPAddress)
+		defer chains.Release(bc)
 		ShardLeaderIP := strings.Replace(knownShardingNodes[belongToInt][0], " ", ":", -1)
 		sendVersion(ShardLeaderIP, bc, belongToInt) //向领导者节点发送版本消息
 		initversionflag = 1
@@ -1080,10 +1163,12 @@ func handleSendPrepareMsg(request []byte) {
 			fmt.Println("当前处理提议：", ProcessingProposalID)
 			fmt.Println("-------------------------------")
 			if len(proposalpool) == 1 {
-				startTime = time.Now()
+				startTime = now()
 				ProcessingProposalID = proposalpool[0].ID
 				fmt.Println("开始处理提议：", ProcessingProposalID)
-				go handleProposal(payload.ShardID, payload.TarGetShardID, payload.QC, payload.Proposalvalue, payload.From, payload.To)
+				runAsync(func() {
+					handleProposal(payload.ShardID, payload.TarGetShardID, payload.QC, payload.Proposalvalue, payload.From, payload.To)
+				})
 				//if VerifyByPublicKey(payload.QC.NodeSignatures[0].PublicKey, payload.QC.Message.Value, payload.QC.NodeSignatures[0].R, payload.QC.NodeSignatures[0].S) {
 				//	fmt.Println("领导者验证通过")
 				//	var wallet string
@@ -1128,6 +1213,7 @@ func handleSendPrepareMsg(request []byte) {
 			var r1, s1 *big.Int
 			//返回签名给领导者节点
 			wallet, _, r1, s1, _ = SignByPrivateKey(payload.QC.Message.Value)
+			rememberVote(payload.QC.Message.Value)
 			var vote Vote
 			vote.Votetype = "agree"
 			vote.NodeID = NodeIPAddress
@@ -1184,6 +1270,7 @@ func handleProposal(ShardID int, TarGetShardID int, QC QuorumCertificate, propos
 							var r1, s1 *big.Int
 							//返回签名给领导者节点
 							wallet, _, r1, s1, _ = SignByPrivateKey(QC.Message.Value)
+							rememberVote(QC.Message.Value)
 							var vote Vote
 							vote.Votetype = "agree"
 							vote.NodeID = NodeIPAddress
@@ -1297,7 +1384,7 @@ func handleCrossShardProposal(ShardID int, TarGetShardID int, QC QuorumCertifica
 		fmt.Println("提议排队中：", proposal.ID)
 	}
 }
-func handleSendVoteMsg(request []byte) {
+func handleSendVoteMsg(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload VoteMsg
 	buff.Write(request[commandLength:])
@@ -1306,8 +1393,8 @@ func handleSendVoteMsg(request []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	bc := NewBlockchain(NodeIPAddress)
-	defer bc.db.Close()
+	bc := chains.Blockchain(NodeIPAddress)
+	defer chains.Release(bc)
 	result := strconv.Itoa(payload.ShardID) + "-" + strconv.Itoa(payload.TarGetShardID)
 	//接收到来自其他节点的投票信息，先判断是否是领导者节点
 	if NodeIPAddress == knownShardingNodes[payload.ShardID][0] || NodeIPAddress == knownShardingNodes[payload.TarGetShardID][0] || NodeIPAddress == knownShardingNodes[RelatedSharding[result]-1][0] {
@@ -1321,7 +1408,7 @@ func handleSendVoteMsg(request []byte) {
 			} else if requiredAgree == 1 {
 				requiredAgree = 2
 			}
-			createOrUpdateVoteCollector(payload.Vote, payload.Message, bc, payload.Proposalvalue, requiredAgree, payload.ShardID, payload.TarGetShardID)
+			createOrUpdateVoteCollector(chains, payload.Vote, payload.Message, bc, payload.Proposalvalue, requiredAgree, payload.ShardID, payload.TarGetShardID)
 			//if completeproposal[payload.Proposalvalue.ID] == nil {
 			//	fmt.Println("验证通过,将投票信息加入投票列表中")
 			//	createOrUpdateVoteCollector(payload.Vote, payload.Message, bc, payload.Proposalvalue)
@@ -1414,7 +1501,7 @@ func handleSendBlockSync(request []byte) {
 				} else {
 					fmt.Println("There are no proposals in the proposal pool, stop processing-2")
 					fmt.Println("Handling ", len(completeproposal), "proposals")
-					endTime = time.Now()
+					endTime = now()
 					// 计算运行时间
 					elapsedTime := endTime.Sub(startTime)
 					fmt.Println("Processing proposal runtime：", elapsedTime)
@@ -1458,7 +1545,7 @@ func handleSendBlockSync(request []byte) {
 
 }
 
-func handleSendCrossShardData(request []byte) {
+func handleSendCrossShardData(chains *ChainService, request []byte) {
 	var buff bytes.Buffer
 	var payload CrossShardData
 	buff.Write(request[commandLength:])
@@ -1467,8 +1554,8 @@ func handleSendCrossShardData(request []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	bc := NewBlockchain(NodeIPAddress)
-	defer bc.db.Close()
+	bc := chains.Blockchain(NodeIPAddress)
+	defer chains.Release(bc)
 	if payload.RelatedShardingFlag == true {
 		fmt.Println("NodeIP:", NodeIP, "接收到来自", payload.AddrFrom, "的CrossShardData消息")
 		fmt.Println("领导者节点，接收跨分片信息,这是关联分片交易")
@@ -1479,6 +1566,7 @@ func handleSendCrossShardData(request []byte) {
 			var r1, s1 *big.Int
 			//返回签名给领导者节点
 			wallet, _, r1, s1, _ = SignByPrivateKey(payload.QC.Message.Value)
+			rememberVote(payload.QC.Message.Value)
 			var vote Vote
 			vote.Votetype = "agree"
 			vote.NodeID = NodeIPAddress
@@ -1489,7 +1577,7 @@ func handleSendCrossShardData(request []byte) {
 			vote.Tx = payload.QC.NodeSignatures[0].Tx
 			fmt.Println("验证通过,返回签名给领导者节点")
 			requiredAgree := 2 //只需要发起分片和目标分片的领导者同意即可
-			createOrUpdateVoteCollector(vote, payload.QC.Message.Value, bc, payload.Proposalvalue, requiredAgree, payload.ShardID, payload.TarGetShardID)
+			createOrUpdateVoteCollector(chains, vote, payload.QC.Message.Value, bc, payload.Proposalvalue, requiredAgree, payload.ShardID, payload.TarGetShardID)
 			for _, node := range knownShardingNodes[belongToInt] {
 				if node != NodeIPAddress {
 					node = strings.Replace(node, " ", ":", -1)
@@ -1526,6 +1614,7 @@ func handleSendCrossShardData(request []byte) {
 			var r1, s1 *big.Int
 			//返回签名给领导者节点
 			wallet, _, r1, s1, _ = SignByPrivateKey(payload.QC.Message.Value)
+			rememberVote(payload.QC.Message.Value)
 			var vote Vote
 			vote.Votetype = "agree"
 			vote.NodeID = NodeIPAddress
@@ -1539,7 +1628,7 @@ func handleSendCrossShardData(request []byte) {
 			//如果是发起分片领导者节点，则向目标分片领导者节点发送消息
 			if NodeIPAddress == knownShardingNodes[payload.ShardID][0] {
 				requiredAgree := (len(knownShardingNodes[belongToInt]) / 2) + 1 //只需要发起分片和目标分片的领导者同意即可
-				createOrUpdateVoteCollector(vote, payload.QC.Message.Value, bc, payload.Proposalvalue, requiredAgree, payload.ShardID, payload.TarGetShardID)
+				createOrUpdateVoteCollector(chains, vote, payload.QC.Message.Value, bc, payload.Proposalvalue, requiredAgree, payload.ShardID, payload.TarGetShardID)
 				// 转:
 				addrIP := strings.Replace(knownShardingNodes[payload.TarGetShardID][0], " ", ":", -1)
 				//向目标分片领导者节点发送消息
@@ -1554,7 +1643,7 @@ func handleSendCrossShardData(request []byte) {
 				}
 			} else { //如果是目标分片领导者节点，则向发起分片领导者节点发送消息
 				requiredAgree := (len(knownShardingNodes[belongToInt]) / 2) + 1 //只需要发起分片和目标分片的领导者同意即可
-				createOrUpdateVoteCollector(vote, payload.QC.Message.Value, bc, payload.Proposalvalue, requiredAgree, payload.ShardID, payload.TarGetShardID)
+				createOrUpdateVoteCollector(chains, vote, payload.QC.Message.Value, bc, payload.Proposalvalue, requiredAgree, payload.ShardID, payload.TarGetShardID)
 				// 转:
 				addrIP := strings.Replace(knownShardingNodes[payload.ShardID][0], " ", ":", -1)
 				//向发起分片领导者节点发送消息
@@ -1586,7 +1675,7 @@ func handleSendCrossShardData(request []byte) {
 
 }
 
-func handleSendBalanceMsg(request []byte) {
+func handleSendBalanceMsg(chains *ChainService, request []byte) {
 	fmt.Println("handleSendBalanceMsg")
 	var buff bytes.Buffer
 	var payload BalanceData
@@ -1596,8 +1685,8 @@ func handleSendBalanceMsg(request []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	bc := NewBlockchain(NodeIPAddress)
-	defer bc.db.Close()
+	bc := chains.Blockchain(NodeIPAddress)
+	defer chains.Release(bc)
 	fmt.Println("NodeIP:", NodeIP, "接收到来自分片", payload.BelongToInt, "的", payload.AddrFrom, "的BalanceMsg消息")
 	fmt.Println("账户:", payload.Address)
 	if !ValidateAddress(payload.Address) {
@@ -1677,61 +1766,87 @@ func handleSendTotalBalanceMsg(request []byte) {
 		//SendBalanceMsg(payload.AddrFrom, address, balance)
 	}
 }
-func handleConnection(conn net.Conn) {
+func handleConnection(chains *ChainService, conn net.Conn) {
 
 	request, err := ioutil.ReadAll(conn)
 	if err != nil {
 		log.Panic(err)
 	}
+	handleMessage(chains, request, conn.RemoteAddr().String())
+
+	conn.Close()
+	//如果bc是nil，就不执行bc.db.Close()
+
+	//err = bc.db.Close()
+	//if err != nil {
+	//	log.Println("Error closing database:", err)
+	//}
+
+}
+
+// handleMessage 处理从 remote 收到的一条消息，request 带网络 magic 前缀
+func handleMessage(chains *ChainService, request []byte, remote string) {
+	request, err := chainParams.unwrapMessage(request)
+	if err != nil {
+		logWarnf("drop message from %s: %v", remote, err)
+		return
+	}
 
 	command := bytesToCommand(request[:commandLength])
 
 	//command := bytesToCommand(request[:commandLength])
-	fmt.Printf("Received %s command\n", command)
+	logDebugf("received %s command from %s", command, remote)
 
 	switch command {
 	case "addr":
 		handleAddr(request)
 	case "block":
-		handleBlock(request)
+		handleBlock(chains, request)
 	case "inv":
 		handleInv(request)
 	case "getblocks":
-		handleGetBlocks(request)
+		handleGetBlocks(chains, request)
 	case "getdata":
-		handleGetData(request)
+		handleGetData(chains, request)
 	case "tx":
-		handleTx(request)
+		handleTx(chains, request)
 	case "version":
-		handleVersion(request)
+		handleVersion(chains, request)
 	case "sendTestdata":
-		handleSendTestdata(request)
+		handleSendTestdata(chains, request)
 	case "sendknownShardingNodes":
-		handleSendknownShardingNodes(request)
+		handleSendknownShardingNodes(chains, request)
 	case "sendPrepareMsg":
 		handleSendPrepareMsg(request)
 	case "sendVoteMsg":
-		handleSendVoteMsg(request)
+		handleSendVoteMsg(chains, request)
 	case "sendBlockSync":
 		handleSendBlockSync(request)
 	case "sendCrossShardData":
-		handleSendCrossShardData(request)
+		handleSendCrossShardData(chains, request)
 	case "sendBalanceMsg":
-		handleSendBalanceMsg(request)
+		handleSendBalanceMsg(chains, request)
 	case "sendTotalBalanceMsg":
 		handleSendTotalBalanceMsg(request)
+	case "getheaders":
+		handleGetHeaders(chains, request)
+	case "getproofs":
+		handleGetProofs(chains, request)
+	case "getcfilters":
+		handleGetCFilters(chains, request)
+	case "getsnapshot":
+		handleGetSnapshot(chains, request)
+	case "snapshot":
+		handleSnapshot(chains, request)
+	case "signheader":
+		handleSignHeader(chains, request)
+	case "headersig":
+		handleHeaderSig(chains, request)
+	case "credit":
+		handleCredit(chains, request)
 	default:
-		fmt.Println("Unknown command!")
+		logWarnf("unknown command %q from %s", command, remote)
 	}
-
-	conn.Close()
-	//如果bc是nil，就不执行bc.db.Close()
-
-	//err = bc.db.Close()
-	//if err != nil {
-	//	log.Println("Error closing database:", err)
-	//}
-
 }
 
 // StartServer 这段代码定义了一个 `StartServer` 函数，用于启动区块链节点的服务器，以监听并处理与其他节点的连接。
@@ -1744,25 +1859,36 @@ func handleConnection(conn net.Conn) {
 //6. 如果当前节点的地址不等于已知节点列表中的第一个地址（`knownNodes[0]`），则向已知的某一个节点发送版本信息，以建立连接。
 //7. 进入无限循环，等待接受连接请求。当有连接请求到来时，会创建一个新的协程来处理连接，调用 `handleConnection` 函数进行处理，同时传入区块链实例 `bc`。
 //总的来说，这个函数的目的是启动一个区块链节点的服务器，用于监听和处理与其他节点的连接，以实现区块链网络的通信和同步。
-func StartServer(nodeID, minerAddress string) {
-	gob.Register(Proposal{})
-	gob.Register(QuorumCertificate{})
-	gob.Register(Vote{})
-	gob.Register(elliptic.P256())
-	substrings := strings.Split(nodeID, " ")
+func StartServer(config *NodeConfig) {
+	registerMessageTypes()
+	nodeID := config.NodeID()
 
 	//replacedString := strings.Replace(nodeID, " ", ":", 0)
 	fmt.Println("---------------------------------------")
 	fmt.Println("启动节点-nodeID:", nodeID)
 	fmt.Println("---------------------------------------")
 	//fmt.Println("replacedString String:", replacedString)
-	nodeAddress = fmt.Sprintf("%s:%s", substrings[0], substrings[1])
+	nodeAddress = config.Listen
 	//输出nodeAddress
 	fmt.Println("nodeAddress:", nodeAddress)
 	//fmt.Println("knownShardingNodes:", knownShardingNodes)
-	miningAddress = minerAddress
+	miningAddress = config.Miner
 	NodeIP = nodeAddress
 	NodeIPAddress = strings.Replace(NodeIP, ":", " ", -1)
+	if config.Shard >= 0 {
+		belongToInt = config.Shard
+		belongTo = strconv.Itoa(config.Shard)
+	}
+	for _, seed := range config.Seeds {
+		if seed != nodeAddress && !nodeIsKnown(seed) {
+			knownNodes = append(knownNodes, seed)
+		}
+	}
+	logInfof("node %s on network %s, shard %d, %d seed peers, data in %s",
+		nodeID, chainParams.Network, belongToInt, len(knownNodes), chainParams.dataPath(""))
+	// 所有连接共享同一组区块库句柄
+	chains := NewChainService(config.KeepOpen)
+	defer chains.Close()
 	//bc := NewBlockchain(nodeID)
 	ln, err := net.Listen(protocol, nodeAddress)
 	if err != nil {
@@ -1770,15 +1896,32 @@ func StartServer(nodeID, minerAddress string) {
 	}
 	defer ln.Close()
 	//defer bc.db.Close()
+	// 还没有区块库时先取快照，导入后再照常同步之后的区块
+	if config.Snapshot && !dbExists(nodeDataFile(dbFile, nodeID)) {
+		logInfof("requesting a UTXO snapshot from %s", config.Seeds[0])
+		sendGetSnapshot(config.Seeds[0], -1)
+	}
+	if config.Prune > 0 {
+		logInfof("pruning mode, keeping the last %d blocks", config.Prune)
+		go pruneBlocks(chains, nodeID, config.Prune)
+	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			log.Panic("conn, err := ln.Accept()", err)
 		}
-		go handleConnection(conn)
+		go handleConnection(chains, conn)
 	}
 }
 
+// registerMessageTypes 注册共识消息中以接口类型出现的值，gob 编解码投票和证书前需要
+func registerMessageTypes() {
+	gob.Register(Proposal{})
+	gob.Register(QuorumCertificate{})
+	gob.Register(Vote{})
+	gob.Register(elliptic.P256())
+}
+
 //这段代码定义了一个 `gobEncode` 函数，用于将数据进行 Gob 编码并返回编码后的字节序列。
 //下面是这个函数的功能和步骤解释：
 //1. 创建一个新的字节缓冲区 `buff`。
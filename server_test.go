@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotStuffCommitsIntraShardTransfer(t *testing.T) {
+	sim := NewSimNetwork(t, SimConfig{Shards: 1, NodesPerShard: 3, Seed: 1, Jitter: 5 * time.Millisecond})
+	shard := sim.Shard(0)
+	from, to := shard[1], shard[2]
+
+	sim.Transfer(from, from.Address, to, to.Address, 30)
+	assert.Nil(t, sim.Run())
+	assert.Empty(t, sim.Crashed())
+
+	tip, err := sim.converged(0)
+	assert.Nil(t, err)
+	assert.NotNil(t, tip)
+	for _, node := range shard {
+		assert.Equal(t, 1, sim.Height(node), node.Addr)
+		// 找零进入新的找零地址，发送地址只剩出块奖励
+		assert.Equal(t, BlockSubsidy(1), sim.Balance(node, from.Address), node.Addr)
+		assert.Equal(t, 130, sim.Balance(node, to.Address), node.Addr)
+	}
+}
+
+func TestStoppedNodeSyncsAfterRestart(t *testing.T) {
+	sim := NewSimNetwork(t, SimConfig{Shards: 1, NodesPerShard: 4, Seed: 2})
+	shard := sim.Shard(0)
+	from, to, stopped := shard[1], shard[2], shard[3]
+
+	sim.Stop(stopped)
+	sim.Transfer(from, from.Address, to, to.Address, 10)
+	assert.Nil(t, sim.Run())
+	assert.Equal(t, 1, sim.Height(from))
+	assert.Equal(t, 0, sim.Height(stopped))
+
+	sim.Start(stopped)
+	assert.Nil(t, sim.Run())
+	assert.Empty(t, sim.Crashed())
+	_, err := sim.converged(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 110, sim.Balance(stopped, to.Address))
+}
+
+func TestCrossShardTransfer(t *testing.T) {
+	sim := NewSimNetwork(t, SimConfig{Shards: 2, NodesPerShard: 2, Seed: 3})
+	from, to := sim.Shard(0)[1], sim.Shard(1)[1]
+
+	sim.Transfer(from, from.Address, to, to.Address, 25)
+	assert.Nil(t, sim.Run())
+	assert.Empty(t, sim.Crashed())
+
+	for shard := 0; shard < 2; shard++ {
+		_, err := sim.converged(shard)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, BlockSubsidy(1), sim.Balance(sim.Leader(0), from.Address))
+	assert.Equal(t, 125, sim.Balance(sim.Leader(1), to.Address))
+}
+
+func TestLeaderFailureBlocksCommit(t *testing.T) {
+	sim := NewSimNetwork(t, SimConfig{Shards: 1, NodesPerShard: 3, Seed: 4})
+	shard := sim.Shard(0)
+	leader, from, to := shard[0], shard[1], shard[2]
+
+	// 没有视图切换，领导者停止期间交易无法提交，但跟随者不会出错
+	sim.Stop(leader)
+	sim.Transfer(from, from.Address, to, to.Address, 10)
+	assert.Nil(t, sim.Run())
+	assert.Empty(t, sim.Crashed())
+	for _, node := range shard {
+		assert.Equal(t, 0, sim.Height(node), node.Addr)
+	}
+
+	// 丢失的交易不会重发，它花费的输出在发送方仍标记为已使用，所以换一个节点发送
+	sim.Start(leader)
+	assert.Nil(t, sim.Run())
+	sim.Transfer(to, to.Address, from, from.Address, 10)
+	assert.Nil(t, sim.Run())
+	assert.Empty(t, sim.Crashed())
+	_, err := sim.converged(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sim.Height(leader))
+	assert.Equal(t, 110, sim.Balance(leader, from.Address))
+}
+
+func TestPartitionedShardDoesNotDiverge(t *testing.T) {
+	sim := NewSimNetwork(t, SimConfig{Shards: 1, NodesPerShard: 4, Seed: 5, Jitter: 10 * time.Millisecond})
+	shard := sim.Shard(0)
+
+	// 少数派一侧收不到区块，多数派照常提交
+	sim.Partition(shard[:3], shard[3:])
+	sim.Transfer(shard[1], shard[1].Address, shard[2], shard[2].Address, 10)
+	assert.Nil(t, sim.Run())
+	assert.Equal(t, 1, sim.Height(shard[0]))
+	assert.Equal(t, 0, sim.Height(shard[3]))
+
+	sim.Heal()
+	sim.Stop(shard[3])
+	sim.Start(shard[3])
+	assert.Nil(t, sim.Run())
+	assert.Empty(t, sim.Crashed())
+	_, err := sim.converged(0)
+	assert.Nil(t, err)
+}
+
+// 父区块后到的区块先放进孤块池，父区块接入后按共识规则检查再接入
+func TestAcceptReceivedBlockKeepsOrphans(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+	previous := orphanBlocks
+	orphanBlocks = newOrphanPool()
+	t.Cleanup(func() { orphanBlocks = previous })
+
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	source := testChain(t, genesis)
+	UTXOSet{source}.Reindex()
+	hashes, err := source.Generate(3, address)
+	assert.Nil(t, err)
+	var blocks []*Block
+	for _, hash := range hashes {
+		block, err := source.GetBlock(hash)
+		assert.Nil(t, err)
+		blocks = append(blocks, &block)
+	}
+	greedy := NewBlock([]*Transaction{NewCoinbaseTX2(address, "", BlockSubsidy(4)+1)}, blocks[2].Hash, 4, 1, nil)
+
+	bc := testChain(t, genesis)
+	UTXOSet{bc}.Reindex()
+	acceptReceivedBlock(bc, orphanBlock{greedy, nil})
+	acceptReceivedBlock(bc, orphanBlock{blocks[2], nil})
+	acceptReceivedBlock(bc, orphanBlock{blocks[1], nil})
+	assert.Equal(t, 3, orphanBlocks.size())
+	assert.Equal(t, 0, bc.GetBestHeight())
+	_, err = bc.GetBlock(blocks[2].Hash)
+	assert.NotNil(t, err, "orphans are not stored before they are checked")
+
+	acceptReceivedBlock(bc, orphanBlock{blocks[0], nil})
+	assert.Equal(t, 0, orphanBlocks.size())
+	assert.Equal(t, blocks[2].Hash, bc.Tip(), "the orphan paying more than the subsidy is rejected")
+	_, err = bc.GetBlock(greedy.Hash)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SigHashType 签名哈希类型，决定签名覆盖交易的哪些部分，作为最后一个字节附加在签名之后
+type SigHashType byte
+
+const (
+	// SigHashAll 签名覆盖所有输入和所有输出
+	SigHashAll SigHashType = 0x01
+	// SigHashNone 签名覆盖所有输入，不覆盖任何输出，输出可由他人任意修改
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle 签名覆盖所有输入，以及与当前输入下标相同的那一个输出
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay 与上面三种组合使用，签名只覆盖当前输入，其他人可以继续追加输入（众筹）
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashBaseMask = 0x1f
+)
+
+// ecdsaSignatureLen r 和 s 各补齐到 32 字节后的签名长度，不含末尾的签名哈希类型
+const ecdsaSignatureLen = 64
+
+// Base 返回去掉 ANYONECANPAY 标志后的基础类型
+func (t SigHashType) Base() SigHashType {
+	return t & sigHashBaseMask
+}
+
+// AnyoneCanPay 是否带有 ANYONECANPAY 标志
+func (t SigHashType) AnyoneCanPay() bool {
+	return t&SigHashAnyoneCanPay != 0
+}
+
+// IsValid 检查签名哈希类型是否是已定义的组合
+func (t SigHashType) IsValid() bool {
+	if t&^(SigHashAnyoneCanPay|sigHashBaseMask) != 0 {
+		return false
+	}
+	switch t.Base() {
+	case SigHashAll, SigHashNone, SigHashSingle:
+		return true
+	}
+	return false
+}
+
+func (t SigHashType) String() string {
+	var name string
+	switch t.Base() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("UNKNOWN(0x%02x)", byte(t))
+	}
+	if t.AnyoneCanPay() {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+// ParseSigHashType 解析 "ALL"、"NONE|ANYONECANPAY" 这样的字符串
+func ParseSigHashType(s string) (SigHashType, error) {
+	for _, t := range []SigHashType{SigHashAll, SigHashNone, SigHashSingle} {
+		if s == t.String() {
+			return t, nil
+		}
+		if s == (t | SigHashAnyoneCanPay).String() {
+			return t | SigHashAnyoneCanPay, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sighash type %q", s)
+}
+
+// SignatureHash 计算第 inIdx 个输入的签名哈希。
+// prevOuts 按输入顺序给出每个输入所花费的输出；带 ANYONECANPAY 时只会用到 prevOuts[inIdx]。
+//
+// 签名原文（所有整数小端序，变长字段前加 varint 长度）：
+//
+//	hashPrevouts  32 字节，所有输入的 (txid, vout) 的双 SHA256；ANYONECANPAY 时全 0
+//	hashAmounts   32 字节，所有输入所花费金额的双 SHA256；ANYONECANPAY 时全 0
+//	txid          当前输入引用的交易 ID
+//	vout          uint32
+//	pubKeyHash    当前输入所花费输出的锁定公钥哈希
+//	amount        int64，当前输入所花费的金额
+//	hashOutputs   32 字节；ALL 为所有输出的双 SHA256，SINGLE 为同下标输出的双 SHA256，NONE 全 0
+//	hashType      uint32
+//
+// 最终签名哈希为签名原文的双 SHA256。
+func (tx *Transaction) SignatureHash(inIdx int, prevOuts []TXOutput, hashType SigHashType) ([]byte, error) {
+	if !hashType.IsValid() {
+		return nil, fmt.Errorf("invalid sighash type 0x%02x", byte(hashType))
+	}
+	if inIdx < 0 || inIdx >= len(tx.Vin) {
+		return nil, fmt.Errorf("input index %d out of range", inIdx)
+	}
+	if len(prevOuts) != len(tx.Vin) {
+		return nil, errors.New("previous outputs do not match inputs")
+	}
+	if hashType.Base() == SigHashSingle && inIdx >= len(tx.Vout) {
+		return nil, fmt.Errorf("SIGHASH_SINGLE input %d has no matching output", inIdx)
+	}
+
+	var zeroHash [32]byte
+	hashPrevouts := zeroHash[:]
+	hashAmounts := zeroHash[:]
+	hashOutputs := zeroHash[:]
+
+	if !hashType.AnyoneCanPay() {
+		var prevouts, amounts bytes.Buffer
+		for i, vin := range tx.Vin {
+			writeOutpoint(&prevouts, vin)
+			writeInt64(&amounts, int64(prevOuts[i].Value))
+		}
+		hashPrevouts = doubleSHA256(prevouts.Bytes())
+		hashAmounts = doubleSHA256(amounts.Bytes())
+	}
+
+	switch hashType.Base() {
+	case SigHashAll:
+		var outputs bytes.Buffer
+		for _, out := range tx.Vout {
+			writeOutput(&outputs, out)
+		}
+		hashOutputs = doubleSHA256(outputs.Bytes())
+	case SigHashSingle:
+		var output bytes.Buffer
+		writeOutput(&output, tx.Vout[inIdx])
+		hashOutputs = doubleSHA256(output.Bytes())
+	}
+
+	var preimage bytes.Buffer
+	preimage.Write(hashPrevouts)
+	preimage.Write(hashAmounts)
+	writeOutpoint(&preimage, tx.Vin[inIdx])
+	writeVarBytes(&preimage, prevOuts[inIdx].PubKeyHash)
+	writeInt64(&preimage, int64(prevOuts[inIdx].Value))
+	preimage.Write(hashOutputs)
+	writeUint32(&preimage, uint32(hashType))
+
+	return doubleSHA256(preimage.Bytes()), nil
+}
+
+// SignInput 用私钥以指定的签名哈希类型对第 inIdx 个输入签名。
+// 输入的 PubKey 为空时会填入私钥对应的公钥。
+func (tx *Transaction) SignInput(privKey ecdsa.PrivateKey, inIdx int, prevOuts []TXOutput, hashType SigHashType) error {
+	if inIdx < 0 || inIdx >= len(tx.Vin) {
+		return fmt.Errorf("input index %d out of range", inIdx)
+	}
+	if privKey.D == nil {
+		return errWalletLocked
+	}
+	if len(tx.Vin[inIdx].PubKey) == 0 {
+		tx.Vin[inIdx].PubKey = marshalPubKey(privKey.PublicKey)
+	}
+
+	sigHash, err := tx.SignatureHash(inIdx, prevOuts, hashType)
+	if err != nil {
+		return err
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, sigHash)
+	if err != nil {
+		return err
+	}
+
+	signature := make([]byte, ecdsaSignatureLen+1)
+	r.FillBytes(signature[:ecdsaSignatureLen/2])
+	s.FillBytes(signature[ecdsaSignatureLen/2 : ecdsaSignatureLen])
+	signature[ecdsaSignatureLen] = byte(hashType)
+	tx.Vin[inIdx].Signature = signature
+
+	return nil
+}
+
+// VerifyInput 验证第 inIdx 个输入的签名，同时检查输入的公钥确实能解锁所花费的输出
+func (tx *Transaction) VerifyInput(inIdx int, prevOuts []TXOutput) bool {
+	vin := tx.Vin[inIdx]
+	if len(vin.Signature) != ecdsaSignatureLen+1 || len(vin.PubKey) == 0 {
+		return false
+	}
+	if !vin.UsesKey(prevOuts[inIdx].PubKeyHash) {
+		return false
+	}
+
+	hashType := SigHashType(vin.Signature[ecdsaSignatureLen])
+	sigHash, err := tx.SignatureHash(inIdx, prevOuts, hashType)
+	if err != nil {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(vin.Signature[:ecdsaSignatureLen/2])
+	s := new(big.Int).SetBytes(vin.Signature[ecdsaSignatureLen/2 : ecdsaSignatureLen])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(vin.PubKey)
+	x.SetBytes(vin.PubKey[:(keyLen / 2)])
+	y.SetBytes(vin.PubKey[(keyLen / 2):])
+	pubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+
+	return ecdsa.Verify(&pubKey, sigHash, r, s)
+}
+
+// prevOutputs 按输入顺序取出每个输入花费的输出
+func (tx *Transaction) prevOutputs(prevTXs map[string]Transaction) ([]TXOutput, error) {
+	prevOuts := make([]TXOutput, len(tx.Vin))
+
+	for i, vin := range tx.Vin {
+		prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok || prevTx.ID == nil {
+			return nil, fmt.Errorf("previous transaction %x is not found", vin.Txid)
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return nil, fmt.Errorf("previous transaction %x has no output %d", vin.Txid, vin.Vout)
+		}
+		prevOuts[i] = prevTx.Vout[vin.Vout]
+	}
+
+	return prevOuts, nil
+}
+
+func writeOutpoint(buff *bytes.Buffer, vin TXInput) {
+	writeVarBytes(buff, vin.Txid)
+	writeUint32(buff, uint32(vin.Vout))
+}
+
+func writeOutput(buff *bytes.Buffer, out TXOutput) {
+	writeInt64(buff, int64(out.Value))
+	writeVarBytes(buff, out.PubKeyHash)
+}
+
+func doubleSHA256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
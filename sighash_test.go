@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSighashFixture 构造两个钱包各花费一笔前序输出的交易，不依赖数据库
+func newSighashFixture() (*Wallet, *Wallet, map[string]Transaction, *Transaction) {
+	alice := NewWallet()
+	bob := NewWallet()
+
+	prevA := NewCoinbaseTX2(string(alice.GetAddress()), "a", 30)
+	prevB := NewCoinbaseTX2(string(bob.GetAddress()), "b", 20)
+	prevTXs := map[string]Transaction{
+		hex.EncodeToString(prevA.ID): *prevA,
+		hex.EncodeToString(prevB.ID): *prevB,
+	}
+
+	tx := &Transaction{nil, []TXInput{
+		{prevA.ID, 0, nil, alice.PublicKey},
+		{prevB.ID, 0, nil, bob.PublicKey},
+	}, []TXOutput{
+		*NewTXOutput(45, string(NewWallet().GetAddress())),
+		*NewTXOutput(5, string(bob.GetAddress())),
+	}}
+	tx.ID = tx.Hash()
+
+	return alice, bob, prevTXs, tx
+}
+
+func TestSignatureHashAll(t *testing.T) {
+	alice, bob, prevTXs, tx := newSighashFixture()
+	prevOuts, err := tx.prevOutputs(prevTXs)
+	assert.Nil(t, err)
+
+	assert.Nil(t, tx.SignInput(alice.PrivateKey, 0, prevOuts, SigHashAll))
+	assert.Nil(t, tx.SignInput(bob.PrivateKey, 1, prevOuts, SigHashAll))
+	assert.True(t, tx.Verify(prevTXs), "signed transaction verifies")
+
+	tx.Vout[0].Value++
+	assert.False(t, tx.Verify(prevTXs), "SIGHASH_ALL commits to outputs")
+}
+
+func TestSignatureHashCommitsToAmounts(t *testing.T) {
+	alice, bob, prevTXs, tx := newSighashFixture()
+	prevOuts, _ := tx.prevOutputs(prevTXs)
+
+	assert.Nil(t, tx.SignInput(alice.PrivateKey, 0, prevOuts, SigHashAll))
+	assert.Nil(t, tx.SignInput(bob.PrivateKey, 1, prevOuts, SigHashAll))
+
+	prevOuts[1].Value = 2000
+	assert.False(t, tx.VerifyInput(0, prevOuts), "signature commits to the amounts of all inputs")
+	assert.False(t, tx.VerifyInput(1, prevOuts), "signature commits to the spent amount")
+}
+
+func TestSignatureHashNoneAndSingle(t *testing.T) {
+	alice, bob, prevTXs, tx := newSighashFixture()
+	prevOuts, _ := tx.prevOutputs(prevTXs)
+
+	assert.Nil(t, tx.SignInput(alice.PrivateKey, 0, prevOuts, SigHashNone))
+	assert.Nil(t, tx.SignInput(bob.PrivateKey, 1, prevOuts, SigHashSingle))
+
+	tx.Vout[0].Value = 1
+	assert.True(t, tx.VerifyInput(0, prevOuts), "SIGHASH_NONE does not cover outputs")
+	assert.True(t, tx.VerifyInput(1, prevOuts), "SIGHASH_SINGLE covers only its own output")
+
+	tx.Vout[1].Value = 1
+	assert.False(t, tx.VerifyInput(1, prevOuts), "SIGHASH_SINGLE covers the output with the same index")
+}
+
+func TestSignatureHashAnyoneCanPay(t *testing.T) {
+	alice, bob, prevTXs, tx := newSighashFixture()
+
+	// 众筹：alice 先只用自己的输入签名，之后 bob 再追加输入
+	bobInput := tx.Vin[1]
+	tx.Vin = tx.Vin[:1]
+	prevOuts, _ := tx.prevOutputs(prevTXs)
+	assert.Nil(t, tx.SignInput(alice.PrivateKey, 0, prevOuts, SigHashAll|SigHashAnyoneCanPay))
+
+	tx.Vin = append(tx.Vin, bobInput)
+	prevOuts, _ = tx.prevOutputs(prevTXs)
+	assert.Nil(t, tx.SignInput(bob.PrivateKey, 1, prevOuts, SigHashAll|SigHashAnyoneCanPay))
+
+	assert.True(t, tx.Verify(prevTXs), "ANYONECANPAY signatures stay valid when inputs are added")
+}
+
+func TestVerifyInputRejectsForeignKey(t *testing.T) {
+	_, bob, prevTXs, tx := newSighashFixture()
+	prevOuts, _ := tx.prevOutputs(prevTXs)
+
+	// bob 的私钥签 alice 的输入，公钥哈希与被花费输出不符
+	tx.Vin[0].PubKey = nil
+	assert.Nil(t, tx.SignInput(bob.PrivateKey, 0, prevOuts, SigHashAll))
+	assert.False(t, tx.VerifyInput(0, prevOuts))
+}
+
+// signLegacyInput 按旧格式签名：对 TrimmedCopy 的 "%x\n" 字符串签名，r 和 s 各 32 字节拼接
+func signLegacyInput(t *testing.T, tx *Transaction, inIdx int, prevOuts []TXOutput, wallet *Wallet) {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[inIdx].PubKey = prevOuts[inIdx].PubKeyHash
+	r, s, err := ecdsa.Sign(rand.Reader, &wallet.PrivateKey, []byte(fmt.Sprintf("%x\n", txCopy)))
+	assert.Nil(t, err)
+	signature := make([]byte, ecdsaSignatureLen)
+	r.FillBytes(signature[:ecdsaSignatureLen/2])
+	s.FillBytes(signature[ecdsaSignatureLen/2:])
+	tx.Vin[inIdx].Signature = signature
+	tx.Vin[inIdx].PubKey = wallet.PublicKey
+}
+
+func TestVerifyLegacyInput(t *testing.T) {
+	alice, bob, prevTXs, tx := newSighashFixture()
+	prevOuts, _ := tx.prevOutputs(prevTXs)
+
+	signLegacyInput(t, tx, 0, prevOuts, alice)
+	signLegacyInput(t, tx, 1, prevOuts, bob)
+	assert.True(t, tx.Verify(prevTXs), "old-format signatures still verify")
+
+	// 用自己的密钥签别人的输入，签名本身有效但公钥哈希不符
+	signLegacyInput(t, tx, 0, prevOuts, bob)
+	assert.False(t, tx.Verify(prevTXs))
+}
+
+func TestParseSigHashType(t *testing.T) {
+	ht, err := ParseSigHashType("SINGLE|ANYONECANPAY")
+	assert.Nil(t, err)
+	assert.Equal(t, SigHashSingle|SigHashAnyoneCanPay, ht)
+
+	_, err = ParseSigHashType("EVERYTHING")
+	assert.NotNil(t, err)
+	assert.False(t, SigHashType(0x04).IsValid())
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"container/heap"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 进程内的多节点模拟网络
+//
+// SimNetwork 在一个测试进程里运行 M 个分片、每个分片 N 个节点，节点之间的消息经过内存中的网络，
+// 不占用 TCP 端口。节点的区块库和钱包是临时目录中的普通文件，所有节点共用一个 ChainService，
+// 和原来在一台机器上运行整个集群时一样，领导者可以直接打开其他分片领导者的库。
+//
+// 服务端把节点状态放在包级变量中，模拟网络为每个节点保存一份（simNodeState），处理发给某个节点的消息前换上它的状态，
+// 处理完再存回去。所有消息在虚拟时钟上按到达时间逐个处理，同一时刻按发送顺序处理；消息延迟、丢失都由随机种子决定，
+// 同一个种子得到同样的消息顺序。节点的密钥和签名仍然是随机的，区块哈希每次运行不同。
+//
+// 测试用 Stop/Start 模拟节点崩溃和重启，Partition/Heal 模拟网络分区。
+
+// simEpoch 虚拟时钟的零点
+var simEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// maxSimEvents Run 最多处理的事件数，超过说明节点之间的消息停不下来
+const maxSimEvents = 100000
+
+// simClient 测试代替 HTTP 接口向节点发出命令时使用的发送方地址
+const simClient = "client"
+
+// SimConfig 模拟网络的参数
+type SimConfig struct {
+	Shards        int
+	NodesPerShard int
+	Seed          int64
+	Latency       time.Duration // 每条消息的基本延迟
+	Jitter        time.Duration // 在基本延迟上随机增加 [0, Jitter)
+	DropRate      float64       // 节点之间的消息丢失的概率，发给自己的消息不会丢失
+	Funds         int           // 创世区块给每个节点的钱包地址分配的币
+}
+
+// SimNode 模拟网络中的一个节点
+type SimNode struct {
+	Addr    string // 监听地址 IP:PORT
+	ID      string // 节点 ID "IP PORT"，区块库和钱包文件按它命名
+	Shard   int
+	Address string      // 钱包地址，创世区块给它分配了 SimConfig.Funds
+	Up      bool        // 节点在运行
+	Err     interface{} // 处理消息时的 panic，节点随之停止
+	epoch   int         // 每次停止加一，丢弃停止之前安排的事件
+	state   simNodeState
+}
+
+// SimDelivery 网络上的一条消息，Dropped 表示丢失或对方不可达
+type SimDelivery struct {
+	At      time.Duration
+	From    string
+	To      string
+	Command string
+	Dropped bool
+}
+
+func (d SimDelivery) String() string {
+	status := ""
+	if d.Dropped {
+		status = " dropped"
+	}
+	return fmt.Sprintf("%v %s -> %s %s%s", d.At, d.From, d.To, d.Command, status)
+}
+
+// simEvent 在虚拟时刻 at 交给 node 处理的消息，或者以 node 的身份运行的 task
+type simEvent struct {
+	at    time.Duration
+	seq   uint64
+	node  *SimNode
+	epoch int
+	from  string
+	data  []byte
+	task  func()
+}
+
+type simQueue []*simEvent
+
+func (q simQueue) Len() int { return len(q) }
+func (q simQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q simQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *simQueue) Push(x interface{}) { *q = append(*q, x.(*simEvent)) }
+func (q *simQueue) Pop() interface{} {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
+
+// simNodeState 服务端保存在包级变量中的节点状态
+type simNodeState struct {
+	nodeAddress          string
+	miningAddress        string
+	myBestHeight         int
+	knownNodes           []string
+	knownShardingNodes   [][]string
+	RelatedSharding      map[string]int
+	blocksInTransit      [][]byte
+	orphanBlocks         *orphanPool
+	mempool              map[string]Transaction
+	firsthandleproposal  int
+	initversionflag      int
+	completeproposal     map[string]*Proposal
+	BlockSyncnum         int
+	proposalpool         []Proposal
+	QCpool               []QuorumCertificate
+	frompool             []string
+	topool               []string
+	ProcessingProposalID string
+	belongTo             string
+	NodeIP               string
+	NodeIPAddress        string
+	belongToInt          int
+	startTime            time.Time
+	endTime              time.Time
+	voteCollectors       map[string]*VoteCollector
+	IndexOfCbtx          int
+	UsedTxId             map[string][]byte
+	publicKey            ecdsa.PublicKey
+	totalBalance         int
+	countNum             int
+}
+
+// captureNodeState 取出当前包级变量中的节点状态
+func captureNodeState() simNodeState {
+	return simNodeState{
+		nodeAddress, miningAddress, myBestHeight, knownNodes, knownShardingNodes, RelatedSharding,
+		blocksInTransit, orphanBlocks, mempool, firsthandleproposal, initversionflag, completeproposal, BlockSyncnum,
+		proposalpool, QCpool, frompool, topool, ProcessingProposalID, belongTo, NodeIP, NodeIPAddress,
+		belongToInt, startTime, endTime, voteCollectors, IndexOfCbtx, UsedTxId, publicKey, totalBalance, countNum,
+	}
+}
+
+// restore 把节点状态放回包级变量
+func (s simNodeState) restore() {
+	nodeAddress, miningAddress, myBestHeight, knownNodes, knownShardingNodes, RelatedSharding = s.nodeAddress, s.miningAddress, s.myBestHeight, s.knownNodes, s.knownShardingNodes, s.RelatedSharding
+	blocksInTransit, orphanBlocks, mempool, firsthandleproposal, initversionflag, completeproposal, BlockSyncnum = s.blocksInTransit, s.orphanBlocks, s.mempool, s.firsthandleproposal, s.initversionflag, s.completeproposal, s.BlockSyncnum
+	proposalpool, QCpool, frompool, topool, ProcessingProposalID = s.proposalpool, s.QCpool, s.frompool, s.topool, s.ProcessingProposalID
+	belongTo, NodeIP, NodeIPAddress, belongToInt, startTime, endTime = s.belongTo, s.NodeIP, s.NodeIPAddress, s.belongToInt, s.startTime, s.endTime
+	voteCollectors, IndexOfCbtx, UsedTxId, publicKey, totalBalance, countNum = s.voteCollectors, s.IndexOfCbtx, s.UsedTxId, s.publicKey, s.totalBalance, s.countNum
+}
+
+// SimNetwork 见本文件开头的说明
+type SimNetwork struct {
+	config  SimConfig
+	rand    *rand.Rand
+	chains  *ChainService
+	nodes   map[string]*SimNode
+	order   []*SimNode
+	shards  [][]string // 每个分片的节点 ID，第一个是领导者
+	events  simQueue
+	seq     uint64
+	clock   time.Duration
+	current *SimNode                 // 正在处理消息的节点
+	groups  map[string]int           // 分区后节点所在的组，nil 表示没有分区
+	links   map[string]time.Duration // 单独设置的链路延迟，键为 "发送方 接收方"
+	Trace   []SimDelivery
+}
+
+// NewSimNetwork 在 regtest 参数上创建模拟网络：生成每个节点的钱包并配置为所在分片的验证者公钥，用同一个创世区块创建每个节点的区块库。
+// 测试结束时恢复包级变量、网络参数和传输方式
+func NewSimNetwork(t *testing.T, config SimConfig) *SimNetwork {
+	if config.Shards <= 0 || config.NodesPerShard <= 0 {
+		t.Fatalf("invalid network of %d shards with %d nodes", config.Shards, config.NodesPerShard)
+	}
+	if config.Latency <= 0 {
+		config.Latency = 10 * time.Millisecond
+	}
+	if config.Funds <= 0 {
+		config.Funds = 100
+	}
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	chainParams = regtestChainParams()
+	chainParams.Consensus = "hotstuff"
+	chainParams.ValidatorKeys = make(map[string]string)
+	chainParams.ValidatorShards = make(map[string]int)
+	registerMessageTypes()
+	resetSigningState()
+
+	previous := captureNodeState()
+	previousTransport, previousRunAsync, previousNow := transport, runAsync, now
+	sim := &SimNetwork{
+		config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)),
+		chains: NewChainService(true),
+		nodes:  make(map[string]*SimNode),
+	}
+	transport = sim
+	runAsync = func(f func()) { sim.schedule(0, sim.current, "", nil, f) }
+	now = func() time.Time { return simEpoch.Add(sim.clock) }
+	t.Cleanup(func() {
+		sim.chains.Close()
+		transport, runAsync, now = previousTransport, previousRunAsync, previousNow
+		previous.restore()
+		resetSigningState()
+	})
+
+	for shard := 0; shard < config.Shards; shard++ {
+		var members []string
+		for i := 0; i < config.NodesPerShard; i++ {
+			port := chainParams.Port + len(sim.order)
+			node := &SimNode{
+				Addr:  fmt.Sprintf("127.0.0.1:%d", port),
+				ID:    fmt.Sprintf("127.0.0.1 %d", port),
+				Shard: shard,
+				Up:    true,
+			}
+			wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+			node.Address = wallets.CreateWallet()
+			wallets.SaveToFile(node.ID)
+			chainParams.Allocations = append(chainParams.Allocations, GenesisAllocation{node.Address, config.Funds})
+			chainParams.ValidatorKeys[node.ID] = hex.EncodeToString(wallets.Wallets[node.Address].PublicKey)
+			chainParams.ValidatorShards[node.ID] = shard
+
+			sim.nodes[node.Addr] = node
+			sim.order = append(sim.order, node)
+			members = append(members, node.ID)
+		}
+		sim.shards = append(sim.shards, members)
+	}
+
+	genesis, err := chainParams.NewGenesisBlock("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range sim.order {
+		db, err := openBoltStore(nodeDataFile(dbFile, node.ID), 0600, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bc, err := createBlockchain(db, genesis)
+		if err != nil {
+			t.Fatal(err)
+		}
+		UTXOSet{bc}.Reindex()
+		db.Close()
+		node.state = sim.newNodeState(node)
+	}
+
+	return sim
+}
+
+// resetSigningState 清空节点共用的投票和签名记录，它们按节点 ID 区分，不同网络的节点 ID 会重复
+func resetSigningState() {
+	votedCommands.Lock()
+	votedCommands.votes, votedCommands.order = make(map[string]int), nil
+	votedCommands.Unlock()
+	signedHeaders.Lock()
+	signedHeaders.hashes, signedHeaders.order = make(map[string][]byte), nil
+	signedHeaders.Unlock()
+	pendingSignRequests.Lock()
+	pendingSignRequests.requests, pendingSignRequests.order = make(map[string]signheader), nil
+	pendingSignRequests.Unlock()
+}
+
+// newNodeState 节点启动时的状态：已经知道所有分片的节点，相当于 HTTP 接口分配完分片之后
+func (sim *SimNetwork) newNodeState(node *SimNode) simNodeState {
+	var shards [][]string
+	var peers []string
+	for _, members := range sim.shards {
+		shards = append(shards, append([]string(nil), members...))
+	}
+	for _, id := range sim.shards[node.Shard] {
+		peers = append(peers, strings.Replace(id, " ", ":", -1))
+	}
+
+	return simNodeState{
+		nodeAddress:        node.Addr,
+		knownNodes:         peers,
+		knownShardingNodes: shards,
+		RelatedSharding:    make(map[string]int),
+		blocksInTransit:    [][]byte{},
+		orphanBlocks:       newOrphanPool(),
+		mempool:            make(map[string]Transaction),
+		completeproposal:   make(map[string]*Proposal),
+		proposalpool:       []Proposal{},
+		QCpool:             []QuorumCertificate{},
+		belongTo:           strconv.Itoa(node.Shard),
+		NodeIP:             node.Addr,
+		NodeIPAddress:      node.ID,
+		belongToInt:        node.Shard,
+		voteCollectors:     make(map[string]*VoteCollector),
+		UsedTxId:           make(map[string][]byte),
+	}
+}
+
+// Nodes 所有节点，按分片排列，每个分片的领导者在前
+func (sim *SimNetwork) Nodes() []*SimNode {
+	return sim.order
+}
+
+// Shard 分片中的节点，第一个是领导者
+func (sim *SimNetwork) Shard(shard int) []*SimNode {
+	var nodes []*SimNode
+	for _, id := range sim.shards[shard] {
+		nodes = append(nodes, sim.nodes[strings.Replace(id, " ", ":", -1)])
+	}
+
+	return nodes
+}
+
+// Leader 分片的领导者
+func (sim *SimNetwork) Leader(shard int) *SimNode {
+	return sim.Shard(shard)[0]
+}
+
+// Now 虚拟时钟的当前时间
+func (sim *SimNetwork) Now() time.Duration {
+	return sim.clock
+}
+
+// Send 实现 Transport：正在处理消息的节点（测试直接发出时为 simClient）向 addr 发送消息
+func (sim *SimNetwork) Send(addr string, data []byte) error {
+	from := simClient
+	if sim.current != nil {
+		from = sim.current.Addr
+	}
+	command := "?"
+	if request, err := chainParams.unwrapMessage(data); err == nil && len(request) >= commandLength {
+		command = bytesToCommand(request[:commandLength])
+	}
+	delivery := SimDelivery{At: sim.clock, From: from, To: addr, Command: command, Dropped: true}
+
+	to := sim.nodes[addr]
+	if to == nil || !to.Up {
+		sim.Trace = append(sim.Trace, delivery)
+		return fmt.Errorf("dial %s: connection refused", addr)
+	}
+	if !sim.connected(from, addr) {
+		sim.Trace = append(sim.Trace, delivery)
+		return fmt.Errorf("dial %s: i/o timeout", addr)
+	}
+	if from != addr && from != simClient && sim.config.DropRate > 0 && sim.rand.Float64() < sim.config.DropRate {
+		sim.Trace = append(sim.Trace, delivery)
+		return nil
+	}
+
+	delivery.Dropped = false
+	sim.Trace = append(sim.Trace, delivery)
+	sim.schedule(sim.latency(from, addr), to, from, append([]byte(nil), data...), nil)
+
+	return nil
+}
+
+// latency from 到 to 的一条消息的延迟
+func (sim *SimNetwork) latency(from, to string) time.Duration {
+	delay, ok := sim.links[from+" "+to]
+	if !ok {
+		delay = sim.config.Latency
+	}
+	if sim.config.Jitter > 0 {
+		delay += time.Duration(sim.rand.Int63n(int64(sim.config.Jitter)))
+	}
+
+	return delay
+}
+
+func (sim *SimNetwork) schedule(delay time.Duration, node *SimNode, from string, data []byte, task func()) {
+	sim.seq++
+	heap.Push(&sim.events, &simEvent{sim.clock + delay, sim.seq, node, node.epoch, from, data, task})
+}
+
+// SetLatency 设置 from 到 to 方向的基本延迟，代替 SimConfig.Latency
+func (sim *SimNetwork) SetLatency(from, to *SimNode, delay time.Duration) {
+	if sim.links == nil {
+		sim.links = make(map[string]time.Duration)
+	}
+	sim.links[from.Addr+" "+to.Addr] = delay
+}
+
+// Partition 把节点分成互相不通的几组，没有列出的节点单独成为一组。测试直接发出的命令不受影响
+func (sim *SimNetwork) Partition(groups ...[]*SimNode) {
+	sim.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, node := range group {
+			sim.groups[node.Addr] = i + 1
+		}
+	}
+}
+
+// Heal 取消分区
+func (sim *SimNetwork) Heal() {
+	sim.groups = nil
+}
+
+func (sim *SimNetwork) connected(from, to string) bool {
+	if sim.groups == nil || from == to || from == simClient {
+		return true
+	}
+	a, b := sim.groups[from], sim.groups[to]
+
+	return a != 0 && a == b
+}
+
+// Stop 节点崩溃：内存中的状态和还没处理的消息丢失，区块库和钱包保留
+func (sim *SimNetwork) Stop(node *SimNode) {
+	node.Up = false
+	node.epoch++
+}
+
+// Start 重启节点：从空的内存状态开始，普通节点向领导者发送版本消息，同步停止期间的区块
+func (sim *SimNetwork) Start(node *SimNode) {
+	node.Up = true
+	node.Err = nil
+	node.state = sim.newNodeState(node)
+	leader := sim.Leader(node.Shard)
+	if node == leader {
+		return
+	}
+	sim.Do(node, func() {
+		bc := sim.chains.Blockchain(node.ID)
+		defer sim.chains.Release(bc)
+		sendVersion(leader.Addr, bc, node.Shard)
+	})
+}
+
+// Do 在当前时刻以 node 的身份运行 f，f 中发出的消息和节点处理消息时一样经过模拟网络
+func (sim *SimNetwork) Do(node *SimNode, f func()) {
+	sim.schedule(0, node, "", nil, f)
+}
+
+// Transfer 代替 HTTP 接口的 createProposal：让 from 节点用钱包中的 fromAddress 向 to 节点上的 toAddress 转账，
+// to 在其他分片时是跨分片转账
+func (sim *SimNetwork) Transfer(from *SimNode, fromAddress string, to *SimNode, toAddress string, amount int) {
+	command := fmt.Sprintf("blockchain_go send -from %s -to %s -amount %d mine", fromAddress, toAddress, amount)
+	payload := gobEncode(Testdata{simClient, command, from.ID, to.ID})
+	request := append(commandToBytes("sendTestdata"), payload...)
+
+	sim.current = nil
+	if err := sim.Send(from.Addr, chainParams.wrapMessage(request)); err != nil {
+		logWarnf("transfer from %s: %v", from.Addr, err)
+	}
+}
+
+// step 处理下一个事件
+func (sim *SimNetwork) step() {
+	ev := heap.Pop(&sim.events).(*simEvent)
+	sim.clock = ev.at
+	node := ev.node
+	if !node.Up || ev.epoch != node.epoch {
+		return
+	}
+
+	sim.current = node
+	node.state.restore()
+	defer func() {
+		if r := recover(); r != nil {
+			logWarnf("node %s crashed: %v\n%s", node.Addr, r, debug.Stack())
+			node.Err = r
+			sim.Stop(node)
+		}
+		node.state = captureNodeState()
+		sim.current = nil
+	}()
+	if ev.task != nil {
+		ev.task()
+	} else {
+		handleMessage(sim.chains, ev.data, ev.from)
+	}
+}
+
+// Run 处理消息直到网络空闲
+func (sim *SimNetwork) Run() error {
+	for n := 0; sim.events.Len() > 0; n++ {
+		if n >= maxSimEvents {
+			return fmt.Errorf("network is still busy after %d events", maxSimEvents)
+		}
+		sim.step()
+	}
+
+	return nil
+}
+
+// RunFor 处理虚拟时钟在 d 之内到达的消息，然后把时钟拨到 d 之后
+func (sim *SimNetwork) RunFor(d time.Duration) {
+	end := sim.clock + d
+	for sim.events.Len() > 0 && sim.events[0].at <= end {
+		sim.step()
+	}
+	sim.clock = end
+}
+
+// Crashed 处理消息时 panic 的节点
+func (sim *SimNetwork) Crashed() []*SimNode {
+	var crashed []*SimNode
+	for _, node := range sim.order {
+		if node.Err != nil {
+			crashed = append(crashed, node)
+		}
+	}
+
+	return crashed
+}
+
+// Height 节点的链高
+func (sim *SimNetwork) Height(node *SimNode) int {
+	bc := sim.chains.Blockchain(node.ID)
+	defer sim.chains.Release(bc)
+
+	return bc.GetBestHeight()
+}
+
+// Tip 节点的链尾
+func (sim *SimNetwork) Tip(node *SimNode) []byte {
+	bc := sim.chains.Blockchain(node.ID)
+	defer sim.chains.Release(bc)
+
+	return bc.Tip()
+}
+
+// Balance 节点的 UTXO 集中地址的余额
+func (sim *SimNetwork) Balance(node *SimNode, address string) int {
+	bc := sim.chains.Blockchain(node.ID)
+	defer sim.chains.Release(bc)
+
+	return UTXOSet{bc}.Balance(address)
+}
+
+// converged 分片中所有运行的节点链尾相同，返回相同时的链尾
+func (sim *SimNetwork) converged(shard int) ([]byte, error) {
+	var tip []byte
+	for _, node := range sim.Shard(shard) {
+		if !node.Up {
+			continue
+		}
+		nodeTip := sim.Tip(node)
+		if tip == nil {
+			tip = nodeTip
+		} else if string(tip) != string(nodeTip) {
+			return nil, fmt.Errorf("%s is at %x, expected %x", node.Addr, nodeTip, tip)
+		}
+	}
+	if tip == nil {
+		return nil, errors.New("no node of the shard is running")
+	}
+
+	return tip, nil
+}
+
+// traceOf 去掉时间以外的随机内容后的消息记录，用来比较两次运行
+func traceOf(sim *SimNetwork) []string {
+	var trace []string
+	for _, d := range sim.Trace {
+		trace = append(trace, d.String())
+	}
+
+	return trace
+}
+
+func TestSimNetworkDeterministic(t *testing.T) {
+	run := func(seed int64) []string {
+		sim := NewSimNetwork(t, SimConfig{Shards: 1, NodesPerShard: 3, Seed: seed, Jitter: 20 * time.Millisecond, DropRate: 0.1})
+		shard := sim.Shard(0)
+		sim.Transfer(shard[1], shard[1].Address, shard[2], shard[2].Address, 5)
+		assert.Nil(t, sim.Run())
+		assert.Empty(t, sim.Crashed())
+		return traceOf(sim)
+	}
+
+	first := run(7)
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, run(7), "the same seed gives the same messages in the same order")
+	assert.NotEqual(t, first, run(8))
+}
+
+func TestSimNetworkFaults(t *testing.T) {
+	sim := NewSimNetwork(t, SimConfig{Shards: 1, NodesPerShard: 3, Seed: 1})
+	shard := sim.Shard(0)
+	leader, a, b := shard[0], shard[1], shard[2]
+	var errs []error
+	send := func(from, to *SimNode) {
+		sim.Do(from, func() {
+			errs = append(errs, transport.Send(to.Addr, chainParams.wrapMessage(commandToBytes("ping"))))
+		})
+		assert.Nil(t, sim.Run())
+	}
+
+	// 延迟
+	sim.SetLatency(a, leader, 50*time.Millisecond)
+	start := sim.Now()
+	send(a, leader)
+	assert.Nil(t, errs[0])
+	assert.Equal(t, start+50*time.Millisecond, sim.Now(), "the clock advances to the delivery")
+
+	// 分区两边互相不可达，同一边照常
+	sim.Partition([]*SimNode{leader, a}, []*SimNode{b})
+	send(a, b)
+	send(a, leader)
+	assert.NotNil(t, errs[1])
+	assert.Nil(t, errs[2])
+	sim.Heal()
+	send(a, b)
+	assert.Nil(t, errs[3])
+
+	// 停止的节点拒绝连接，发给它还没处理的消息丢失
+	sim.Do(a, func() { sendData(b.Addr, commandToBytes("ping")) })
+	sim.RunFor(time.Millisecond)
+	sim.Stop(b)
+	send(a, b)
+	assert.NotNil(t, errs[4])
+	last := sim.Trace[len(sim.Trace)-1]
+	assert.True(t, last.Dropped)
+	assert.Equal(t, "ping", last.Command)
+	sim.Start(b)
+	assert.Nil(t, sim.Run())
+	assert.Empty(t, sim.Crashed())
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+)
+
+// 轻节点协议
+//
+// 轻节点只保存区块头，向全节点发送 getheaders 取得 Locator 中第一个共同区块之后的区块头（hotstuff 区块附带法定人数证书），
+// 再用 getproofs 请求涉及钱包公钥哈希的交易及其默克尔证明，在本地对照区块头验证。
+// getcfilters 取得区块过滤器（见 block_filter.go），轻节点只为过滤器命中的区块请求证明。
+// 全节点的回复和其他命令一样，重新连接请求中的 AddrFrom 发送
+
+// maxHeadersPerMessage 一条 headers 消息最多携带的区块头数，收到满额时轻节点继续请求
+const maxHeadersPerMessage = 2000
+
+type getheaders struct {
+	AddrFrom string
+	Height   int
+	Locator  [][]byte // 轻节点的区块哈希（见 LightClient.locator），不为空时代替 Height 找到双方主链的分叉点
+}
+
+type headers struct {
+	AddrFrom string
+	Headers  [][]byte
+	QCs      [][]byte // 与 Headers 一一对应，没有证书时为空
+}
+
+type getproofs struct {
+	AddrFrom     string
+	PubKeyHashes [][]byte
+	Height       int
+}
+
+type proofs struct {
+	AddrFrom     string
+	PubKeyHashes [][]byte // 请求中的公钥哈希
+	Height       int      // 全节点扫描到的高度
+	Items        []TxProof
+}
+
+// TxProof 交易和它在区块中的默克尔包含证明
+type TxProof struct {
+	Tx        []byte
+	BlockHash []byte
+	Index     int
+	Siblings  [][]byte
+}
+
+// HeadersAfter 按高度升序返回高度大于 height 的区块头，最多 max 个，以及对应的法定人数证书
+func (bc *Blockchain) HeadersAfter(height, max int) ([]*BlockHeader, []*BlockQC, error) {
+	// 沿区块头遍历，区块体已被修剪的区块也能提供区块头
+	var chain []*BlockHeader
+	for hash := bc.Tip(); len(hash) > 0; {
+		header, err := bc.blockHeader(hash)
+		if err != nil || header.Height <= height {
+			break
+		}
+		chain = append(chain, header)
+		hash = header.PrevBlockHash
+	}
+
+	var headers []*BlockHeader
+	var qcs []*BlockQC
+	for i := len(chain) - 1; i >= 0 && len(headers) < max; i-- {
+		qc, err := bc.BlockQC(chain[i].Hash())
+		if err != nil {
+			return nil, nil, err
+		}
+		headers = append(headers, chain[i])
+		qcs = append(qcs, qc)
+	}
+
+	return headers, qcs, nil
+}
+
+// locatorHeight locator 中第一个在本地主链上的区块的高度，都不在时为 -1
+func (bc *Blockchain) locatorHeight(locator [][]byte) int {
+	for _, hash := range locator {
+		if header, err := bc.blockHeader(hash); err == nil && bc.onMainChain(header) {
+			return header.Height
+		}
+	}
+
+	return -1
+}
+
+// FindProofs 返回高度大于 height 的区块中，输出属于 pubKeyHashes 或由它们花费输入的交易及其包含证明
+func (bc *Blockchain) FindProofs(pubKeyHashes [][]byte, height int) ([]TxProof, error) {
+	var items []TxProof
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+		if block == nil || block.Height <= height {
+			break
+		}
+
+		var tree *MerkleTree
+		for i, tx := range block.Transactions {
+			if !touchesKeys(tx, pubKeyHashes) {
+				continue
+			}
+			if tree == nil {
+				tree = block.MerkleTree()
+			}
+			proof, err := tree.Proof(i)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, TxProof{tx.Serialize(), block.Hash, proof.Index, proof.Siblings})
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+// touchesKeys 交易是否向 pubKeyHashes 付款或花费它们的输出
+func touchesKeys(tx *Transaction, pubKeyHashes [][]byte) bool {
+	for _, pubKeyHash := range pubKeyHashes {
+		for _, out := range tx.Vout {
+			if out.IsLockedWithKey(pubKeyHash) {
+				return true
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Vin {
+			if in.UsesKey(pubKeyHash) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func sendGetHeaders(address string, height int, locator [][]byte) {
+	payload := gobEncode(getheaders{nodeAddress, height, locator})
+	request := append(commandToBytes("getheaders"), payload...)
+
+	sendData(address, request)
+}
+
+func sendGetProofs(address string, pubKeyHashes [][]byte, height int) {
+	payload := gobEncode(getproofs{nodeAddress, pubKeyHashes, height})
+	request := append(commandToBytes("getproofs"), payload...)
+
+	sendData(address, request)
+}
+
+// handleGetHeaders 全节点回复区块头
+func handleGetHeaders(chains *ChainService, request []byte) {
+	var payload getheaders
+	decodePayload(request, &payload)
+
+	bc := chains.Blockchain(NodeIPAddress)
+	if bc == nil {
+		return
+	}
+	defer chains.Release(bc)
+
+	height := payload.Height
+	if len(payload.Locator) > 0 {
+		height = bc.locatorHeight(payload.Locator)
+	}
+	list, qcs, err := bc.HeadersAfter(height, maxHeadersPerMessage)
+	if err != nil {
+		logWarnf("headers for %s: %v", payload.AddrFrom, err)
+		return
+	}
+	reply := headers{AddrFrom: nodeAddress}
+	for i, header := range list {
+		reply.Headers = append(reply.Headers, header.Bytes())
+		var qc []byte
+		if qcs[i] != nil {
+			qc = qcs[i].Serialize()
+		}
+		reply.QCs = append(reply.QCs, qc)
+	}
+	logDebugf("send %d headers after height %d to %s", len(list), height, payload.AddrFrom)
+
+	sendData(payload.AddrFrom, append(commandToBytes("headers"), gobEncode(reply)...))
+}
+
+// handleGetProofs 全节点回复涉及轻节点钱包的交易和包含证明
+func handleGetProofs(chains *ChainService, request []byte) {
+	var payload getproofs
+	decodePayload(request, &payload)
+
+	bc := chains.Blockchain(NodeIPAddress)
+	if bc == nil {
+		return
+	}
+	defer chains.Release(bc)
+
+	height := bc.GetBestHeight()
+	items, err := bc.FindProofs(payload.PubKeyHashes, payload.Height)
+	if err != nil {
+		logWarnf("proofs for %s: %v", payload.AddrFrom, err)
+		return
+	}
+	logDebugf("send %d proofs after height %d to %s", len(items), payload.Height, payload.AddrFrom)
+
+	sendData(payload.AddrFrom, append(commandToBytes("proofs"), gobEncode(proofs{nodeAddress, payload.PubKeyHashes, height, items})...))
+}
+
+// decodePayload 解码命令之后的 gob 数据
+func decodePayload(request []byte, payload interface{}) {
+	dec := gob.NewDecoder(bytes.NewReader(request[commandLength:]))
+	if err := dec.Decode(payload); err != nil {
+		log.Panic(err)
+	}
+}
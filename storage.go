@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+// 存储引擎
+//
+// Blockchain 和 UTXOSet 只通过 Store 访问数据：View 在一致的只读快照上执行，Update 中的全部写入
+// 作为一批原子提交，fn 返回错误时全部丢弃。数据按名称分桶，桶内按键的字节序遍历。
+// 在此之上按用途分为三类存储：
+//   - 区块存储 blockStore：blocks 桶中的区块体和链尾（"l" 键）
+//   - 链状态 chainState：chainstate 桶中按交易 ID 保存的 UTXO 记录
+//   - 索引存储 indexStore：按区块哈希等键保存的过滤器、证书、UTXO 承诺、撤销数据、已修剪区块的区块头和元数据
+//
+// 引擎有 bolt 文件（openBoltStore）和内存（NewMemStore）两种实现
+
+var (
+	errBucketNotFound = errors.New("bucket not found")
+	errBucketExists   = errors.New("bucket already exists")
+	errTxNotWritable  = errors.New("transaction is read-only")
+	errStoreClosed    = errors.New("store is closed")
+)
+
+// Store 区块库的存储引擎
+type Store interface {
+	View(fn func(tx StoreTx) error) error
+	Update(fn func(tx StoreTx) error) error
+	Close() error
+}
+
+// StoreTx 存储事务
+type StoreTx interface {
+	// Bucket 桶不存在时返回 nil
+	Bucket(name string) StoreBucket
+	CreateBucket(name string) (StoreBucket, error)
+	CreateBucketIfNotExists(name string) (StoreBucket, error)
+	// DeleteBucket 桶不存在时返回 errBucketNotFound
+	DeleteBucket(name string) error
+}
+
+// StoreBucket 键值桶，Get 返回的数据只在事务内有效
+type StoreBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	ForEach(fn func(k, v []byte) error) error
+}
+
+// tipKey blocks 桶中保存链尾区块哈希的键
+const tipKey = "l"
+
+// blockStore 区块体和链尾
+type blockStore struct {
+	b StoreBucket
+}
+
+func blocksOf(tx StoreTx) blockStore {
+	return blockStore{tx.Bucket(blocksBucket)}
+}
+
+// createBlockStore 新库创建 blocks 桶
+func createBlockStore(tx StoreTx) (blockStore, error) {
+	b, err := tx.CreateBucket(blocksBucket)
+	return blockStore{b}, err
+}
+
+// Tip 链尾区块的哈希
+func (s blockStore) Tip() []byte {
+	return append([]byte(nil), s.b.Get([]byte(tipKey))...)
+}
+
+func (s blockStore) SetTip(hash []byte) error {
+	return s.b.Put([]byte(tipKey), hash)
+}
+
+// Block 区块体不在本地时返回 nil
+func (s blockStore) Block(hash []byte) *Block {
+	data := s.b.Get(hash)
+	if data == nil {
+		return nil
+	}
+
+	return DeserializeBlock(data)
+}
+
+// TipBlock 链尾区块
+func (s blockStore) TipBlock() *Block {
+	return s.Block(s.b.Get([]byte(tipKey)))
+}
+
+func (s blockStore) Has(hash []byte) bool {
+	return s.b.Get(hash) != nil
+}
+
+func (s blockStore) Put(block *Block) error {
+	return s.b.Put(block.Hash, block.Serialize())
+}
+
+func (s blockStore) Delete(hash []byte) error {
+	return s.b.Delete(hash)
+}
+
+// chainState UTXO 集，键为交易 ID
+type chainState struct {
+	b StoreBucket
+}
+
+func chainStateOf(tx StoreTx) chainState {
+	return chainState{tx.Bucket(utxoBucket)}
+}
+
+// Outputs 交易 txID 的未花费输出
+func (s chainState) Outputs(txID []byte) (TXOutputs, bool) {
+	data := s.b.Get(txID)
+	if data == nil {
+		return TXOutputs{}, false
+	}
+
+	return DeserializeOutputs(data), true
+}
+
+func (s chainState) Put(txID []byte, outs TXOutputs) error {
+	return s.b.Put(txID, outs.Serialize())
+}
+
+func (s chainState) Delete(txID []byte) error {
+	return s.b.Delete(txID)
+}
+
+// ForEach 按交易 ID 升序遍历 UTXO 记录，txID 只在回调内有效
+func (s chainState) ForEach(fn func(txID []byte, outs TXOutputs) error) error {
+	return s.b.ForEach(func(k, v []byte) error {
+		return fn(k, DeserializeOutputs(v))
+	})
+}
+
+// putAll 写入 UTXO 记录，键为十六进制交易 ID
+func (s chainState) putAll(utxos map[string]TXOutputs) error {
+	for txID, outs := range utxos {
+		key, err := hex.DecodeString(txID)
+		if err != nil {
+			return err
+		}
+		if err := s.Put(key, outs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexStore 由区块派生的索引，每种索引一个桶，写入时自动创建
+type indexStore struct {
+	tx StoreTx
+}
+
+func indexesOf(tx StoreTx) indexStore {
+	return indexStore{tx}
+}
+
+// Get 返回数据的副本，索引或键不存在时返回 nil
+func (s indexStore) Get(index string, key []byte) []byte {
+	b := s.tx.Bucket(index)
+	if b == nil {
+		return nil
+	}
+	if v := b.Get(key); v != nil {
+		return append([]byte(nil), v...)
+	}
+
+	return nil
+}
+
+func (s indexStore) Put(index string, key, value []byte) error {
+	b, err := s.tx.CreateBucketIfNotExists(index)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(key, value)
+}
+
+func (s indexStore) Delete(index string, key []byte) error {
+	if b := s.tx.Bucket(index); b != nil {
+		return b.Delete(key)
+	}
+
+	return nil
+}
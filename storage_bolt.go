@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore 保存在 bolt 文件中的 Store
+type boltStore struct {
+	db *bolt.DB
+}
+
+// openBoltStore 打开或创建 bolt 文件，其他进程占用文件时最多等待 timeout，0 表示一直等待
+func openBoltStore(path string, mode os.FileMode, timeout time.Duration) (Store, error) {
+	db, err := bolt.Open(path, mode, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+
+	return boltStore{db}, nil
+}
+
+func (s boltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s boltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s boltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Bucket(name string) StoreBucket {
+	// 不能直接返回 nil 的 *bolt.Bucket，那样得到的接口值不是 nil
+	if b := t.tx.Bucket([]byte(name)); b != nil {
+		return b
+	}
+
+	return nil
+}
+
+func (t boltTx) CreateBucket(name string) (StoreBucket, error) {
+	b, err := t.tx.CreateBucket([]byte(name))
+	if err == bolt.ErrBucketExists {
+		return nil, errBucketExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name string) (StoreBucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (t boltTx) DeleteBucket(name string) error {
+	err := t.tx.DeleteBucket([]byte(name))
+	if err == bolt.ErrBucketNotFound {
+		return errBucketNotFound
+	}
+
+	return err
+}
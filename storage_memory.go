@@ -0,0 +1,187 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// memStore 内存中的 Store，用于测试和模拟，不落盘。
+// 已提交的桶不再修改：写事务第一次写某个桶时复制一份，提交时整体替换，
+// 因此读事务拿到的总是提交时的快照，读写互不阻塞
+type memStore struct {
+	mu      sync.Mutex // 保护 buckets 和 closed
+	writeMu sync.Mutex // 同一时刻只有一个写事务
+	buckets map[string]map[string][]byte
+	closed  bool
+}
+
+// NewMemStore 创建空的内存存储
+func NewMemStore() Store {
+	return &memStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memStore) snapshot() (map[string]map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errStoreClosed
+	}
+
+	return s.buckets, nil
+}
+
+func (s *memStore) View(fn func(tx StoreTx) error) error {
+	buckets, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return fn(&memTx{buckets: buckets})
+}
+
+func (s *memStore) Update(fn func(tx StoreTx) error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	committed, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+
+	tx := &memTx{buckets: make(map[string]map[string][]byte, len(committed)), writable: true, copied: make(map[string]bool)}
+	for name, b := range committed {
+		tx.buckets[name] = b
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.buckets = tx.buckets
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *memStore) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	return nil
+}
+
+type memTx struct {
+	buckets  map[string]map[string][]byte
+	writable bool
+	copied   map[string]bool // 本事务已复制过的桶
+}
+
+func (t *memTx) Bucket(name string) StoreBucket {
+	if _, ok := t.buckets[name]; !ok {
+		return nil
+	}
+
+	return &memBucket{t, name}
+}
+
+func (t *memTx) CreateBucket(name string) (StoreBucket, error) {
+	if !t.writable {
+		return nil, errTxNotWritable
+	}
+	if _, ok := t.buckets[name]; ok {
+		return nil, errBucketExists
+	}
+	t.buckets[name] = make(map[string][]byte)
+	t.copied[name] = true
+
+	return &memBucket{t, name}, nil
+}
+
+func (t *memTx) CreateBucketIfNotExists(name string) (StoreBucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *memTx) DeleteBucket(name string) error {
+	if !t.writable {
+		return errTxNotWritable
+	}
+	if _, ok := t.buckets[name]; !ok {
+		return errBucketNotFound
+	}
+	delete(t.buckets, name)
+	delete(t.copied, name)
+
+	return nil
+}
+
+// writableData 写之前复制已提交的桶
+func (t *memTx) writableData(name string) (map[string][]byte, error) {
+	if !t.writable {
+		return nil, errTxNotWritable
+	}
+	data, ok := t.buckets[name]
+	if !ok {
+		return nil, errBucketNotFound
+	}
+	if !t.copied[name] {
+		clone := make(map[string][]byte, len(data)+1)
+		for k, v := range data {
+			clone[k] = v
+		}
+		t.buckets[name] = clone
+		t.copied[name] = true
+		data = clone
+	}
+
+	return data, nil
+}
+
+type memBucket struct {
+	tx   *memTx
+	name string
+}
+
+func (b *memBucket) Get(key []byte) []byte {
+	return b.tx.buckets[b.name][string(key)]
+}
+
+func (b *memBucket) Put(key, value []byte) error {
+	data, err := b.tx.writableData(b.name)
+	if err != nil {
+		return err
+	}
+	data[string(key)] = append([]byte(nil), value...)
+
+	return nil
+}
+
+func (b *memBucket) Delete(key []byte) error {
+	data, err := b.tx.writableData(b.name)
+	if err != nil {
+		return err
+	}
+	delete(data, string(key))
+
+	return nil
+}
+
+func (b *memBucket) ForEach(fn func(k, v []byte) error) error {
+	data := b.tx.buckets[b.name]
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := fn([]byte(k), data[k]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
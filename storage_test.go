@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 两种存储引擎行为一致
+func TestStore(t *testing.T) {
+	boltDB, err := openBoltStore(filepath.Join(t.TempDir(), "store_test.db"), 0600, 0)
+	assert.Nil(t, err)
+	defer boltDB.Close()
+
+	for name, db := range map[string]Store{"bolt": boltDB, "memory": NewMemStore()} {
+		err := db.Update(func(tx StoreTx) error {
+			assert.Nil(t, tx.Bucket("b"), name)
+			b, err := tx.CreateBucket("b")
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket("b")
+			assert.Equal(t, errBucketExists, err, name)
+			for _, k := range []string{"c", "a", "b"} {
+				if err := b.Put([]byte(k), []byte("v"+k)); err != nil {
+					return err
+				}
+			}
+			return b.Delete([]byte("b"))
+		})
+		assert.Nil(t, err, name)
+
+		// 返回错误的写事务全部丢弃
+		failed := errors.New("rollback")
+		err = db.Update(func(tx StoreTx) error {
+			tx.Bucket("b").Put([]byte("d"), []byte("vd"))
+			tx.CreateBucket("other")
+			return failed
+		})
+		assert.Equal(t, failed, err, name)
+
+		var keys []string
+		err = db.View(func(tx StoreTx) error {
+			assert.Nil(t, tx.Bucket("other"), name)
+			b := tx.Bucket("b")
+			assert.Equal(t, []byte("va"), b.Get([]byte("a")), name)
+			assert.Nil(t, b.Get([]byte("b")), name)
+			return b.ForEach(func(k, v []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+		})
+		assert.Nil(t, err, name)
+		assert.Equal(t, []string{"a", "c"}, keys, "keys are visited in byte order")
+
+		err = db.Update(func(tx StoreTx) error {
+			assert.Nil(t, tx.DeleteBucket("b"), name)
+			return tx.DeleteBucket("b")
+		})
+		assert.Equal(t, errBucketNotFound, err, name)
+	}
+}
+
+func TestMemStoreSnapshot(t *testing.T) {
+	db := NewMemStore()
+	db.Update(func(tx StoreTx) error {
+		b, _ := tx.CreateBucket("b")
+		return b.Put([]byte("k"), []byte("old"))
+	})
+
+	// 读事务看到开始时的快照，不受之后提交的写入影响
+	db.View(func(tx StoreTx) error {
+		assert.Nil(t, db.Update(func(w StoreTx) error {
+			return w.Bucket("b").Put([]byte("k"), []byte("new"))
+		}))
+		assert.Equal(t, []byte("old"), tx.Bucket("b").Get([]byte("k")))
+		assert.Equal(t, errTxNotWritable, tx.Bucket("b").Put([]byte("k"), nil))
+		return nil
+	})
+	db.View(func(tx StoreTx) error {
+		assert.Equal(t, []byte("new"), tx.Bucket("b").Get([]byte("k")))
+		return nil
+	})
+
+	db.Close()
+	assert.Equal(t, errStoreClosed, db.View(func(tx StoreTx) error { return nil }))
+}
+
+// 整条链可以只在内存中运行
+func TestMemStoreBlockchain(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc, err := createBlockchain(NewMemStore(), genesis)
+	assert.Nil(t, err)
+	utxos := UTXOSet{bc}
+	utxos.Reindex()
+	_, err = bc.Generate(3, address)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 3, bc.GetBestHeight())
+	assert.Len(t, bc.GetBlockHashes(), 4)
+	commitment := utxos.Commitment()
+	utxos.Reindex()
+	assert.Equal(t, commitment, utxos.Commitment())
+	assert.Equal(t, utxos.TotalValue(), utxos.Balance(address))
+	report, err := utxos.AuditSupply()
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -9,14 +8,11 @@ import (
 	"math/big"
 	"strings"
 
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
 )
 
-const subsidy = 10
-
 // Transaction represents a Bitcoin transaction
 type Transaction struct {
 	ID   []byte
@@ -31,25 +27,26 @@ func (tx Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-// Serialize returns a serialized Transaction
+// Serialize 按规范二进制编码序列化交易，格式见 encoding.go
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
-
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return encoded.Bytes()
+	return encodeTransaction(&tx)
 }
 
-// Hash returns the hash of the Transaction
+// Hash 计算交易 ID，所有交易 ID 都按这一规则计算。
+// 输入的签名不参与计算，普通输入的公钥也不参与，交易 ID 在签名前后不变，改写签名也得不到新的 ID；
+// coinbase 输入的 PubKey 保存的是奖励数据，仍然计算在内
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
-	txCopy := *tx
-	txCopy.ID = []byte{}
+	coinbase := tx.IsCoinbase()
+	txCopy := Transaction{[]byte{}, make([]TXInput, len(tx.Vin)), tx.Vout}
+	for i, vin := range tx.Vin {
+		vin.Signature = nil
+		if !coinbase {
+			vin.PubKey = nil
+		}
+		txCopy.Vin[i] = vin
+	}
 
 	hash = sha256.Sum256(txCopy.Serialize())
 
@@ -58,33 +55,25 @@ func (tx *Transaction) Hash() []byte {
 
 // Sign signs each input of a Transaction
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+	tx.SignWithHashType(privKey, prevTXs, SigHashAll)
+}
+
+// SignWithHashType 以指定的签名哈希类型对交易的每个输入签名
+func (tx *Transaction) SignWithHashType(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction, hashType SigHashType) {
 	if tx.IsCoinbase() {
 		return
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	prevOuts, err := tx.prevOutputs(prevTXs)
+	if err != nil {
+		log.Panic("ERROR: Previous transaction is not correct: ", err)
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for inID, vin := range txCopy.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+	for inID := range tx.Vin {
+		err := tx.SignInput(privKey, inID, prevOuts, hashType)
 		if err != nil {
 			log.Panic(err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
-
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
 	}
 }
 
@@ -130,63 +119,78 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	return txCopy
 }
 
-// Verify 这段代码是一个Go语言方法，用于验证一个交易（Transaction）的有效性。它接受一个包含前一笔交易的映射 `prevTXs` 作为参数，并执行以下步骤：
-//1. 首先，它检查交易是否是Coinbase交易，如果是，它将直接返回`true`，因为Coinbase交易无需验证。
-//2. 对于非Coinbase交易，它遍历交易的每个输入（`vin`），并检查 `prevTXs` 中是否存在与输入引用的前一笔交易相对应的交易。
-//如果找不到相应的前一笔交易，它将引发一个日志记录（Panic）错误，指示前一笔交易不正确。
-//3. 接下来，它创建一个交易的拷贝 `txCopy`，使用 `TrimmedCopy` 方法，该拷贝不包含输入的签名和公钥信息。
-//4. 它使用椭圆曲线P-256（`elliptic.P256()`）来定义椭圆曲线。
-//5. 然后，它对交易的每个输入执行以下操作：
-//- 将交易拷贝 `txCopy` 中当前输入的签名（`Signature`）和公钥（`PubKey`）字段设置为前一笔交易的输出（Vout）中对应的公钥哈希（PubKeyHash）。
-//- 从签名中提取 `r` 和 `s` 值，并从公钥中提取 `x` 和 `y` 值。
-//- 生成要验证的数据，通常是交易拷贝的十六进制表示，存储在 `dataToVerify` 变量中。
-//- 创建一个ECDSA公钥对象 `rawPubKey`，然后使用它来验证签名的有效性。如果验证失败，返回`false`，表示交易无效。
-//- 最后，将交交易拷贝 `txCopy` 中的公钥字段重置为`nil`，以便进行下一个输入的验证。
-//6. 如果所有的输入都成功验证，该方法返回`true`，表示交易是有效的。
-//总的来说，这段代码用于验证一个交易的有效性，确保交易的输入引用了正确的前一笔交易，并且交易的签名是有效的。这是区块链中重要的一部分，用于确保交易的一致性和安全性。
+// Verify 验证交易每个输入的签名。
+// 签名末尾带签名哈希类型的使用 SignatureHash 规范签名原文验证，
+// 旧版本节点产生的签名（r||s，没有类型字节）仍按原来的 TrimmedCopy 方式验证。
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	prevOuts, err := tx.prevOutputs(prevTXs)
+	if err != nil {
+		log.Panic("ERROR: Previous transaction is not correct: ", err)
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	return tx.VerifyOutputs(prevOuts)
+}
+
+// VerifyOutputs 同 Verify，prevOuts 是各输入花费的输出，按输入顺序排列
+func (tx *Transaction) VerifyOutputs(prevOuts []TXOutput) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+	if len(prevOuts) != len(tx.Vin) {
+		return false
+	}
 
 	for inID, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		if len(vin.Signature) == ecdsaSignatureLen+1 {
+			if !tx.VerifyInput(inID, prevOuts) {
+				return false
+			}
+			continue
+		}
+		if !tx.verifyLegacyInput(inID, prevOuts) {
 			return false
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
 	return true
 }
 
+// verifyLegacyInput 验证旧格式签名：签名原文是 TrimmedCopy 的 "%x\n" 字符串。
+// 和 VerifyInput 一样要求公钥属于被花费的输出
+func (tx *Transaction) verifyLegacyInput(inID int, prevOuts []TXOutput) bool {
+	vin := tx.Vin[inID]
+	if len(vin.Signature) == 0 || len(vin.PubKey) == 0 {
+		return false
+	}
+	if !vin.UsesKey(prevOuts[inID].PubKeyHash) {
+		return false
+	}
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[inID].PubKey = prevOuts[inID].PubKeyHash
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(vin.Signature)
+	r.SetBytes(vin.Signature[:(sigLen / 2)])
+	s.SetBytes(vin.Signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(vin.PubKey)
+	x.SetBytes(vin.PubKey[:(keyLen / 2)])
+	y.SetBytes(vin.PubKey[(keyLen / 2):])
+
+	dataToVerify := fmt.Sprintf("%x\n", txCopy)
+
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+	return ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s)
+}
+
 // NewCoinbaseTX 这段代码是一个函数 `NewCoinbaseTX`，用于创建一个 coinbase 交易，即区块链中的首个交易，用于为矿工奖励提供新的货币。
 //下面是这个函数的功能和步骤解释：
 //1. 如果提供的 `data` 为空，则生成随机数据作为 coinbase 交易的数据。这是为了确保每个 coinbase 交易都有一个唯一的数据，用于识别不同的挖矿尝试。
@@ -207,7 +211,7 @@ func NewCoinbaseTX(to, data string) *Transaction {
 	}
 
 	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
+	txout := NewTXOutput(chainParams.Subsidy, to)
 	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
 	tx.ID = tx.Hash()
 
@@ -233,88 +237,86 @@ func NewCoinbaseTX2(to, data string, amount int) *Transaction {
 	return &tx
 }
 
+// PlanSend 为 from 地址选币并确定找零地址，得到可以预览的交易计划。
+// DryRun 时不生成新地址，也不标记 UsedTxId；否则新的找零地址加入 wallets，调用者需要保存钱包文件。
+func PlanSend(wallets *Wallets, from string, recipients []Recipient, UTXOSet *UTXOSet, opts SendOptions) (*TransactionPlan, error) {
+	wallet, ok := wallets.Wallets[from]
+	if !ok {
+		return nil, fmt.Errorf("address %s is not in the wallet", from)
+	}
+
+	return planSend(wallets, from, recipients, UTXOSet.SpendableOutputs(HashPubKey(wallet.PublicKey)), opts)
+}
+
+// planSend 从给定的未花费输出中选币，轻节点使用经过证明的输出
+func planSend(wallets *Wallets, from string, recipients []Recipient, utxos []SpendableOutput, opts SendOptions) (*TransactionPlan, error) {
+	plan, err := PlanTransaction(utxos, recipients, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.Change > 0 {
+		switch {
+		case opts.ReuseChange:
+			plan.ChangeAddress = from
+		case opts.DryRun:
+			plan.ChangeAddress = wallets.PeekChangeAddress()
+		default:
+			plan.ChangeAddress, err = wallets.NewChangeAddress()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return plan, nil
+}
+
 // NewUTXOTransaction 这段代码是一个函数 `NewUTXOTransaction`，用于创建一个新的未花费输出（UTXO）交易。
 //下面是这个函数的功能和步骤解释：
-//1. 获取钱包的公钥哈希（通过 `HashPubKey` 函数）以及可用的未花费输出列表（`validOutputs`）。
-//2. 检查钱包中是否有足够的资金（`amount`）来发送交易，如果资金不足，触发 Panic。
-//3. 构建交易的输入列表（`inputs`），遍历有效的未花费输出，为每个输出创建一个交易输入（`TXInput`）。
-//4. 构建交易的输出列表（`outputs`），包括一个输出用于发送给目标地址 `to`，以及可能的找零地址输出。
-//5. 创建一个交易（`Transaction`）对象，设置其输入和输出列表，计算交易的哈希值（`ID`）。
-//6. 使用钱包的私钥对交易进行签名。
-//7. 返回创建的交易对象。
-//总的来说，这个函数的目的是创建一个新的未花费输出交易，即一个包含输入和输出的交易，其中输出将资金发送给目标地址，
-//并可能返回余额到找零地址。创建交易后，还对其进行签名以确保交易的合法性。
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+//1. 通过 `PlanSend` 按 `opts` 中的选币策略从发送地址的未花费输出中选出输入，并计算找零和手续费。
+//2. 如果资金不足或选币失败，打印错误并返回 nil。
+//3. 找零默认发送到钱包中新生成的地址，避免把找零和发送地址关联在一起；低于粉尘阈值的找零并入手续费。
+//4. 将所选输入所在的交易记入 `UsedTxId`，防止在区块确认前被再次花费。
+//5. 构建交易并使用发送地址的私钥签名，返回创建的交易对象。
+//新的找零地址只加入了 `wallets`，调用者需要调用 `SaveToFile` 保存钱包文件。
+func NewUTXOTransaction(wallets *Wallets, from, to string, amount int, UTXOSet *UTXOSet, opts SendOptions) *Transaction {
+	return NewBatchTransaction(wallets, from, []Recipient{{to, amount}}, UTXOSet, opts)
+}
 
+// NewBatchTransaction 在一笔交易中向多个收款人付款，每个收款人一个输出，最多一个找零输出，
+// 其余与 NewUTXOTransaction 相同
+func NewBatchTransaction(wallets *Wallets, from string, recipients []Recipient, UTXOSet *UTXOSet, opts SendOptions) *Transaction {
 	fmt.Println("")
 	fmt.Println("----------NewUTXOTransaction start-----------------------")
 	fmt.Println("")
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
-	fmt.Println("acc", acc)
-	fmt.Println("validOutputs", validOutputs)
-	fmt.Println("amount", amount)
-	if acc < amount {
-		//log.Panic("ERROR: Not enough funds")
-		fmt.Println("ERROR: Not enough funds")
+	opts.DryRun = false
+	plan, err := PlanSend(wallets, from, recipients, UTXOSet, opts)
+	if err != nil {
+		fmt.Println("ERROR:", err)
 		return nil
-	} else {
-		// Build a list of inputs
-		for txid, outs := range validOutputs {
-			txID, err := hex.DecodeString(txid)
-			if err != nil {
-				log.Panic("txID, err := hex.DecodeString(txid)", err)
-			}
+	}
+	fmt.Println(plan)
 
-			for _, out := range outs {
-				input := TXInput{txID, out, nil, wallet.PublicKey}
-				inputs = append(inputs, input)
-				UsedTxId[txid] = txID
-			}
-		}
-		fmt.Println("inputs------------------------------")
-		//循环遍历打印inputs
-		for _, input := range inputs {
-			fmt.Println("input.Txid", hex.EncodeToString(input.Txid))
-			fmt.Println("input.Vout", input.Vout)
-			fmt.Println("----------------------------")
-			//fmt.Println("input.Signature", input.Signature)
-			//fmt.Println("input.PubKey", input.PubKey)
-		}
-		//遍历打印UsedTxId
-		//for k, v := range UsedTxId {
-		//	fmt.Println("UsedTxId", k, v)
-		//}
-		// Build a list of outputs
-		from := fmt.Sprintf("%s", wallet.GetAddress())
-		outputs = append(outputs, *NewTXOutput(amount, to))
-		if acc > amount {
-			outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
-		}
-		fmt.Println("outputs", outputs)
-		tx := Transaction{nil, inputs, outputs}
-		tx.ID = tx.Hash()
-		fmt.Println("tx.ID", tx.ID)
-		UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
-		fmt.Println("UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)")
-
-		fmt.Println("")
-		fmt.Println("----------NewUTXOTransaction end-----------------------")
-		fmt.Println("")
-		return &tx
+	wallet := wallets.Wallets[from]
+	for _, in := range plan.Inputs {
+		UsedTxId[hex.EncodeToString(in.TxID)] = in.TxID
 	}
+	tx := plan.UnsignedTransaction(wallet.PublicKey)
+	fmt.Println("tx.ID", tx.ID)
+	UTXOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	fmt.Println("")
+	fmt.Println("----------NewUTXOTransaction end-----------------------")
+	fmt.Println("")
+	return tx
 }
 
 // DeserializeTransaction deserializes a transaction
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	transaction, err := decodeTransaction(data)
 	if err != nil {
-		log.Panic(err)
+		log.Panic("DeserializeTransaction: ", err)
 	}
 
 	return transaction
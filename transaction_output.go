@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
 	"log"
 )
 
@@ -44,41 +43,40 @@ func NewTXOutput(value int, address string) *TXOutput {
 }
 
 // TXOutputs collects TXOutput
+// 用作 UTXO 记录时只保存未花费的输出，Indexes[i] 是 Outputs[i] 在交易中的下标（即输入里的 Vout）；
+// Height 是交易所在区块的高度，Reward 表示它是新发行币的奖励交易
 type TXOutputs struct {
 	Outputs []TXOutput
+	Indexes []int
+	Height  int
+	Reward  bool
 }
 
-// Serialize serializes TXOutputs
-func (outs TXOutputs) Serialize() []byte {
-	var buff bytes.Buffer
+// Mature 奖励交易的输出在链高为 bestHeight 时是否已可花费；创世区块的输出不受限制
+func (outs TXOutputs) Mature(bestHeight int) bool {
+	return !outs.Reward || outs.Height == 0 || bestHeight+1-outs.Height >= chainParams.CoinbaseMaturity
+}
 
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(outs)
-	if err != nil {
-		log.Panic(err)
-	}
+// add 追加交易中第 index 个输出
+func (outs *TXOutputs) add(index int, out TXOutput) {
+	outs.Outputs = append(outs.Outputs, out)
+	outs.Indexes = append(outs.Indexes, index)
+}
 
-	return buff.Bytes()
+// Serialize serializes TXOutputs
+func (outs TXOutputs) Serialize() []byte {
+	return encodeOutputs(outs)
 }
 
-// DeserializeOutputs 这段代码的作用是将序列化的交易输出数据反序列化为 `TXOutputs` 结构体。
-//1. `var outputs TXOutputs`：创建一个 `TXOutputs` 类型的变量 `outputs`，用于存储反序列化后的交易输出。
-//2. `if len(data) == 0 { ... }`：检查传入的序列化数据是否为空，如果为空，则记录错误并触发 panic。
-//3. `dec := gob.NewDecoder(bytes.NewReader(data))`：创建一个新的 `gob` 解码器，使用传入的序列化数据初始化它。
-//4. `err := dec.Decode(&outputs)`：使用解码器将数据解码到 `outputs` 变量中。如果解码失败，记录错误并触发 panic。
-//5. `return outputs`：返回反序列化后的交易输出。
-//整个函数的目的是将二进制数据反序列化为 `TXOutputs` 结构体，以便在程序中使用。如果序列化数据为空或解码失败，函数将触发 panic。
+// DeserializeOutputs 将 chainstate 中的 UTXO 记录反序列化为 `TXOutputs`，数据为空或格式错误时触发 panic。
 func DeserializeOutputs(data []byte) TXOutputs {
-	var outputs TXOutputs
-
 	if len(data) == 0 {
 		log.Panic("DeserializeOutputs: Empty data")
 	}
 
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err := dec.Decode(&outputs)
+	outputs, err := decodeOutputs(data)
 	if err != nil {
-		log.Panic("err := dec.Decode(&outputs)", err)
+		log.Panic("DeserializeOutputs: ", err)
 	}
 
 	return outputs
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// genesisBlockData 创世区块的 Data；定义了国库时后面跟着 " treasury M ADDRESS..."
+const genesisBlockData = "NewGenesisBlock"
+
+// mintDataPrefix 铸币交易 coinbase 输入的数据以此开头，后面是随机数和备注
+const mintDataPrefix = "mint "
+
+var errMintingDisabled = errors.New("minting is disabled, the genesis block defines no treasury")
+
+// Treasury 创世区块中定义的国库：铸币交易需要 Addresses 中至少 Threshold 个不同地址的签名
+type Treasury struct {
+	Threshold int
+	Addresses []string
+}
+
+// NewTreasury 检查 m-of-n 设置：1 <= threshold <= 地址个数，地址有效且不重复
+func NewTreasury(threshold int, addresses []string) (*Treasury, error) {
+	return newTreasuryFor(chainParams.AddressVersion, threshold, addresses)
+}
+
+// newTreasuryFor 同 NewTreasury，地址的版本字节必须是 version
+func newTreasuryFor(version byte, threshold int, addresses []string) (*Treasury, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("treasury needs at least one address")
+	}
+	if threshold < 1 || threshold > len(addresses) {
+		return nil, fmt.Errorf("treasury threshold must be between 1 and %d", len(addresses))
+	}
+	seen := make(map[string]bool)
+	for _, address := range addresses {
+		if !validateAddressFor(version, address) {
+			return nil, fmt.Errorf("treasury address %s is not valid", address)
+		}
+		if seen[address] {
+			return nil, fmt.Errorf("treasury address %s is listed twice", address)
+		}
+		seen[address] = true
+	}
+
+	return &Treasury{threshold, append([]string(nil), addresses...)}, nil
+}
+
+// String m-of-n 和成员地址
+func (t *Treasury) String() string {
+	return fmt.Sprintf("%d-of-%d %s", t.Threshold, len(t.Addresses), strings.Join(t.Addresses, ","))
+}
+
+// ParseTreasury 解析逗号分隔的国库地址，addresses 为空时返回 nil，表示不能铸币
+func ParseTreasury(addresses string, threshold int) (*Treasury, error) {
+	if addresses == "" {
+		return nil, nil
+	}
+
+	return NewTreasury(threshold, strings.Split(addresses, ","))
+}
+
+// genesisData 写入创世区块 Data 的内容，treasury 为 nil 时不能铸币
+func genesisData(treasury *Treasury) []byte {
+	if treasury == nil {
+		return []byte(genesisBlockData)
+	}
+
+	fields := append([]string{genesisBlockData, "treasury", strconv.Itoa(treasury.Threshold)}, treasury.Addresses...)
+	return []byte(strings.Join(fields, " "))
+}
+
+// parseGenesisData 从创世区块 Data 中取出国库，没有定义时返回 errMintingDisabled
+func parseGenesisData(data []byte) (*Treasury, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 || fields[1] != "treasury" {
+		return nil, errMintingDisabled
+	}
+	threshold, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid treasury threshold: %v", err)
+	}
+
+	return NewTreasury(threshold, fields[3:])
+}
+
+// Treasury 读取创世区块中定义的国库
+func (bc *Blockchain) Treasury() (*Treasury, error) {
+	genesis, err := bc.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGenesisData(genesis.Data)
+}
+
+// treasuryIn 在存储事务中沿链尾回溯到创世区块，读取其中定义的国库
+func treasuryIn(tx StoreTx) (*Treasury, error) {
+	hash := blocksOf(tx).Tip()
+	for {
+		header := headerIn(tx, hash)
+		if header == nil {
+			return nil, fmt.Errorf("block %x is not found", hash)
+		}
+		if len(header.PrevBlockHash) == 0 {
+			break
+		}
+		hash = header.PrevBlockHash
+	}
+	genesis := blocksOf(tx).Block(hash)
+	if genesis == nil {
+		return nil, fmt.Errorf("genesis block %x is not found", hash)
+	}
+
+	return parseGenesisData(genesis.Data)
+}
+
+// NewMintTX 创建未签名的铸币交易，向 recipients 发行新币。
+// 输入数据带随机数，同样的收款人和备注也会得到不同的交易
+func NewMintTX(recipients []Recipient, memo string) (*Transaction, error) {
+	if err := validateRecipients(recipients); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var outputs []TXOutput
+	for _, recipient := range recipients {
+		outputs = append(outputs, *NewTXOutput(recipient.Amount, recipient.Address))
+	}
+	data := fmt.Sprintf("%s%x %s", mintDataPrefix, nonce, memo)
+	tx := Transaction{nil, []TXInput{{[]byte{}, -1, nil, []byte(data)}}, outputs}
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// IsMint 是否为国库铸币交易，签名是否足够由 Treasury.VerifyMint 检查
+func (tx Transaction) IsMint() bool {
+	return tx.IsCoinbase() && strings.HasPrefix(string(tx.Vin[0].PubKey), mintDataPrefix)
+}
+
+// MintMemo 铸币交易的备注
+func (tx Transaction) MintMemo() string {
+	data := strings.TrimPrefix(string(tx.Vin[0].PubKey), mintDataPrefix)
+	if i := strings.Index(data, " "); i >= 0 {
+		return data[i+1:]
+	}
+
+	return ""
+}
+
+// mintHash 国库成员签名的摘要：签名置空后交易编码的双 SHA256
+func (tx *Transaction) mintHash() []byte {
+	txCopy := Transaction{nil, []TXInput{tx.Vin[0]}, tx.Vout}
+	txCopy.Vin[0].Signature = nil
+
+	return doubleSHA256(txCopy.Serialize())
+}
+
+// mintSignatures 铸币交易的签名依次保存在 coinbase 输入的 Signature 中，每个都是紧凑签名
+func (tx *Transaction) mintSignatures() ([][]byte, error) {
+	data := tx.Vin[0].Signature
+	if len(data)%compactSignatureLen != 0 {
+		return nil, errInvalidCompactSignature
+	}
+	var signatures [][]byte
+	for i := 0; i < len(data); i += compactSignatureLen {
+		signatures = append(signatures, data[i:i+compactSignatureLen])
+	}
+
+	return signatures, nil
+}
+
+func (t *Treasury) isMember(address string) bool {
+	return t.memberIndex(address) >= 0
+}
+
+// memberIndex 地址在国库成员中的位置，不是成员时返回 -1
+func (t *Treasury) memberIndex(address string) int {
+	for i, member := range t.Addresses {
+		if member == address {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// SignMint 用国库成员的钱包为铸币交易签名，已经签过时不重复签名
+func (t *Treasury) SignMint(tx *Transaction, wallet *Wallet) error {
+	if !tx.IsMint() {
+		return errors.New("transaction is not a mint")
+	}
+	address := string(wallet.GetAddress())
+	if !t.isMember(address) {
+		return fmt.Errorf("address %s is not a treasury address", address)
+	}
+	signers, err := t.MintSigners(tx)
+	if err != nil {
+		return err
+	}
+	for _, signer := range signers {
+		if signer == address {
+			return nil
+		}
+	}
+
+	signature, err := wallet.signCompact(tx.mintHash())
+	if err != nil {
+		return err
+	}
+
+	// 签名按成员在国库中的顺序排列
+	signatures, _ := tx.mintSignatures()
+	position := len(signers)
+	for i, signer := range signers {
+		if t.memberIndex(signer) > t.memberIndex(address) {
+			position = i
+			break
+		}
+	}
+	var data []byte
+	for _, s := range signatures[:position] {
+		data = append(data, s...)
+	}
+	data = append(data, signature...)
+	for _, s := range signatures[position:] {
+		data = append(data, s...)
+	}
+	tx.Vin[0].Signature = data
+
+	return nil
+}
+
+// MintSigners 从签名中恢复签名的国库地址，有非成员签名、重复签名或签名没有按成员顺序排列时返回错误。
+// 签名只能有一种排列，加上 RecoverPubKey 只接受较小的 s，同一笔铸币的签名部分是唯一的
+func (t *Treasury) MintSigners(tx *Transaction) ([]string, error) {
+	signatures, err := tx.mintSignatures()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := tx.mintHash()
+	last := -1
+	var signers []string
+	for _, signature := range signatures {
+		pubKey, err := RecoverPubKey(hash, signature)
+		if err != nil {
+			return nil, err
+		}
+		address := string(Wallet{PublicKey: pubKey}.GetAddress())
+		index := t.memberIndex(address)
+		if index < 0 {
+			return nil, fmt.Errorf("mint is signed by %s, which is not a treasury address", address)
+		}
+		if index == last {
+			return nil, fmt.Errorf("mint is signed twice by %s", address)
+		}
+		if index < last {
+			return nil, errors.New("mint signatures are not in treasury order")
+		}
+		last = index
+		signers = append(signers, address)
+	}
+
+	return signers, nil
+}
+
+// VerifyMint 检查铸币交易的输出和签名，至少 Threshold 个国库地址签名才有效
+func (t *Treasury) VerifyMint(tx *Transaction) error {
+	if !tx.IsMint() {
+		return errors.New("transaction is not a mint")
+	}
+	if len(tx.Vout) == 0 {
+		return errors.New("mint has no outputs")
+	}
+	for _, out := range tx.Vout {
+		if out.Value <= 0 {
+			return errors.New("mint outputs must be positive")
+		}
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return errors.New("mint ID does not match its contents")
+	}
+
+	signers, err := t.MintSigners(tx)
+	if err != nil {
+		return err
+	}
+	if len(signers) < t.Threshold {
+		return fmt.Errorf("mint has %d of the %d required treasury signatures", len(signers), t.Threshold)
+	}
+
+	return nil
+}
+
+// EncodeMint 铸币交易的 base64 文本，在国库成员之间传递签名
+func EncodeMint(tx *Transaction) string {
+	return base64.StdEncoding.EncodeToString(tx.Serialize())
+}
+
+// DecodeMint 解析 EncodeMint 的结果
+func DecodeMint(s string) (*Transaction, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	tx, err := decodeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+	if !tx.IsMint() {
+		return nil, errors.New("transaction is not a mint")
+	}
+
+	return &tx, nil
+}
+
+// CheckMint 检查接在链尾的铸币交易：签名足够，并且没有上过链
+func (bc *Blockchain) CheckMint(tx *Transaction) error {
+	treasury, err := bc.Treasury()
+	if err != nil {
+		return err
+	}
+	if err := treasury.VerifyMint(tx); err != nil {
+		return err
+	}
+	found := false
+	bc.db.View(func(dbTx StoreTx) error {
+		found = hasMintBefore(dbTx, blocksOf(dbTx).Tip(), tx.mintHash())
+		return nil
+	})
+	if found {
+		return fmt.Errorf("mint %x is already in the chain", tx.ID)
+	}
+
+	return nil
+}
+
+// hasMintBefore 从区块 hash 向前查找签名摘要为 mintHash 的铸币交易
+func hasMintBefore(dbTx StoreTx, hash, mintHash []byte) bool {
+	blocks := blocksOf(dbTx)
+	for len(hash) > 0 {
+		block := blocks.Block(hash)
+		if block == nil {
+			return false
+		}
+		for _, tx := range block.Transactions {
+			if tx.IsMint() && bytes.Equal(tx.mintHash(), mintHash) {
+				return true
+			}
+		}
+		hash = block.PrevBlockHash
+	}
+
+	return false
+}
+
+// MintRecord 链上一笔铸币交易
+type MintRecord struct {
+	TxID      string      `json:"txid"`
+	BlockHash string      `json:"block"`
+	Height    int         `json:"height"`
+	Memo      string      `json:"memo"`
+	Outputs   []Recipient `json:"outputs"`
+	Signers   []string    `json:"signers"`
+}
+
+// ListMints 按高度从低到高列出链上所有铸币交易
+func (bc *Blockchain) ListMints() ([]MintRecord, error) {
+	treasury, err := bc.Treasury()
+	if err == errMintingDisabled {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var mints []MintRecord
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+		if block == nil {
+			break
+		}
+		for _, tx := range block.Transactions {
+			if !tx.IsMint() {
+				continue
+			}
+			signers, err := treasury.MintSigners(tx)
+			if err != nil {
+				return nil, err
+			}
+			record := MintRecord{
+				TxID:      hex.EncodeToString(tx.ID),
+				BlockHash: hex.EncodeToString(block.Hash),
+				Height:    block.Height,
+				Memo:      tx.MintMemo(),
+				Signers:   signers,
+			}
+			for _, out := range tx.Vout {
+				record.Outputs = append(record.Outputs, Recipient{string(AddressFromPubKeyHash(out.PubKeyHash)), out.Value})
+			}
+			mints = append(mints, record)
+		}
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	sort.SliceStable(mints, func(i, j int) bool { return mints[i].Height < mints[j].Height })
+
+	return mints, nil
+}
+
+// CommitMint 检查铸币交易并单独打包成区块接在链尾，同时更新 UTXO 集
+func (bc *Blockchain) CommitMint(tx *Transaction) (*Block, error) {
+	if err := bc.CheckMint(tx); err != nil {
+		return nil, err
+	}
+
+	block := bc.commitTransaction([]*Transaction{tx}, "mint")
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Update(block)
+
+	return block, nil
+}
+
+// SignMintWithWallets 用钱包中的国库地址为铸币交易签名，address 不为空时只用这个地址，返回新签名的个数
+func (t *Treasury) SignMintWithWallets(tx *Transaction, wallets *Wallets, address string) (int, error) {
+	addresses := t.Addresses
+	if address != "" {
+		addresses = []string{address}
+	}
+
+	before, err := t.MintSigners(tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, addr := range addresses {
+		wallet, ok := wallets.Wallets[addr]
+		if !ok {
+			if address != "" {
+				return 0, fmt.Errorf("address %s is not in the wallet", addr)
+			}
+			continue
+		}
+		if err := t.SignMint(tx, wallet); err != nil {
+			return 0, err
+		}
+	}
+	after, err := t.MintSigners(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(after) - len(before), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTreasury(t *testing.T) (*Treasury, []*Wallet) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	var addresses []string
+	for _, wallet := range wallets {
+		addresses = append(addresses, string(wallet.GetAddress()))
+	}
+	treasury, err := NewTreasury(2, addresses)
+	assert.Nil(t, err)
+
+	return treasury, wallets
+}
+
+func TestTreasuryGenesisData(t *testing.T) {
+	treasury, _ := testTreasury(t)
+
+	parsed, err := parseGenesisData(genesisData(treasury))
+	assert.Nil(t, err)
+	assert.Equal(t, treasury, parsed)
+
+	_, err = parseGenesisData(genesisData(nil))
+	assert.Equal(t, errMintingDisabled, err)
+
+	_, err = NewTreasury(3, treasury.Addresses[:2])
+	assert.NotNil(t, err, "threshold above the number of addresses")
+	_, err = NewTreasury(1, []string{treasury.Addresses[0], treasury.Addresses[0]})
+	assert.NotNil(t, err, "duplicate address")
+}
+
+func TestSignMint(t *testing.T) {
+	treasury, members := testTreasury(t)
+	to := string(NewWallet().GetAddress())
+
+	tx, err := NewMintTX([]Recipient{{to, 1000}}, "grant #1")
+	assert.Nil(t, err)
+	assert.True(t, tx.IsMint())
+	assert.Equal(t, "grant #1", tx.MintMemo())
+
+	assert.Nil(t, treasury.SignMint(tx, members[0]))
+	assert.Nil(t, treasury.SignMint(tx, members[0]), "signing twice is a no-op")
+	assert.NotNil(t, treasury.VerifyMint(tx), "1 of 2 signatures")
+	assert.NotNil(t, treasury.SignMint(tx, NewWallet()), "not a treasury member")
+
+	// 签名在成员之间以 base64 传递
+	decoded, err := DecodeMint(EncodeMint(tx))
+	assert.Nil(t, err)
+	assert.Nil(t, treasury.SignMint(decoded, members[2]))
+	assert.Nil(t, treasury.VerifyMint(decoded))
+
+	signers, err := treasury.MintSigners(decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{treasury.Addresses[0], treasury.Addresses[2]}, signers)
+
+	// 不论签名的先后，签名都按成员顺序排列
+	reversed, err := NewMintTX([]Recipient{{to, 1000}}, "grant #1")
+	assert.Nil(t, err)
+	assert.Nil(t, treasury.SignMint(reversed, members[2]))
+	assert.Nil(t, treasury.SignMint(reversed, members[0]))
+	signers, err = treasury.MintSigners(reversed)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{treasury.Addresses[0], treasury.Addresses[2]}, signers)
+
+	signatures, err := decoded.mintSignatures()
+	assert.Nil(t, err)
+	reordered := *decoded
+	reordered.Vin = []TXInput{decoded.Vin[0]}
+	reordered.Vin[0].Signature = append(append([]byte(nil), signatures[1]...), signatures[0]...)
+	reordered.ID = reordered.Hash()
+	assert.NotNil(t, treasury.VerifyMint(&reordered), "signatures out of treasury order")
+
+	tampered := *decoded
+	tampered.Vout = []TXOutput{*NewTXOutput(1000000, to)}
+	tampered.ID = tampered.Hash()
+	assert.NotNil(t, treasury.VerifyMint(&tampered), "signatures do not cover the new outputs")
+
+	_, err = DecodeMint(EncodeMint(NewCoinbaseTX(to, "")))
+	assert.NotNil(t, err, "a coinbase is not a mint")
+}
+
+func TestValidateMintBlock(t *testing.T) {
+	treasury, members := testTreasury(t)
+	address := string(NewWallet().GetAddress())
+
+	params := *chainParams
+	params.Treasury = &TreasuryConfig{treasury.Threshold, treasury.Addresses}
+	genesis, err := params.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	mint, err := NewMintTX([]Recipient{{address, 500}}, "")
+	assert.Nil(t, err)
+	assert.Nil(t, treasury.SignMint(mint, members[1]))
+	candidate := &Block{Transactions: []*Transaction{mint}, PrevBlockHash: genesis.Hash, Height: 1}
+
+	bc := testChain(t, genesis)
+	UTXOSet{bc}.Reindex()
+	assert.NotNil(t, bc.ValidateBlock(candidate), "not enough signatures")
+
+	assert.Nil(t, treasury.SignMint(mint, members[0]))
+	assert.Nil(t, bc.ValidateBlock(candidate))
+	assert.NotNil(t, bc.ValidateBlock(&Block{Transactions: []*Transaction{mint, mint}, PrevBlockHash: genesis.Hash, Height: 1}))
+
+	// 已经上链的铸币交易不能再次使用
+	next := NewBlock([]*Transaction{mint}, genesis.Hash, 1, 1, []byte("mint"))
+	bc = testChain(t, genesis, next)
+	assert.NotNil(t, bc.CheckMint(mint))
+	assert.NotNil(t, bc.ValidateBlock(&Block{Transactions: []*Transaction{mint}, PrevBlockHash: next.Hash, Height: 2}))
+
+	// 重新签名得到的签名不同，但签的是同一笔铸币，交易 ID 也不变
+	resigned := &Transaction{mint.ID, []TXInput{mint.Vin[0]}, mint.Vout}
+	resigned.Vin[0].Signature = nil
+	assert.Nil(t, treasury.SignMint(resigned, members[0]))
+	assert.Nil(t, treasury.SignMint(resigned, members[1]))
+	assert.NotEqual(t, mint.Vin[0].Signature, resigned.Vin[0].Signature)
+	assert.Nil(t, treasury.VerifyMint(resigned))
+	assert.NotNil(t, bc.CheckMint(resigned))
+	assert.NotNil(t, bc.ValidateBlock(&Block{Transactions: []*Transaction{resigned}, PrevBlockHash: next.Hash, Height: 2}))
+	assert.NotNil(t, testChain(t, genesis).ValidateBlock(&Block{Transactions: []*Transaction{mint, resigned}, PrevBlockHash: genesis.Hash, Height: 1}))
+
+	mints, err := bc.ListMints()
+	assert.Nil(t, err)
+	assert.Len(t, mints, 1)
+	assert.Equal(t, []Recipient{{address, 500}}, mints[0].Outputs)
+	assert.Equal(t, 1, mints[0].Height)
+
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+	report, err := UTXOSet.AuditSupply()
+	assert.Nil(t, err)
+	assert.Equal(t, 500, report.Mints)
+	assert.True(t, report.OK())
+
+	// 没有国库的链不能铸币
+	plainGenesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	plain := testChain(t, plainGenesis)
+	assert.Equal(t, errMintingDisabled, plain.CheckMint(mint))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// UTXO 集的滚动承诺
+//
+// 每条 UTXO 记录（交易 ID || TXOutputs 编码）映射为模素数 p = 2^3072 - 1103717 的乘法群中的一个元素，
+// UTXO 集对应所有记录元素的乘积（与 Bitcoin Core 的 MuHash3072 思路相同）。乘法满足交换律，
+// 加入记录时乘上它的元素，删除记录时除以它的元素，每个区块只处理被花费和新建的记录，
+// 不需要重新遍历整个 UTXO 集。除法先累乘到分母上，取承诺时才求一次逆元。
+//
+// 当前乘积保存在 metaBucket 中，和 UTXO 集在同一个事务中更新
+
+// utxoAccumulatorKey metaBucket 中 UTXO 集乘积的键
+const utxoAccumulatorKey = "utxoAccumulator"
+
+// utxoAccumulatorLen 乘积编码的字节数
+const utxoAccumulatorLen = 384
+
+var muHashPrime = func() *big.Int {
+	p := new(big.Int).Lsh(big.NewInt(1), 3072)
+	return p.Sub(p, big.NewInt(1103717))
+}()
+
+// utxoAccumulator UTXO 集的乘积，值为 num / den mod p
+type utxoAccumulator struct {
+	num *big.Int
+	den *big.Int
+}
+
+// newUTXOAccumulator 空集合的乘积
+func newUTXOAccumulator() *utxoAccumulator {
+	return &utxoAccumulator{big.NewInt(1), big.NewInt(1)}
+}
+
+// muHashElement 用 SHA256 的计数器模式把记录扩展为 3072 位，再对 p 取模
+func muHashElement(txID, data []byte) *big.Int {
+	seed := sha256.Sum256(append(append([]byte(nil), txID...), data...))
+
+	expanded := make([]byte, 0, utxoAccumulatorLen)
+	var counter [4]byte
+	for i := uint32(0); len(expanded) < utxoAccumulatorLen; i++ {
+		binary.LittleEndian.PutUint32(counter[:], i)
+		block := sha256.Sum256(append(seed[:], counter[:]...))
+		expanded = append(expanded, block[:]...)
+	}
+
+	element := new(big.Int).SetBytes(expanded)
+	return element.Mod(element, muHashPrime)
+}
+
+// add 加入一条 UTXO 记录
+func (a *utxoAccumulator) add(txID, data []byte) {
+	a.num.Mul(a.num, muHashElement(txID, data))
+	a.num.Mod(a.num, muHashPrime)
+}
+
+// remove 删除一条 UTXO 记录
+func (a *utxoAccumulator) remove(txID, data []byte) {
+	a.den.Mul(a.den, muHashElement(txID, data))
+	a.den.Mod(a.den, muHashPrime)
+}
+
+// value 约去分母后的乘积
+func (a *utxoAccumulator) value() *big.Int {
+	if a.den.Cmp(big.NewInt(1)) != 0 {
+		a.num.Mul(a.num, new(big.Int).ModInverse(a.den, muHashPrime))
+		a.num.Mod(a.num, muHashPrime)
+		a.den.SetInt64(1)
+	}
+
+	return a.num
+}
+
+// Commitment 乘积编码的 SHA256，空集合的承诺为 32 个零字节
+func (a *utxoAccumulator) Commitment() []byte {
+	v := a.value()
+	if v.Cmp(big.NewInt(1)) == 0 {
+		return make([]byte, sha256.Size)
+	}
+	hash := sha256.Sum256(v.FillBytes(make([]byte, utxoAccumulatorLen)))
+
+	return hash[:]
+}
+
+// Bytes 乘积的定长编码
+func (a *utxoAccumulator) Bytes() []byte {
+	return a.value().FillBytes(make([]byte, utxoAccumulatorLen))
+}
+
+func decodeUTXOAccumulator(data []byte) (*utxoAccumulator, error) {
+	if len(data) != utxoAccumulatorLen {
+		return nil, errors.New("invalid UTXO accumulator")
+	}
+	num := new(big.Int).SetBytes(data)
+	if num.Sign() == 0 || num.Cmp(muHashPrime) >= 0 {
+		return nil, errors.New("invalid UTXO accumulator")
+	}
+
+	return &utxoAccumulator{num, big.NewInt(1)}, nil
+}
+
+// loadUTXOAccumulator 读取 UTXO 集当前的乘积，没有记录时从 UTXO 桶重新计算
+func loadUTXOAccumulator(tx StoreTx) (*utxoAccumulator, error) {
+	if data := indexesOf(tx).Get(metaBucket, []byte(utxoAccumulatorKey)); data != nil {
+		return decodeUTXOAccumulator(data)
+	}
+	b := tx.Bucket(utxoBucket)
+	if b == nil {
+		return nil, errNoChainstate
+	}
+
+	return accumulateUTXOBucket(b), nil
+}
+
+func putUTXOAccumulator(tx StoreTx, acc *utxoAccumulator) error {
+	return indexesOf(tx).Put(metaBucket, []byte(utxoAccumulatorKey), acc.Bytes())
+}
+
+// accumulateUTXOBucket 遍历 UTXO 桶计算乘积，用于重建 UTXO 集和检查滚动承诺
+func accumulateUTXOBucket(b StoreBucket) *utxoAccumulator {
+	acc := newUTXOAccumulator()
+	b.ForEach(func(k, v []byte) error {
+		acc.add(k, v)
+		return nil
+	})
+
+	return acc
+}
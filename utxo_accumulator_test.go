@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTXOAccumulator(t *testing.T) {
+	a, b := []byte("tx a"), []byte("tx b")
+
+	forward := newUTXOAccumulator()
+	forward.add(a, []byte{1})
+	forward.add(b, []byte{2})
+	backward := newUTXOAccumulator()
+	backward.add(b, []byte{2})
+	backward.add(a, []byte{1})
+	assert.Equal(t, forward.Commitment(), backward.Commitment(), "the order of the records does not matter")
+	assert.Equal(t, forward.Commitment(), utxoCommitment([][]byte{a, b}, [][]byte{{1}, {2}}))
+
+	// 修改记录等于删除旧记录再加入新记录
+	forward.remove(a, []byte{1})
+	forward.add(a, []byte{3})
+	assert.Equal(t, utxoCommitment([][]byte{a, b}, [][]byte{{3}, {2}}), forward.Commitment())
+
+	decoded, err := decodeUTXOAccumulator(forward.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, forward.Commitment(), decoded.Commitment())
+	_, err = decodeUTXOAccumulator(make([]byte, utxoAccumulatorLen))
+	assert.NotNil(t, err)
+
+	forward.remove(a, []byte{3})
+	forward.remove(b, []byte{2})
+	assert.Equal(t, make([]byte, 32), forward.Commitment())
+}
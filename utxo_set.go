@@ -1,12 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/hex"
-	"fmt"
 	"log"
-	"time"
-
-	"github.com/boltdb/bolt"
 )
 
 const utxoBucket = "chainstate"
@@ -35,34 +32,28 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 	//fmt.Println("")
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
+	bestHeight := u.Blockchain.GetBestHeight()
 	//db := u.Blockchain.db
 
-	err := u.Blockchain.db.View(func(tx *bolt.Tx) error {
-		for tx.Writable() {
-			//暂停10ms
-			time.Sleep(time.Millisecond * 10)
-			fmt.Println("等待数据库解锁")
-		}
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
+	err := u.Blockchain.db.View(func(tx StoreTx) error {
+		return chainStateOf(tx).ForEach(func(k []byte, outs TXOutputs) error {
 			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
+			if !outs.Mature(bestHeight) {
+				return nil
+			}
 			//fmt.Println("FindSpendableOutputs-txID", txID)
 			//fmt.Println("FindSpendableOutputs-outs", outs)
-			for outIdx, out := range outs.Outputs {
+			for i, out := range outs.Outputs {
 				if out.IsLockedWithKey(pubkeyHash) && accumulated < amount && UsedTxId[txID] == nil {
 					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-					UsedTxId[txID] = v
+					unspentOutputs[txID] = append(unspentOutputs[txID], outs.Indexes[i])
+					UsedTxId[txID] = append([]byte(nil), k...)
 					//fmt.Println("FindSpendableOutputs-outIdx", outIdx)
 					//fmt.Println("FindSpendableOutputs-out.Value", out.Value)
 				}
 			}
-		}
-
-		return nil
+			return nil
+		})
 	})
 	if err != nil {
 		log.Panic(err)
@@ -89,15 +80,9 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	var UTXOs []TXOutput
 	db := u.Blockchain.db
 	//fmt.Println("FindUTXO-db", db)
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		//fmt.Println("FindUTXO-b", b)
-		c := b.Cursor()
-		//fmt.Println("FindUTXO-c", c)
-		for k, v := c.First(); k != nil; k, v = c.Next() {
+	err := db.View(func(tx StoreTx) error {
+		return chainStateOf(tx).ForEach(func(k []byte, outs TXOutputs) error {
 			//fmt.Println("FindUTXO-k", hex.EncodeToString(k))
-			//fmt.Println("FindUTXO-v", v)
-			outs := DeserializeOutputs(v)
 			//fmt.Println("FindUTXO-PubKeyHash", hex.EncodeToString(outs.Outputs[0].PubKeyHash))
 			//fmt.Println("FindUTXO-outs", outs.Outputs[0].Value)
 			for _, out := range outs.Outputs {
@@ -107,9 +92,8 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 					UTXOs = append(UTXOs, out)
 				}
 			}
-		}
-
-		return nil
+			return nil
+		})
 	})
 	if err != nil {
 		log.Panic(err)
@@ -122,6 +106,72 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	return UTXOs
 }
 
+// SpendableOutputs 返回 pubKeyHash 的全部未花费输出，跳过已被待确认交易使用的交易和未成熟的奖励输出，
+// 只读取不标记 UsedTxId，选币之后由调用者标记
+func (u UTXOSet) SpendableOutputs(pubKeyHash []byte) []SpendableOutput {
+	var utxos []SpendableOutput
+	bestHeight := u.Blockchain.GetBestHeight()
+
+	err := u.Blockchain.db.View(func(tx StoreTx) error {
+		return chainStateOf(tx).ForEach(func(k []byte, outs TXOutputs) error {
+			if UsedTxId[hex.EncodeToString(k)] != nil {
+				return nil
+			}
+			if !outs.Mature(bestHeight) {
+				return nil
+			}
+			for i, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					txID := append([]byte(nil), k...)
+					utxos = append(utxos, SpendableOutput{txID, outs.Indexes[i], out})
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return utxos
+}
+
+// Balance 地址的未花费输出总额
+func (u UTXOSet) Balance(address string) int {
+	pubKeyHash := Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+
+	balance := 0
+	for _, out := range u.FindUTXO(pubKeyHash) {
+		balance += out.Value
+	}
+
+	return balance
+}
+
+// PubKeyHashes 返回 UTXO 集中出现过的所有锁定公钥哈希，每个只出现一次
+func (u UTXOSet) PubKeyHashes() [][]byte {
+	var pubKeyHashes [][]byte
+	seen := make(map[string]bool)
+
+	err := u.Blockchain.db.View(func(tx StoreTx) error {
+		return chainStateOf(tx).ForEach(func(k []byte, outs TXOutputs) error {
+			for _, out := range outs.Outputs {
+				if !seen[string(out.PubKeyHash)] {
+					seen[string(out.PubKeyHash)] = true
+					pubKeyHashes = append(pubKeyHashes, out.PubKeyHash)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return pubKeyHashes
+}
+
 // CountTransactions 这段代码是 `UTXOSet` 结构体的 `CountTransactions` 方法，用于计算 UTXO（未花费输出）集合中的交易数量。
 //下面是这个方法的功能和步骤解释：
 //1. 获取与 `UTXOSet` 相关的区块链数据库实例 `db`。
@@ -135,15 +185,11 @@ func (u UTXOSet) CountTransactions() int {
 	db := u.Blockchain.db
 	counter := 0
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
-
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+	err := db.View(func(tx StoreTx) error {
+		return tx.Bucket(utxoBucket).ForEach(func(k, v []byte) error {
 			counter++
-		}
-
-		return nil
+			return nil
+		})
 	})
 	if err != nil {
 		log.Panic(err)
@@ -152,58 +198,73 @@ func (u UTXOSet) CountTransactions() int {
 	return counter
 }
 
+// TotalValue UTXO 集中所有未花费输出的总额
+func (u UTXOSet) TotalValue() int {
+	total := 0
+
+	err := u.Blockchain.db.View(func(tx StoreTx) error {
+		return chainStateOf(tx).ForEach(func(k []byte, outs TXOutputs) error {
+			for _, out := range outs.Outputs {
+				total += out.Value
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return total
+}
+
 // Reindex 这段代码是一个方法 ，属于 `UTXOSet` 结构体的方法，用于重建 UTXO 集合（未花费输出）。
 //1. 获取 `UTXOSet` 所关联的区块链数据库（`u.Blockchain.db`）。
 //2. 定义用于存储 UTXO 的 Bucket 名称为 `utxoBucket`。
-//3. 使用数据库事务更新，首先删除现有的 UTXO Bucket。如果出现错误，且错误不是 `bolt.ErrBucketNotFound`（表示 Bucket 不存在），则触发 Panic。
-//4. 创建一个新的 UTXO Bucket。
-//5. 获取 UTXO 集合（未花费输出）。
-//6. 使用数据库事务更新，将 UTXO 集合中的每个未花费输出（以交易 ID 为键）序列化后存储在 UTXO Bucket 中。
-//总的来说，这个 `Reindex` 方法的目的是重建 UTXO 集合。它首先删除现有的 UTXO Bucket，然后创建一个新的 Bucket，并将 UTXO 集合中的未花费输出按照交易 ID 存储在这个 Bucket 中，以便稍后在验证交易和计算余额时使用。
+//3. 获取链尾之后的 UTXO 集合（未花费输出）。
+//4. 在一个数据库事务中删除现有的 UTXO Bucket（不存在时忽略），创建一个新的 UTXO Bucket。
+//5. 在同一个事务中将 UTXO 集合中的每个未花费输出（以交易 ID 为键）序列化后存储在 UTXO Bucket 中，并记录它对应的最佳区块。
+//总的来说，这个 `Reindex` 方法的目的是重建 UTXO 集合。UTXO 集和最佳区块在同一个事务中替换，中途出错时事务回滚，数据库中仍是原来的 UTXO 集。
 func (u UTXOSet) Reindex() {
-	db := u.Blockchain.db            //1. 获取 `UTXOSet` 所关联的区块链数据库（`u.Blockchain.db`）。
-	bucketName := []byte(utxoBucket) //2. 定义用于存储 UTXO 的 Bucket 名称为 `utxoBucket`。
+	db := u.Blockchain.db    //1. 获取 `UTXOSet` 所关联的区块链数据库（`u.Blockchain.db`）。
+	bucketName := utxoBucket //2. 定义用于存储 UTXO 的 Bucket 名称为 `utxoBucket`。
+
+	//3. 获取链尾之后的 UTXO 集合（未花费输出）。
+	tip := u.Blockchain.Tip()
+	UTXO := u.Blockchain.findUTXOAt(tip)
 
-	//3.使用数据库事务更新
-	err := db.Update(func(tx *bolt.Tx) error {
-		//首先删除现有的 UTXO Bucket。如果出现错误，且错误不是 `bolt.ErrBucketNotFound`（表示 Bucket 不存在），则触发 Panic。
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
+	err := db.Update(func(tx StoreTx) error {
+		//4. 删除现有的 UTXO Bucket，不存在（`errBucketNotFound`）时忽略，然后创建一个新的 UTXO Bucket。
+		if err := tx.DeleteBucket(bucketName); err != nil && err != errBucketNotFound {
+			return err
 		}
-		//4. 创建一个新的 UTXO Bucket。
-		_, err = tx.CreateBucket(bucketName)
-		if err != nil {
-			log.Panic(err)
+		if _, err := tx.CreateBucket(bucketName); err != nil {
+			return err
 		}
 
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
-	}
-	//5. 获取 UTXO 集合（未花费输出）。
-	UTXO := u.Blockchain.FindUTXO()
-	//6. 使用数据库事务更新，将 UTXO 集合中的每个未花费输出（以交易 ID 为键）序列化后存储在 UTXO Bucket 中。
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
-
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
+		//5. 存入未花费输出，更新累加器、承诺和最佳区块
+		if err := chainStateOf(tx).putAll(UTXO); err != nil {
+			return err
+		}
+		if err := putUTXOAccumulator(tx, accumulateUTXOBucket(tx.Bucket(bucketName))); err != nil {
+			return err
+		}
+		if len(tip) == 0 {
+			if err := indexesOf(tx).Delete(metaBucket, []byte(bestBlockKey)); err != nil {
+				return err
 			}
-
-			err = b.Put(key, outs.Serialize())
-			fmt.Println("txID", txID)
-			fmt.Println("outs", outs)
-			if err != nil {
-				log.Panic(err)
+		} else {
+			if err := putUTXOCommitment(tx, tip); err != nil {
+				return err
+			}
+			if err := putBestBlock(tx, tip); err != nil {
+				return err
 			}
 		}
-
-		return nil
+		return putChainstateVersion(tx)
 	})
+	if err != nil {
+		log.Panic(err)
+	}
 }
 
 // Update 这段代码是 `UTXOSet` 结构体的方法 `Update`，用于更新 UTXO 集合（未花费输出）以反映新的区块的交易。
@@ -216,81 +277,25 @@ func (u UTXOSet) Reindex() {
 //6. 遍历交易的输出（vout）列表，将每个输出存储到数据库中，使用交易 ID 作为键。
 //总的来说，这个方法的目的是根据新的区块中的交易信息，更新 UTXO 集合中的数据，以反映区块链的最新状态。
 //它会处理输入（花费）和输出（未花费）的关系，删除已经花费的输出，同时添加新的输出。
+//UTXO 集已经包含这个区块时什么也不做，不在区块的上一个区块时改为与链尾对齐。
 func (u UTXOSet) Update(block *Block) {
-	//fmt.Println("UTXOSet.Update block", block)
-	db := u.Blockchain.db
-	//fmt.Println("UTXOSet.Update-db", db)
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		//打印b中的数据
-		//err := b.ForEach(func(k, v []byte) error {
-		//	fmt.Printf("b.ForEach(func(k, v []byte) error -------Key: %s\n", hex.EncodeToString(k))
-		//	return nil
-		//})
-		//
-		//if err != nil {
-		//	log.Panic(err)
-		//}
-		for _, tx := range block.Transactions {
-			//fmt.Println("UTXOSet.Update-tx", tx)
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					hexString := hex.EncodeToString(vin.Txid)
-
-					byteData, err := hex.DecodeString(hexString)
-					if err != nil {
-						fmt.Println("解码失败:", err)
-					}
-					outsBytes := b.Get(vin.Txid)
-					if outsBytes == nil {
-						fmt.Println("hex.EncodeToString(vin.Txid)", hex.EncodeToString(vin.Txid))
-						fmt.Println("outsBytes为空 : ", outsBytes)
-						if UsedTxId[hex.EncodeToString(vin.Txid)] != nil {
-							fmt.Println(hex.EncodeToString(vin.Txid), "已经被使用过了")
-						} else {
-							fmt.Println(hex.EncodeToString(vin.Txid), "不知道去哪里了")
-						}
-
-					}
-					fmt.Printf("UTXOSet.Update - vin.Txid: %x, outsBytes: %v\n", byteData, outsBytes)
-					outs := DeserializeOutputs(outsBytes)
-					//fmt.Println("UTXOSet.Update-outs", outs)
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-					//fmt.Println("UTXOSet.Update-updatedOuts", updatedOuts)
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
-					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
-
-				}
-			}
-
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
-
-			err := b.Put(tx.ID, newOutputs.Serialize())
-			if err != nil {
-				log.Panic(err)
-			}
+	// AddBlock、MineBlock 接入区块时已经更新了 UTXO 集，这里只处理还没有接入的区块
+	stale := false
+	err := u.Blockchain.db.Update(func(tx StoreTx) error {
+		best := bestBlock(tx)
+		if bytes.Equal(best, block.Hash) {
+			return nil
+		}
+		if best != nil && !bytes.Equal(best, block.PrevBlockHash) {
+			stale = true
+			return nil
 		}
-		fmt.Println("----------UTXOSet.Update-success------------------------")
-		return nil
+		return applyBlockUTXO(tx, block)
 	})
 	if err != nil {
-		log.Panic(err)
+		log.Panic("UTXOSet.Update: ", err)
+	}
+	if stale {
+		u.Blockchain.repairChainstate()
 	}
 }
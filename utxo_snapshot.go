@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// UTXO 承诺和快照
+//
+// 每个区块上链、UTXO 集更新之后，把 UTXO 集的滚动承诺（见 utxo_accumulator.go）记为这个区块之后
+// UTXO 集的承诺，空集合的承诺为 32 个零字节。
+//
+// 快照包含某个高度的区块、创世区块到该区块的区块头（hotstuff 区块附带法定人数证书）、该区块之后的
+// UTXO 集和它的承诺。导入时第一个区块头必须是配置确定的创世区块（见 ChainParams.configuredGenesis），
+// 区块头按轻节点的规则验证（工作量证明或足够的投票，见 verifyHeader），快照区块必须与最后一个区块头相同，
+// UTXO 记录必须与快照区块头中的承诺一致，因此 UTXO 集和区块头链一样受工作量证明或投票保护。
+//
+// 从快照启动的节点只有快照区块和之后的区块体，更早的区块只有区块头（headers 桶）：快照中的 UTXO 记录
+// 另存一份（snapshot 桶），遍历区块链到快照区块为止，查找更早的交易和输出时使用这份记录。
+// 修剪过的节点以修剪点为快照区块，使用同样的记录，见 prune.go
+
+const (
+	utxoCommitBucket   = "utxocommitments" // 区块哈希 -> 该区块之后 UTXO 集的承诺
+	utxoSnapshotBucket = "snapshot"        // 交易 ID -> 导入快照时的 UTXO 记录
+	snapshotBaseKey    = "snapshotBase"    // metaBucket 中快照区块的哈希
+	snapshotGenesisKey = "snapshotGenesis" // metaBucket 中快照区块头链的创世区块哈希
+)
+
+// UTXOSnapshot 某个区块之后的 UTXO 集快照
+type UTXOSnapshot struct {
+	Block      *Block
+	Headers    []*BlockHeader // 创世区块到 Block 的区块头
+	QCs        []*BlockQC     // 与 Headers 一一对应，没有证书时为 nil
+	Commitment []byte
+	Entries    []SnapshotEntry // 按交易 ID 升序
+}
+
+// SnapshotEntry 快照中一笔交易的未花费输出
+type SnapshotEntry struct {
+	TxID    []byte
+	Outputs TXOutputs
+}
+
+// utxoCommitment 由 UTXO 记录计算承诺，与记录的顺序无关
+func utxoCommitment(keys, values [][]byte) []byte {
+	acc := newUTXOAccumulator()
+	for i, key := range keys {
+		acc.add(key, values[i])
+	}
+
+	return acc.Commitment()
+}
+
+// putUTXOCommitment 记录区块 hash 之后 UTXO 集的承诺
+func putUTXOCommitment(tx StoreTx, hash []byte) error {
+	acc, err := loadUTXOAccumulator(tx)
+	if err != nil {
+		return err
+	}
+
+	return indexesOf(tx).Put(utxoCommitBucket, hash, acc.Commitment())
+}
+
+// Commitment 当前 UTXO 集的承诺
+func (u UTXOSet) Commitment() []byte {
+	var commitment []byte
+	err := u.Blockchain.db.View(func(tx StoreTx) error {
+		acc, err := loadUTXOAccumulator(tx)
+		if err != nil {
+			return err
+		}
+		commitment = acc.Commitment()
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	return commitment
+}
+
+// UTXOCommitment 区块 hash 之后 UTXO 集的承诺，没有记录时返回 nil
+func (bc *Blockchain) UTXOCommitment(hash []byte) ([]byte, error) {
+	var commitment []byte
+	err := bc.db.View(func(tx StoreTx) error {
+		commitment = indexesOf(tx).Get(utxoCommitBucket, hash)
+		return nil
+	})
+
+	return commitment, err
+}
+
+// snapshotEntries 把 UTXO 集排成快照记录
+func snapshotEntries(utxos map[string]TXOutputs) []SnapshotEntry {
+	var entries []SnapshotEntry
+	for txID, outs := range utxos {
+		key, err := hex.DecodeString(txID)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, SnapshotEntry{key, outs})
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].TxID, entries[j].TxID) < 0 })
+
+	return entries
+}
+
+// entriesCommitment 快照记录的承诺
+func entriesCommitment(entries []SnapshotEntry) []byte {
+	var keys, values [][]byte
+	for _, entry := range entries {
+		keys = append(keys, entry.TxID)
+		values = append(values, entry.Outputs.Serialize())
+	}
+
+	return utxoCommitment(keys, values)
+}
+
+// Snapshot 导出高度 height 的区块之后的 UTXO 快照，height 为负数时导出链尾
+func (bc *Blockchain) Snapshot(height int) (*UTXOSnapshot, error) {
+	if height < 0 || height > bc.GetBestHeight() {
+		height = bc.GetBestHeight()
+	}
+	if height < bc.PruneHeight() {
+		return nil, fmt.Errorf("blocks before height %d are pruned", bc.PruneHeight())
+	}
+	headers, qcs, err := bc.HeadersAfter(-1, height+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 || headers[0].Height != 0 {
+		return nil, errors.New("the chain does not start at the genesis block, a snapshot needs all block headers")
+	}
+	last := headers[len(headers)-1]
+	if last.Height != height {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	block, err := bc.GetBlock(last.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := snapshotEntries(bc.findUTXOAt(block.Hash))
+	commitment := entriesCommitment(entries)
+	if !bytes.Equal(commitment, block.UTXOCommitment) {
+		return nil, fmt.Errorf("UTXO set at height %d does not match the commitment %x in the block header", height, block.UTXOCommitment)
+	}
+
+	return &UTXOSnapshot{&block, headers, qcs, commitment, entries}, nil
+}
+
+// Serialize 快照的规范编码，格式见 encoding.go
+func (s *UTXOSnapshot) Serialize() []byte {
+	return encodeSnapshot(s)
+}
+
+// DeserializeSnapshot 解码 Serialize 的结果
+func DeserializeSnapshot(data []byte) (*UTXOSnapshot, error) {
+	return decodeSnapshot(data)
+}
+
+// Verify 检查区块头链、快照区块和 UTXO 记录的承诺
+func (s *UTXOSnapshot) Verify() error {
+	if len(s.Headers) == 0 {
+		return errors.New("snapshot has no block headers")
+	}
+
+	var tip *BlockHeader
+	for i, header := range s.Headers {
+		var qc *BlockQC
+		if i < len(s.QCs) {
+			qc = s.QCs[i]
+		}
+		if err := verifyHeader(tip, header, qc); err != nil {
+			return fmt.Errorf("header at height %d: %v", header.Height, err)
+		}
+		tip = header
+	}
+
+	if !bytes.Equal(s.Block.ComputeHash(), s.Block.Hash) || !bytes.Equal(s.Block.Hash, tip.Hash()) {
+		return errors.New("snapshot block does not match the last header")
+	}
+	for i, entry := range s.Entries {
+		if i > 0 && bytes.Compare(s.Entries[i-1].TxID, entry.TxID) >= 0 {
+			return errors.New("snapshot entries are not sorted by transaction ID")
+		}
+		if len(entry.Outputs.Outputs) == 0 || entry.Outputs.Height > s.Block.Height {
+			return fmt.Errorf("invalid snapshot entry %x", entry.TxID)
+		}
+	}
+	if !bytes.Equal(s.Commitment, s.Block.UTXOCommitment) {
+		return errors.New("snapshot commitment does not match the block header")
+	}
+	if !bytes.Equal(entriesCommitment(s.Entries), s.Commitment) {
+		return errors.New("snapshot entries do not match the UTXO commitment")
+	}
+
+	return nil
+}
+
+// ImportSnapshot 验证快照并为节点创建从快照区块开始的区块库，节点不能已有区块库
+func ImportSnapshot(nodeID string, s *UTXOSnapshot) error {
+	if err := s.Verify(); err != nil {
+		return err
+	}
+	dbFile := nodeDataFile(dbFile, nodeID)
+	if dbExists(dbFile) {
+		return errors.New("blockchain already exists")
+	}
+
+	db, err := openBoltStore(dbFile, 0600, 0)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return importSnapshot(db, s)
+}
+
+// importSnapshot 在空的存储中写入已验证的快照
+func importSnapshot(db Store, s *UTXOSnapshot) error {
+	return db.Update(func(tx StoreTx) error {
+		blocks, err := createBlockStore(tx)
+		if err != nil {
+			return err
+		}
+		if err := blocks.Put(s.Block); err != nil {
+			return err
+		}
+		if err := blocks.SetTip(s.Block.Hash); err != nil {
+			return err
+		}
+
+		utxos, err := tx.CreateBucket(utxoBucket)
+		if err != nil {
+			return err
+		}
+		records, err := tx.CreateBucket(utxoSnapshotBucket)
+		if err != nil {
+			return err
+		}
+		acc := newUTXOAccumulator()
+		for _, entry := range s.Entries {
+			data := entry.Outputs.Serialize()
+			if err := utxos.Put(entry.TxID, data); err != nil {
+				return err
+			}
+			if err := records.Put(entry.TxID, data); err != nil {
+				return err
+			}
+			acc.add(entry.TxID, data)
+		}
+		if err := putUTXOAccumulator(tx, acc); err != nil {
+			return err
+		}
+		if err := putUTXOCommitment(tx, s.Block.Hash); err != nil {
+			return err
+		}
+		if err := putBestBlock(tx, s.Block.Hash); err != nil {
+			return err
+		}
+
+		// 快照区块之前只保存区块头和证书，与修剪过的节点相同
+		indexes := indexesOf(tx)
+		for i, header := range s.Headers {
+			hash := header.Hash()
+			if i < len(s.Headers)-1 {
+				if err := indexes.Put(headersBucket, hash, header.Bytes()); err != nil {
+					return err
+				}
+			}
+			if i < len(s.QCs) && s.QCs[i] != nil {
+				if err := indexes.Put(qcBucket, hash, s.QCs[i].Serialize()); err != nil {
+					return err
+				}
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if err := meta.Put([]byte(snapshotBaseKey), s.Block.Hash); err != nil {
+			return err
+		}
+		if err := meta.Put([]byte(snapshotGenesisKey), s.Headers[0].Hash()); err != nil {
+			return err
+		}
+		if err := putChainstateVersion(tx); err != nil {
+			return err
+		}
+
+		return putStorageFormat(tx)
+	})
+}
+
+// snapshotBase 从快照启动的节点的快照区块哈希，其他节点返回 nil
+func (bc *Blockchain) snapshotBase() []byte {
+	return bc.snapshotMeta(snapshotBaseKey)
+}
+
+// snapshotGenesis 从快照启动的节点的创世区块哈希（创世区块不在本地），其他节点返回 nil
+func (bc *Blockchain) snapshotGenesis() []byte {
+	return bc.snapshotMeta(snapshotGenesisKey)
+}
+
+func (bc *Blockchain) snapshotMeta(key string) []byte {
+	var value []byte
+	bc.db.View(func(tx StoreTx) error {
+		value = indexesOf(tx).Get(metaBucket, []byte(key))
+		return nil
+	})
+
+	return value
+}
+
+// snapshotOutputs 导入快照时交易 txID 的未花费输出
+func (bc *Blockchain) snapshotOutputs(txID []byte) (TXOutputs, bool) {
+	var data []byte
+	bc.db.View(func(tx StoreTx) error {
+		data = indexesOf(tx).Get(utxoSnapshotBucket, txID)
+		return nil
+	})
+	if len(data) == 0 {
+		return TXOutputs{}, false
+	}
+
+	return DeserializeOutputs(data), true
+}
+
+// snapshotUTXOs 导入快照时的全部 UTXO 记录，键为十六进制交易 ID
+func (bc *Blockchain) snapshotUTXOs() map[string]TXOutputs {
+	utxos := make(map[string]TXOutputs)
+	bc.db.View(func(tx StoreTx) error {
+		b := tx.Bucket(utxoSnapshotBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			utxos[hex.EncodeToString(k)] = DeserializeOutputs(v)
+			return nil
+		})
+	})
+
+	return utxos
+}
+
+// snapshotTransaction 用快照记录还原快照之前的交易，只有快照时未花费的输出，其余输出为空，
+// 足以验证花费这些输出的签名和金额
+func (bc *Blockchain) snapshotTransaction(txID []byte) (Transaction, bool) {
+	outs, ok := bc.snapshotOutputs(txID)
+	if !ok {
+		return Transaction{}, false
+	}
+
+	tx := Transaction{ID: append([]byte(nil), txID...)}
+	for i, out := range outs.Outputs {
+		for len(tx.Vout) <= outs.Indexes[i] {
+			tx.Vout = append(tx.Vout, TXOutput{})
+		}
+		tx.Vout[outs.Indexes[i]] = out
+	}
+
+	return tx, true
+}
+
+type getsnapshot struct {
+	AddrFrom string
+	Height   int
+}
+
+type snapshot struct {
+	AddrFrom string
+	Snapshot []byte
+}
+
+func sendGetSnapshot(address string, height int) {
+	payload := gobEncode(getsnapshot{nodeAddress, height})
+	request := append(commandToBytes("getsnapshot"), payload...)
+
+	sendData(address, request)
+}
+
+// handleGetSnapshot 回复指定高度的 UTXO 快照
+func handleGetSnapshot(chains *ChainService, request []byte) {
+	var payload getsnapshot
+	decodePayload(request, &payload)
+
+	bc := chains.Blockchain(NodeIPAddress)
+	if bc == nil {
+		return
+	}
+	defer chains.Release(bc)
+
+	s, err := bc.Snapshot(payload.Height)
+	if err != nil {
+		logWarnf("snapshot for %s: %v", payload.AddrFrom, err)
+		return
+	}
+	logInfof("send snapshot at height %d with %d UTXO records to %s", s.Block.Height, len(s.Entries), payload.AddrFrom)
+
+	sendData(payload.AddrFrom, append(commandToBytes("snapshot"), gobEncode(snapshot{nodeAddress, s.Serialize()})...))
+}
+
+// handleSnapshot 还没有区块库时导入收到的快照，然后照常向对方同步之后的区块
+func handleSnapshot(chains *ChainService, request []byte) {
+	var payload snapshot
+	decodePayload(request, &payload)
+
+	if dbExists(nodeDataFile(dbFile, NodeIPAddress)) {
+		logDebugf("ignore snapshot from %s, the blockchain already exists", payload.AddrFrom)
+		return
+	}
+	s, err := DeserializeSnapshot(payload.Snapshot)
+	if err == nil {
+		err = ImportSnapshot(NodeIPAddress, s)
+	}
+	if err != nil {
+		logWarnf("snapshot from %s: %v", payload.AddrFrom, err)
+		return
+	}
+	logInfof("bootstrapped from snapshot at height %d, commitment %x", s.Block.Height, s.Commitment)
+
+	bc := chains.Blockchain(NodeIPAddress)
+	if bc == nil {
+		return
+	}
+	defer chains.Release(bc)
+	sendVersion(payload.AddrFrom, bc, belongToInt)
+}
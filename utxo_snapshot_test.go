@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTXOCommitment(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+	hashes, err := bc.Generate(3, address)
+	assert.Nil(t, err)
+
+	// 每个区块都记录了承诺，链尾的承诺就是当前 UTXO 集的承诺
+	var commitments [][]byte
+	for _, hash := range append([][]byte{genesis.Hash}, hashes...) {
+		commitment, err := bc.UTXOCommitment(hash)
+		assert.Nil(t, err)
+		assert.Len(t, commitment, 32)
+		commitments = append(commitments, commitment)
+	}
+	assert.NotEqual(t, commitments[1], commitments[2])
+	tip := commitments[len(commitments)-1]
+	assert.Equal(t, tip, UTXOSet.Commitment())
+
+	// 重建 UTXO 集得到相同的承诺，与区块上链时增量更新的结果一致
+	UTXOSet.Reindex()
+	assert.Equal(t, tip, UTXOSet.Commitment())
+	assert.Equal(t, commitments[2], entriesCommitment(snapshotEntries(bc.findUTXOAt(hashes[1]))))
+	assert.Equal(t, make([]byte, 32), utxoCommitment(nil, nil))
+}
+
+func TestUTXOSnapshot(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	other := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	utxos := UTXOSet{bc}
+	utxos.Reindex()
+	_, err = bc.Generate(chainParams.CoinbaseMaturity, other)
+	assert.Nil(t, err)
+	height := bc.GetBestHeight()
+	chainParams.GenesisHash = hex.EncodeToString(genesis.Hash)
+
+	s, err := bc.Snapshot(height)
+	assert.Nil(t, err)
+	assert.Equal(t, height, s.Block.Height)
+	assert.Len(t, s.Headers, height+1)
+	recorded, err := bc.UTXOCommitment(s.Block.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, recorded, s.Commitment)
+	assert.Equal(t, s.Block.UTXOCommitment, s.Commitment)
+	assert.Nil(t, s.Verify())
+
+	decoded, err := DeserializeSnapshot(s.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, s.Serialize(), decoded.Serialize())
+	assert.Nil(t, decoded.Verify())
+
+	// 篡改任何部分都无法通过验证
+	tampered, _ := DeserializeSnapshot(s.Serialize())
+	tampered.Entries[0].Outputs.Outputs[0].Value++
+	assert.NotNil(t, tampered.Verify(), "entries do not match the commitment")
+	tampered.Commitment = entriesCommitment(tampered.Entries)
+	assert.NotNil(t, tampered.Verify(), "the commitment does not match the block header")
+	tampered, _ = DeserializeSnapshot(s.Serialize())
+	tampered.Block.Nonce++
+	assert.NotNil(t, tampered.Verify(), "block does not match the last header")
+	tampered, _ = DeserializeSnapshot(s.Serialize())
+	tampered.Headers = tampered.Headers[1:]
+	assert.NotNil(t, tampered.Verify(), "header chain must start at the genesis block")
+	chainParams.GenesisHash = hex.EncodeToString(make([]byte, 32))
+	assert.NotNil(t, s.Verify(), "the chain does not start at the configured genesis block")
+	chainParams.GenesisHash = ""
+	assert.NotNil(t, s.Verify(), "the genesis block is not fixed by the configuration")
+	chainParams.GenesisHash = hex.EncodeToString(genesis.Hash)
+	_, err = DeserializeSnapshot(s.Serialize()[:100])
+	assert.NotNil(t, err)
+
+	// 快照之后花费创世区块的输出
+	wallets := &Wallets{Wallets: map[string]*Wallet{address: wallet}}
+	tx := NewUTXOTransaction(wallets, address, other, 5, &utxos, DefaultSendOptions())
+	spend := bc.MineBlock([]*Transaction{bc.NewRewardTX(other, []*Transaction{tx}), tx})
+	utxos.Update(spend)
+	_, err = bc.Generate(1, other)
+	assert.Nil(t, err)
+
+	// 新节点从快照启动，再向前同步
+	nodeID := "127.0.0.1 3001"
+	assert.Nil(t, ImportSnapshot(nodeID, s))
+	assert.NotNil(t, ImportSnapshot(nodeID, s), "the blockchain already exists")
+	fresh := NewBlockchain(nodeID)
+	assert.NotNil(t, fresh)
+	t.Cleanup(func() { fresh.db.Close() })
+	assert.Equal(t, height, fresh.GetBestHeight())
+	genesisHash, err := fresh.GenesisHash()
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Hash, genesisHash)
+	assert.Equal(t, s.Commitment, UTXOSet{fresh}.Commitment())
+	assert.True(t, fresh.VerifyTransaction(tx), "previous outputs come from the snapshot")
+
+	headers, _, err := bc.HeadersAfter(height, maxHeadersPerMessage)
+	assert.Nil(t, err)
+	for _, header := range headers {
+		block, err := bc.GetBlock(header.Hash())
+		assert.Nil(t, err)
+		_, err = fresh.acceptBlock(&block)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, bc.GetBestHeight(), fresh.GetBestHeight())
+	assert.Equal(t, utxos.Commitment(), UTXOSet{fresh}.Commitment())
+	assert.Equal(t, utxos.Balance(other), UTXOSet{fresh}.Balance(other))
+	assert.Len(t, fresh.GetBlockHashes(), len(headers)+1, "the chain starts at the snapshot block")
+
+	// 从快照启动的节点也能重建 UTXO 集
+	UTXOSet{fresh}.Reindex()
+	assert.Equal(t, utxos.Commitment(), UTXOSet{fresh}.Commitment())
+
+	// 快照之前的区块头也导入了，可以再导出之后的快照，但不能导出更早的
+	again, err := fresh.Snapshot(-1)
+	assert.Nil(t, err)
+	assert.Equal(t, utxos.Commitment(), again.Commitment)
+	assert.Nil(t, again.Verify())
+	_, err = fresh.Snapshot(height - 1)
+	assert.NotNil(t, err, "blocks before the snapshot block are not stored")
+}
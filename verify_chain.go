@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 区块库检查
+//
+// VerifyChain 用 BlockchainIterator 从链尾往前检查 depth 个区块（0 表示到创世区块或修剪点为止），
+// 检查的内容随 level 递增，每一级包含之前各级：
+//
+//	0  区块能解码，保存的键就是区块的哈希
+//	1  按区块内容重算区块头哈希（包括交易默克尔根），交易 ID 正确，高度与下一个区块相连
+//	2  区块有工作量证明或有效的法定人数证书（创世区块除外）
+//	3  从全部区块重建 UTXO 集，与 chainstate、UTXO 承诺和最佳区块标记比较，只在检查了整条链时进行
+//
+// 发现问题时记录最低的有问题的区块，TruncateChain 把链尾退回它的上一个区块
+
+const (
+	verifyLevelRead = iota
+	verifyLevelHeader
+	verifyLevelWork
+	verifyLevelUTXO
+)
+
+// ChainCheck VerifyChain 的结果
+type ChainCheck struct {
+	Checked     int      // 检查过的区块数
+	Bad         []byte   // 最低的有问题的区块，没有时为 nil
+	BadErr      error    // Bad 的问题
+	LastGood    []byte   // Bad 的上一个区块，Bad 无法解码时为 nil
+	Discard     [][]byte // 从链尾到 Bad 的区块，截断时删除
+	UTXOChecked bool
+	UTXOErr     error // UTXO 集与区块不一致
+}
+
+// OK 没有发现问题
+func (c *ChainCheck) OK() bool {
+	return c.Bad == nil && c.UTXOErr == nil
+}
+
+func (c *ChainCheck) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Checked %d blocks", c.Checked)
+	if c.Bad != nil {
+		fmt.Fprintf(&b, "\nBad block %x: %v", c.Bad, c.BadErr)
+		if c.LastGood != nil {
+			fmt.Fprintf(&b, "\nLast good block: %x (%d blocks above it)", c.LastGood, len(c.Discard))
+		}
+	}
+	switch {
+	case c.UTXOErr != nil:
+		fmt.Fprintf(&b, "\nUTXO set: %v", c.UTXOErr)
+	case c.UTXOChecked:
+		b.WriteString("\nUTXO set matches the blocks")
+	}
+	if c.OK() {
+		b.WriteString("\nNo problems found")
+	}
+
+	return b.String()
+}
+
+// VerifyChain 检查链尾之前 depth 个区块，见本文件开头的说明
+func (bc *Blockchain) VerifyChain(depth, level int) (*ChainCheck, error) {
+	if level < verifyLevelRead || level > verifyLevelUTXO {
+		return nil, fmt.Errorf("level must be between %d and %d", verifyLevelRead, verifyLevelUTXO)
+	}
+	if depth < 0 {
+		return nil, errors.New("depth must not be negative")
+	}
+
+	check := &ChainCheck{}
+	var walked [][]byte
+	var child *Block
+	complete := false
+	bci := bc.Iterator()
+	for depth == 0 || check.Checked < depth {
+		hash, block, err := bci.nextChecked()
+		if len(hash) == 0 {
+			complete = true
+			break
+		}
+		if err == nil && block == nil {
+			// 修剪或从快照启动的节点到此为止，否则区块丢失了
+			if _, headerErr := bc.blockHeader(hash); headerErr == nil {
+				complete = true
+				break
+			}
+			err = errors.New("block is missing")
+		}
+		if err == nil {
+			err = bc.verifyBlock(hash, block, child, level)
+		}
+		walked = append(walked, hash)
+		check.Checked++
+
+		if err != nil {
+			check.Bad, check.BadErr = hash, err
+			check.Discard = append([][]byte(nil), walked...)
+			check.LastGood = nil
+			if block == nil {
+				break
+			}
+			check.LastGood = block.PrevBlockHash
+		}
+		child = block
+	}
+
+	if level >= verifyLevelUTXO && complete && check.Bad == nil {
+		check.UTXOChecked = true
+		check.UTXOErr = bc.verifyChainstate()
+	}
+
+	return check, nil
+}
+
+// verifyBlock 检查以 hash 为键保存的区块，child 是链上的下一个区块（链尾时为 nil）
+func (bc *Blockchain) verifyBlock(hash []byte, block, child *Block, level int) error {
+	if !bytes.Equal(block.Hash, hash) {
+		return fmt.Errorf("stored under %x but its hash is %x", hash, block.Hash)
+	}
+	if level < verifyLevelHeader {
+		return nil
+	}
+
+	header := block.Header()
+	if !bytes.Equal(header.Hash(), hash) {
+		return errors.New("header hash does not match, the transactions or the Merkle root are corrupted")
+	}
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return fmt.Errorf("transaction %x does not match its ID", tx.ID)
+		}
+	}
+	if child != nil && child.Height != block.Height+1 {
+		return fmt.Errorf("height %d but the next block is at height %d", block.Height, child.Height)
+	}
+	if len(block.PrevBlockHash) == 0 && block.Height != 0 {
+		return fmt.Errorf("no parent at height %d", block.Height)
+	}
+	if level < verifyLevelWork || len(block.PrevBlockHash) == 0 || header.HasValidWork() {
+		return nil
+	}
+
+	qc, err := bc.BlockQC(hash)
+	if err != nil {
+		return err
+	}
+	if err := qc.Verify(header); err != nil {
+		return fmt.Errorf("no proof of work: %v", err)
+	}
+
+	return nil
+}
+
+// verifyChainstate 从区块重建 UTXO 集，与 chainstate 比较
+func (bc *Blockchain) verifyChainstate() error {
+	expected := entriesCommitment(snapshotEntries(bc.FindUTXO()))
+	tip := bc.Tip()
+
+	var actual, best, stored []byte
+	var header *BlockHeader
+	err := bc.db.View(func(tx StoreTx) error {
+		utxos := chainStateOf(tx)
+		if utxos.b == nil {
+			return errNoChainstate
+		}
+		acc, err := loadUTXOAccumulator(tx)
+		if err != nil {
+			return err
+		}
+		actual = acc.Commitment()
+		if scanned := accumulateUTXOBucket(utxos.b).Commitment(); !bytes.Equal(actual, scanned) {
+			return fmt.Errorf("rolling UTXO commitment %x does not match the UTXO set %x", actual, scanned)
+		}
+		best = bestBlock(tx)
+		stored = indexesOf(tx).Get(utxoCommitBucket, tip)
+		header = headerIn(tx, tip)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(best, tip) {
+		return fmt.Errorf("UTXO set is at block %x but the tip is %x", best, tip)
+	}
+	if !bytes.Equal(actual, expected) {
+		return fmt.Errorf("UTXO set commitment is %x, the blocks give %x", actual, expected)
+	}
+	if stored != nil && !bytes.Equal(stored, expected) {
+		return fmt.Errorf("recorded commitment of the tip is %x, the blocks give %x", stored, expected)
+	}
+	if header != nil && !bytes.Equal(header.UTXOCommitment, expected) {
+		return fmt.Errorf("the tip header commits to %x, the blocks give %x", header.UTXOCommitment, expected)
+	}
+
+	return nil
+}
+
+// TruncateChain 删除 check 中有问题的区块和它之后的区块，链尾退回 LastGood 并重建 UTXO 集，返回删除的区块数
+func (bc *Blockchain) TruncateChain(check *ChainCheck) (int, error) {
+	if check.Bad == nil {
+		return 0, nil
+	}
+	if check.LastGood == nil {
+		return 0, fmt.Errorf("block %x cannot be read, no good block is known below it", check.Bad)
+	}
+	header, err := bc.blockHeader(check.LastGood)
+	if err != nil {
+		return 0, fmt.Errorf("last good block: %v", err)
+	}
+	if header.Height < bc.PruneHeight() {
+		return 0, fmt.Errorf("last good block at height %d is below the prune height %d", header.Height, bc.PruneHeight())
+	}
+
+	err = bc.db.Update(func(tx StoreTx) error {
+		blocks := blocksOf(tx)
+		indexes := indexesOf(tx)
+		for _, hash := range check.Discard {
+			if err := blocks.Delete(hash); err != nil {
+				return err
+			}
+			for _, index := range []string{undoBucket, cfilterBucket, cfheaderBucket, qcBucket, utxoCommitBucket} {
+				if err := indexes.Delete(index, hash); err != nil {
+					return err
+				}
+			}
+		}
+		// 最佳区块可能已被删除，清除后 UTXO 集视为没有建立，下面重建之前崩溃时启动修复会重建它
+		if err := indexes.Delete(metaBucket, []byte(bestBlockKey)); err != nil {
+			return err
+		}
+
+		return blocks.SetTip(check.LastGood)
+	})
+	if err != nil {
+		return 0, err
+	}
+	bc.setTip(check.LastGood)
+	UTXOSet{bc}.Reindex()
+	logInfof("truncated %d blocks, the tip is %x at height %d", len(check.Discard), check.LastGood, header.Height)
+
+	return len(check.Discard), nil
+}
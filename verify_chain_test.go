@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyChain(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	address := string(NewWallet().GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	UTXOSet{bc}.Reindex()
+	hashes, err := bc.Generate(3, address)
+	assert.Nil(t, err)
+
+	check, err := bc.VerifyChain(0, verifyLevelUTXO)
+	assert.Nil(t, err)
+	assert.True(t, check.OK(), check.String())
+	assert.Equal(t, 4, check.Checked)
+	assert.True(t, check.UTXOChecked)
+	check, err = bc.VerifyChain(2, verifyLevelUTXO)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, check.Checked)
+	assert.False(t, check.UTXOChecked, "the UTXO set needs the whole chain")
+	_, err = bc.VerifyChain(0, verifyLevelUTXO+1)
+	assert.NotNil(t, err)
+
+	// UTXO 集丢了一条记录
+	assert.Nil(t, bc.db.Update(func(tx StoreTx) error {
+		return chainStateOf(tx).Delete(genesis.Transactions[0].ID)
+	}))
+	check, err = bc.VerifyChain(0, verifyLevelUTXO)
+	assert.Nil(t, err)
+	assert.Nil(t, check.Bad)
+	assert.NotNil(t, check.UTXOErr)
+	UTXOSet{bc}.Reindex()
+
+	// 区块内容被改动，哈希字段不变
+	block, err := bc.GetBlock(hashes[1])
+	assert.Nil(t, err)
+	block.Transactions[0].Vout[0].Value++
+	assert.Nil(t, bc.db.Update(func(tx StoreTx) error {
+		return blocksOf(tx).Put(&block)
+	}))
+	check, err = bc.VerifyChain(0, verifyLevelRead)
+	assert.Nil(t, err)
+	assert.True(t, check.OK(), "level 0 only reads the blocks")
+	check, err = bc.VerifyChain(0, verifyLevelUTXO)
+	assert.Nil(t, err)
+	assert.False(t, check.OK())
+	assert.Equal(t, hashes[1], check.Bad)
+	assert.Equal(t, hashes[0], check.LastGood)
+	assert.Equal(t, [][]byte{hashes[2], hashes[1]}, check.Discard)
+	assert.False(t, check.UTXOChecked)
+
+	n, err := bc.TruncateChain(check)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, hashes[0], bc.Tip())
+	assert.Equal(t, hashes[0], chainstateBest(bc))
+	assert.Equal(t, 1, bc.GetBestHeight())
+	check, err = bc.VerifyChain(0, verifyLevelUTXO)
+	assert.Nil(t, err)
+	assert.True(t, check.OK(), check.String())
+	_, err = bc.Generate(1, address)
+	assert.Nil(t, err)
+
+	// 无法解码的区块
+	assert.Nil(t, bc.db.Update(func(tx StoreTx) error {
+		return tx.Bucket(blocksBucket).Put(hashes[0], []byte{1, 2, 3})
+	}))
+	check, err = bc.VerifyChain(0, verifyLevelRead)
+	assert.Nil(t, err)
+	assert.Equal(t, hashes[0], check.Bad)
+	assert.Nil(t, check.LastGood)
+	_, err = bc.TruncateChain(check)
+	assert.NotNil(t, err)
+}
+
+// 交易 ID 不包含签名和公钥，钱包签名的交易和 PSBT 签名的交易使用同一规则
+func TestVerifyChainSignedTransactions(t *testing.T) {
+	useChainParams(t, regtestChainParams())
+
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	genesis, err := chainParams.NewGenesisBlock(address)
+	assert.Nil(t, err)
+	bc := testChain(t, genesis)
+	utxos := UTXOSet{bc}
+	utxos.Reindex()
+	_, err = bc.Generate(chainParams.CoinbaseMaturity, address)
+	assert.Nil(t, err)
+	wallets := &Wallets{Wallets: map[string]*Wallet{address: wallet}}
+	tx := NewUTXOTransaction(wallets, address, string(NewWallet().GetAddress()), 5, &utxos, DefaultSendOptions())
+	bc.MineBlock([]*Transaction{bc.NewRewardTX(address, []*Transaction{tx}), tx})
+
+	psbt, err := NewPSBT(address, string(NewWallet().GetAddress()), 5, &utxos)
+	assert.Nil(t, err)
+	_, err = psbt.Sign(wallet, SigHashAll)
+	assert.Nil(t, err)
+	finalized, err := psbt.Finalize()
+	assert.Nil(t, err)
+	bc.MineBlock([]*Transaction{bc.NewRewardTX(address, []*Transaction{finalized}), finalized})
+
+	check, err := bc.VerifyChain(0, verifyLevelUTXO)
+	assert.Nil(t, err)
+	assert.True(t, check.OK(), check.String())
+
+	resigned := *finalized
+	resigned.Vin = append([]TXInput(nil), finalized.Vin...)
+	resigned.Vin[0].Signature = nil
+	resigned.Vin[0].PubKey = nil
+	assert.Equal(t, finalized.ID, resigned.Hash())
+
+	tx.Vout[0].Value++
+	assert.NotEqual(t, tx.ID, tx.Hash())
+}
@@ -7,17 +7,18 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"log"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
-const version = byte(0x00)
 const addressChecksumLen = 4
 
 // Wallet stores private and public keys
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
+	Path       string // HD 派生路径，随机生成的钱包为空
 }
 
 // NewWallet 这段代码是 `NewWallet` 函数，用于创建一个新的钱包。
@@ -28,16 +29,19 @@ type Wallet struct {
 //总的来说，这个函数的目的是生成一个新的密钥对并将其用于创建一个钱包实例。密钥对在加密货币中用于签署交易和验证身份。
 func NewWallet() *Wallet {
 	private, public := newKeyPair()
-	wallet := Wallet{private, public}
+	wallet := Wallet{PrivateKey: private, PublicKey: public}
 
 	return &wallet
 }
 
 // GetAddress returns wallet address
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	return AddressFromPubKeyHash(HashPubKey(w.PublicKey))
+}
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+// AddressFromPubKeyHash 由公钥哈希得到地址，用于显示输出的收款地址
+func AddressFromPubKeyHash(pubKeyHash []byte) []byte {
+	versionedPayload := append([]byte{chainParams.AddressVersion}, pubKeyHash...)
 	checksum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checksum...)
@@ -69,10 +73,18 @@ func HashPubKey(pubKey []byte) []byte {
 //6. 计算目标校验和（`targetChecksum`），它是将版本字节和剩余的公钥哈希合并后再进行校验和计算。
 //7. 最后，使用 `bytes.Compare` 函数比较实际校验和和目标校验和是否相等，如果相等则返回 `true`，表示地址有效，否则返回 `false`，表示地址无效。
 //总之，这个函数用于验证区块链交易中的地址是否有效，通过比较校验和来检查地址的完整性和正确性。
+//版本字节必须是当前网络的 chainParams.AddressVersion，其他网络的地址无效。
 func ValidateAddress(address string) bool {
+	return validateAddressFor(chainParams.AddressVersion, address)
+}
+
+// validateAddressFor 地址是否为版本字节 version 的有效地址，用于检查还没有生效的网络参数中的地址
+func validateAddressFor(version byte, address string) bool {
 	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) <= addressChecksumLen || pubKeyHash[0] != version {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
 	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
 
@@ -101,7 +113,26 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pubKey := marshalPubKey(private.PublicKey)
 
 	return *private, pubKey
 }
+
+// marshalPubKey X 和 Y 各补齐到 32 字节后拼接；验证签名时按长度的一半拆分，
+// 不补齐的话坐标开头为 0 的公钥会被拆错
+func marshalPubKey(pub ecdsa.PublicKey) []byte {
+	pubKey := make([]byte, 64)
+	pub.X.FillBytes(pubKey[:32])
+	pub.Y.FillBytes(pubKey[32:])
+
+	return pubKey
+}
+
+// unmarshalPubKey marshalPubKey 的逆操作
+func unmarshalPubKey(pubKey []byte) ecdsa.PublicKey {
+	return ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pubKey[:len(pubKey)/2]),
+		Y:     new(big.Int).SetBytes(pubKey[len(pubKey)/2:]),
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"io"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedWalletMagic 加密钱包文件的开头，没有这个前缀的是旧的明文 gob 钱包文件。
+// 这一版的附加认证数据包括文件中全部明文内容，见 walletAAD
+const encryptedWalletMagic = "WALLETENC\x03"
+
+// keysEncryptedWalletMagic 第二版加密钱包文件，附加认证数据只有 KDF 参数、公钥和 HD 路径。仍可读取，解锁后保存时升级为新格式
+const keysEncryptedWalletMagic = "WALLETENC\x02"
+
+// legacyEncryptedWalletMagic 第一版加密钱包文件，附加认证数据只有文件头。仍可读取，解锁后保存时升级为新格式
+const legacyEncryptedWalletMagic = "WALLETENC\x01"
+
+// scrypt 参数，写入文件以便以后调整
+const (
+	walletScryptN = 1 << 15
+	walletScryptR = 8
+	walletScryptP = 1
+)
+
+var (
+	errWalletLocked    = errors.New("wallet is locked")
+	errWrongPassphrase = errors.New("wrong wallet passphrase")
+	errNotEncrypted    = errors.New("wallet is not encrypted")
+)
+
+// walletKDF 从口令派生 AES-256 密钥的 scrypt 参数
+type walletKDF struct {
+	Salt []byte
+	N    int
+	R    int
+	P    int
+}
+
+// encryptedWallets 加密钱包文件的内容：公钥、HD 路径和派生下标、只读地址和标签明文保存，锁定时也能列出地址；
+// 私钥和 HD 种子（walletSecrets）用 AES-GCM 加密
+type encryptedWallets struct {
+	KDF        walletKDF
+	Nonce      []byte
+	PublicKeys map[string][]byte
+	Paths      map[string]string
+	HD         *HDChain // Seed 为空
+	Ciphertext []byte
+	WatchOnly  map[string][]byte
+	Labels     map[string]string
+}
+
+// walletSecrets 加密部分的明文
+type walletSecrets struct {
+	PrivateKeys map[string][]byte
+	HDSeed      []byte
+}
+
+// walletGob 明文钱包文件中一个钱包的 gob 编码。ecdsa.PrivateKey 中的曲线是接口，Go 1.19 起 P256 曲线的实现没有导出字段，
+// gob 无法编码，所以只保存私钥的 D，曲线固定为 P256，公钥坐标在解码时由 D 算出
+type walletGob struct {
+	D         []byte
+	PublicKey []byte
+	Path      string
+}
+
+// GobEncode 见 walletGob
+func (w Wallet) GobEncode() ([]byte, error) {
+	var buff bytes.Buffer
+	var d []byte
+	if w.PrivateKey.D != nil {
+		d = w.PrivateKey.D.Bytes()
+	}
+
+	err := gob.NewEncoder(&buff).Encode(walletGob{d, w.PublicKey, w.Path})
+
+	return buff.Bytes(), err
+}
+
+// GobDecode 见 walletGob
+func (w *Wallet) GobDecode(data []byte) error {
+	var decoded walletGob
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+		return err
+	}
+
+	*w = Wallet{PublicKey: decoded.PublicKey, Path: decoded.Path}
+	if len(decoded.D) > 0 {
+		w.PrivateKey = privateKeyFromBytes(decoded.D)
+	}
+
+	return nil
+}
+
+// privateKeyFromBytes 由 D 恢复 P256 私钥
+func privateKeyFromBytes(d []byte) ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	private.Curve = curve
+	private.X, private.Y = curve.ScalarBaseMult(d)
+
+	return private
+}
+
+// legacyWallets 旧版本直接用 gob 编码 ecdsa.PrivateKey 写的明文钱包文件，见 walletGob。
+// 解码时跳过文件中的曲线和公钥坐标，只取私钥的 D
+type legacyWallets struct {
+	Wallets map[string]*struct {
+		PrivateKey struct{ D *big.Int }
+		PublicKey  []byte
+		Path       string
+	}
+	HD        *HDChain
+	WatchOnly map[string][]byte
+	Labels    map[string]string
+}
+
+func decodeLegacyWallets(data []byte) (*Wallets, error) {
+	var legacy legacyWallets
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy); err != nil {
+		return nil, err
+	}
+
+	wallets := &Wallets{Wallets: make(map[string]*Wallet), HD: legacy.HD, WatchOnly: legacy.WatchOnly, Labels: legacy.Labels}
+	for address, wallet := range legacy.Wallets {
+		decoded := &Wallet{PublicKey: wallet.PublicKey, Path: wallet.Path}
+		if wallet.PrivateKey.D != nil {
+			decoded.PrivateKey = privateKeyFromBytes(wallet.PrivateKey.D.Bytes())
+		}
+		wallets.Wallets[address] = decoded
+	}
+
+	return wallets, nil
+}
+
+// walletSession 节点解锁钱包后在内存中保存的派生密钥，到期自动清除
+type walletSession struct {
+	key     []byte
+	expires time.Time
+}
+
+var (
+	walletSessions      = make(map[string]*walletSession)
+	walletSessionsMutex sync.Mutex
+)
+
+func newWalletKDF() (*walletKDF, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	return &walletKDF{salt, walletScryptN, walletScryptR, walletScryptP}, nil
+}
+
+func (kdf *walletKDF) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), kdf.Salt, kdf.N, kdf.R, kdf.P, 32)
+}
+
+// IsEncrypted 钱包文件是否加密
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.kdf != nil
+}
+
+// IsLocked 加密钱包没有解锁时不能签名
+func (ws *Wallets) IsLocked() bool {
+	return ws.IsEncrypted() && ws.key == nil
+}
+
+// IsLocked 私钥不在内存中时钱包处于锁定状态
+func (w Wallet) IsLocked() bool {
+	return w.PrivateKey.D == nil
+}
+
+// Encrypt 用口令加密明文钱包，调用 SaveToFile 后生效
+func (ws *Wallets) Encrypt(passphrase string) error {
+	if ws.IsEncrypted() {
+		return errors.New("wallet is already encrypted")
+	}
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+
+	kdf, err := newWalletKDF()
+	if err != nil {
+		return err
+	}
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	ws.kdf = kdf
+	ws.key = key
+
+	return nil
+}
+
+// Unlock 用口令解密钱包私钥
+func (ws *Wallets) Unlock(passphrase string) error {
+	if !ws.IsEncrypted() {
+		return errNotEncrypted
+	}
+	key, err := ws.kdf.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	return ws.unlockWithKey(key)
+}
+
+// Lock 从内存中清除私钥和派生密钥
+func (ws *Wallets) Lock() {
+	if !ws.IsEncrypted() {
+		return
+	}
+	for _, wallet := range ws.Wallets {
+		wallet.PrivateKey.D = nil
+	}
+	if ws.HD != nil {
+		ws.HD.Seed = nil
+	}
+	ws.key = nil
+}
+
+// ChangePassphrase 验证旧口令后用新口令和新的盐重新加密，调用 SaveToFile 后生效
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if err := ws.Unlock(oldPassphrase); err != nil {
+		return err
+	}
+	if newPassphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+
+	kdf, err := newWalletKDF()
+	if err != nil {
+		return err
+	}
+	key, err := kdf.deriveKey(newPassphrase)
+	if err != nil {
+		return err
+	}
+	ws.kdf = kdf
+	ws.key = key
+
+	return nil
+}
+
+func (ws *Wallets) unlockWithKey(key []byte) error {
+	aead, err := newWalletAEAD(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := aead.Open(nil, ws.nonce, ws.ciphertext, ws.aad)
+	if err != nil {
+		return errWrongPassphrase
+	}
+
+	var secrets walletSecrets
+	err = gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&secrets)
+	if err != nil {
+		return err
+	}
+
+	if ws.HD != nil {
+		ws.HD.Seed = secrets.HDSeed
+	}
+	for address, d := range secrets.PrivateKeys {
+		wallet, ok := ws.Wallets[address]
+		if !ok {
+			continue
+		}
+		wallet.PrivateKey = privateKeyFromBytes(d)
+	}
+	ws.key = key
+
+	return nil
+}
+
+// encrypt 把钱包编码为加密文件内容。锁定时沿用读入的密文，只能保存只读地址、标签等明文部分的修改
+func (ws *Wallets) encrypt() ([]byte, error) {
+	if ws.key == nil {
+		return ws.encodeLocked()
+	}
+
+	publicKeys := make(map[string][]byte)
+	paths := make(map[string]string)
+	secrets := walletSecrets{PrivateKeys: make(map[string][]byte)}
+	for address, wallet := range ws.Wallets {
+		publicKeys[address] = wallet.PublicKey
+		paths[address] = wallet.Path
+		secrets.PrivateKeys[address] = wallet.PrivateKey.D.Bytes()
+	}
+	var hd *HDChain
+	if ws.HD != nil {
+		secrets.HDSeed = ws.HD.Seed
+		hd = &HDChain{Next: ws.HD.Next}
+	}
+
+	var plaintext bytes.Buffer
+	if err := gob.NewEncoder(&plaintext).Encode(secrets); err != nil {
+		return nil, err
+	}
+
+	aead, err := newWalletAEAD(ws.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	file := encryptedWallets{*ws.kdf, nonce, publicKeys, paths, hd, nil, ws.WatchOnly, ws.Labels}
+	aad := walletAAD(encryptedWalletMagic, &file)
+	file.Ciphertext = aead.Seal(nil, nonce, plaintext.Bytes(), aad)
+	ws.nonce = nonce
+	ws.ciphertext = file.Ciphertext
+	ws.aad = aad
+	ws.magic = encryptedWalletMagic
+
+	content := bytes.NewBufferString(encryptedWalletMagic)
+	if err := gob.NewEncoder(content).Encode(file); err != nil {
+		return nil, err
+	}
+
+	return content.Bytes(), nil
+}
+
+// encodeLocked 锁定状态下重新写出文件：密文不变，私钥不在内存中的钱包都已包含在密文里。
+// 附加认证数据覆盖的明文内容改动后，没有密钥无法重新加密，返回 errWalletLocked
+func (ws *Wallets) encodeLocked() ([]byte, error) {
+	if ws.ciphertext == nil {
+		return nil, errWalletLocked
+	}
+	publicKeys := make(map[string][]byte)
+	paths := make(map[string]string)
+	for address, wallet := range ws.Wallets {
+		if !wallet.IsLocked() {
+			// 锁定后新加入的私钥无法写入密文
+			return nil, errWalletLocked
+		}
+		publicKeys[address] = wallet.PublicKey
+		paths[address] = wallet.Path
+	}
+
+	file := encryptedWallets{*ws.kdf, ws.nonce, publicKeys, paths, ws.HD, ws.ciphertext, ws.WatchOnly, ws.Labels}
+	if !bytes.Equal(walletAAD(ws.magic, &file), ws.aad) {
+		return nil, errWalletLocked
+	}
+
+	content := bytes.NewBufferString(ws.magic)
+	if err := gob.NewEncoder(content).Encode(file); err != nil {
+		return nil, err
+	}
+
+	return content.Bytes(), nil
+}
+
+// decrypt 读取加密文件内容，得到只有公钥的锁定钱包
+func (ws *Wallets) decrypt(content []byte) error {
+	magic := string(content[:len(encryptedWalletMagic)])
+	var file encryptedWallets
+	reader := bytes.NewReader(content[len(encryptedWalletMagic):])
+	if err := gob.NewDecoder(reader).Decode(&file); err != nil {
+		return err
+	}
+
+	ws.Wallets = make(map[string]*Wallet)
+	for address, publicKey := range file.PublicKeys {
+		ws.Wallets[address] = &Wallet{PublicKey: publicKey, Path: file.Paths[address]}
+	}
+	ws.HD = file.HD
+	ws.WatchOnly = file.WatchOnly
+	ws.Labels = file.Labels
+	ws.kdf = &file.KDF
+	ws.nonce = file.Nonce
+	ws.ciphertext = file.Ciphertext
+	ws.magic = magic
+	ws.aad = walletAAD(magic, &file)
+	ws.key = nil
+
+	return nil
+}
+
+// isEncryptedWalletFile 文件内容是否为加密钱包
+func isEncryptedWalletFile(content []byte) bool {
+	for _, magic := range []string{encryptedWalletMagic, keysEncryptedWalletMagic, legacyEncryptedWalletMagic} {
+		if bytes.HasPrefix(content, []byte(magic)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// walletAAD 加密私钥时的附加认证数据，第一版只有文件头；第二版加上 KDF 参数和按地址排序的公钥、HD 路径；
+// 当前版本再加上 HD 派生下标、只读地址和标签。这些内容明文保存，改动其中任何一项都会导致解锁失败
+func walletAAD(magic string, file *encryptedWallets) []byte {
+	buff := bytes.NewBufferString(magic)
+	if magic == legacyEncryptedWalletMagic {
+		return buff.Bytes()
+	}
+	writeVarBytes(buff, file.KDF.Salt)
+	writeUint32(buff, uint32(file.KDF.N))
+	writeUint32(buff, uint32(file.KDF.R))
+	writeUint32(buff, uint32(file.KDF.P))
+
+	addresses := sortedAddresses(file.PublicKeys)
+	writeVarInt(buff, uint64(len(addresses)))
+	for _, address := range addresses {
+		writeVarBytes(buff, []byte(address))
+		writeVarBytes(buff, file.PublicKeys[address])
+		writeVarBytes(buff, []byte(file.Paths[address]))
+	}
+	if magic == keysEncryptedWalletMagic {
+		return buff.Bytes()
+	}
+
+	if file.HD == nil {
+		buff.WriteByte(0)
+	} else {
+		buff.WriteByte(1)
+		for _, next := range file.HD.Next {
+			writeUint32(buff, next)
+		}
+	}
+	watchOnly := sortedAddresses(file.WatchOnly)
+	writeVarInt(buff, uint64(len(watchOnly)))
+	for _, address := range watchOnly {
+		writeVarBytes(buff, []byte(address))
+		writeVarBytes(buff, file.WatchOnly[address])
+	}
+	labels := make([]string, 0, len(file.Labels))
+	for address := range file.Labels {
+		labels = append(labels, address)
+	}
+	sort.Strings(labels)
+	writeVarInt(buff, uint64(len(labels)))
+	for _, address := range labels {
+		writeVarBytes(buff, []byte(address))
+		writeVarBytes(buff, []byte(file.Labels[address]))
+	}
+
+	return buff.Bytes()
+}
+
+// sortedAddresses 排好序的地址
+func sortedAddresses(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func newWalletAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// UnlockWallets 节点解锁钱包，timeout 内 NewWallets 加载的钱包都带有私钥
+func UnlockWallets(nodeID, passphrase string, timeout time.Duration) error {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		return err
+	}
+	if err := wallets.Unlock(passphrase); err != nil {
+		return err
+	}
+
+	walletSessionsMutex.Lock()
+	defer walletSessionsMutex.Unlock()
+	session := &walletSession{wallets.key, time.Now().Add(timeout)}
+	walletSessions[nodeID] = session
+	time.AfterFunc(timeout, func() {
+		walletSessionsMutex.Lock()
+		defer walletSessionsMutex.Unlock()
+		if walletSessions[nodeID] == session {
+			delete(walletSessions, nodeID)
+		}
+	})
+
+	return nil
+}
+
+// LockWallets 立即清除节点的解锁状态
+func LockWallets(nodeID string) {
+	walletSessionsMutex.Lock()
+	defer walletSessionsMutex.Unlock()
+	delete(walletSessions, nodeID)
+}
+
+// unlockedWalletKey 返回节点未过期的派生密钥
+func unlockedWalletKey(nodeID string) []byte {
+	walletSessionsMutex.Lock()
+	defer walletSessionsMutex.Unlock()
+
+	session, ok := walletSessions[nodeID]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.expires) {
+		delete(walletSessions, nodeID)
+		return nil
+	}
+
+	return session.key
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reloadWallets 模拟保存到文件再读回，得到锁定状态的钱包
+func reloadWallets(t *testing.T, ws *Wallets) *Wallets {
+	content, err := ws.encrypt()
+	assert.Nil(t, err)
+
+	loaded := &Wallets{}
+	assert.Nil(t, loaded.decrypt(content))
+	return loaded
+}
+
+func TestWalletEncryptUnlock(t *testing.T) {
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := ws.CreateWallet()
+	original := ws.GetWallet(address)
+	assert.Nil(t, ws.Encrypt("correct horse"))
+
+	loaded := reloadWallets(t, ws)
+	assert.True(t, loaded.IsLocked())
+	assert.Equal(t, []string{address}, loaded.GetAddresses(), "addresses are readable while locked")
+	assert.Equal(t, original.PublicKey, loaded.GetWallet(address).PublicKey)
+
+	_, _, _, _, err := loaded.Sign(address, []byte("message"))
+	assert.Equal(t, errWalletLocked, err, "locked wallet refuses to sign")
+
+	assert.Equal(t, errWrongPassphrase, loaded.Unlock("battery staple"))
+	assert.True(t, loaded.IsLocked())
+
+	assert.Nil(t, loaded.Unlock("correct horse"))
+	assert.Equal(t, 0, original.PrivateKey.D.Cmp(loaded.GetWallet(address).PrivateKey.D))
+	_, _, r, s, err := loaded.Sign(address, []byte("message"))
+	assert.Nil(t, err)
+	assert.True(t, loaded.Verify(address, "message", r, s))
+
+	loaded.Lock()
+	assert.True(t, loaded.GetWallet(address).IsLocked())
+}
+
+func TestWalletChangePassphrase(t *testing.T) {
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := ws.CreateWallet()
+	assert.Nil(t, ws.Encrypt("old"))
+
+	loaded := reloadWallets(t, ws)
+	assert.NotNil(t, loaded.ChangePassphrase("wrong", "new"))
+	assert.Nil(t, loaded.ChangePassphrase("old", "new"))
+
+	reloaded := reloadWallets(t, loaded)
+	assert.Equal(t, errWrongPassphrase, reloaded.Unlock("old"))
+	assert.Nil(t, reloaded.Unlock("new"))
+	assert.False(t, reloaded.GetWallet(address).IsLocked())
+}
+
+func TestSignInputRefusesLockedKey(t *testing.T) {
+	_, _, prevTXs, tx := newSighashFixture()
+	prevOuts, _ := tx.prevOutputs(prevTXs)
+
+	locked := Wallet{PublicKey: tx.Vin[0].PubKey}
+	assert.Equal(t, errWalletLocked, tx.SignInput(locked.PrivateKey, 0, prevOuts, SigHashAll))
+}
+
+func TestEncryptedHDWalletKeepsSeedSecret(t *testing.T) {
+	mnemonic, _ := NewMnemonic()
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	assert.Nil(t, ws.InitHD(mnemonic))
+	ws.CreateWallet()
+	assert.Nil(t, ws.Encrypt("pass"))
+
+	loaded := reloadWallets(t, ws)
+	assert.Nil(t, loaded.HD.Seed, "seed is not readable while locked")
+	_, err := loaded.NewHDAddress(false)
+	assert.Equal(t, errWalletLocked, err)
+
+	assert.Nil(t, loaded.Unlock("pass"))
+	address, err := loaded.NewHDAddress(false)
+	assert.Nil(t, err)
+	assert.Equal(t, "m/44'/0'/0'/0/1", loaded.Wallets[address].Path)
+}
+
+func TestEncryptedWalletAuthenticatesPlaintext(t *testing.T) {
+	mnemonic, _ := NewMnemonic()
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	assert.Nil(t, ws.InitHD(mnemonic))
+	victim := ws.CreateWallet()
+	watched := string(NewWallet().GetAddress())
+	assert.Nil(t, ws.ImportAddress(watched, "cold"))
+	assert.Nil(t, ws.Encrypt("pass"))
+	content, err := ws.encrypt()
+	assert.Nil(t, err)
+
+	// 明文部分的任何改动都会导致解锁失败，例如把公钥换成别人的，签名会被别人的地址认领
+	tampers := map[string]func(file *encryptedWallets){
+		"public key": func(file *encryptedWallets) { file.PublicKeys[victim] = NewWallet().PublicKey },
+		"hd path":    func(file *encryptedWallets) { file.Paths[victim] = "m/0" },
+		"hd index":   func(file *encryptedWallets) { file.HD.Next[0] = 7 },
+		"watch-only": func(file *encryptedWallets) { file.WatchOnly[string(NewWallet().GetAddress())] = nil },
+		"label":      func(file *encryptedWallets) { file.Labels[watched] = "hot" },
+	}
+	for name, tamper := range tampers {
+		var file encryptedWallets
+		assert.Nil(t, gob.NewDecoder(bytes.NewReader(content[len(encryptedWalletMagic):])).Decode(&file))
+		tamper(&file)
+		tampered := bytes.NewBufferString(encryptedWalletMagic)
+		assert.Nil(t, gob.NewEncoder(tampered).Encode(file))
+
+		loaded := &Wallets{}
+		assert.Nil(t, loaded.decrypt(tampered.Bytes()))
+		assert.Equal(t, errWrongPassphrase, loaded.Unlock("pass"), name)
+	}
+}
+
+func TestLegacyEncryptedWalletUnlocks(t *testing.T) {
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := ws.CreateWallet()
+	assert.Nil(t, ws.Encrypt("pass"))
+	content, err := ws.encrypt()
+	assert.Nil(t, err)
+
+	// 第一版文件的附加认证数据只有文件头，第二版只到公钥和 HD 路径
+	for _, magic := range []string{legacyEncryptedWalletMagic, keysEncryptedWalletMagic} {
+		var file encryptedWallets
+		assert.Nil(t, gob.NewDecoder(bytes.NewReader(content[len(encryptedWalletMagic):])).Decode(&file))
+		aead, err := newWalletAEAD(ws.key)
+		assert.Nil(t, err)
+		plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, walletAAD(encryptedWalletMagic, &file))
+		assert.Nil(t, err)
+		file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, walletAAD(magic, &file))
+		legacy := bytes.NewBufferString(magic)
+		assert.Nil(t, gob.NewEncoder(legacy).Encode(file))
+
+		loaded := &Wallets{}
+		assert.True(t, isEncryptedWalletFile(legacy.Bytes()))
+		assert.Nil(t, loaded.decrypt(legacy.Bytes()))
+		locked, err := loaded.encrypt()
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(locked, []byte(magic)), "a locked rewrite keeps the old format")
+
+		assert.Nil(t, loaded.Unlock("pass"))
+		assert.False(t, loaded.GetWallet(address).IsLocked())
+		upgraded, err := loaded.encrypt()
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(upgraded, []byte(encryptedWalletMagic)))
+	}
+}
+
+// 节点解锁时保存的密钥打不开钱包文件（例如文件已经换了口令），加载钱包要报错而不是返回锁定的钱包
+func TestLoadFromFileReturnsUnlockError(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	ws.CreateWallet()
+	assert.Nil(t, ws.Encrypt("pass"))
+	ws.SaveToFile("3000")
+
+	assert.Nil(t, UnlockWallets("3000", "pass", time.Minute))
+	t.Cleanup(func() { LockWallets("3000") })
+	loaded, err := NewWallets("3000")
+	assert.Nil(t, err)
+	assert.False(t, loaded.IsLocked())
+
+	assert.Nil(t, loaded.ChangePassphrase("pass", "new pass"))
+	loaded.SaveToFile("3000")
+	_, err = NewWallets("3000")
+	assert.Equal(t, errWrongPassphrase, err)
+}
+
+func TestSaveToFileRestrictsPermissions(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	path := nodeDataFile(walletFile, "3000")
+	assert.Nil(t, ioutil.WriteFile(path, nil, 0644))
+	assert.Nil(t, os.Chmod(path, 0644))
+
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	ws.CreateWallet()
+	assert.Nil(t, ws.Encrypt("pass"))
+	ws.SaveToFile("3000")
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	files, _ := ioutil.ReadDir(nodeConfig.DataDir)
+	assert.Len(t, files, 1, "no temporary file is left behind")
+}
+
+func TestWalletFileRoundTrip(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := ws.CreateWallet()
+	ws.SaveToFile("3000")
+
+	loaded, err := NewWallets("3000")
+	assert.Nil(t, err)
+	wallet := loaded.GetWallet(address)
+	assert.Equal(t, ws.GetWallet(address).PublicKey, wallet.PublicKey)
+	assert.Equal(t, 0, ws.GetWallet(address).PrivateKey.D.Cmp(wallet.PrivateKey.D))
+	assert.Equal(t, marshalPubKey(wallet.PrivateKey.PublicKey), wallet.PublicKey, "public key is rebuilt from D")
+
+	_, _, r, s, err := loaded.Sign(address, []byte("message"))
+	assert.Nil(t, err)
+	assert.True(t, ws.Verify(address, "message", r, s))
+}
+
+// 旧版本的明文钱包文件直接编码 ecdsa.PrivateKey，这里用没有曲线字段的同名结构模拟
+func TestLoadLegacyWalletFile(t *testing.T) {
+	useNodeConfig(t)
+	nodeConfig = &NodeConfig{DataDir: t.TempDir()}
+	type legacyKey struct {
+		PublicKey struct{ X, Y *big.Int }
+		D         *big.Int
+	}
+	type legacyWallet struct {
+		PrivateKey legacyKey
+		PublicKey  []byte
+		Path       string
+	}
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	old := legacyWallet{PublicKey: wallet.PublicKey}
+	old.PrivateKey.PublicKey.X, old.PrivateKey.PublicKey.Y = wallet.PrivateKey.X, wallet.PrivateKey.Y
+	old.PrivateKey.D = wallet.PrivateKey.D
+
+	var content bytes.Buffer
+	err := gob.NewEncoder(&content).Encode(struct {
+		Wallets map[string]*legacyWallet
+		Labels  map[string]string
+	}{map[string]*legacyWallet{address: &old}, map[string]string{address: "old"}})
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(nodeDataFile(walletFile, "3000"), content.Bytes(), 0600))
+
+	loaded, err := NewWallets("3000")
+	assert.Nil(t, err)
+	assert.Equal(t, "old", loaded.Labels[address])
+	assert.Equal(t, 0, wallet.PrivateKey.D.Cmp(loaded.GetWallet(address).PrivateKey.D))
+	assert.Equal(t, wallet.PublicKey, marshalPubKey(loaded.GetWallet(address).PrivateKey.PublicKey))
+}
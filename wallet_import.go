@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// wifKeyLen 导出私钥中 D 补齐后的长度
+const wifKeyLen = 32
+
+var errWatchOnly = errors.New("address is watch-only, the wallet has no private key for it")
+
+// EncodePrivateKey 把私钥编码为 Base58Check 字符串：版本字节 chainParams.WIFVersion、32 字节私钥、4 字节校验和
+func EncodePrivateKey(privKey ecdsa.PrivateKey) string {
+	payload := make([]byte, 1+wifKeyLen)
+	payload[0] = chainParams.WIFVersion
+	privKey.D.FillBytes(payload[1:])
+
+	return string(Base58Encode(append(payload, checksum(payload)...)))
+}
+
+// DecodePrivateKey 解码 EncodePrivateKey 的结果，得到对应的钱包
+func DecodePrivateKey(wif string) (*Wallet, error) {
+	decoded := Base58Decode([]byte(wif))
+	if len(decoded) != 1+wifKeyLen+addressChecksumLen || decoded[0] != chainParams.WIFVersion {
+		return nil, errors.New("not an exported private key")
+	}
+	payload := decoded[:1+wifKeyLen]
+	if !bytes.Equal(checksum(payload), decoded[1+wifKeyLen:]) {
+		return nil, errors.New("private key checksum mismatch")
+	}
+
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(payload[1:])
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
+	private := ecdsa.PrivateKey{D: d}
+	private.Curve = curve
+	private.X, private.Y = curve.ScalarBaseMult(payload[1:])
+
+	return &Wallet{PrivateKey: private, PublicKey: marshalPubKey(private.PublicKey)}, nil
+}
+
+// ImportPrivateKey 导入私钥，已作为只读地址导入的地址会升级为可花费的钱包
+func (ws *Wallets) ImportPrivateKey(wif, label string) (string, error) {
+	if ws.IsLocked() {
+		return "", errWalletLocked
+	}
+	wallet, err := DecodePrivateKey(wif)
+	if err != nil {
+		return "", err
+	}
+
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	if _, ok := ws.Wallets[address]; !ok {
+		ws.Wallets[address] = wallet
+	}
+	delete(ws.WatchOnly, address)
+	if label != "" {
+		ws.setLabel(address, label)
+	}
+
+	return address, nil
+}
+
+// ExportPrivateKey 导出地址的私钥，加密钱包必须先解锁
+func (ws *Wallets) ExportPrivateKey(address string) (string, error) {
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		if ws.IsWatchOnly(address) {
+			return "", errWatchOnly
+		}
+		return "", fmt.Errorf("address %s is not in the wallet", address)
+	}
+	if wallet.IsLocked() {
+		return "", errWalletLocked
+	}
+
+	return EncodePrivateKey(wallet.PrivateKey), nil
+}
+
+// ImportAddress 导入只读地址：可以查询余额，不能签名。只读地址受加密钱包的认证保护，锁定时不能导入
+func (ws *Wallets) ImportAddress(address, label string) error {
+	if !ValidateAddress(address) {
+		return errors.New("address is not valid")
+	}
+
+	return ws.importWatchOnly(address, nil, label)
+}
+
+// ImportPubKey 导入只读公钥（X 和 Y 各 32 字节，可以带 0x04 前缀），返回对应的地址
+func (ws *Wallets) ImportPubKey(pubKey []byte, label string) (string, error) {
+	if len(pubKey) == 65 && pubKey[0] == 4 {
+		pubKey = pubKey[1:]
+	}
+	if len(pubKey) != 64 {
+		return "", errors.New("public key must be 64 bytes")
+	}
+	x := new(big.Int).SetBytes(pubKey[:32])
+	y := new(big.Int).SetBytes(pubKey[32:])
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return "", errors.New("public key is not on the curve")
+	}
+
+	address := fmt.Sprintf("%s", Wallet{PublicKey: pubKey}.GetAddress())
+	return address, ws.importWatchOnly(address, pubKey, label)
+}
+
+func (ws *Wallets) importWatchOnly(address string, pubKey []byte, label string) error {
+	if ws.IsLocked() {
+		return errWalletLocked
+	}
+	if _, ok := ws.Wallets[address]; ok {
+		return fmt.Errorf("address %s is already in the wallet with its private key", address)
+	}
+	if ws.WatchOnly == nil {
+		ws.WatchOnly = make(map[string][]byte)
+	}
+	if pubKey == nil {
+		pubKey = ws.WatchOnly[address]
+	}
+	ws.WatchOnly[address] = pubKey
+	if label != "" {
+		ws.setLabel(address, label)
+	}
+
+	return nil
+}
+
+// IsWatchOnly 地址是否作为只读地址导入
+func (ws *Wallets) IsWatchOnly(address string) bool {
+	_, ok := ws.WatchOnly[address]
+	return ok
+}
+
+// GetWatchOnlyAddresses 返回排好序的只读地址
+func (ws *Wallets) GetWatchOnlyAddresses() []string {
+	var addresses []string
+	for address := range ws.WatchOnly {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// SetLabel 给钱包中的地址或只读地址加标签，label 为空时删除标签。标签受加密钱包的认证保护，锁定时不能修改
+func (ws *Wallets) SetLabel(address, label string) error {
+	if ws.IsLocked() {
+		return errWalletLocked
+	}
+	if _, ok := ws.Wallets[address]; !ok && !ws.IsWatchOnly(address) {
+		return fmt.Errorf("address %s is not in the wallet", address)
+	}
+	ws.setLabel(address, label)
+
+	return nil
+}
+
+// GetLabel 返回地址的标签，没有标签时为空
+func (ws *Wallets) GetLabel(address string) string {
+	return ws.Labels[address]
+}
+
+func (ws *Wallets) setLabel(address, label string) {
+	if label == "" {
+		delete(ws.Labels, address)
+		return
+	}
+	if ws.Labels == nil {
+		ws.Labels = make(map[string]string)
+	}
+	ws.Labels[address] = label
+}
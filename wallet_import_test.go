@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivateKeyExportImport(t *testing.T) {
+	source := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := source.CreateWallet()
+	key, err := source.ExportPrivateKey(address)
+	assert.Nil(t, err)
+
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	assert.Nil(t, ws.ImportAddress(address, "cold"))
+	assert.True(t, ws.IsWatchOnly(address))
+	_, err = ws.ExportPrivateKey(address)
+	assert.Equal(t, errWatchOnly, err)
+
+	imported, err := ws.ImportPrivateKey(key, "")
+	assert.Nil(t, err)
+	assert.Equal(t, address, imported)
+	assert.False(t, ws.IsWatchOnly(address), "importing the key upgrades a watch-only address")
+	assert.Equal(t, "cold", ws.GetLabel(address))
+	assert.Equal(t, 0, source.Wallets[address].PrivateKey.D.Cmp(ws.Wallets[address].PrivateKey.D))
+
+	last := "1"
+	if key[len(key)-1:] == last {
+		last = "2"
+	}
+	_, err = DecodePrivateKey(key[:len(key)-1] + last)
+	assert.NotNil(t, err, "checksum is verified")
+	_, err = DecodePrivateKey(address)
+	assert.NotNil(t, err, "an address is not a private key")
+}
+
+func TestWatchOnlyPubKeyAndLabels(t *testing.T) {
+	watched := NewWallet()
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	own := ws.CreateWallet()
+
+	address, err := ws.ImportPubKey(append([]byte{4}, watched.PublicKey...), "exchange")
+	assert.Nil(t, err)
+	assert.Equal(t, string(watched.GetAddress()), address)
+	assert.Equal(t, []string{address}, ws.GetWatchOnlyAddresses())
+	assert.Equal(t, []string{own}, ws.GetAddresses(), "watch-only addresses cannot sign")
+
+	assert.NotNil(t, ws.ImportAddress(own, ""), "an address with a private key is not watch-only")
+	_, err = ws.ImportPubKey(make([]byte, 64), "")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ws.SetLabel(own, "savings"))
+	assert.Nil(t, ws.SetLabel(address, ""))
+	assert.Equal(t, "", ws.GetLabel(address))
+	assert.NotNil(t, ws.SetLabel(string(NewWallet().GetAddress()), "x"))
+}
+
+// 只读地址和标签受加密钱包的认证保护，锁定时不能修改，解锁后修改并保存，再次加载后仍能解锁
+func TestLockedWalletRefusesWatchOnlyChanges(t *testing.T) {
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	own := ws.CreateWallet()
+	assert.Nil(t, ws.Encrypt("pass"))
+
+	loaded := reloadWallets(t, ws)
+	watched := string(NewWallet().GetAddress())
+	assert.Equal(t, errWalletLocked, loaded.ImportAddress(watched, "monitor"))
+	assert.Equal(t, errWalletLocked, loaded.SetLabel(own, "main"))
+	_, err := loaded.encrypt()
+	assert.Nil(t, err, "an unchanged locked wallet can be saved")
+
+	loaded.Labels = map[string]string{own: "main"}
+	_, err = loaded.encrypt()
+	assert.Equal(t, errWalletLocked, err, "authenticated fields cannot change while locked")
+	loaded.Labels = nil
+
+	assert.Nil(t, loaded.Unlock("pass"))
+	assert.Nil(t, loaded.ImportAddress(watched, "monitor"))
+	assert.Nil(t, loaded.SetLabel(own, "main"))
+	reloaded := reloadWallets(t, loaded)
+	assert.True(t, reloaded.IsWatchOnly(watched))
+	assert.Equal(t, "main", reloaded.GetLabel(own))
+	assert.Nil(t, reloaded.Unlock("pass"))
+	assert.False(t, reloaded.Wallets[own].IsLocked())
+
+	reloaded.Lock()
+	reloaded.Wallets[string(NewWallet().GetAddress())] = NewWallet()
+	_, err = reloaded.encrypt()
+	assert.Equal(t, errWalletLocked, err, "new keys cannot be saved while locked")
+}
@@ -12,15 +12,29 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"path/filepath"
 )
 
 const walletFile = "wallet_%s.dat"
 
+// publicKey 本节点最近一次 SignByPrivateKey 使用的公钥，投票消息附带它供其他节点验证
 var publicKey ecdsa.PublicKey
 
 // Wallets stores a collection of wallets
 type Wallets struct {
 	Wallets map[string]*Wallet
+	HD      *HDChain // 用助记词创建或恢复的钱包才有，CreateWallet 改为按顺序派生地址
+
+	WatchOnly map[string][]byte // 只读地址，只能查询余额；值为导入的公钥，只导入地址时为空
+	Labels    map[string]string // 地址标签，包括只读地址
+
+	// 以下字段只用于加密钱包，gob 编码明文钱包时会被忽略
+	kdf        *walletKDF
+	key        []byte
+	nonce      []byte
+	ciphertext []byte
+	aad        []byte // ciphertext 的附加认证数据
+	magic      string // ciphertext 所属的文件格式，锁定时按这个格式写回
 }
 
 // NewWallets 这个函数是用于创建一个新的钱包集合（`Wallets`）。下面是这个函数的功能解释：
@@ -45,8 +59,17 @@ func NewWallets(nodeID string) (*Wallets, error) {
 //2. 获取新钱包的地址（公钥哈希）。
 //3. 将新钱包添加到钱包集合 `ws.Wallets` 中，以钱包地址为键，钱包实例为值。
 //4. 返回新钱包的地址。
+//HD 钱包不再随机生成密钥，而是在收款链上派生下一个地址。
 //总的来说，这个方法的目的是创建一个新的钱包，将其添加到钱包集合中，并返回新钱包的地址。这个地址可以用来接收加密货币或用于其他与区块链交互相关的操作。
 func (ws *Wallets) CreateWallet() string {
+	if ws.HD != nil {
+		address, err := ws.NewHDAddress(false)
+		if err != nil {
+			log.Panic(err)
+		}
+		return address
+	}
+
 	wallet := NewWallet()
 	address := fmt.Sprintf("%s", wallet.GetAddress())
 	fmt.Println("CreateWallet：", address)
@@ -55,6 +78,36 @@ func (ws *Wallets) CreateWallet() string {
 	return address
 }
 
+// NewChangeAddress 生成一个新的找零地址：HD 钱包在找零链上派生，否则生成随机密钥
+func (ws *Wallets) NewChangeAddress() (string, error) {
+	if ws.HD != nil {
+		return ws.NewHDAddress(true)
+	}
+	if ws.IsLocked() {
+		return "", errWalletLocked
+	}
+
+	wallet := NewWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
+
+// PeekChangeAddress 预览 NewChangeAddress 将返回的地址，不修改钱包；
+// 随机密钥或锁定的 HD 钱包无法预知，返回空字符串
+func (ws *Wallets) PeekChangeAddress() string {
+	if ws.HD == nil || ws.HD.Seed == nil {
+		return ""
+	}
+	wallet, err := ws.HD.DeriveWallet(hdChangeChain, ws.HD.Next[hdChangeChain])
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s", wallet.GetAddress())
+}
+
 // GetAddresses 这段代码是 `Wallets` 结构体的方法 `GetAddresses`，用于获取钱包集合中存储的所有钱包地址。
 //下面是这个方法的功能和步骤解释：
 //1. 创建一个空的字符串切片 `addresses`，用于存储钱包地址。
@@ -112,10 +165,18 @@ func (ws *Wallets) GetWallets() map[string]*Wallet {
 // GetWallet 这段代码是 `Wallets` 结构体的 `GetWallet` 方法，用于根据地址获取对应的钱包信息。
 //下面是这个方法的功能和步骤解释：
 //1. 使用给定的钱包地址 `address`，从 `Wallets` 实例中查找并获取对应的钱包。
-//2. 返回找到的钱包对象。
+//2. 返回找到的钱包对象。地址不在钱包中或者只是只读地址时触发 Panic。
 //总的来说，这个方法的目的是根据地址获取钱包对象，以便在区块链交易中进行地址验证、签名等操作。
 func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		if ws.IsWatchOnly(address) {
+			log.Panicf("ERROR: %s: %v", address, errWatchOnly)
+		}
+		log.Panicf("ERROR: address %s is not in the wallet", address)
+	}
+
+	return *wallet
 }
 
 // LoadFromFile 这个方法是用于从文件中加载钱包数据到钱包集合（`Wallets`）中。下面是这个方法的功能解释：
@@ -130,7 +191,7 @@ func (ws Wallets) GetWallet(address string) Wallet {
 //9. 返回 nil 表示加载操作成功完成。
 //总的来说，这个方法的目的是从文件中加载已存在的钱包数据到钱包集合中，以便可以在集合中管理和操作这些钱包的地址和密钥对。这是一个重要的功能，因为钱包数据用于签署和验证交易，从而确保区块链的安全和完整性。
 func (ws *Wallets) LoadFromFile(nodeID string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := nodeDataFile(walletFile, nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -140,15 +201,32 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 		log.Panic(err)
 	}
 
+	if isEncryptedWalletFile(fileContent) {
+		if err := ws.decrypt(fileContent); err != nil {
+			log.Panic(err)
+		}
+		if key := unlockedWalletKey(nodeID); key != nil {
+			return ws.unlockWithKey(key)
+		}
+		return nil
+	}
+
 	var wallets Wallets
 	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoder.Decode(&wallets)
 	if err != nil {
-		log.Panic(err)
+		legacy, legacyErr := decodeLegacyWallets(fileContent)
+		if legacyErr != nil {
+			log.Panic(err)
+		}
+		wallets = *legacy
 	}
 
 	ws.Wallets = wallets.Wallets
+	ws.HD = wallets.HD
+	ws.WatchOnly = wallets.WatchOnly
+	ws.Labels = wallets.Labels
 
 	return nil
 }
@@ -159,31 +237,56 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 //2. 使用给定的 `nodeID` 构建钱包文件的文件名。
 //3. 注册 `elliptic.P256()` 类型，以确保在编码和解码过程中正确处理椭圆曲线密钥。
 //4. 创建一个新的 Gob 编码器，并使用它将钱包集合编码到 `content` 缓冲区中。
-//5. 将编码后的数据写入文件，文件名为构建的钱包文件名，权限为 0644。
+//5. 将编码后的数据写入文件，文件名为构建的钱包文件名，权限为 0600。加密钱包改为写入加密后的内容，必须先解锁。
 //总的来说，这个方法的目的是将钱包集合编码并保存到文件中，以便在之后重新加载时使用。钱包集合是用于管理多个钱包的数据结构，在区块链中用于存储和管理用户的密钥对和地址。
-func (ws Wallets) SaveToFile(nodeID string) {
+func (ws *Wallets) SaveToFile(nodeID string) {
 	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := nodeDataFile(walletFile, nodeID)
+
+	if ws.IsEncrypted() {
+		encrypted, err := ws.encrypt()
+		if err != nil {
+			log.Panic(err)
+		}
+		content.Write(encrypted)
+	} else {
+		gob.Register(elliptic.P256())
 
-	gob.Register(elliptic.P256())
+		encoder := gob.NewEncoder(&content)
+		err := encoder.Encode(ws)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
 
-	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	// 先写入权限为 0600 的临时文件再替换，旧文件的权限不会保留下来，写入中途出错也不会损坏旧文件
+	tmp, err := ioutil.TempFile(filepath.Dir(walletFile), filepath.Base(walletFile)+".tmp")
 	if err != nil {
 		log.Panic(err)
 	}
-
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
-	if err != nil {
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content.Bytes()); err != nil {
+		tmp.Close()
+		log.Panic(err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Panic(err)
+	}
+	if err := os.Rename(tmp.Name(), walletFile); err != nil {
 		log.Panic(err)
 	}
 }
 
 //签名函数
 func (ws Wallets) Sign(address string, message []byte) (string, []byte, *big.Int, *big.Int, error) {
-	fmt.Println("ws", ws)
 	//找到Wallets中的指定地址
 	wallet := ws.Wallets[address]
+	if wallet == nil {
+		return "", nil, nil, nil, fmt.Errorf("address %s is not in the wallet", address)
+	}
+	if wallet.IsLocked() {
+		return "", nil, nil, nil, errWalletLocked
+	}
 	//fmt.Printf("GetAddresses：wallet.PublicKey=%v\n", wallet.PublicKey)
 	//fmt.Printf("GetAddresses：wallet.PrivateKey=%v\n", wallet.PrivateKey)
 	//fmt.Println("wallet", wallet)
@@ -213,9 +316,12 @@ func (ws Wallets) Verify(address string, message string, r *big.Int, s *big.Int)
 	hash := sha256.Sum256([]byte(message))
 
 	wallet := ws.Wallets[address]
+	if wallet == nil || r == nil || s == nil {
+		return false
+	}
 
 	// 将 wallet.PublicKey 转换为 *ecdsa.PublicKey
-	//var publicKey ecdsa.PublicKey
+	var publicKey ecdsa.PublicKey
 	publicKey.Curve = elliptic.P256() // 使用相应的椭圆曲线
 	publicKey.X = new(big.Int).SetBytes(wallet.PublicKey[:32])
 	publicKey.Y = new(big.Int).SetBytes(wallet.PublicKey[32:])
@@ -244,6 +350,12 @@ func SignByPrivateKey(command string) (string, []byte, *big.Int, *big.Int, error
 	commandByte := []byte(command)
 	var r1, s1 *big.Int
 	addresses[0], commandByte, r1, s1, _ = wallets.Sign(addresses[0], commandByte)
+	signer := wallets.Wallets[addresses[0]]
+	publicKey = ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(signer.PublicKey[:32]),
+		Y:     new(big.Int).SetBytes(signer.PublicKey[32:]),
+	}
 	//fmt.Println("签名成功")
 	if wallets.Verify(addresses[0], command, r1, s1) {
 		fmt.Println("Signature verification successful")